@@ -0,0 +1,132 @@
+package toydb
+
+import (
+	"fmt"
+	"slices"
+)
+
+// AddColumn adds a column after the table's existing ones. Rows already in
+// the table are not rewritten: they read back with def in the new column
+// until they are next written. def must be a value of the column's type,
+// or [Null] if the column is nullable; it returns ErrSchemaMismatch
+// otherwise.
+//
+// Like [Table.CreateIndex], AddColumn commits on its own and is not part of
+// a transaction. Altering a table replaces its schema; the one returned by
+// an earlier call to [Table.Schema] describes the table as it was.
+func (t *Table) AddColumn(column Column, def Value) error {
+	if err := t.schemaChange(); err != nil {
+		return err
+	}
+	if column.Name == "" || len(column.Name) > maxSchemaByteField {
+		return fmt.Errorf("add column: name must be 1 to %d bytes, got %d", maxSchemaByteField, len(column.Name))
+	}
+	if !column.Type.valid() {
+		return fmt.Errorf("add column %q: unknown type %d", column.Name, column.Type)
+	}
+	if def == nil {
+		return fmt.Errorf("%w: add column %q: missing default", ErrSchemaMismatch, column.Name)
+	}
+	return t.alter(func(s *Schema) error {
+		if _, ok := s.columnIndex(column.Name); ok {
+			return fmt.Errorf("add column: table already has a column named %q", column.Name)
+		}
+		if len(s.stored) == maxSchemaByteField {
+			return fmt.Errorf("add column %q: table has had %d columns, the most it can", column.Name, maxSchemaByteField)
+		}
+		s.columns = append(s.columns, column)
+		s.stored = append(s.stored, storedColumn{typ: column.Type, column: len(s.columns) - 1, def: def})
+		return s.validateValue(len(s.columns)-1, def, ErrSchemaMismatch)
+	})
+}
+
+// DropColumn removes the named column. Rows already in the table are not
+// rewritten: the column's values stay on disk, unread, until each row is
+// next written. Primary key columns and columns used by an index cannot be
+// dropped. It commits on its own in the same way as [Table.AddColumn].
+func (t *Table) DropColumn(name string) error {
+	if err := t.schemaChange(); err != nil {
+		return err
+	}
+	return t.alter(func(s *Schema) error {
+		c, ok := s.columnIndex(name)
+		if !ok {
+			return fmt.Errorf("drop column: no column named %q", name)
+		}
+		if s.isKeyColumn(c) {
+			return fmt.Errorf("drop column %q: column is part of the primary key", name)
+		}
+		for _, idx := range t.indexes {
+			if slices.Contains(idx.columns, c) {
+				return fmt.Errorf("drop column %q: column is used by index %q", name, idx.name)
+			}
+		}
+
+		// Every position after c shifts down by one.
+		shift := func(i int) int {
+			if i > c {
+				return i - 1
+			}
+			return i
+		}
+		s.columns = slices.Delete(s.columns, c, c+1)
+		for i, pkIndex := range s.primaryKey {
+			s.primaryKey[i] = shift(pkIndex)
+		}
+		for j, sc := range s.stored {
+			switch {
+			case sc.column == c:
+				s.stored[j] = storedColumn{typ: sc.typ, column: -1}
+			case sc.column > c:
+				s.stored[j].column = shift(sc.column)
+			}
+		}
+		// Index columns are replaced rather than updated in place: reads
+		// outside the lock may still hold the old slices.
+		for _, idx := range t.indexes {
+			columns := make([]int, len(idx.columns))
+			for i, ic := range idx.columns {
+				columns[i] = shift(ic)
+			}
+			idx.columns = columns
+		}
+		return nil
+	})
+}
+
+// RenameColumn renames a column. Rows are unaffected. It commits on its own
+// in the same way as [Table.AddColumn].
+func (t *Table) RenameColumn(name, newName string) error {
+	if err := t.schemaChange(); err != nil {
+		return err
+	}
+	if newName == "" || len(newName) > maxSchemaByteField {
+		return fmt.Errorf("rename column: name must be 1 to %d bytes, got %d", maxSchemaByteField, len(newName))
+	}
+	return t.alter(func(s *Schema) error {
+		c, ok := s.columnIndex(name)
+		if !ok {
+			return fmt.Errorf("rename column: no column named %q", name)
+		}
+		if _, ok := s.columnIndex(newName); ok {
+			return fmt.Errorf("rename column %q: table already has a column named %q", name, newName)
+		}
+		s.columns[c].Name = newName
+		return nil
+	})
+}
+
+// alter applies change to a copy of the table's schema, then installs the
+// copy as the next version of the schema and records it in the catalog. A
+// failed alteration is rolled back along with any changes change made.
+func (t *Table) alter(change func(s *Schema) error) error {
+	return t.db.autocommit(func() error {
+		s := t.schema.clone()
+		if err := change(s); err != nil {
+			return err
+		}
+		s.version++
+		t.schema = s
+		return t.db.catalog.Upsert(t.name, t.catalogRow())
+	})
+}
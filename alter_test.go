@@ -0,0 +1,102 @@
+package toydb_test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	toydb "github.com/guiwoch/toyDB"
+)
+
+// copyDB copies the DB file at path and its log to a new directory, as a
+// crash would leave them, and returns the copy's path.
+func copyDB(t *testing.T, path string) string {
+	t.Helper()
+	dst := filepath.Join(t.TempDir(), filepath.Base(path))
+	for _, suffix := range []string{"", "-wal"} {
+		in, err := os.Open(path + suffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := os.Create(dst + suffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			t.Fatal(err)
+		}
+		in.Close()
+		if err := out.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dst
+}
+
+func TestAlterReadsOldRows(t *testing.T) {
+	t.Parallel()
+	d, path := newTestDB(t)
+	tbl, err := d.CreateTable("users", mustSchema(t, 0,
+		toydb.Column{Name: "id", Type: toydb.TypeInt},
+		toydb.Column{Name: "name", Type: toydb.TypeText},
+		toydb.Column{Name: "age", Type: toydb.TypeInt, Nullable: true},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range []toydb.Row{
+		{toydb.IntValue(1), toydb.TextValue("ann"), toydb.IntValue(30)},
+		{toydb.IntValue(2), toydb.TextValue("bob"), toydb.Null},
+	} {
+		if err := tbl.Insert(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Rows 1 and 2 are stored with three columns; 3 with four.
+	if err := tbl.AddColumn(toydb.Column{Name: "plan", Type: toydb.TypeText}, toydb.TextValue("free")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tbl.Insert(toydb.Row{toydb.IntValue(3), toydb.TextValue("cy"), toydb.IntValue(40), toydb.TextValue("pro")}); err != nil {
+		t.Fatal(err)
+	}
+	checkRows(t, "Scan after AddColumn", tbl.Scan(nil, nil),
+		"1 ann 30 free", "2 bob null free", "3 cy 40 pro")
+
+	// Every row still holds age on disk; row 2, once rewritten, no
+	// longer does.
+	if err := tbl.DropColumn("age"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tbl.Update(toydb.Row{toydb.IntValue(2), toydb.TextValue("bo"), toydb.TextValue("team")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tbl.AddColumn(toydb.Column{Name: "age", Type: toydb.TypeInt, Nullable: true}, toydb.Null); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"1 ann free null", "2 bo team null", "3 cy pro null"}
+	checkRows(t, "Scan after DropColumn", tbl.Scan(nil, nil), want...)
+	if row, err := tbl.Get(toydb.IntValue(1)); err != nil || formatRow(row) != want[0] {
+		t.Errorf("Get 1: got %v, %v, want %s", row, err, want[0])
+	}
+
+	// Reopened from the log, as after a crash, before any checkpoint.
+	crashed := openTestDB(t, copyDB(t, path))
+	ctbl, err := crashed.OpenTable("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRows(t, "Scan after log replay", ctbl.Scan(nil, nil), want...)
+
+	// Close checkpoints the log into the file.
+	d = reopen(t, d, path)
+	tbl, err = d.OpenTable("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRows(t, "Scan after checkpoint and reopen", tbl.Scan(nil, nil), want...)
+	if got := tbl.Schema().Columns(); len(got) != 4 || got[3].Name != "age" {
+		t.Errorf("columns after reopen: %v", got)
+	}
+}
@@ -0,0 +1,149 @@
+package toydb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/guiwoch/toyDB/internal/storage/btree"
+)
+
+// Batch collects row writes, across one or more tables, to be applied
+// atomically by [DB.Write]. Adding to a batch only records the write;
+// nothing is validated or stored until Write. The zero Batch is empty and
+// ready to use. A Batch must be used by one goroutine at a time.
+type Batch struct {
+	ops []batchOp
+}
+
+// batchOp is one write recorded in a Batch: a Put of row, or a Delete of
+// key.
+type batchOp struct {
+	table  *Table
+	delete bool
+	row    Row
+	key    Value
+
+	encoded []byte // the primary key, once Write has validated the op
+}
+
+// Put records a write of row to table t, inserting it or replacing the row
+// with the same primary key as [Table.Upsert] does.
+func (b *Batch) Put(t *Table, row Row) {
+	b.ops = append(b.ops, batchOp{table: t, row: row})
+}
+
+// Delete records the removal of the row with the given primary key from
+// table t. Unlike [Table.Delete], key must hold a value for every primary
+// key column, and a key with no row is not an error.
+func (b *Batch) Delete(t *Table, key Value) {
+	b.ops = append(b.ops, batchOp{table: t, delete: true, key: key})
+}
+
+// Len returns the number of writes recorded in the batch.
+func (b *Batch) Len() int { return len(b.ops) }
+
+// Reset empties the batch so it can be reused.
+func (b *Batch) Reset() {
+	clear(b.ops)
+	b.ops = b.ops[:0]
+}
+
+// Write applies every write in the batch in a single commit: either all of
+// them land or, if any fails, none does. Writes to the same row apply in
+// the order they were added. Each table's writes are applied in primary
+// key order, so the leaves they touch are visited in turn while cached.
+//
+// Every write is validated before any is applied, against the schemas of
+// the tables at the time of the call: Write returns ErrSchemaMismatch or
+// ErrKeyTypeMismatch as the corresponding Table methods do, or
+// ErrTableNotFound if a table has been dropped. The batch's table handles
+// only name the tables; writes are not part of any transaction the handles
+// belong to, and handles from a [Snapshot] return ErrReadOnly. Like other
+// writes outside a transaction, Write waits for any open [Tx] to end.
+func (d *DB) Write(b *Batch) error {
+	for _, op := range b.ops {
+		if op.table.db != d {
+			return fmt.Errorf("write batch: table %q belongs to another DB", op.table.name)
+		}
+		if op.table.snap != nil {
+			return ErrReadOnly
+		}
+	}
+	return d.autocommit(func() error {
+		// Group the writes by table, keeping tables in first-use order so
+		// the outcome does not depend on map iteration.
+		var names []string
+		groups := make(map[string][]batchOp)
+		for _, op := range b.ops {
+			name := op.table.name
+			if _, ok := groups[name]; !ok {
+				names = append(names, name)
+			}
+			groups[name] = append(groups[name], op)
+		}
+
+		tables := make([]*Table, len(names))
+		for i, name := range names {
+			t, err := d.openTable(name)
+			if err != nil {
+				return err
+			}
+			ops := groups[name]
+			for j := range ops {
+				if err := t.encodeBatchOp(&ops[j]); err != nil {
+					return err
+				}
+			}
+			// Stable, so writes to the same key keep their order.
+			slices.SortStableFunc(ops, func(a, b batchOp) int {
+				return bytes.Compare(a.encoded, b.encoded)
+			})
+			tables[i] = t
+		}
+
+		for i, t := range tables {
+			for _, op := range groups[names[i]] {
+				if err := t.applyBatchOp(op); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// encodeBatchOp validates op against the table's schema and records its
+// encoded primary key.
+func (t *Table) encodeBatchOp(op *batchOp) error {
+	if !op.delete {
+		if err := t.schema.validateRow(op.row); err != nil {
+			return err
+		}
+		op.encoded = t.schema.encodeKeyFromRow(op.row)
+		return nil
+	}
+	full, err := t.schema.validateKey(op.key)
+	if err != nil {
+		return err
+	}
+	if !full {
+		return fmt.Errorf("%w: batch delete needs a value for each of the %d primary key columns",
+			ErrKeyTypeMismatch, len(t.schema.primaryKey))
+	}
+	op.encoded = t.schema.encodeKeyFromValue(op.key)
+	return nil
+}
+
+// applyBatchOp applies a validated op.
+func (t *Table) applyBatchOp(op batchOp) error {
+	if !op.delete {
+		return t.upsert(op.encoded, op.row)
+	}
+	err := t.deleteRow(op.encoded)
+	if errors.Is(err, btree.ErrKeyNotFound) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,79 @@
+package toydb_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	toydb "github.com/guiwoch/toyDB"
+)
+
+func TestWriteBatchAtomic(t *testing.T) {
+	t.Parallel()
+	d, path := newTestDB(t)
+	schema := mustSchema(t, 0,
+		toydb.Column{Name: "id", Type: toydb.TypeText},
+		toydb.Column{Name: "n", Type: toydb.TypeInt},
+	)
+	accounts, err := d.CreateTable("accounts", schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit, err := d.CreateTable("audit", schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gone, err := d.CreateTable("gone", schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DropTable("gone"); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b"} {
+		if err := accounts.Insert(toydb.Row{toydb.TextValue(id), toydb.IntValue(10)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	row := func(id string, n int) toydb.Row { return toydb.Row{toydb.TextValue(id), toydb.IntValue(n)} }
+
+	tests := []struct {
+		name string
+		last func(b *toydb.Batch) // the op that fails
+		want error
+	}{
+		// Fails once the earlier writes are already applied.
+		{"key too large", func(b *toydb.Batch) { b.Put(audit, row(strings.Repeat("x", 10000), 1)) }, toydb.ErrKeyTooLarge},
+		{"dropped table", func(b *toydb.Batch) { b.Put(gone, row("a", 1)) }, toydb.ErrTableNotFound},
+		{"schema mismatch", func(b *toydb.Batch) { b.Put(audit, toydb.Row{toydb.TextValue("a")}) }, toydb.ErrSchemaMismatch},
+	}
+	for _, tt := range tests {
+		var b toydb.Batch
+		b.Put(accounts, row("a", 5))
+		b.Delete(accounts, toydb.TextValue("b"))
+		b.Put(accounts, row("c", 15))
+		b.Put(audit, row("a", -5))
+		tt.last(&b)
+		if err := d.Write(&b); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+		checkRows(t, tt.name+": accounts", accounts.Scan(nil, nil), "a 10", "b 10")
+		checkRows(t, tt.name+": audit", audit.Scan(nil, nil))
+	}
+
+	d = reopen(t, d, path)
+	for name, want := range map[string][]string{"accounts": {"a 10", "b 10"}, "audit": nil} {
+		tbl, err := d.OpenTable(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkRows(t, name+" after reopen", tbl.Scan(nil, nil), want...)
+		info, err := d.TableInfo(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Rows != int64(len(want)) {
+			t.Errorf("%s after reopen: TableInfo counts %d rows, want %d", name, info.Rows, len(want))
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package toydb_test
+
+import (
+	"iter"
+	"testing"
+	"time"
+
+	toydb "github.com/guiwoch/toyDB"
+)
+
+// rowsOf adapts seq for BulkLoad, recording the first error it yields in
+// *err and stopping there.
+func rowsOf(seq iter.Seq2[toydb.Row, error], err *error) iter.Seq[toydb.Row] {
+	return func(yield func(toydb.Row) bool) {
+		for row, e := range seq {
+			if e != nil {
+				*err = e
+				return
+			}
+			if !yield(row) {
+				return
+			}
+		}
+	}
+}
+
+func TestBulkLoadFromScan(t *testing.T) {
+	t.Parallel()
+	d, path := newTestDB(t)
+	src := newPeople(t, d)
+	schema := src.Schema()
+
+	load := func(t *testing.T, dst *toydb.Table) {
+		t.Helper()
+		var scanErr error
+		done := make(chan error, 1)
+		go func() { done <- dst.BulkLoad(rowsOf(src.Scan(nil, nil), &scanErr)) }()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("BulkLoad from a Scan of the same DB did not return")
+		}
+		if scanErr != nil {
+			t.Fatal(scanErr)
+		}
+	}
+
+	copied, err := d.CreateTable("copied", schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := copied.CreateIndex("by_city", "city"); err != nil {
+		t.Fatal(err)
+	}
+	load(t, copied)
+
+	// Inside a transaction, reading through a handle outside it.
+	if _, err := d.CreateTable("in_tx", schema); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := d.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inTx, err := tx.Table("in_tx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	load(t, inTx)
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	d = reopen(t, d, path)
+	want := []string{"1 paris 30", "2 lima 25", "3 null 40", "4 paris 20"}
+	for _, name := range []string{"copied", "in_tx"} {
+		tbl, err := d.OpenTable(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkRows(t, name+" Scan", tbl.Scan(nil, nil), want...)
+	}
+	copied, err = d.OpenTable("copied")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRows(t, "copied Lookup paris", copied.Lookup("by_city", toydb.TextValue("paris")),
+		"1 paris 30", "4 paris 20")
+	info, err := d.TableInfo("copied")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Rows != 4 {
+		t.Errorf("TableInfo rows = %d, want 4", info.Rows)
+	}
+}
@@ -2,16 +2,19 @@ package main
 
 import (
 	"bufio"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"io"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	toydb "github.com/guiwoch/toyDB"
 	"github.com/guiwoch/toyDB/internal/storage/pager"
+	"github.com/guiwoch/toyDB/query"
 )
 
 func main() {
@@ -43,6 +46,10 @@ func main() {
 }
 
 func repl(d *toydb.DB, in io.Reader, out io.Writer) {
+	// The SQL session outlives a line so that BEGIN and COMMIT can be
+	// typed separately.
+	sess := query.NewSession(d)
+	defer sess.Close()
 	scanner := bufio.NewScanner(in)
 	for {
 		fmt.Fprint(out, "toydb> ")
@@ -60,7 +67,16 @@ func repl(d *toydb.DB, in io.Reader, out io.Writer) {
 		if verb == "exit" || verb == "quit" {
 			return
 		}
-		if err := dispatch(d, verb, args, out); err != nil {
+		var err error
+		switch verb {
+		case "sql":
+			err = cmdSQL(sess, strings.TrimSpace(line[len(verb):]), out)
+		case "explain":
+			err = cmdExplain(sess, strings.TrimSpace(line[len(verb):]), out)
+		default:
+			err = dispatch(d, verb, args, out)
+		}
+		if err != nil {
 			fmt.Fprintln(os.Stderr, "error:", err)
 		}
 	}
@@ -71,11 +87,17 @@ func dispatch(d *toydb.DB, verb string, args []string, out io.Writer) error {
 	case "help":
 		return cmdHelp(out)
 	case "tables":
-		return cmdTables(d, out)
+		return cmdTables(d, args, out)
 	case "create":
 		return cmdCreate(d, args)
 	case "drop":
 		return cmdDrop(d, args)
+	case "truncate":
+		return cmdTruncate(d, args)
+	case "rename":
+		return cmdRename(d, args)
+	case "comment":
+		return cmdComment(d, args)
 	case "schema":
 		return cmdSchema(d, args, out)
 	case "insert":
@@ -84,8 +106,12 @@ func dispatch(d *toydb.DB, verb string, args []string, out io.Writer) error {
 		return cmdGet(d, args, out)
 	case "update":
 		return cmdUpdate(d, args)
+	case "upsert":
+		return cmdUpsert(d, args)
 	case "delete":
 		return cmdDelete(d, args)
+	case "deleterange":
+		return cmdDeleteRange(d, args, out)
 	case "scan":
 		return cmdScan(d, args, out)
 	case "scandesc":
@@ -97,49 +123,88 @@ func dispatch(d *toydb.DB, verb string, args []string, out io.Writer) error {
 
 func cmdHelp(out io.Writer) error {
 	fmt.Fprintln(out, `commands:
-  tables                                  list tables
-  create <name> <col:int|text|bool|timestamp> ... pk=<col>
+  tables [-v]                             list tables (-v: with row counts, times and comments)
+  create <name> <col:int|text|bool|timestamp|float|bytes|decimal|uuid|json> ... pk=<col>[,<col>...]
                                               example: create users id:int name:text pk=id
+                                              composite key: create visits user:int day:timestamp pk=user,day
                                               timestamp values: RFC3339 (e.g. 2026-04-30T14:00:00Z) or "now"
                                               bool values: true|false (or 1|0)
+                                              bytes values: hex (e.g. deadbeef); decimal values: up to 6 places (e.g. 12.34)
+                                              uuid values: canonical form or "new"; json values: a document without spaces
+                                              nullable column: append ? to its type (e.g. note:text?), then pass null
   drop <name>                             drop a table
+  truncate <name>                         delete every row, keeping the table
+  rename <name> <new name>                rename a table
+  comment <name> [<text> ...]             set a table's comment (no text clears it)
   schema <name>                           show table columns
   insert <table> <val> ...                insert a row
-  get <table> <key>                       fetch a row by primary key
+  get <table> <key> ...                   fetch a row by primary key (or its leading columns)
   update <table> <val> ...                update an existing row
-  delete <table> <key>                    delete a row by primary key
-  scan <table> [<lo> <hi>]                range scan, ascending (no bounds = all rows)
-  scandesc <table> [<lo> <hi>]            range scan, descending (no bounds = all rows)
+  upsert <table> <val> ...                insert a row, or replace the one with its key
+  delete <table> <key> ...                delete rows by primary key (or its leading columns)
+  deleterange <table> <lo> <hi>           delete rows with keys in [lo, hi); - leaves a bound open
+  scan <table> [<lo> <hi>] [where ...]    range scan, ascending (no bounds = all rows)
+  scandesc <table> [<lo> <hi>] [where ...]
+                                          range scan, descending (no bounds = all rows)
+                                              composite key bounds: comma-separated, e.g. 1,2026-01-01T00:00:00Z
+                                              filter: append where <col>=<val>, or where <col>.<path>=<val> on
+                                              json columns (e.g. where doc.tags[0]=red)
+  sql <statement>                         run a SQL statement, e.g. sql SELECT * FROM users WHERE id < 10
+                                              CREATE TABLE, CREATE INDEX, DROP TABLE, INSERT, SELECT
+                                              (with [LEFT] JOIN ... ON, GROUP BY and COUNT, SUM, MIN, MAX
+                                              and AVG), UPDATE, DELETE, EXPLAIN, BEGIN, COMMIT and ROLLBACK
+  explain <statement>                     show how a SQL statement would run, with estimated rows and pages
   help                                    this message
   exit                                    close and quit`)
 	return nil
 }
 
-func cmdTables(d *toydb.DB, out io.Writer) error {
+func cmdTables(d *toydb.DB, args []string, out io.Writer) error {
+	verbose := len(args) == 1 && args[0] == "-v"
+	if len(args) > 0 && !verbose {
+		return fmt.Errorf("usage: tables [-v]")
+	}
 	names, err := d.Tables()
 	if err != nil {
 		return err
 	}
 	for _, name := range names {
-		fmt.Fprintln(out, name)
+		if !verbose {
+			fmt.Fprintln(out, name)
+			continue
+		}
+		info, err := d.TableInfo(name)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s rows=%d created=%s modified=%s comment=%q\n",
+			name, info.Rows, formatTime(info.Created), formatTime(info.Modified), info.Comment)
 	}
 	return nil
 }
 
+// formatTime formats t as RFC3339, or - if it is zero.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
 func cmdCreate(d *toydb.DB, args []string) error {
 	if len(args) < 3 {
-		return fmt.Errorf("usage: create <name> <col:type> ... pk=<col>")
+		return fmt.Errorf("usage: create <name> <col:type> ... pk=<col>[,<col>...]")
 	}
 	name, rest := args[0], args[1:]
 
-	var pkName string
+	var pkNames []string
 	var columns []toydb.Column
 	for _, tok := range rest {
 		if pk, ok := strings.CutPrefix(tok, "pk="); ok {
-			if pkName != "" {
+			if pkNames != nil {
 				return fmt.Errorf("multiple pk= clauses")
 			}
-			pkName = pk
+			pkNames = strings.Split(pk, ",")
 			continue
 		}
 		col, err := parseColumn(tok)
@@ -149,21 +214,24 @@ func cmdCreate(d *toydb.DB, args []string) error {
 		columns = append(columns, col)
 	}
 
-	if pkName == "" {
+	if pkNames == nil {
 		return fmt.Errorf("missing pk=<col>")
 	}
-	pkIndex := -1
-	for i, c := range columns {
-		if c.Name == pkName {
-			pkIndex = i
-			break
+	pkIndexes := make([]int, len(pkNames))
+	for i, pkName := range pkNames {
+		pkIndexes[i] = -1
+		for j, c := range columns {
+			if c.Name == pkName {
+				pkIndexes[i] = j
+				break
+			}
+		}
+		if pkIndexes[i] == -1 {
+			return fmt.Errorf("pk %q is not among the columns", pkName)
 		}
-	}
-	if pkIndex == -1 {
-		return fmt.Errorf("pk %q is not among the columns", pkName)
 	}
 
-	s, err := toydb.NewSchema(pkIndex, columns)
+	s, err := toydb.NewCompositeSchema(pkIndexes, columns)
 	if err != nil {
 		return err
 	}
@@ -180,6 +248,27 @@ func cmdDrop(d *toydb.DB, args []string) error {
 	return d.DropTable(args[0])
 }
 
+func cmdTruncate(d *toydb.DB, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: truncate <name>")
+	}
+	return d.TruncateTable(args[0])
+}
+
+func cmdRename(d *toydb.DB, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: rename <name> <new name>")
+	}
+	return d.RenameTable(args[0], args[1])
+}
+
+func cmdComment(d *toydb.DB, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: comment <name> [<text> ...]")
+	}
+	return d.SetTableComment(args[0], strings.Join(args[1:], " "))
+}
+
 func cmdSchema(d *toydb.DB, args []string, out io.Writer) error {
 	if len(args) != 1 {
 		return fmt.Errorf("usage: schema <name>")
@@ -189,12 +278,17 @@ func cmdSchema(d *toydb.DB, args []string, out io.Writer) error {
 		return err
 	}
 	s := t.Schema()
-	pk := s.PrimaryKey()
+	pk := s.PrimaryKeyColumns()
 	for _, c := range s.Columns() {
 		marker := ""
-		if c.Name == pk {
+		if i := slices.Index(pk, c.Name); i >= 0 && len(pk) > 1 {
+			marker = fmt.Sprintf(" (pk %d)", i+1)
+		} else if i >= 0 {
 			marker = " (pk)"
 		}
+		if c.Nullable {
+			marker += " (nullable)"
+		}
 		fmt.Fprintf(out, "%s: %s%s\n", c.Name, typeName(c.Type), marker)
 	}
 	return nil
@@ -216,17 +310,24 @@ func cmdUpdate(d *toydb.DB, args []string) error {
 	return t.Update(row)
 }
 
+func cmdUpsert(d *toydb.DB, args []string) error {
+	t, row, err := openAndParseRow(d, args, "upsert")
+	if err != nil {
+		return err
+	}
+	return t.Upsert(row)
+}
+
 func cmdGet(d *toydb.DB, args []string, out io.Writer) error {
-	if len(args) != 2 {
-		return fmt.Errorf("usage: get <table> <key>")
+	if len(args) < 2 {
+		return fmt.Errorf("usage: get <table> <key> ...")
 	}
 	t, err := d.OpenTable(args[0])
 	if err != nil {
 		return err
 	}
 	s := t.Schema()
-	pkCol := pkColumn(s)
-	key, err := parseValue(pkCol, args[1])
+	key, err := parseKey(s, args[1:])
 	if err != nil {
 		return err
 	}
@@ -239,36 +340,70 @@ func cmdGet(d *toydb.DB, args []string, out io.Writer) error {
 }
 
 func cmdDelete(d *toydb.DB, args []string) error {
-	if len(args) != 2 {
-		return fmt.Errorf("usage: delete <table> <key>")
+	if len(args) < 2 {
+		return fmt.Errorf("usage: delete <table> <key> ...")
 	}
 	t, err := d.OpenTable(args[0])
 	if err != nil {
 		return err
 	}
-	key, err := parseValue(pkColumn(t.Schema()), args[1])
+	key, err := parseKey(t.Schema(), args[1:])
 	if err != nil {
 		return err
 	}
 	return t.Delete(key)
 }
 
+func cmdDeleteRange(d *toydb.DB, args []string, out io.Writer) error {
+	if len(args) != 3 {
+		return fmt.Errorf("usage: deleterange <table> <lo> <hi>")
+	}
+	t, err := d.OpenTable(args[0])
+	if err != nil {
+		return err
+	}
+	var bounds [2]toydb.Value
+	for i, arg := range args[1:] {
+		if arg == "-" {
+			continue
+		}
+		if bounds[i], err = parseKey(t.Schema(), strings.Split(arg, ",")); err != nil {
+			return err
+		}
+	}
+	n, err := t.DeleteRange(bounds[0], bounds[1])
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "deleted %d rows\n", n)
+	return nil
+}
+
 func cmdScan(d *toydb.DB, args []string, out io.Writer) error {
-	if len(args) != 1 && len(args) != 3 {
-		return fmt.Errorf("usage: scan <table> [<lo> <hi>]")
+	var where []string
+	if i := slices.Index(args, "where"); i >= 0 {
+		args, where = args[:i], args[i+1:]
+	}
+	if len(args) != 1 && len(args) != 3 || where != nil && len(where) != 1 {
+		return fmt.Errorf("usage: scan <table> [<lo> <hi>] [where <col>[.<path>]=<val>]")
 	}
 	t, err := d.OpenTable(args[0])
 	if err != nil {
 		return err
 	}
 	s := t.Schema()
+	match := func(toydb.Row) (bool, error) { return true, nil }
+	if where != nil {
+		if match, err = parseWhere(s, where[0]); err != nil {
+			return err
+		}
+	}
 	var lo, hi toydb.Value
 	if len(args) == 3 {
-		pkCol := pkColumn(s)
-		if lo, err = parseValue(pkCol, args[1]); err != nil {
+		if lo, err = parseKey(s, strings.Split(args[1], ",")); err != nil {
 			return err
 		}
-		if hi, err = parseValue(pkCol, args[2]); err != nil {
+		if hi, err = parseKey(s, strings.Split(args[2], ",")); err != nil {
 			return err
 		}
 	}
@@ -276,27 +411,40 @@ func cmdScan(d *toydb.DB, args []string, out io.Writer) error {
 		if err != nil {
 			return err
 		}
-		printRow(out, s, row)
+		if ok, err := match(row); err != nil {
+			return err
+		} else if ok {
+			printRow(out, s, row)
+		}
 	}
 	return nil
 }
 
 func cmdScanDesc(d *toydb.DB, args []string, out io.Writer) error {
-	if len(args) != 1 && len(args) != 3 {
-		return fmt.Errorf("usage: scandesc <table> [<lo> <hi>]")
+	var where []string
+	if i := slices.Index(args, "where"); i >= 0 {
+		args, where = args[:i], args[i+1:]
+	}
+	if len(args) != 1 && len(args) != 3 || where != nil && len(where) != 1 {
+		return fmt.Errorf("usage: scandesc <table> [<lo> <hi>] [where <col>[.<path>]=<val>]")
 	}
 	t, err := d.OpenTable(args[0])
 	if err != nil {
 		return err
 	}
 	s := t.Schema()
+	match := func(toydb.Row) (bool, error) { return true, nil }
+	if where != nil {
+		if match, err = parseWhere(s, where[0]); err != nil {
+			return err
+		}
+	}
 	var lo, hi toydb.Value
 	if len(args) == 3 {
-		pkCol := pkColumn(s)
-		if lo, err = parseValue(pkCol, args[1]); err != nil {
+		if lo, err = parseKey(s, strings.Split(args[1], ",")); err != nil {
 			return err
 		}
-		if hi, err = parseValue(pkCol, args[2]); err != nil {
+		if hi, err = parseKey(s, strings.Split(args[2], ",")); err != nil {
 			return err
 		}
 	}
@@ -304,11 +452,43 @@ func cmdScanDesc(d *toydb.DB, args []string, out io.Writer) error {
 		if err != nil {
 			return err
 		}
-		printRow(out, s, row)
+		if ok, err := match(row); err != nil {
+			return err
+		} else if ok {
+			printRow(out, s, row)
+		}
 	}
 	return nil
 }
 
+// parseWhere parses a scan filter, <col>=<val> or, for json columns,
+// <col>.<path>=<val>, into a function reporting whether a row matches. Values
+// are compared as printed, so strings inside json documents match unquoted.
+func parseWhere(s *toydb.Schema, token string) (func(toydb.Row) (bool, error), error) {
+	lhs, want, ok := strings.Cut(token, "=")
+	if !ok {
+		return nil, fmt.Errorf("expected <col>[.<path>]=<val>, got %q", token)
+	}
+	name, path, hasPath := strings.Cut(lhs, ".")
+	c := slices.IndexFunc(s.Columns(), func(col toydb.Column) bool { return col.Name == name })
+	if c < 0 {
+		return nil, fmt.Errorf("no column named %q", name)
+	}
+	if hasPath && s.Columns()[c].Type != toydb.TypeJSON {
+		return nil, fmt.Errorf("column %q: paths are only supported on json columns", name)
+	}
+	return func(row toydb.Row) (bool, error) {
+		v := row[c]
+		if doc, ok := v.(toydb.JSONValue); ok && hasPath {
+			var err error
+			if v, err = doc.Extract(path); err != nil {
+				return false, err
+			}
+		}
+		return fmt.Sprint(v) == want, nil
+	}, nil
+}
+
 func openAndParseRow(d *toydb.DB, args []string, verb string) (*toydb.Table, toydb.Row, error) {
 	if len(args) < 2 {
 		return nil, nil, fmt.Errorf("usage: %s <table> <val> ...", verb)
@@ -333,14 +513,23 @@ func openAndParseRow(d *toydb.DB, args []string, verb string) (*toydb.Table, toy
 	return t, row, nil
 }
 
-func pkColumn(s *toydb.Schema) toydb.Column {
-	pk := s.PrimaryKey()
-	for _, c := range s.Columns() {
-		if c.Name == pk {
-			return c
+// parseKey parses tokens as values for the leading primary key columns.
+func parseKey(s *toydb.Schema, tokens []string) (toydb.Key, error) {
+	pk := s.PrimaryKeyColumns()
+	if len(tokens) > len(pk) {
+		return nil, fmt.Errorf("primary key has %d columns, got %d values", len(pk), len(tokens))
+	}
+	cols := s.Columns()
+	key := make(toydb.Key, len(tokens))
+	for i, token := range tokens {
+		j := slices.IndexFunc(cols, func(c toydb.Column) bool { return c.Name == pk[i] })
+		v, err := parseValue(cols[j], token)
+		if err != nil {
+			return nil, err
 		}
+		key[i] = v
 	}
-	return toydb.Column{}
+	return key, nil
 }
 
 func parseColumn(token string) (toydb.Column, error) {
@@ -348,21 +537,37 @@ func parseColumn(token string) (toydb.Column, error) {
 	if !ok {
 		return toydb.Column{}, fmt.Errorf("expected name:type, got %q", token)
 	}
+	ty, nullable := strings.CutSuffix(ty, "?")
+	col := toydb.Column{Name: name, Nullable: nullable}
 	switch ty {
 	case "int":
-		return toydb.Column{Name: name, Type: toydb.TypeInt}, nil
+		col.Type = toydb.TypeInt
 	case "text":
-		return toydb.Column{Name: name, Type: toydb.TypeText}, nil
+		col.Type = toydb.TypeText
 	case "bool":
-		return toydb.Column{Name: name, Type: toydb.TypeBool}, nil
+		col.Type = toydb.TypeBool
 	case "timestamp":
-		return toydb.Column{Name: name, Type: toydb.TypeTimestamp}, nil
+		col.Type = toydb.TypeTimestamp
+	case "float":
+		col.Type = toydb.TypeFloat
+	case "bytes":
+		col.Type = toydb.TypeBytes
+	case "decimal":
+		col.Type = toydb.TypeDecimal
+	case "uuid":
+		col.Type = toydb.TypeUUID
+	case "json":
+		col.Type = toydb.TypeJSON
 	default:
-		return toydb.Column{}, fmt.Errorf("unknown type %q (want int, text, bool, or timestamp)", ty)
+		return toydb.Column{}, fmt.Errorf("unknown type %q (want int, text, bool, timestamp, float, bytes, decimal, uuid, or json)", ty)
 	}
+	return col, nil
 }
 
 func parseValue(col toydb.Column, token string) (toydb.Value, error) {
+	if col.Nullable && token == "null" {
+		return toydb.Null, nil
+	}
 	switch col.Type {
 	case toydb.TypeInt:
 		var n int64
@@ -387,11 +592,92 @@ func parseValue(col toydb.Column, token string) (toydb.Value, error) {
 			return nil, fmt.Errorf("column %q: %w", col.Name, err)
 		}
 		return toydb.TimestampValue(t.UnixNano()), nil
+	case toydb.TypeFloat:
+		f, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return nil, fmt.Errorf("column %q: %w", col.Name, err)
+		}
+		return toydb.FloatValue(f), nil
+	case toydb.TypeBytes:
+		b, err := hex.DecodeString(token)
+		if err != nil {
+			return nil, fmt.Errorf("column %q: %w", col.Name, err)
+		}
+		return toydb.BytesValue(b), nil
+	case toydb.TypeDecimal:
+		d, err := toydb.ParseDecimal(token)
+		if err != nil {
+			return nil, fmt.Errorf("column %q: %w", col.Name, err)
+		}
+		return d, nil
+	case toydb.TypeUUID:
+		if token == "new" {
+			return toydb.NewUUID(), nil
+		}
+		u, err := toydb.ParseUUID(token)
+		if err != nil {
+			return nil, fmt.Errorf("column %q: %w", col.Name, err)
+		}
+		return u, nil
+	case toydb.TypeJSON:
+		return toydb.JSONValue(token), nil
 	default:
 		return nil, fmt.Errorf("column %q: unsupported type", col.Name)
 	}
 }
 
+func cmdSQL(sess *query.Session, src string, out io.Writer) error {
+	if src == "" {
+		return fmt.Errorf("usage: sql <statement>")
+	}
+	stmt, err := query.Parse(src)
+	if err != nil {
+		return err
+	}
+	res, err := sess.ExecStmt(stmt)
+	if err != nil {
+		return err
+	}
+	switch stmt.(type) {
+	case *query.Insert, *query.Update, *query.Delete:
+		fmt.Fprintf(out, "%d rows affected\n", res.RowsAffected)
+	case *query.Explain:
+		for row, err := range res.Rows {
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(out, row[0])
+		}
+	case *query.Select:
+		n := 0
+		for row, err := range res.Rows {
+			if err != nil {
+				return err
+			}
+			parts := make([]string, len(row))
+			for i, v := range row {
+				parts[i] = fmt.Sprintf("%s=%v", res.Columns[i], v)
+			}
+			fmt.Fprintln(out, strings.Join(parts, " "))
+			n++
+		}
+		fmt.Fprintf(out, "(%d rows)\n", n)
+	}
+	return nil
+}
+
+func cmdExplain(sess *query.Session, src string, out io.Writer) error {
+	if src == "" {
+		return fmt.Errorf("usage: explain <statement>")
+	}
+	plan, err := sess.Explain(src)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, plan)
+	return nil
+}
+
 func printRow(out io.Writer, s *toydb.Schema, row toydb.Row) {
 	cols := s.Columns()
 	parts := make([]string, len(cols))
@@ -411,6 +697,16 @@ func typeName(t toydb.ColType) string {
 		return "bool"
 	case toydb.TypeTimestamp:
 		return "timestamp"
+	case toydb.TypeFloat:
+		return "float"
+	case toydb.TypeBytes:
+		return "bytes"
+	case toydb.TypeDecimal:
+		return "decimal"
+	case toydb.TypeUUID:
+		return "uuid"
+	case toydb.TypeJSON:
+		return "json"
 	default:
 		return fmt.Sprintf("unknown(%d)", t)
 	}
@@ -0,0 +1,166 @@
+package toydb
+
+import (
+	"errors"
+
+	"github.com/guiwoch/toyDB/internal/storage/btree"
+)
+
+// ErrCursorClosed is returned by operations on a [Cursor] after Close.
+var ErrCursorClosed = errors.New("cursor is closed")
+
+// Cursor is a position in a table's primary key order that moves in both
+// directions, for paging through rows without starting a new scan for each
+// page. Obtain one with [Table.Cursor] and close it when done.
+//
+// A cursor reads the same view a scan through its table handle would,
+// fixed when the cursor is created: outside a transaction, a snapshot of
+// the last commit, which is kept until Close. Through a [Tx] handle it
+// reads the live table, and after writing to the table through the
+// transaction it must be positioned again with Seek, First or Last. A
+// cursor from a [Snapshot] handle reads the snapshot, and its operations
+// return ErrSnapshotClosed once the snapshot is closed. A Cursor must be
+// used by one goroutine at a time.
+type Cursor struct {
+	t       *Table
+	v       *view
+	c       *btree.Cursor
+	release func() error
+}
+
+// Cursor returns an unpositioned cursor over the table's rows.
+func (t *Table) Cursor() (*Cursor, error) {
+	v, release, err := t.read()
+	if err != nil {
+		return nil, err
+	}
+	if t.snap != nil {
+		// Holding the snapshot's read lock until Close would block
+		// Snapshot.Close; each operation takes it instead.
+		if err := release(); err != nil {
+			return nil, err
+		}
+		release = func() error { return nil }
+	}
+	return &Cursor{t: t, v: v, c: v.tree.Cursor(), release: release}, nil
+}
+
+// Seek positions the cursor at the first row whose primary key is at least
+// key, given as for [Table.Get], and reports whether there is one. Given a
+// prefix of a composite key, that is the first row whose key starts with it
+// or sorts after it. If there is no such row the cursor is left
+// unpositioned. Returns ErrKeyTypeMismatch if a key value's type does not
+// match its column type.
+func (c *Cursor) Seek(key Value) (bool, error) {
+	done, err := c.check()
+	if err != nil {
+		return false, err
+	}
+	defer done()
+	if _, err := c.v.schema.validateKey(key); err != nil {
+		return false, err
+	}
+	return c.c.Seek(c.v.schema.encodeKeyFromValue(key))
+}
+
+// First positions the cursor at the table's first row, and reports whether
+// there is one.
+func (c *Cursor) First() (bool, error) {
+	done, err := c.check()
+	if err != nil {
+		return false, err
+	}
+	defer done()
+	return c.c.First()
+}
+
+// Last positions the cursor at the table's last row, and reports whether
+// there is one.
+func (c *Cursor) Last() (bool, error) {
+	done, err := c.check()
+	if err != nil {
+		return false, err
+	}
+	defer done()
+	return c.c.Last()
+}
+
+// Next moves the cursor to the following row and reports whether there is
+// one. At the last row it returns false and stays there, so Prev steps
+// back from it. An unpositioned cursor moves to the first row.
+func (c *Cursor) Next() (bool, error) {
+	done, err := c.check()
+	if err != nil {
+		return false, err
+	}
+	defer done()
+	return c.c.Next()
+}
+
+// Prev moves the cursor to the preceding row and reports whether there is
+// one. At the first row it returns false and stays there. An unpositioned
+// cursor moves to the last row.
+func (c *Cursor) Prev() (bool, error) {
+	done, err := c.check()
+	if err != nil {
+		return false, err
+	}
+	defer done()
+	return c.c.Prev()
+}
+
+// Row returns the row the cursor is at. Returns ErrNotFound if the cursor
+// is not positioned.
+func (c *Cursor) Row() (Row, error) {
+	done, err := c.check()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	if !c.c.Valid() {
+		return nil, ErrNotFound
+	}
+	pk, err := c.v.schema.decodeKey(c.c.Key())
+	if err != nil {
+		return nil, err
+	}
+	val, err := c.c.Value()
+	if err != nil {
+		return nil, err
+	}
+	return c.v.schema.decodeRow(pk, val)
+}
+
+// Close releases the cursor's pinned page and the view it reads. Later
+// operations return ErrCursorClosed; closing again returns nil.
+func (c *Cursor) Close() error {
+	if c.release == nil {
+		return nil
+	}
+	c.c.Close()
+	release := c.release
+	c.release = nil
+	return release()
+}
+
+// check returns the error, if any, that operations on the cursor fail with.
+// Otherwise it returns the function to call when the operation is done,
+// which for a cursor from a snapshot handle releases the snapshot's read
+// lock, held so that the snapshot is not closed mid-operation.
+func (c *Cursor) check() (done func(), err error) {
+	if c.release == nil {
+		return nil, ErrCursorClosed
+	}
+	if c.t.tx != nil && c.t.tx.done {
+		return nil, ErrTxDone
+	}
+	if snap := c.t.snap; snap != nil {
+		snap.mu.RLock()
+		if snap.closed {
+			snap.mu.RUnlock()
+			return nil, ErrSnapshotClosed
+		}
+		return snap.mu.RUnlock, nil
+	}
+	return func() {}, nil
+}
@@ -0,0 +1,218 @@
+package toydb_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	toydb "github.com/guiwoch/toyDB"
+)
+
+// within runs fn and returns its error, failing the test if it has not
+// returned after a few seconds.
+func within(t *testing.T, what string, fn func() error) error {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s did not return", what)
+		return nil
+	}
+}
+
+// newNumbers creates a table keyed by the given ids.
+func newNumbers(t *testing.T, d *toydb.DB, ids ...int) *toydb.Table {
+	t.Helper()
+	tbl, err := d.CreateTable("numbers", mustSchema(t, 0, toydb.Column{Name: "id", Type: toydb.TypeInt}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if err := tbl.Insert(toydb.Row{toydb.IntValue(id)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tbl
+}
+
+// checkAt fails the test unless a cursor move reported ok and left the
+// cursor at the row with the given id, or, for id 0, reported no row.
+func checkAt(t *testing.T, c *toydb.Cursor, what string, ok bool, err error, id int) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %v", what, err)
+	}
+	if ok != (id != 0) {
+		t.Fatalf("%s reported %v", what, ok)
+	}
+	if id == 0 {
+		return
+	}
+	row, err := c.Row()
+	if err != nil {
+		t.Fatalf("%s: Row: %v", what, err)
+	}
+	if got := formatRow(row); got != formatRow(toydb.Row{toydb.IntValue(id)}) {
+		t.Errorf("%s: at %s, want %d", what, got, id)
+	}
+}
+
+func TestCursor(t *testing.T) {
+	t.Parallel()
+	d, _ := newTestDB(t)
+	tbl := newNumbers(t, d, 10, 20, 30, 40)
+
+	c, err := tbl.Cursor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Row(); !errors.Is(err, toydb.ErrNotFound) {
+		t.Errorf("Row of an unpositioned cursor: got %v, want ErrNotFound", err)
+	}
+	ok, err := c.Next()
+	checkAt(t, c, "Next from unpositioned", ok, err, 10)
+	ok, err = c.Seek(toydb.IntValue(20))
+	checkAt(t, c, "Seek(20)", ok, err, 20)
+	ok, err = c.Seek(toydb.IntValue(25))
+	checkAt(t, c, "Seek(25)", ok, err, 30)
+	ok, err = c.Next()
+	checkAt(t, c, "Next", ok, err, 40)
+	ok, err = c.Next()
+	checkAt(t, c, "Next past the end", ok, err, 0)
+	ok, err = c.Prev()
+	checkAt(t, c, "Prev from the end", ok, err, 30)
+	ok, err = c.First()
+	checkAt(t, c, "First", ok, err, 10)
+	ok, err = c.Prev()
+	checkAt(t, c, "Prev past the start", ok, err, 0)
+	ok, err = c.Last()
+	checkAt(t, c, "Last", ok, err, 40)
+	ok, err = c.Seek(toydb.IntValue(50))
+	checkAt(t, c, "Seek(50)", ok, err, 0)
+	if _, err := c.Row(); !errors.Is(err, toydb.ErrNotFound) {
+		t.Errorf("Row after a Seek past the end: got %v, want ErrNotFound", err)
+	}
+	ok, err = c.Prev()
+	checkAt(t, c, "Prev from unpositioned", ok, err, 40)
+	if _, err := c.Seek(toydb.TextValue("20")); !errors.Is(err, toydb.ErrKeyTypeMismatch) {
+		t.Errorf("Seek with text: got %v, want ErrKeyTypeMismatch", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Next(); !errors.Is(err, toydb.ErrCursorClosed) {
+		t.Errorf("Next after Close: got %v, want ErrCursorClosed", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+}
+
+func TestCursorViews(t *testing.T) {
+	t.Parallel()
+	d, _ := newTestDB(t)
+	tbl := newNumbers(t, d, 10, 20)
+
+	// Outside a transaction a cursor reads the last commit before it was
+	// made, and does not hold up writers.
+	c, err := tbl.Cursor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	err = within(t, "Insert with a cursor open", func() error {
+		return tbl.Insert(toydb.Row{toydb.IntValue(15)})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := c.Seek(toydb.IntValue(11))
+	checkAt(t, c, "Seek(11) from before the insert", ok, err, 20)
+
+	// Through a transaction it reads the live table, uncommitted rows
+	// included.
+	tx, err := d.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	txTbl, err := tx.Table("numbers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := txTbl.Insert(toydb.Row{toydb.IntValue(12)}); err != nil {
+		t.Fatal(err)
+	}
+	tc, err := txTbl.Cursor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tc.Close()
+	ok, err = tc.Seek(toydb.IntValue(11))
+	checkAt(t, tc, "Seek(11) in the transaction", ok, err, 12)
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tc.Next(); !errors.Is(err, toydb.ErrTxDone) {
+		t.Errorf("Next after Rollback: got %v, want ErrTxDone", err)
+	}
+
+	// A snapshot's cursor reads the snapshot.
+	snap, err := d.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Close()
+	snapTbl, err := snap.Table("numbers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tbl.Delete(toydb.IntValue(15)); err != nil {
+		t.Fatal(err)
+	}
+	sc, err := snapTbl.Cursor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+	ok, err = sc.Seek(toydb.IntValue(11))
+	checkAt(t, sc, "Seek(11) in the snapshot", ok, err, 15)
+}
+
+func TestCursorSnapshotClose(t *testing.T) {
+	t.Parallel()
+	d, _ := newTestDB(t)
+	newNumbers(t, d, 10, 20)
+	snap, err := d.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl, err := snap.Table("numbers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := tbl.Cursor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := c.First()
+	checkAt(t, c, "First", ok, err, 10)
+
+	if err := within(t, "Snapshot.Close with a cursor open", snap.Close); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Next(); !errors.Is(err, toydb.ErrSnapshotClosed) {
+		t.Errorf("Next after Snapshot.Close: got %v, want ErrSnapshotClosed", err)
+	}
+	if _, err := c.Row(); !errors.Is(err, toydb.ErrSnapshotClosed) {
+		t.Errorf("Row after Snapshot.Close: got %v, want ErrSnapshotClosed", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("Close after Snapshot.Close: %v", err)
+	}
+}
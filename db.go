@@ -5,18 +5,33 @@
 // Users define a [Schema] (columns plus a primary key), create a [Table]
 // via [DB.CreateTable], and operate on rows through [Table.Insert],
 // [Table.Get], [Table.Update], [Table.Delete], and the [Table.Scan] /
-// [Table.ScanDescending] iterators.
+// [Table.ScanDescending] iterators. [Table.CreateIndex] adds a secondary
+// index, queried with [Table.Lookup] and [Table.ScanIndex].
 //
-// Not yet supported: concurrent access, transactions, crash
-// recovery, SQL. The DB is single-process, single-threaded, and durable
-// only at [DB.Close]. See [Value] for the closed set of supported column
-// types.
+// Every successful write is committed to a write-ahead log before it
+// returns, and [Open] replays the log after a crash, so a killed process
+// reopens to its last committed write.
+//
+// Writes are committed one at a time unless grouped with [DB.Begin], whose
+// [Tx] applies them all at [Tx.Commit] or none at [Tx.Rollback].
+//
+// A DB is safe for concurrent use by multiple goroutines. Writes, and
+// transactions as a whole, are serialized, but reads never wait for them:
+// each read outside a transaction sees the last commit as of when it
+// started. [DB.Snapshot] holds such a view across many reads.
+//
+// Package query runs a subset of SQL on top of this API.
+//
+// Not yet supported: multi-process access. See [Value] for the closed set of
+// supported column types.
 package toydb
 
 import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/guiwoch/toyDB/internal/storage/btree"
 	"github.com/guiwoch/toyDB/internal/storage/catalog"
@@ -26,7 +41,7 @@ import (
 
 const (
 	magicNumber    = 0x54444231 // "TDB1"
-	currentVersion = 1
+	currentVersion = 5
 	headerSize     = 20
 )
 
@@ -59,7 +74,7 @@ func decodeHeader(buf []byte) (dbHeader, error) {
 	if h.magic != magicNumber {
 		return dbHeader{}, fmt.Errorf("bad db magic: 0x%08x", h.magic)
 	}
-	if h.version != currentVersion {
+	if h.version < minVersion || h.version > currentVersion {
 		return dbHeader{}, fmt.Errorf("unsupported db version: %d", h.version)
 	}
 	return h, nil
@@ -69,25 +84,58 @@ func decodeHeader(buf []byte) (dbHeader, error) {
 // with errors.Is. Wrapped errors carry a human-readable detail in their
 // message but preserve the sentinel for matching.
 var (
-	// ErrTableExists is returned by CreateTable when a table with the same
-	// name already exists.
+	// ErrTableExists is returned by CreateTable and RenameTable when a
+	// table with the same name already exists.
 	ErrTableExists = errors.New("table already exists")
 
-	// ErrTableNotFound is returned by OpenTable and DropTable when no
-	// table with the given name exists.
+	// ErrTableNotFound is returned by OpenTable, DropTable and the other
+	// DB methods taking a table name when no table with that name exists.
 	ErrTableNotFound = errors.New("table not found")
 
-	// ErrNotFound is returned by Get when no row matches the given key.
+	// ErrNotFound is returned by Get when no row matches the given key, and
+	// by Update and CompareAndSwap when no row has the given row's key.
 	ErrNotFound = errors.New("row not found")
 
-	// ErrSchemaMismatch is returned by Insert and Update when a row's
-	// length or column types do not match the table schema.
+	// ErrDuplicateKey is returned by Insert when the table already has a
+	// row with the same primary key.
+	ErrDuplicateKey = errors.New("row with this primary key already exists")
+
+	// ErrNotSorted is returned by BulkLoad when its rows are not in
+	// ascending primary key order.
+	ErrNotSorted = errors.New("rows are not in ascending primary key order")
+
+	// ErrSchemaMismatch is returned by Insert, Update, and the other row
+	// writes when a row's length or column types do not match the table
+	// schema, and by NewTypedTable when a struct does not map to it.
 	ErrSchemaMismatch = errors.New("row does not match schema")
 
 	// ErrKeyTypeMismatch is returned by Get, Delete, Scan, and
 	// ScanDescending when a key argument's runtime type does not match
-	// the primary key column type.
-	ErrKeyTypeMismatch = errors.New("key value type does not match primary key column type")
+	// the primary key column type, and by Lookup and ScanIndex when a value
+	// does not match its indexed column's type.
+	ErrKeyTypeMismatch = errors.New("key value type does not match column type")
+
+	// ErrTxDone is returned by operations on a transaction, or on a table
+	// handle obtained from it, after Commit or Rollback.
+	ErrTxDone = errors.New("transaction has already been committed or rolled back")
+
+	// ErrIndexExists is returned by CreateIndex when the table already has
+	// an index with the same name.
+	ErrIndexExists = errors.New("index already exists")
+
+	// ErrIndexNotFound is returned by DropIndex, Lookup, and ScanIndex when
+	// the table has no index with the given name.
+	ErrIndexNotFound = errors.New("index not found")
+
+	// ErrSchemaChangeInTx is returned by schema changes, such as
+	// CreateIndex, made through a transaction's table handle. They commit
+	// on their own and cannot be part of a transaction.
+	ErrSchemaChangeInTx = errors.New("schema changes cannot be made inside a transaction")
+
+	// ErrKeyTooLarge is returned by Insert and Update when a row's encoded
+	// primary key, or one of its index entries, is too long to store.
+	// Non-key columns may be of any length.
+	ErrKeyTooLarge = btree.ErrKeyTooLarge
 )
 
 // Option configures optional DB behavior.
@@ -108,15 +156,27 @@ func WithCacheSize(n int) Option {
 
 // DB is a handle to an open database file.
 type DB struct {
+	// writer is held by the one write transaction allowed at a time: a
+	// single autocommitted write, or a Tx from Begin until it ends.
+	writer sync.Mutex
+	// mu guards the fields below and every open table's tree. Readers
+	// hold it shared; a writer holds it exclusively while it modifies
+	// pages, commits, or rolls back.
+	mu sync.RWMutex
+
 	pager   *pager.Pager
 	catalog *catalog.Catalog
 	header  dbHeader
 	open    map[string]*Table
+	tx      *Tx
 }
 
 // Open opens the DB at path, creating a new file if none exists. The
-// returned DB must be closed with Close to persist any changes; durability
-// is guaranteed by Close, not by individual writes.
+// write-ahead log at path+"-wal" is replayed first, so a DB that was not
+// closed cleanly reopens to its last committed write. A file written in an
+// older format is then migrated to the current one in a single commit. The
+// returned DB should be closed with Close to fold the log back into the
+// file.
 func Open(path string, opts ...Option) (*DB, error) {
 	var o options
 	for _, opt := range opts {
@@ -131,14 +191,7 @@ func Open(path string, opts ...Option) (*DB, error) {
 		open:  make(map[string]*Table),
 	}
 	if fresh {
-		root, err := p.Allocate(page.TypeLeaf)
-		if err != nil {
-			p.Close()
-			return nil, err
-		}
-		rootID := root.PageID()
-		p.Unpin(rootID)
-		tree, err := btree.Open(p, rootID)
+		tree, err := d.newTree()
 		if err != nil {
 			p.Close()
 			return nil, err
@@ -147,15 +200,11 @@ func Open(path string, opts ...Option) (*DB, error) {
 		d.header = dbHeader{
 			magic:         magicNumber,
 			version:       currentVersion,
-			catalogRootID: rootID,
+			catalogRootID: tree.RootID(),
 		}
-		// Write an initial durable state so a crash before Close still leaves
-		// the file with a valid header and catalog root.
-		if err := p.Flush(); err != nil {
-			p.Close()
-			return nil, err
-		}
-		if err := p.WritePage0(d.header.encode()); err != nil {
+		// Commit an initial state so a crash before Close still leaves the
+		// file with a valid header and catalog root.
+		if err := d.commit(); err != nil {
 			p.Close()
 			return nil, err
 		}
@@ -180,55 +229,135 @@ func Open(path string, opts ...Option) (*DB, error) {
 		return nil, err
 	}
 	d.catalog = catalog.Open(tree)
+	switch {
+	case h.version < currentVersion:
+		if err := d.migrate(); err != nil {
+			p.Close()
+			return nil, err
+		}
+	case p.Recovered():
+		if err := d.recount(); err != nil {
+			p.Close()
+			return nil, err
+		}
+	}
 	return d, nil
 }
 
 // CreateTable creates a new table with the given schema.
 // Returns [ErrTableExists] if a table with that name already exists.
 func (d *DB) CreateTable(name string, s *Schema) (*Table, error) {
-	if _, ok, err := d.catalog.Lookup(name); err != nil {
+	if err := checkTableName("create table", name); err != nil {
 		return nil, err
-	} else if ok {
-		return nil, ErrTableExists
 	}
-	root, err := d.pager.Allocate(page.TypeLeaf)
+	var t *Table
+	err := d.autocommit(func() error {
+		if _, ok, err := d.catalog.Lookup(name); err != nil {
+			return err
+		} else if ok {
+			return ErrTableExists
+		}
+		tree, err := d.newTree()
+		if err != nil {
+			return err
+		}
+		rootID := tree.RootID()
+		meta := &tableMeta{created: time.Now().UnixNano()}
+		t = &Table{db: d, name: name, schema: s, tree: tree, meta: meta, savedRootID: rootID}
+		if err := d.catalog.Upsert(name, t.catalogRow()); err != nil {
+			return err
+		}
+		d.open[name] = t
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	rootID := root.PageID()
-	d.pager.Unpin(rootID)
-	tree, err := btree.Open(d.pager, rootID)
-	if err != nil {
-		return nil, err
+	return t, nil
+}
+
+// checkTableName returns an error if name cannot name a table: it keys the
+// table's catalog row, so it must be a valid, non-empty key.
+func checkTableName(op, name string) error {
+	if name == "" || len(name) > btree.MaxKeySize {
+		return fmt.Errorf("%s: name must be 1 to %d bytes, got %d", op, btree.MaxKeySize, len(name))
 	}
-	if err := d.catalog.Upsert(name, catalog.Row{
-		RootID:      rootID,
-		SchemaBytes: s.marshal(),
-	}); err != nil {
+	return nil
+}
+
+// newTree allocates an empty B+tree.
+func (d *DB) newTree() (*btree.Btree, error) {
+	root, err := d.pager.Allocate(page.TypeLeaf)
+	if err != nil {
 		return nil, err
 	}
-	t := &Table{name: name, schema: s, tree: tree}
-	d.open[name] = t
-	return t, nil
+	rootID := root.PageID()
+	d.pager.Unpin(rootID)
+	return btree.Open(d.pager, rootID)
 }
 
 // DropTable removes a table and frees its pages. Returns ErrTableNotFound
 // if no table with the given name exists.
 func (d *DB) DropTable(name string) error {
-	table, err := d.OpenTable(name)
-	if err != nil {
-		return err
-	}
-	if err := d.catalog.Delete(name); err != nil {
-		return err
-	}
-	delete(d.open, name)
-	return table.tree.Destroy()
+	return d.autocommit(func() error {
+		table, err := d.openTable(name)
+		if err != nil {
+			return err
+		}
+		if err := d.catalog.Delete(name); err != nil {
+			return err
+		}
+		delete(d.open, name)
+		for _, idx := range table.indexes {
+			if err := idx.tree.Destroy(); err != nil {
+				return err
+			}
+		}
+		return table.tree.Destroy()
+	})
+}
+
+// TruncateTable removes every row from a table, freeing its pages and
+// those of its indexes, and leaves it with empty trees. Unlike dropping and
+// recreating it, the table keeps its schema, indexes and catalog entry, and
+// open handles to it stay valid. Returns ErrTableNotFound if no table with
+// the given name exists.
+func (d *DB) TruncateTable(name string) error {
+	return d.autocommit(func() error {
+		table, err := d.openTable(name)
+		if err != nil {
+			return err
+		}
+		for _, idx := range table.indexes {
+			if err := idx.tree.Truncate(); err != nil {
+				return err
+			}
+		}
+		if err := table.tree.Truncate(); err != nil {
+			return err
+		}
+		// The new roots reach the catalog when the change commits.
+		table.touch(-int(table.meta.rows))
+		return nil
+	})
 }
 
 // OpenTable returns the Table for an existing table name, caching it for the
 // remainder of the DB's lifetime.
 func (d *DB) OpenTable(name string) (*Table, error) {
+	d.mu.RLock()
+	t, ok := d.open[name]
+	d.mu.RUnlock()
+	if ok {
+		return t, nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.openTable(name)
+}
+
+// openTable is OpenTable for callers that hold mu exclusively.
+func (d *DB) openTable(name string) (*Table, error) {
 	if t, ok := d.open[name]; ok {
 		return t, nil
 	}
@@ -239,7 +368,7 @@ func (d *DB) OpenTable(name string) (*Table, error) {
 	if !ok {
 		return nil, ErrTableNotFound
 	}
-	s, err := unmarshalSchema(row.SchemaBytes)
+	s, err := tableSchema(row)
 	if err != nil {
 		return nil, err
 	}
@@ -247,29 +376,148 @@ func (d *DB) OpenTable(name string) (*Table, error) {
 	if err != nil {
 		return nil, err
 	}
-	t := &Table{name: name, schema: s, tree: tree}
+	indexes, err := openIndexes(row, func(rootID uint32) (*btree.Btree, error) {
+		return btree.Open(d.pager, rootID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	t := &Table{db: d, name: name, schema: s, tree: tree, indexes: indexes, meta: metaFromRow(row), savedRootID: row.RootID, savedName: name}
+	t.savedMeta = *t.meta
 	d.open[name] = t
 	return t, nil
 }
 
-// Close persists the catalog and header, then closes the underlying file.
-// Any table whose root changed during the session is re-upserted first.
-func (d *DB) Close() error {
+// tableSchema returns the schema recorded in a table's catalog row.
+func tableSchema(row catalog.Row) (*Schema, error) {
+	s, err := unmarshalSchema(row.SchemaBytes)
+	if err != nil {
+		return nil, err
+	}
+	s.version = row.SchemaVersion
+	return s, nil
+}
+
+// commit makes every change since the previous commit durable. Any table
+// whose root, or an index's, moved is re-upserted into the catalog first, so
+// the committed header always points at a catalog that matches the
+// committed trees. Row counts are recorded too if the commit is going to
+// checkpoint, since Open only recounts the rows of a file that was not
+// checkpointed.
+func (d *DB) commit() error {
+	if err := d.record(func(t *Table) bool { return t.moved() || t.meta.dirty }); err != nil {
+		return err
+	}
+	if d.pager.CheckpointDue() {
+		if err := d.record(func(t *Table) bool { return t.meta.touched }); err != nil {
+			return err
+		}
+	}
+	h := d.header
+	h.catalogRootID = d.catalog.RootID()
+	h.freeListHead = d.pager.FreeListHead()
+	if err := d.pager.Commit(h.encode()); err != nil {
+		return err
+	}
+	d.header = h
+	for _, t := range d.open {
+		t.saved()
+	}
+	return nil
+}
+
+// record re-upserts the catalog rows of the open tables for which want
+// returns true.
+func (d *DB) record(want func(*Table) bool) error {
 	for name, t := range d.open {
-		if err := d.catalog.Upsert(name, catalog.Row{
-			RootID:      t.tree.RootID(),
-			SchemaBytes: t.schema.marshal(),
-		}); err != nil {
+		if !want(t) {
+			continue
+		}
+		if err := d.catalog.Upsert(name, t.catalogRow()); err != nil {
+			return err
+		}
+		t.meta.dirty, t.meta.touched = false, false
+	}
+	return nil
+}
+
+// rollback discards every change since the previous commit. The pager drops
+// the modified pages, then the catalog and every open table are pointed back
+// at their committed names, roots and schemas; tables and indexes created
+// since the commit are forgotten.
+func (d *DB) rollback() error {
+	if err := d.pager.Rollback(); err != nil {
+		return err
+	}
+	if err := d.catalog.Reopen(d.header.catalogRootID); err != nil {
+		return err
+	}
+	open := d.open
+	d.open = make(map[string]*Table, len(open))
+	for _, t := range open {
+		row, ok, err := d.catalog.Lookup(t.savedName)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		t.name = t.savedName
+		d.open[t.name] = t
+		if err := t.tree.Reopen(row.RootID); err != nil {
+			return err
+		}
+		t.savedRootID = row.RootID
+		*t.meta = t.savedMeta
+		if row.SchemaVersion != t.schema.version {
+			if t.schema, err = tableSchema(row); err != nil {
+				return err
+			}
+		}
+		if err := t.reopenIndexes(row); err != nil {
 			return err
 		}
 	}
-	d.header.catalogRootID = d.catalog.RootID()
-	d.header.freeListHead = d.pager.FreeListHead()
+	return nil
+}
 
-	if err := d.pager.Flush(); err != nil {
+// autocommit runs fn as a transaction of its own: its changes are committed
+// if it succeeds and rolled back if it, or the commit, fails. It waits for
+// any open Tx to end.
+func (d *DB) autocommit(fn func() error) error {
+	d.writer.Lock()
+	defer d.writer.Unlock()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	err := fn()
+	if err == nil {
+		err = d.commit()
+	}
+	if err != nil {
+		if rbErr := d.rollback(); rbErr != nil {
+			return errors.Join(err, rbErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// Close commits any outstanding changes, folds the write-ahead log into the
+// database file, and closes it. An open transaction is rolled back first.
+// Close waits for in-flight operations; none may start after it is called.
+func (d *DB) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.tx != nil {
+		d.tx.end()
+		if err := d.rollback(); err != nil {
+			return err
+		}
+	}
+	if err := d.record(func(t *Table) bool { return t.meta.touched }); err != nil {
 		return err
 	}
-	if err := d.pager.WritePage0(d.header.encode()); err != nil {
+	if err := d.commit(); err != nil {
 		return err
 	}
 	return d.pager.Close()
@@ -277,6 +525,8 @@ func (d *DB) Close() error {
 
 // Tables returns the names of all tables in the DB, in unspecified order.
 func (d *DB) Tables() ([]string, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
 	return d.catalog.Names()
 }
 
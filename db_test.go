@@ -0,0 +1,79 @@
+package toydb_test
+
+import (
+	"fmt"
+	"iter"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	toydb "github.com/guiwoch/toyDB"
+)
+
+// openTestDB opens the DB at path, closing it on test cleanup unless the
+// test closed it first.
+func openTestDB(t *testing.T, path string) *toydb.DB {
+	t.Helper()
+	d, err := toydb.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = d.Close() })
+	return d
+}
+
+// newTestDB opens a fresh DB in a temp dir and returns it and its path.
+func newTestDB(t *testing.T) (*toydb.DB, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.db")
+	return openTestDB(t, path), path
+}
+
+// reopen closes d and opens the DB at path again.
+func reopen(t *testing.T, d *toydb.DB, path string) *toydb.DB {
+	t.Helper()
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return openTestDB(t, path)
+}
+
+// mustSchema returns the schema keyed by the column at pk.
+func mustSchema(t *testing.T, pk int, columns ...toydb.Column) *toydb.Schema {
+	t.Helper()
+	s, err := toydb.NewSchema(pk, columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// collect formats the rows of seq, each as its values separated by spaces.
+func collect(t *testing.T, seq iter.Seq2[toydb.Row, error]) []string {
+	t.Helper()
+	var rows []string
+	for row, err := range seq {
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, formatRow(row))
+	}
+	return rows
+}
+
+func formatRow(row toydb.Row) string {
+	fields := make([]string, len(row))
+	for i, v := range row {
+		fields[i] = fmt.Sprint(v)
+	}
+	return strings.Join(fields, " ")
+}
+
+// checkRows fails the test if seq does not yield want, in order.
+func checkRows(t *testing.T, what string, seq iter.Seq2[toydb.Row, error], want ...string) {
+	t.Helper()
+	if got := collect(t, seq); !slices.Equal(got, want) {
+		t.Errorf("%s\n got %q\nwant %q", what, got, want)
+	}
+}
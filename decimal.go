@@ -0,0 +1,85 @@
+package toydb
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DecimalScale is the number of digits after the point a [DecimalValue]
+// holds.
+const DecimalScale = 6
+
+// decimalUnit is the DecimalValue of 1.
+const decimalUnit = 1_000_000
+
+// DecimalValue is the [Value] for [TypeDecimal] columns: a fixed-point
+// number held as an integer count of millionths, so that 12.34 is
+// DecimalValue(12_340_000). Arithmetic on the integer is exact, and its
+// range is about ±9.2 trillion. Use [ParseDecimal] to convert from text.
+type DecimalValue int64
+
+// ParseDecimal parses a decimal number such as "12.34", "-0.5", or "7".
+// It returns an error if s has more than [DecimalScale] digits after the
+// point or is out of range.
+func ParseDecimal(s string) (DecimalValue, error) {
+	text := s
+	neg := false
+	if rest, ok := strings.CutPrefix(text, "-"); ok {
+		neg, text = true, rest
+	} else if rest, ok := strings.CutPrefix(text, "+"); ok {
+		text = rest
+	}
+	whole, frac, _ := strings.Cut(text, ".")
+	if whole == "" && frac == "" {
+		return 0, fmt.Errorf("parse decimal %q: no digits", s)
+	}
+	if len(frac) > DecimalScale {
+		return 0, fmt.Errorf("parse decimal %q: more than %d digits after the point", s, DecimalScale)
+	}
+	for _, part := range []string{whole, frac} {
+		for _, r := range part {
+			if r < '0' || r > '9' {
+				return 0, fmt.Errorf("parse decimal %q: invalid character %q", s, r)
+			}
+		}
+	}
+
+	var units uint64
+	if whole != "" {
+		n, err := strconv.ParseUint(whole, 10, 64)
+		if err != nil || n > math.MaxInt64/decimalUnit+1 {
+			return 0, fmt.Errorf("parse decimal %q: out of range", s)
+		}
+		units = n * decimalUnit
+	}
+	if frac != "" {
+		n, _ := strconv.ParseUint(frac+strings.Repeat("0", DecimalScale-len(frac)), 10, 64)
+		units += n
+	}
+	// A negative value may reach one unit further than a positive one.
+	if units > math.MaxInt64+1 || (!neg && units > math.MaxInt64) {
+		return 0, fmt.Errorf("parse decimal %q: out of range", s)
+	}
+	if neg {
+		return DecimalValue(-units), nil
+	}
+	return DecimalValue(units), nil
+}
+
+// String formats the decimal without trailing zeros after the point, so
+// %v output is human-readable.
+func (v DecimalValue) String() string {
+	units := uint64(v)
+	sign := ""
+	if v < 0 {
+		sign, units = "-", -units
+	}
+	whole := strconv.FormatUint(units/decimalUnit, 10)
+	frac := strings.TrimRight(fmt.Sprintf("%0*d", DecimalScale, units%decimalUnit), "0")
+	if frac == "" {
+		return sign + whole
+	}
+	return sign + whole + "." + frac
+}
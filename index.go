@@ -0,0 +1,426 @@
+package toydb
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+
+	"github.com/guiwoch/toyDB/internal/storage/btree"
+	"github.com/guiwoch/toyDB/internal/storage/catalog"
+)
+
+// maxIndexes bounds the number of secondary indexes per table, and the
+// number of columns per index. The catalog encodes both counts in a single
+// byte.
+const maxIndexes = 255
+
+// index is a secondary index: a B+tree whose keys are the encoded values of
+// the indexed columns followed by the encoded primary key, with empty
+// values. Appending the primary key makes every entry unique, so many rows
+// may share the same indexed values, and lets a lookup fetch the row from
+// the table's tree.
+type index struct {
+	name    string
+	columns []int // schema positions of the indexed columns, in key order
+	tree    *btree.Btree
+
+	// savedRootID is the index root recorded in the catalog as of the last
+	// commit.
+	savedRootID uint32
+}
+
+// Presence bytes precede the value of a nullable column in an index key, so
+// that nulls sort before every value.
+const (
+	indexNull    = 0x00
+	indexPresent = 0x01
+)
+
+// encodeIndexValue appends the index key encoding of v, a value of column c.
+func (s *Schema) encodeIndexValue(dst []byte, c int, v Value) []byte {
+	if !s.columns[c].Nullable {
+		return v.encode(dst)
+	}
+	if _, ok := v.(NullValue); ok {
+		return append(dst, indexNull)
+	}
+	return v.encode(append(dst, indexPresent))
+}
+
+// entryKey returns the index key for row.
+func (idx *index) entryKey(s *Schema, row Row) []byte {
+	var key []byte
+	for _, c := range idx.columns {
+		key = s.encodeIndexValue(key, c, row[c])
+	}
+	return append(key, s.encodeKeyFromRow(row)...)
+}
+
+// primaryKey returns the encoded primary key at the end of an index key.
+func (idx *index) primaryKey(s *Schema, key []byte) ([]byte, error) {
+	offset := 0
+	for _, c := range idx.columns {
+		if s.columns[c].Nullable {
+			if offset == len(key) {
+				return nil, fmt.Errorf("index %q: key truncated", idx.name)
+			}
+			offset++
+			if key[offset-1] == indexNull {
+				continue
+			}
+		}
+		_, n, err := decodeValue(key[offset:], s.columns[c].Type)
+		if err != nil {
+			return nil, fmt.Errorf("index %q: %w", idx.name, err)
+		}
+		offset += n
+	}
+	return key[offset:], nil
+}
+
+// prefix encodes values as a prefix of the index's keys, validating them
+// against the indexed columns' types.
+func (idx *index) prefix(s *Schema, values []Value) ([]byte, error) {
+	if len(values) > len(idx.columns) {
+		return nil, fmt.Errorf("%w: index %q has %d columns, got %d values", ErrKeyTypeMismatch, idx.name, len(idx.columns), len(values))
+	}
+	var key []byte
+	for i, v := range values {
+		if err := s.validateValue(idx.columns[i], v, ErrKeyTypeMismatch); err != nil {
+			return nil, err
+		}
+		key = s.encodeIndexValue(key, idx.columns[i], v)
+	}
+	return key, nil
+}
+
+func (idx *index) catalogIndex() catalog.Index {
+	columns := make([]uint8, len(idx.columns))
+	for i, c := range idx.columns {
+		columns[i] = uint8(c)
+	}
+	return catalog.Index{Name: idx.name, RootID: idx.tree.RootID(), Columns: columns}
+}
+
+// openIndexes opens the trees of the indexes recorded in a catalog row.
+func openIndexes(row catalog.Row, open func(rootID uint32) (*btree.Btree, error)) ([]*index, error) {
+	indexes := make([]*index, 0, len(row.Indexes))
+	for _, ci := range row.Indexes {
+		tree, err := open(ci.RootID)
+		if err != nil {
+			return nil, err
+		}
+		columns := make([]int, len(ci.Columns))
+		for i, c := range ci.Columns {
+			columns[i] = int(c)
+		}
+		indexes = append(indexes, &index{
+			name:        ci.Name,
+			columns:     columns,
+			tree:        tree,
+			savedRootID: ci.RootID,
+		})
+	}
+	return indexes, nil
+}
+
+// reopenIndexes points the table's indexes at the roots recorded in row,
+// after a rollback. Indexes are reopened in place, so handles sharing them
+// stay in step; ones created since row was committed are dropped and ones
+// dropped since are restored.
+func (t *Table) reopenIndexes(row catalog.Row) error {
+	existing := make(map[string]*index, len(t.indexes))
+	for _, idx := range t.indexes {
+		existing[idx.name] = idx
+	}
+	indexes, err := openIndexes(row, func(rootID uint32) (*btree.Btree, error) {
+		return btree.Open(t.db.pager, rootID)
+	})
+	if err != nil {
+		return err
+	}
+	for i, idx := range indexes {
+		if old, ok := existing[idx.name]; ok {
+			if err := old.tree.Reopen(idx.savedRootID); err != nil {
+				return err
+			}
+			old.savedRootID = idx.savedRootID
+			old.columns = idx.columns
+			indexes[i] = old
+		}
+	}
+	t.indexes = indexes
+	return nil
+}
+
+// prefixEnd returns the smallest key greater than every key starting with
+// prefix, or nil if there is none.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xFF {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// CreateIndex builds a secondary index named name over the given columns,
+// in order, from the table's existing rows, and maintains it on every later
+// Insert, Update, and Delete. Indexes are not unique: any number of rows may
+// share the same indexed values. Query it with [Table.Lookup] and
+// [Table.ScanIndex].
+//
+// Like [DB.CreateTable], CreateIndex commits on its own and is not part of
+// a transaction; it returns ErrSchemaChangeInTx through a [Tx] handle and
+// ErrReadOnly through a [Snapshot] handle. Returns ErrIndexExists if the
+// table already has an index with that name.
+func (t *Table) CreateIndex(name string, columns ...string) error {
+	if err := t.schemaChange(); err != nil {
+		return err
+	}
+	if name == "" || len(name) > maxSchemaByteField {
+		return fmt.Errorf("create index: name must be 1 to %d bytes, got %d", maxSchemaByteField, len(name))
+	}
+	if len(columns) == 0 || len(columns) > maxIndexes {
+		return fmt.Errorf("create index %q: must have 1 to %d columns, got %d", name, maxIndexes, len(columns))
+	}
+	return t.db.autocommit(func() error {
+		// The schema is read under the lock, which AlterTable changes it
+		// under.
+		positions := make([]int, len(columns))
+		for i, column := range columns {
+			c, ok := t.schema.columnIndex(column)
+			if !ok {
+				return fmt.Errorf("create index %q: no column named %q", name, column)
+			}
+			positions[i] = c
+		}
+		for _, idx := range t.indexes {
+			if idx.name == name {
+				return ErrIndexExists
+			}
+		}
+		if len(t.indexes) == maxIndexes {
+			return fmt.Errorf("create index %q: table already has %d indexes", name, maxIndexes)
+		}
+		tree, err := t.db.newTree()
+		if err != nil {
+			return err
+		}
+		idx := &index{name: name, columns: positions, tree: tree}
+		if err := t.buildIndex(idx); err != nil {
+			return err
+		}
+		t.indexes = append(t.indexes, idx)
+		return t.db.catalog.Upsert(t.name, t.catalogRow())
+	})
+}
+
+// buildIndex adds an entry to idx for every row of the table.
+func (t *Table) buildIndex(idx *index) error {
+	for r, err := range t.tree.AscendingRange(nil, nil) {
+		if err != nil {
+			return err
+		}
+		pk, err := t.schema.decodeKey(r.Key)
+		if err != nil {
+			return err
+		}
+		row, err := t.schema.decodeRow(pk, r.Value)
+		if err != nil {
+			return err
+		}
+		if err := idx.tree.Insert(idx.entryKey(t.schema, row), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DropIndex removes the named index and frees its pages. It commits on its
+// own in the same way as [Table.CreateIndex]. Returns ErrIndexNotFound if
+// the table has no index with that name.
+func (t *Table) DropIndex(name string) error {
+	if err := t.schemaChange(); err != nil {
+		return err
+	}
+	return t.db.autocommit(func() error {
+		for i, idx := range t.indexes {
+			if idx.name != name {
+				continue
+			}
+			t.indexes = append(t.indexes[:i:i], t.indexes[i+1:]...)
+			if err := t.db.catalog.Upsert(t.name, t.catalogRow()); err != nil {
+				return err
+			}
+			return idx.tree.Destroy()
+		}
+		return ErrIndexNotFound
+	})
+}
+
+// schemaChange returns the error for a schema or index change through a
+// handle that may not make one, or nil.
+func (t *Table) schemaChange() error {
+	switch {
+	case t.snap != nil:
+		return ErrReadOnly
+	case t.tx != nil:
+		return ErrSchemaChangeInTx
+	}
+	return nil
+}
+
+// Indexes returns the names of the table's secondary indexes, in creation
+// order.
+func (t *Table) Indexes() []string {
+	if t.snap == nil && t.tx == nil {
+		t.db.mu.RLock()
+		defer t.db.mu.RUnlock()
+	}
+	names := make([]string, len(t.indexes))
+	for i, idx := range t.indexes {
+		names[i] = idx.name
+	}
+	return names
+}
+
+// Lookup returns the rows whose indexed columns equal values, through the
+// named index. values may be shorter than the index's column list, in
+// which case it matches on the leading columns only. [Null] matches rows
+// with no value in that column. Rows are ordered by the remaining indexed
+// columns, nulls first, then by primary key. Returns
+// ErrIndexNotFound if the table has no such index, or ErrKeyTypeMismatch
+// if a value's type does not match its column type.
+func (t *Table) Lookup(indexName string, values ...Value) iter.Seq2[Row, error] {
+	return func(yield func(Row, error) bool) {
+		v, release, err := t.read()
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer release()
+		idx, err := v.index(indexName)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		lo, err := idx.prefix(v.schema, values)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		t.scanIndex(v, idx, lo, prefixEnd(lo), yield)
+	}
+}
+
+// ScanIndex returns rows in the order of the named index whose leading
+// indexed columns are in [lo, hi). lo and hi each hold values for a prefix
+// of the index's columns, and rows are compared on that many leading
+// columns; a nil bound is unbounded on that side. Rows with equal indexed
+// values are ordered by primary key. Returns ErrIndexNotFound if the table
+// has no such index, or ErrKeyTypeMismatch if a bound's type does not match
+// its column type. It reads a snapshot in the same way as [Table.Scan].
+func (t *Table) ScanIndex(indexName string, lo, hi []Value) iter.Seq2[Row, error] {
+	return func(yield func(Row, error) bool) {
+		v, release, err := t.read()
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer release()
+		idx, err := v.index(indexName)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		var loKey, hiKey []byte
+		if lo != nil {
+			if loKey, err = idx.prefix(v.schema, lo); err != nil {
+				yield(nil, err)
+				return
+			}
+		}
+		if hi != nil {
+			if hiKey, err = idx.prefix(v.schema, hi); err != nil {
+				yield(nil, err)
+				return
+			}
+		}
+		t.scanIndex(v, idx, loKey, hiKey, yield)
+	}
+}
+
+// scanIndex yields the rows behind the index entries in [lo, hi).
+func (t *Table) scanIndex(v *view, idx *index, lo, hi []byte, yield func(Row, error) bool) {
+	for r, err := range idx.tree.AscendingRange(lo, hi) {
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		key, err := idx.primaryKey(v.schema, r.Key)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		val, err := v.tree.Search(key)
+		if errors.Is(err, btree.ErrKeyNotFound) {
+			err = fmt.Errorf("index %q: entry for missing row", idx.name)
+		}
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		pk, err := v.schema.decodeKey(key)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		row, err := v.schema.decodeRow(pk, val)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		if !yield(row, nil) {
+			return
+		}
+	}
+}
+
+// insertEntries adds row to every index.
+func (t *Table) insertEntries(row Row) error {
+	for _, idx := range t.indexes {
+		if err := idx.tree.Insert(idx.entryKey(t.schema, row), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteEntries removes the row stored under key from every index. It
+// returns btree.ErrKeyNotFound if there is no such row.
+func (t *Table) deleteEntries(key []byte) error {
+	if len(t.indexes) == 0 {
+		return nil
+	}
+	val, err := t.tree.Search(key)
+	if err != nil {
+		return err
+	}
+	pk, err := t.schema.decodeKey(key)
+	if err != nil {
+		return err
+	}
+	row, err := t.schema.decodeRow(pk, val)
+	if err != nil {
+		return err
+	}
+	for _, idx := range t.indexes {
+		if err := idx.tree.Delete(idx.entryKey(t.schema, row)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
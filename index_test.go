@@ -0,0 +1,126 @@
+package toydb_test
+
+import (
+	"errors"
+	"testing"
+
+	toydb "github.com/guiwoch/toyDB"
+)
+
+// newPeople creates a table of people keyed by id, with an index on their
+// nullable city and one on (city, age).
+func newPeople(t *testing.T, d *toydb.DB) *toydb.Table {
+	t.Helper()
+	tbl, err := d.CreateTable("people", mustSchema(t, 0,
+		toydb.Column{Name: "id", Type: toydb.TypeInt},
+		toydb.Column{Name: "city", Type: toydb.TypeText, Nullable: true},
+		toydb.Column{Name: "age", Type: toydb.TypeInt},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range []toydb.Row{
+		{toydb.IntValue(1), toydb.TextValue("paris"), toydb.IntValue(30)},
+		{toydb.IntValue(2), toydb.TextValue("lima"), toydb.IntValue(25)},
+		{toydb.IntValue(3), toydb.Null, toydb.IntValue(40)},
+	} {
+		if err := tbl.Insert(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Built from the rows already there, then maintained.
+	if err := tbl.CreateIndex("by_city", "city"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tbl.CreateIndex("by_city_age", "city", "age"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tbl.Insert(toydb.Row{toydb.IntValue(4), toydb.TextValue("paris"), toydb.IntValue(20)}); err != nil {
+		t.Fatal(err)
+	}
+	return tbl
+}
+
+func TestIndexMaintained(t *testing.T) {
+	t.Parallel()
+	d, path := newTestDB(t)
+	tbl := newPeople(t, d)
+
+	checkRows(t, "ScanIndex by_city", tbl.ScanIndex("by_city", nil, nil),
+		"3 null 40", "2 lima 25", "1 paris 30", "4 paris 20")
+	checkRows(t, "Lookup paris", tbl.Lookup("by_city", toydb.TextValue("paris")),
+		"1 paris 30", "4 paris 20")
+	checkRows(t, "Lookup null", tbl.Lookup("by_city", toydb.Null), "3 null 40")
+	checkRows(t, "Lookup paris by age", tbl.Lookup("by_city_age", toydb.TextValue("paris")),
+		"4 paris 20", "1 paris 30")
+
+	// Moving a row to another city moves its entries.
+	if err := tbl.Update(toydb.Row{toydb.IntValue(1), toydb.TextValue("lima"), toydb.IntValue(31)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tbl.Delete(toydb.IntValue(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tbl.Update(toydb.Row{toydb.IntValue(4), toydb.Null, toydb.IntValue(20)}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"4 null 20", "3 null 40", "1 lima 31"}
+	checkRows(t, "ScanIndex by_city_age after writes", tbl.ScanIndex("by_city_age", nil, nil), want...)
+	checkRows(t, "Lookup paris after writes", tbl.Lookup("by_city", toydb.TextValue("paris")))
+	checkRows(t, "Lookup lima after writes", tbl.Lookup("by_city", toydb.TextValue("lima")), "1 lima 31")
+
+	// Rolled back writes leave no entries behind.
+	tx, err := d.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	txt, err := tx.Table("people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := txt.Insert(toydb.Row{toydb.IntValue(5), toydb.TextValue("paris"), toydb.IntValue(50)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := txt.Update(toydb.Row{toydb.IntValue(3), toydb.TextValue("oslo"), toydb.IntValue(40)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := txt.Delete(toydb.IntValue(1)); err != nil {
+		t.Fatal(err)
+	}
+	checkRows(t, "ScanIndex in tx", txt.ScanIndex("by_city_age", nil, nil), "4 null 20", "3 oslo 40", "5 paris 50")
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	checkRows(t, "ScanIndex after rollback", tbl.ScanIndex("by_city_age", nil, nil), want...)
+
+	d = reopen(t, d, path)
+	tbl, err = d.OpenTable("people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRows(t, "ScanIndex after reopen", tbl.ScanIndex("by_city_age", nil, nil), want...)
+	checkRows(t, "ScanIndex range after reopen",
+		tbl.ScanIndex("by_city", []toydb.Value{toydb.TextValue("a")}, []toydb.Value{toydb.TextValue("m")}), "1 lima 31")
+	if err := tbl.Insert(toydb.Row{toydb.IntValue(6), toydb.TextValue("lima"), toydb.IntValue(1)}); err != nil {
+		t.Fatal(err)
+	}
+	checkRows(t, "Lookup lima after reopen", tbl.Lookup("by_city_age", toydb.TextValue("lima")), "6 lima 1", "1 lima 31")
+}
+
+func TestCreateIndexErrors(t *testing.T) {
+	t.Parallel()
+	d, _ := newTestDB(t)
+	tbl := newPeople(t, d)
+
+	if err := tbl.CreateIndex("by_city", "age"); !errors.Is(err, toydb.ErrIndexExists) {
+		t.Errorf("duplicate index: got %v, want ErrIndexExists", err)
+	}
+	if err := tbl.CreateIndex("by_nope", "nope"); err == nil {
+		t.Error("index on a missing column: no error")
+	}
+	for _, err := range tbl.Lookup("nope") {
+		if !errors.Is(err, toydb.ErrIndexNotFound) {
+			t.Errorf("Lookup through a missing index: got %v, want ErrIndexNotFound", err)
+		}
+	}
+}
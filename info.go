@@ -0,0 +1,165 @@
+package toydb
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/guiwoch/toyDB/internal/storage/btree"
+	"github.com/guiwoch/toyDB/internal/storage/catalog"
+)
+
+// TableInfo describes a table as of the last commit.
+type TableInfo struct {
+	Name string
+	// Created is when the table was created. It is zero for tables
+	// created by versions of toyDB that did not record it.
+	Created time.Time
+	// Modified is when rows were last inserted, updated or deleted, or
+	// zero if they never were since toyDB started recording it. Writes
+	// made shortly before a crash may be missing from it.
+	Modified time.Time
+	Rows     int64
+	Comment  string
+}
+
+// tableMeta is the bookkeeping a table keeps in its catalog row besides
+// its schema and roots. Every handle to a table shares one, so that writes
+// through a Tx handle count too.
+type tableMeta struct {
+	created  int64 // Unix nanoseconds
+	modified int64
+	rows     int64
+	comment  string
+
+	// dirty is set when the comment or name changes, so that DB.commit
+	// records the fields in the catalog.
+	dirty bool
+	// touched is set when writes change the row count and modification
+	// time. Rewriting the catalog row on every write would double the
+	// cost of small commits, so these are only recorded along with other
+	// changes to the row, before a checkpoint, and on Close; Open
+	// recounts the rows after a crash.
+	touched bool
+}
+
+func metaFromRow(row catalog.Row) *tableMeta {
+	return &tableMeta{
+		created:  row.Created,
+		modified: row.Modified,
+		rows:     int64(row.RowCount),
+		comment:  row.Comment,
+	}
+}
+
+// touch records a write to the table that added delta rows.
+func (t *Table) touch(delta int) {
+	t.meta.rows += int64(delta)
+	t.meta.modified = time.Now().UnixNano()
+	t.meta.touched = true
+}
+
+// TableInfo returns the metadata of the named table as of the last commit.
+// Returns ErrTableNotFound if no table with the given name exists.
+func (d *DB) TableInfo(name string) (TableInfo, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	row, ok, err := d.catalog.Lookup(name)
+	if err != nil {
+		return TableInfo{}, err
+	}
+	if !ok {
+		return TableInfo{}, ErrTableNotFound
+	}
+	meta := *metaFromRow(row)
+	if t, ok := d.open[name]; ok && t.savedName == name {
+		// The catalog may be behind on the table's row count.
+		meta = t.savedMeta
+	}
+	info := TableInfo{
+		Name:    name,
+		Rows:    meta.rows,
+		Comment: meta.comment,
+	}
+	if meta.created != 0 {
+		info.Created = time.Unix(0, meta.created)
+	}
+	if meta.modified != 0 {
+		info.Modified = time.Unix(0, meta.modified)
+	}
+	return info, nil
+}
+
+// RenameTable gives a table a new name. Handles to it stay valid and take
+// the new name; snapshots taken before the rename still know it by the old
+// one. Returns ErrTableNotFound if no table is named oldName, and
+// ErrTableExists if one is already named newName.
+func (d *DB) RenameTable(oldName, newName string) error {
+	if err := checkTableName("rename table", newName); err != nil {
+		return err
+	}
+	return d.autocommit(func() error {
+		return d.renameTable(oldName, newName)
+	})
+}
+
+// renameTable is RenameTable for callers that hold mu exclusively.
+func (d *DB) renameTable(oldName, newName string) error {
+	t, err := d.openTable(oldName)
+	if err != nil {
+		return err
+	}
+	err = d.catalog.Rename(oldName, newName)
+	if errors.Is(err, btree.ErrDuplicateKey) {
+		return ErrTableExists
+	}
+	if err != nil {
+		return err
+	}
+	delete(d.open, oldName)
+	d.open[newName] = t
+	t.name = newName
+	t.meta.dirty = true
+	return nil
+}
+
+// recount counts the rows of every table again, for a file reopened after
+// a crash: its catalog misses the row counts of writes committed since they
+// were last recorded.
+func (d *DB) recount() error {
+	return d.autocommit(func() error {
+		names, err := d.catalog.Names()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			t, err := d.openTable(name)
+			if err != nil {
+				return err
+			}
+			if err := t.count(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetTableComment records a free-form comment on a table, replacing any
+// previous one; an empty comment clears it. Returns ErrTableNotFound if no
+// table with the given name exists.
+func (d *DB) SetTableComment(name, comment string) error {
+	if len(comment) > math.MaxUint16 {
+		return fmt.Errorf("comment is %d bytes, at most %d are allowed", len(comment), math.MaxUint16)
+	}
+	return d.autocommit(func() error {
+		t, err := d.openTable(name)
+		if err != nil {
+			return err
+		}
+		t.meta.comment = comment
+		t.meta.dirty = true
+		return nil
+	})
+}
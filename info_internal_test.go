@@ -0,0 +1,77 @@
+package toydb
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestRenameTableRollback(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "test.db")
+	d, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = d.Close() }()
+	s, err := NewSchema(0, []Column{{Name: "id", Type: TypeInt}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl, err := d.CreateTable("a", s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tbl.Insert(Row{IntValue(1)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// As if the commit after the rename failed.
+	errCommit := errors.New("commit failed")
+	err = d.autocommit(func() error {
+		if err := d.renameTable("a", "b"); err != nil {
+			return err
+		}
+		return errCommit
+	})
+	if !errors.Is(err, errCommit) {
+		t.Fatalf("got %v, want errCommit", err)
+	}
+	if got := tbl.Name(); got != "a" {
+		t.Errorf("handle Name() = %q after rollback, want a", got)
+	}
+	if got, err := d.OpenTable("a"); err != nil || got != tbl {
+		t.Errorf("OpenTable(a) = %p, %v; want the existing handle", got, err)
+	}
+	if _, err := d.OpenTable("b"); !errors.Is(err, ErrTableNotFound) {
+		t.Errorf("OpenTable(b): got %v, want ErrTableNotFound", err)
+	}
+
+	// The table is still usable under its old name, now and after reopen.
+	if err := tbl.Insert(Row{IntValue(2)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if d, err = Open(path); err != nil {
+		t.Fatal(err)
+	}
+	tbl, err = d.OpenTable("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, err := range tbl.Scan(nil, nil) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		n++
+	}
+	if n != 2 {
+		t.Errorf("Scan after reopen yields %d rows, want 2", n)
+	}
+	if info, err := d.TableInfo("a"); err != nil || info.Rows != 2 {
+		t.Errorf("TableInfo after reopen: %+v, %v; want 2 rows", info, err)
+	}
+}
@@ -0,0 +1,181 @@
+package toydb_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	toydb "github.com/guiwoch/toyDB"
+)
+
+// checkInfo fails the test if the named table's TableInfo does not count
+// rows rows.
+func checkInfo(t *testing.T, d *toydb.DB, name string, rows int64) {
+	t.Helper()
+	info, err := d.TableInfo(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Rows != rows {
+		t.Errorf("TableInfo(%q) counts %d rows, want %d", name, info.Rows, rows)
+	}
+}
+
+func TestRenameTable(t *testing.T) {
+	t.Parallel()
+	d, path := newTestDB(t)
+	tbl := newPeople(t, d)
+	if err := d.SetTableComment("people", "who lives where"); err != nil {
+		t.Fatal(err)
+	}
+	snap, err := d.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Close()
+	if _, err := d.CreateTable("taken", tbl.Schema()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		want error
+	}{
+		{"taken", toydb.ErrTableExists},
+		{"", nil},
+		{strings.Repeat("x", 4096), nil},
+	} {
+		err := d.RenameTable("people", tt.name)
+		if err == nil || tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("RenameTable to a %d-byte name: got %v, want %v", len(tt.name), err, tt.want)
+		}
+	}
+	if err := d.RenameTable("nobody", "somebody"); !errors.Is(err, toydb.ErrTableNotFound) {
+		t.Errorf("RenameTable of a missing table: got %v, want ErrTableNotFound", err)
+	}
+
+	if err := d.RenameTable("people", "residents"); err != nil {
+		t.Fatal(err)
+	}
+	if got := tbl.Name(); got != "residents" {
+		t.Errorf("handle Name() = %q after rename, want residents", got)
+	}
+	if _, err := d.OpenTable("people"); !errors.Is(err, toydb.ErrTableNotFound) {
+		t.Errorf("OpenTable of the old name: got %v, want ErrTableNotFound", err)
+	}
+	old, err := snap.Table("people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := old.Name(); got != "people" {
+		t.Errorf("snapshot handle Name() = %q, want people", got)
+	}
+	if err := tbl.Insert(toydb.Row{toydb.IntValue(5), toydb.Null, toydb.IntValue(50)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := snap.Close(); err != nil {
+		t.Fatal(err)
+	}
+	d = reopen(t, d, path)
+	tbl, err = d.OpenTable("residents")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRows(t, "Lookup paris after reopen", tbl.Lookup("by_city", toydb.TextValue("paris")),
+		"1 paris 30", "4 paris 20")
+	info, err := d.TableInfo("residents")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Rows != 5 || info.Comment != "who lives where" {
+		t.Errorf("TableInfo after reopen: %d rows, comment %q; want 5 rows, comment %q", info.Rows, info.Comment, "who lives where")
+	}
+}
+
+func TestTableInfoRows(t *testing.T) {
+	t.Parallel()
+	d, path := newTestDB(t)
+	tbl := newPeople(t, d)
+	checkInfo(t, d, "people", 4)
+
+	// Counted as of the last commit.
+	tx, err := d.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	txTbl, err := tx.Table("people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := txTbl.Delete(toydb.IntValue(1)); err != nil {
+		t.Fatal(err)
+	}
+	checkInfo(t, d, "people", 4)
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	checkInfo(t, d, "people", 4)
+	if err := tbl.Delete(toydb.IntValue(2)); err != nil {
+		t.Fatal(err)
+	}
+	checkInfo(t, d, "people", 3)
+	snap, err := d.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Close()
+	old, err := snap.Table("people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tbl.Insert(toydb.Row{toydb.IntValue(9), toydb.Null, toydb.IntValue(1)}); err != nil {
+		t.Fatal(err)
+	}
+	if stats, err := old.Stats(); err != nil {
+		t.Fatal(err)
+	} else if stats.Rows != 3 {
+		t.Errorf("snapshot Stats counts %d rows, want 3", stats.Rows)
+	}
+	if err := snap.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A copy taken without closing is what a crash leaves behind; Open
+	// counts its rows again.
+	crashed := openTestDB(t, copyDB(t, path))
+	checkInfo(t, crashed, "people", 4)
+	d = reopen(t, d, path)
+	checkInfo(t, d, "people", 4)
+
+	// A commit large enough to checkpoint the log records the counts of
+	// every table, so none are lost to a crash after it.
+	tbl, err = d.OpenTable("people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tbl.Delete(toydb.IntValue(3)); err != nil {
+		t.Fatal(err)
+	}
+	blobs, err := d.CreateTable("blobs", mustSchema(t, 0,
+		toydb.Column{Name: "id", Type: toydb.TypeInt},
+		toydb.Column{Name: "data", Type: toydb.TypeBytes},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make(toydb.BytesValue, 3000)
+	err = blobs.BulkLoad(func(yield func(toydb.Row) bool) {
+		for i := range 1500 {
+			if !yield(toydb.Row{toydb.IntValue(i), data}) {
+				return
+			}
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	crashed = openTestDB(t, copyDB(t, path))
+	checkInfo(t, crashed, "people", 3)
+	checkInfo(t, crashed, "blobs", 1500)
+}
@@ -8,34 +8,65 @@ import (
 	"github.com/guiwoch/toyDB/internal/storage/pager"
 )
 
+// Btree is a B+-tree over pager pages. Search and the range iterators may
+// run concurrently with each other, but not with Insert, Update, Delete,
+// Destroy or Reopen, which move the root and leaf pointers and rewrite pages
+// in place; callers serialize writers against readers.
 type Btree struct {
-	pager *pager.Pager
+	pager *pager.Pager // nil for trees opened with OpenSnapshot
+	src   PageSource   // where reads fetch pages; the pager for writable trees
 
 	rootID      uint32
-	firstLeafID uint32
+	firstLeafID uint32 // 0 until found, for trees opened with OpenSnapshot
 	lastLeafID  uint32
 }
 
+// PageSource supplies pages to a tree's read paths. *pager.Pager and
+// *pager.Snapshot both implement it.
+type PageSource interface {
+	Get(id uint32) (*page.Page, error)
+	Unpin(id uint32)
+}
+
 // Open returns a Btree rooted at the given page. It descends the tree once to
 // cache the leftmost and rightmost leaf IDs; these are maintained by insert
 // and delete afterwards.
 func Open(p *pager.Pager, rootID uint32) (*Btree, error) {
-	b := &Btree{pager: p, rootID: rootID}
+	b := &Btree{pager: p, src: p}
+	if err := b.Reopen(rootID); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// OpenSnapshot returns a read-only Btree rooted at the given page that
+// reads through src, typically a *pager.Snapshot. It does not descend the
+// tree up front; the leaf ends are found on first use. Only Search and the
+// range iterators may be called on it.
+func OpenSnapshot(src PageSource, rootID uint32) *Btree {
+	return &Btree{src: src, rootID: rootID}
+}
+
+// Reopen points the tree at rootID and re-caches the leftmost and rightmost
+// leaf IDs, discarding the in-memory state. Used after the pager rolls back
+// changes that moved the root or the leaf ends.
+func (b *Btree) Reopen(rootID uint32) error {
+	b.rootID = rootID
 	first, err := b.findLeftmostLeaf()
 	if err != nil {
-		return nil, err
+		return err
 	}
 	b.firstLeafID = first
 	last, err := b.findRightmostLeaf()
 	if err != nil {
-		return nil, err
+		return err
 	}
 	b.lastLeafID = last
-	return b, nil
+	return nil
 }
 
-// Destroy frees every page in the tree via a BFS from the root. The Btree
-// is unusable after this call.
+// Destroy frees every page in the tree, overflow chains included, via a BFS
+// from the root. The Btree is unusable after this call.
 func (b *Btree) Destroy() error {
 	var frontier []uint32
 	frontier = append(frontier, b.rootID)
@@ -53,6 +84,16 @@ func (b *Btree) Destroy() error {
 				frontier = append(frontier, binary.BigEndian.Uint32(p.ValueByIndex(i)))
 			}
 			frontier = append(frontier, p.RightPointer())
+		} else {
+			for i := range p.RecordCount() {
+				if !p.OverflowByIndex(i) {
+					continue
+				}
+				if err := b.freeOverflow(p.ValueByIndex(i)); err != nil {
+					b.pager.Unpin(p.PageID())
+					return err
+				}
+			}
 		}
 		b.pager.Free(p.PageID())
 	}
@@ -62,7 +103,7 @@ func (b *Btree) Destroy() error {
 func (b *Btree) RootID() uint32 { return b.rootID }
 
 func (b *Btree) findLeftmostLeaf() (uint32, error) {
-	p, err := b.pager.Get(b.rootID)
+	p, err := b.src.Get(b.rootID)
 	if err != nil {
 		return 0, err
 	}
@@ -73,32 +114,32 @@ func (b *Btree) findLeftmostLeaf() (uint32, error) {
 		} else {
 			childID = p.RightPointer()
 		}
-		b.pager.Unpin(p.PageID())
-		p, err = b.pager.Get(childID)
+		b.src.Unpin(p.PageID())
+		p, err = b.src.Get(childID)
 		if err != nil {
 			return 0, err
 		}
 	}
 	id := p.PageID()
-	b.pager.Unpin(id)
+	b.src.Unpin(id)
 	return id, nil
 }
 
 func (b *Btree) findRightmostLeaf() (uint32, error) {
-	p, err := b.pager.Get(b.rootID)
+	p, err := b.src.Get(b.rootID)
 	if err != nil {
 		return 0, err
 	}
 	for p.PageType() == page.TypeInternal {
 		childID := p.RightPointer()
-		b.pager.Unpin(p.PageID())
-		p, err = b.pager.Get(childID)
+		b.src.Unpin(p.PageID())
+		p, err = b.src.Get(childID)
 		if err != nil {
 			return 0, err
 		}
 	}
 	id := p.PageID()
-	b.pager.Unpin(id)
+	b.src.Unpin(id)
 	return id, nil
 }
 
@@ -109,16 +150,16 @@ func (b *Btree) Search(key []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	value, found := p.Get(key)
-	b.pager.Unpin(p.PageID())
+	defer b.src.Unpin(p.PageID())
+	i, found := p.SearchKey(key)
 	if !found {
 		return nil, ErrKeyNotFound
 	}
-	return value, nil
+	return b.leafValue(p, i)
 }
 
 func (b *Btree) findLeaf(key []byte) (*page.Page, error) {
-	p, err := b.pager.Get(b.rootID)
+	p, err := b.src.Get(b.rootID)
 	if err != nil {
 		return nil, err
 	}
@@ -131,8 +172,8 @@ func (b *Btree) findLeaf(key []byte) (*page.Page, error) {
 			idx = i
 		}
 		childID := b.findChildID(p, idx)
-		b.pager.Unpin(p.PageID())
-		p, err = b.pager.Get(childID)
+		b.src.Unpin(p.PageID())
+		p, err = b.src.Get(childID)
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,223 @@
+package btree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"iter"
+
+	"github.com/guiwoch/toyDB/internal/storage/page"
+)
+
+// DefaultFillFactor is the fill factor BulkLoad uses when given zero. It
+// leaves each page room for a few later inserts before it splits.
+const DefaultFillFactor = 0.9
+
+// ErrUnsorted is returned by BulkLoad when a key sorts before the one
+// preceding it.
+var ErrUnsorted = errors.New("keys are not in ascending order")
+
+// BulkLoad fills an empty tree from records given in strictly ascending key
+// order. Rather than inserting them one at a time, it builds the tree
+// bottom-up: it packs leaves in turn, linking each to the last, then builds
+// each level of internal pages over the one below until a single root
+// remains. Every page is filled to fillFactor of its capacity, which must
+// lie between 0.5 and 1; zero selects DefaultFillFactor.
+//
+// Returns ErrUnsorted or ErrDuplicateKey if a key does not sort strictly
+// after the previous one, ErrKeyTooLarge as Insert does, or the first error
+// records yields. On error every page written so far is freed and the tree
+// is left empty.
+func (b *Btree) BulkLoad(records iter.Seq2[Record, error], fillFactor float64) error {
+	if fillFactor == 0 {
+		fillFactor = DefaultFillFactor
+	}
+	if fillFactor < 0.5 || fillFactor > 1 {
+		return fmt.Errorf("bulk load: fill factor %v outside [0.5, 1]", fillFactor)
+	}
+	oldRoot, err := b.pager.Get(b.rootID)
+	if err != nil {
+		return err
+	}
+	if oldRoot.PageType() != page.TypeLeaf || oldRoot.RecordCount() != 0 {
+		b.pager.Unpin(oldRoot.PageID())
+		return errors.New("bulk load: tree is not empty")
+	}
+	// The old root stays pinned until it is freed or the load fails.
+
+	l := bulkLoader{b: b, capacity: int(fillFactor * (page.PageSize - page.PageHeaderSize))}
+	if err := l.loadLeaves(records); err != nil {
+		b.pager.Unpin(oldRoot.PageID())
+		return errors.Join(err, l.free())
+	}
+	if len(l.level) == 0 {
+		b.pager.Unpin(oldRoot.PageID())
+		return nil
+	}
+	firstLeaf, lastLeaf := l.level[0].id, l.level[len(l.level)-1].id
+	for len(l.level) > 1 {
+		if err := l.buildLevel(); err != nil {
+			b.pager.Unpin(oldRoot.PageID())
+			return errors.Join(err, l.free())
+		}
+	}
+
+	b.pager.Free(oldRoot.PageID())
+	b.rootID = l.level[0].id
+	b.firstLeafID, b.lastLeafID = firstLeaf, lastLeaf
+	return nil
+}
+
+// bulkLoader holds the state of one BulkLoad.
+type bulkLoader struct {
+	b        *Btree
+	capacity int // bytes of records to put on each page
+
+	// level is the level most recently built, one entry per page.
+	level []bulkChild
+
+	// pages and refs are everything written so far, to free on error.
+	pages []uint32
+	refs  [][]byte
+}
+
+// bulkChild is a page of a level under construction: its ID, and the
+// first key of its subtree, which separates it from its left sibling.
+type bulkChild struct {
+	firstKey []byte
+	id       uint32
+}
+
+// loadLeaves writes records to packed, linked leaves, recording each in
+// l.level.
+func (l *bulkLoader) loadLeaves(records iter.Seq2[Record, error]) error {
+	var leaf *page.Page
+	defer func() {
+		if leaf != nil {
+			l.b.pager.Unpin(leaf.PageID())
+		}
+	}()
+	var used int
+	var prev []byte
+	for r, err := range records {
+		if err != nil {
+			return err
+		}
+		if len(r.Key) > MaxKeySize {
+			return fmt.Errorf("%w: %d bytes, max %d", ErrKeyTooLarge, len(r.Key), MaxKeySize)
+		}
+		if leaf != nil {
+			switch c := bytes.Compare(r.Key, prev); {
+			case c == 0:
+				return fmt.Errorf("%w: %x", ErrDuplicateKey, r.Key)
+			case c < 0:
+				return fmt.Errorf("%w: %x follows %x", ErrUnsorted, r.Key, prev)
+			}
+		}
+		prev = bytes.Clone(r.Key)
+
+		value, overflow := r.Value, needsOverflow(r.Key, r.Value)
+		if overflow {
+			ref, err := l.b.writeOverflow(value)
+			if err != nil {
+				return err
+			}
+			l.refs = append(l.refs, ref)
+			value = ref
+		}
+		size := page.RecordFootprint(len(r.Key), len(value))
+		if leaf == nil || used+size > l.capacity {
+			next, err := l.b.pager.Allocate(page.TypeLeaf)
+			if err != nil {
+				return err
+			}
+			l.pages = append(l.pages, next.PageID())
+			if leaf != nil {
+				leaf.SetNextLeaf(next.PageID())
+				next.SetPrevLeaf(leaf.PageID())
+				l.b.pager.Unpin(leaf.PageID())
+			}
+			leaf, used = next, 0
+			l.level = append(l.level, bulkChild{firstKey: prev, id: leaf.PageID()})
+		}
+		if err := insertLeafRecord(leaf, r.Key, value, overflow); err != nil {
+			return err
+		}
+		used += size
+	}
+	return nil
+}
+
+// buildLevel replaces l.level with a level of internal pages over it.
+func (l *bulkLoader) buildLevel() error {
+	const childPointerLen = 4
+	// Group the children into pages. A page over n children holds n-1
+	// records, so each needs at least two children.
+	var groups [][]bulkChild
+	for i := 0; i < len(l.level); {
+		j, used := i+1, 0
+		for j < len(l.level) {
+			size := page.RecordFootprint(len(l.level[j].firstKey), childPointerLen)
+			if used+size > l.capacity && j-i >= 2 {
+				break
+			}
+			used += size
+			j++
+		}
+		groups = append(groups, l.level[i:j])
+		i = j
+	}
+	if n := len(groups); n > 1 && len(groups[n-1]) == 1 {
+		// Give the lone last child a sibling from the previous page, or
+		// join that page if it cannot spare one. Records are at most a
+		// quarter of a page, so three children's two records always fit.
+		prev, last := groups[n-2], groups[n-1]
+		if len(prev) > 2 {
+			groups[n-2], groups[n-1] = prev[:len(prev)-1], l.level[len(l.level)-2:]
+		} else {
+			groups = append(groups[:n-2], l.level[len(l.level)-len(prev)-len(last):])
+		}
+	}
+
+	var parents []bulkChild
+	for _, children := range groups {
+		p, err := l.b.pager.Allocate(page.TypeInternal)
+		if err != nil {
+			return err
+		}
+		l.pages = append(l.pages, p.PageID())
+		var childID [childPointerLen]byte
+		for k := 1; k < len(children); k++ {
+			// Keys equal to a separator go right, so each separator is the
+			// first key under the child to its right.
+			binary.BigEndian.PutUint32(childID[:], children[k-1].id)
+			if err := p.InsertRecord(children[k].firstKey, childID[:]); err != nil {
+				l.b.pager.Unpin(p.PageID())
+				return err
+			}
+		}
+		p.SetRightPointer(children[len(children)-1].id)
+		l.b.pager.Unpin(p.PageID())
+		parents = append(parents, bulkChild{firstKey: children[0].firstKey, id: p.PageID()})
+	}
+	l.level = parents
+	return nil
+}
+
+// free releases every page written so far.
+func (l *bulkLoader) free() error {
+	var errs []error
+	for _, ref := range l.refs {
+		errs = append(errs, l.b.freeOverflow(ref))
+	}
+	for _, id := range l.pages {
+		// Free needs the page in memory, and it may have been evicted.
+		if _, err := l.b.pager.Get(id); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		l.b.pager.Free(id)
+	}
+	return errors.Join(errs...)
+}
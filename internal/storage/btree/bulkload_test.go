@@ -0,0 +1,208 @@
+package btree_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"iter"
+	"slices"
+	"testing"
+
+	"github.com/guiwoch/toyDB/internal/storage/btree"
+)
+
+// sortedRecords returns n records with ascending keys padded to keyLen
+// bytes, every tenth with a value large enough to need an overflow chain.
+func sortedRecords(n, keyLen int) []btree.Record {
+	records := make([]btree.Record, n)
+	for i := range records {
+		key := binary.BigEndian.AppendUint32(make([]byte, 0, keyLen), uint32(i)*3)
+		key = key[:max(keyLen, 4)]
+		value := key
+		if i%10 == 0 {
+			value = largeValue(uint32(i), 3000)
+		}
+		records[i] = btree.Record{Key: key, Value: value}
+	}
+	return records
+}
+
+func seqOf(records []btree.Record) iter.Seq2[btree.Record, error] {
+	return func(yield func(btree.Record, error) bool) {
+		for _, r := range records {
+			if !yield(r, nil) {
+				return
+			}
+		}
+	}
+}
+
+func TestBulkLoad(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name       string
+		n          int
+		keyLen     int
+		fillFactor float64
+	}{
+		{"empty", 0, 4, 0},
+		{"one record", 1, 4, 0},
+		{"one leaf", 100, 4, 0},
+		{"half full pages", 20_000, 4, 0.5},
+		{"default fill", 20_000, 4, 0},
+		{"full pages", 20_000, 4, 1},
+		// Few keys fit on a page, so the tree is several levels deep.
+		{"long keys", 3000, 1000, 0},
+		{"long keys, full pages", 3001, 1500, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			tree := newTestTree(t)
+			records := sortedRecords(tt.n, tt.keyLen)
+			if err := tree.BulkLoad(seqOf(records), tt.fillFactor); err != nil {
+				t.Fatal(err)
+			}
+
+			got := collectRange(t, tree.AscendingRange(nil, nil))
+			if !slices.EqualFunc(got, records, recordsEqual) {
+				t.Fatalf("ascending range returned %d records, want the %d loaded", len(got), len(records))
+			}
+			got = collectRange(t, tree.DescendingRange(nil, nil))
+			slices.Reverse(got)
+			if !slices.EqualFunc(got, records, recordsEqual) {
+				t.Fatalf("descending range returned %d records, want the %d loaded", len(got), len(records))
+			}
+			for _, r := range records {
+				value, err := tree.Search(r.Key)
+				if err != nil || !bytes.Equal(value, r.Value) {
+					t.Fatalf("search %x: %v", r.Key, err)
+				}
+			}
+
+			// The tree keeps working as an ordinary one: fill the gaps
+			// between keys, then delete the loaded records.
+			for i := range tt.n {
+				key := binary.BigEndian.AppendUint32(nil, uint32(i)*3+1)
+				if err := tree.Insert(key, key); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, r := range records {
+				if err := tree.Delete(r.Key); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got = collectRange(t, tree.AscendingRange(nil, nil))
+			if len(got) != tt.n {
+				t.Fatalf("expected %d records after inserts and deletes, got %d", tt.n, len(got))
+			}
+			for i, r := range got {
+				if want := binary.BigEndian.AppendUint32(nil, uint32(i)*3+1); !bytes.Equal(r.Key, want) {
+					t.Fatalf("record %d: expected key %x, got %x", i, want, r.Key)
+				}
+			}
+		})
+	}
+}
+
+func TestBulkLoadPacksPages(t *testing.T) {
+	t.Parallel()
+	records := make([]btree.Record, 50_000)
+	for i := range records {
+		key := binary.BigEndian.AppendUint32(nil, uint32(i))
+		records[i] = btree.Record{Key: key, Value: key}
+	}
+
+	inserted, insertPager := newTestTreeAndPager(t)
+	for _, r := range records {
+		if err := inserted.Insert(r.Key, r.Value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	loaded, loadPager := newTestTreeAndPager(t)
+	if err := loaded.BulkLoad(seqOf(records), 1); err != nil {
+		t.Fatal(err)
+	}
+	// Ascending inserts leave every split leaf half full.
+	if got, insertedPages := loadPager.NewID(), insertPager.NewID(); got*3/2 > insertedPages {
+		t.Errorf("expected bulk load to use at most two thirds of the %d pages inserting used, got %d", insertedPages, got)
+	}
+}
+
+func TestBulkLoadRejectsBadInput(t *testing.T) {
+	t.Parallel()
+	records := sortedRecords(5000, 4)
+	unsorted := slices.Clone(records)
+	unsorted[4000], unsorted[4001] = unsorted[4001], unsorted[4000]
+	duplicate := slices.Clone(records)
+	duplicate[4001] = duplicate[4000]
+	failing := func(yield func(btree.Record, error) bool) {
+		for _, r := range records[:4000] {
+			if !yield(r, nil) {
+				return
+			}
+		}
+		yield(btree.Record{}, errFailingSource)
+	}
+
+	fresh, freshPager := newTestTreeAndPager(t)
+	freshBefore := freshPager.NewID()
+	if err := fresh.BulkLoad(seqOf(records), 0); err != nil {
+		t.Fatal(err)
+	}
+	loadPages := freshPager.NewID() - freshBefore
+
+	tests := []struct {
+		name    string
+		records iter.Seq2[btree.Record, error]
+		want    error
+	}{
+		{"unsorted", seqOf(unsorted), btree.ErrUnsorted},
+		{"duplicate", seqOf(duplicate), btree.ErrDuplicateKey},
+		{"key too large", seqOf([]btree.Record{{Key: make([]byte, btree.MaxKeySize+1)}}), btree.ErrKeyTooLarge},
+		{"source error", failing, errFailingSource},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			tree, p := newTestTreeAndPager(t)
+			before := p.NewID()
+			if err := tree.BulkLoad(tt.records, 0); !errors.Is(err, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, err)
+			}
+			if got := collectRange(t, tree.AscendingRange(nil, nil)); len(got) != 0 {
+				t.Fatalf("expected the tree to stay empty, got %d records", len(got))
+			}
+			// The pages written before the failure were freed, so a
+			// successful load reuses them and grows the file no more than
+			// loading into a fresh tree does.
+			if err := tree.BulkLoad(seqOf(records), 0); err != nil {
+				t.Fatal(err)
+			}
+			if got := p.NewID() - before; got != loadPages {
+				t.Errorf("expected the failed load's pages to be reused: file grew by %d pages, want %d", got, loadPages)
+			}
+		})
+	}
+}
+
+var errFailingSource = errors.New("source failed")
+
+func TestBulkLoadRejectsNonEmptyTree(t *testing.T) {
+	t.Parallel()
+	tree := newTestTree(t)
+	if err := tree.Insert([]byte("a"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.BulkLoad(seqOf(sortedRecords(10, 4)), 0); err == nil {
+		t.Error("expected an error loading into a non-empty tree")
+	}
+	for _, fillFactor := range []float64{0.4, 1.1} {
+		if err := newTestTree(t).BulkLoad(seqOf(nil), fillFactor); err == nil {
+			t.Errorf("expected an error for fill factor %v", fillFactor)
+		}
+	}
+}
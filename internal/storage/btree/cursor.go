@@ -0,0 +1,148 @@
+package btree
+
+import "github.com/guiwoch/toyDB/internal/storage/page"
+
+// Cursor is a position in a tree's key order that moves in both
+// directions along the leaf sibling links. It keeps the leaf it is
+// positioned on pinned until it moves off it or is closed. Like the range
+// iterators, a cursor must not be used while the tree is written to; after
+// a write, position it again with Seek, First or Last.
+type Cursor struct {
+	b *Btree
+	p *page.Page // the pinned leaf, nil when the cursor is not positioned
+	i uint16
+}
+
+// Cursor returns an unpositioned cursor over the tree.
+func (b *Btree) Cursor() *Cursor {
+	return &Cursor{b: b}
+}
+
+// Seek positions the cursor at the first record whose key is at least key,
+// and reports whether there is one. If there is not, the cursor is left
+// unpositioned.
+func (c *Cursor) Seek(key []byte) (bool, error) {
+	c.Close()
+	p, err := c.b.findLeaf(key)
+	if err != nil {
+		return false, err
+	}
+	i, _ := p.SearchKey(key)
+	if i < p.RecordCount() {
+		c.p, c.i = p, i
+		return true, nil
+	}
+	// key sorts after every key in its leaf, so the first record at
+	// least key is the next leaf's first.
+	next := p.NextLeaf()
+	c.b.src.Unpin(p.PageID())
+	return c.moveTo(next, true)
+}
+
+// First positions the cursor at the tree's first record, and reports
+// whether there is one.
+func (c *Cursor) First() (bool, error) {
+	c.Close()
+	first, err := c.b.firstLeaf()
+	if err != nil {
+		return false, err
+	}
+	return c.moveTo(first, true)
+}
+
+// Last positions the cursor at the tree's last record, and reports whether
+// there is one.
+func (c *Cursor) Last() (bool, error) {
+	c.Close()
+	last, err := c.b.lastLeaf()
+	if err != nil {
+		return false, err
+	}
+	return c.moveTo(last, false)
+}
+
+// Next moves the cursor to the following record and reports whether there
+// is one. At the last record it returns false and stays put. An
+// unpositioned cursor moves to the first record.
+func (c *Cursor) Next() (bool, error) {
+	if c.p == nil {
+		return c.First()
+	}
+	if c.i+1 < c.p.RecordCount() {
+		c.i++
+		return true, nil
+	}
+	return c.moveTo(c.p.NextLeaf(), true)
+}
+
+// Prev moves the cursor to the preceding record and reports whether there
+// is one. At the first record it returns false and stays put. An
+// unpositioned cursor moves to the last record.
+func (c *Cursor) Prev() (bool, error) {
+	if c.p == nil {
+		return c.Last()
+	}
+	if c.i > 0 {
+		c.i--
+		return true, nil
+	}
+	return c.moveTo(c.p.PrevLeaf(), false)
+}
+
+// Valid reports whether the cursor is positioned at a record.
+func (c *Cursor) Valid() bool { return c.p != nil }
+
+// Key returns the key of the record the cursor is at, or nil if it is not
+// positioned. The key is a copy, safe to keep after the cursor moves.
+func (c *Cursor) Key() []byte {
+	if c.p == nil {
+		return nil
+	}
+	return c.p.KeyByIndex(c.i)
+}
+
+// Value returns the value of the record the cursor is at, reading it from
+// its overflow chain if it has one, or nil if the cursor is not positioned.
+func (c *Cursor) Value() ([]byte, error) {
+	if c.p == nil {
+		return nil, nil
+	}
+	return c.b.leafValue(c.p, c.i)
+}
+
+// Close unpins the cursor's leaf and leaves it unpositioned. The cursor can
+// be positioned again afterwards.
+func (c *Cursor) Close() {
+	if c.p != nil {
+		c.b.src.Unpin(c.p.PageID())
+		c.p = nil
+	}
+}
+
+// moveTo positions the cursor at the first record of the first non-empty
+// leaf from id onwards, or, going backwards, at the last record of the
+// first non-empty leaf from id back. If there is none it reports false and
+// leaves the cursor where it was.
+func (c *Cursor) moveTo(id uint32, forward bool) (bool, error) {
+	for id != 0 {
+		p, err := c.b.src.Get(id)
+		if err != nil {
+			return false, err
+		}
+		if n := p.RecordCount(); n > 0 {
+			c.Close()
+			c.p, c.i = p, 0
+			if !forward {
+				c.i = n - 1
+			}
+			return true, nil
+		}
+		if forward {
+			id = p.NextLeaf()
+		} else {
+			id = p.PrevLeaf()
+		}
+		c.b.src.Unpin(p.PageID())
+	}
+	return false, nil
+}
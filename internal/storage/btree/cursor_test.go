@@ -0,0 +1,157 @@
+package btree_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/guiwoch/toyDB/internal/storage/btree"
+)
+
+// newCursorTree returns a tree holding the even keys below 2n, with values
+// equal to their keys.
+func newCursorTree(t *testing.T, n int) *btree.Btree {
+	t.Helper()
+	tree := newTestTree(t)
+	for i := range n {
+		key := binary.BigEndian.AppendUint32(nil, uint32(2*i))
+		if err := tree.Insert(key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tree
+}
+
+func cursorKey(t *testing.T, c *btree.Cursor) uint32 {
+	t.Helper()
+	if !c.Valid() {
+		t.Fatal("cursor is not positioned")
+	}
+	value, err := c.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(value, c.Key()) {
+		t.Fatalf("value %x does not match key %x", value, c.Key())
+	}
+	return binary.BigEndian.Uint32(c.Key())
+}
+
+func TestCursorWalksBothWays(t *testing.T) {
+	t.Parallel()
+	const n = 20_000
+	tree := newCursorTree(t, n)
+	c := tree.Cursor()
+	defer c.Close()
+
+	ok, err := c.First()
+	for i := 0; ok; i++ {
+		if got := cursorKey(t, c); got != uint32(2*i) {
+			t.Fatalf("forward step %d: expected key %d, got %d", i, 2*i, got)
+		}
+		if i == n-1 {
+			break
+		}
+		if ok, err = c.Next(); !ok {
+			t.Fatalf("forward walk ended after %d records: %v", i+1, err)
+		}
+	}
+	// Next at the last record stays put.
+	if ok, err = c.Next(); ok || err != nil || cursorKey(t, c) != 2*(n-1) {
+		t.Fatalf("expected Next at the end to fail and stay put, got %v, %v", ok, err)
+	}
+
+	for i := n - 1; i >= 0; i-- {
+		if got := cursorKey(t, c); got != uint32(2*i) {
+			t.Fatalf("backward step %d: expected key %d, got %d", i, 2*i, got)
+		}
+		ok, err = c.Prev()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok == (i == 0) {
+			t.Fatalf("backward step %d: Prev returned %v", i, ok)
+		}
+	}
+	if got := cursorKey(t, c); got != 0 {
+		t.Fatalf("expected Prev at the start to stay put, at key %d", got)
+	}
+}
+
+func TestCursorSeek(t *testing.T) {
+	t.Parallel()
+	tree := newCursorTree(t, 5000)
+	c := tree.Cursor()
+	defer c.Close()
+
+	tests := []struct {
+		name  string
+		seek  uint32
+		found bool
+		want  uint32
+	}{
+		{"existing key", 2000, true, 2000},
+		{"gap between keys", 2001, true, 2002},
+		{"before the first key", 0, true, 0},
+		{"last key", 9998, true, 9998},
+		{"past the last key", 9999, false, 0},
+	}
+	for _, tt := range tests {
+		ok, err := c.Seek(binary.BigEndian.AppendUint32(nil, tt.seek))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != tt.found || ok != c.Valid() {
+			t.Fatalf("%s: Seek returned %v, Valid %v, want %v", tt.name, ok, c.Valid(), tt.found)
+		}
+		if ok && cursorKey(t, c) != tt.want {
+			t.Errorf("%s: expected key %d, got %d", tt.name, tt.want, cursorKey(t, c))
+		}
+	}
+
+	// An unpositioned cursor steps onto the ends.
+	if ok, err := c.Prev(); !ok || err != nil || cursorKey(t, c) != 9998 {
+		t.Fatalf("expected Prev from past the end to reach the last key, got %v, %v", ok, err)
+	}
+	c.Close()
+	if ok, err := c.Next(); !ok || err != nil || cursorKey(t, c) != 0 {
+		t.Fatalf("expected Next after Close to reach the first key, got %v, %v", ok, err)
+	}
+
+	// Paging back and forth from a seek returns to where it started.
+	if _, err := c.Seek(binary.BigEndian.AppendUint32(nil, 5001)); err != nil {
+		t.Fatal(err)
+	}
+	for range 700 {
+		if ok, err := c.Next(); !ok || err != nil {
+			t.Fatalf("Next: %v, %v", ok, err)
+		}
+	}
+	for range 700 {
+		if ok, err := c.Prev(); !ok || err != nil {
+			t.Fatalf("Prev: %v, %v", ok, err)
+		}
+	}
+	if got := cursorKey(t, c); got != 5002 {
+		t.Errorf("expected to page back to key 5002, got %d", got)
+	}
+}
+
+func TestCursorEmptyTree(t *testing.T) {
+	t.Parallel()
+	c := newTestTree(t).Cursor()
+	for name, move := range map[string]func() (bool, error){
+		"First": c.First,
+		"Last":  c.Last,
+		"Next":  c.Next,
+		"Prev":  c.Prev,
+		"Seek":  func() (bool, error) { return c.Seek([]byte("a")) },
+	} {
+		if ok, err := move(); ok || err != nil || c.Valid() {
+			t.Errorf("%s on an empty tree: got %v, %v", name, ok, err)
+		}
+	}
+	if c.Key() != nil {
+		t.Error("expected no key from an unpositioned cursor")
+	}
+}
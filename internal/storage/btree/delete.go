@@ -11,11 +11,21 @@ import (
 var ErrKeyNotFound = errors.New("key not found")
 
 func (b *Btree) Delete(key []byte) error {
+	return b.deleteWith(key, b.deleteOnLeaf)
+}
+
+// leafDeleter removes records from the leaf p that key leads to, and
+// reports whether the leaf underflowed.
+type leafDeleter func(key []byte, p *page.Page) (bool, error)
+
+// deleteWith descends to the leaf key belongs to, applies onLeaf to it, and
+// rebalances the tree on the way back up.
+func (b *Btree) deleteWith(key []byte, onLeaf leafDeleter) error {
 	root, err := b.pager.Get(b.rootID)
 	if err != nil {
 		return err
 	}
-	underflow, err := b.delete(key, root)
+	underflow, err := b.delete(key, root, onLeaf)
 	b.pager.Unpin(root.PageID())
 	if err != nil {
 		return err
@@ -43,17 +53,17 @@ func (b *Btree) collapseRoot() error {
 	return nil
 }
 
-func (b *Btree) delete(key []byte, p *page.Page) (bool, error) {
+func (b *Btree) delete(key []byte, p *page.Page, onLeaf leafDeleter) (bool, error) {
 	switch p.PageType() {
 	case page.TypeInternal:
-		return b.deleteOnInternal(key, p)
+		return b.deleteOnInternal(key, p, onLeaf)
 	case page.TypeLeaf:
-		return b.deleteOnLeaf(key, p)
+		return onLeaf(key, p)
 	}
 	panic(fmt.Sprintf("unknown page type: %v", p.PageType()))
 }
 
-func (b *Btree) deleteOnInternal(key []byte, p *page.Page) (bool, error) {
+func (b *Btree) deleteOnInternal(key []byte, p *page.Page, onLeaf leafDeleter) (bool, error) {
 	i, found := p.SearchKey(key)
 	var childIdx uint16
 	if found {
@@ -66,7 +76,7 @@ func (b *Btree) deleteOnInternal(key []byte, p *page.Page) (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	underflow, err := b.delete(key, childPage)
+	underflow, err := b.delete(key, childPage, onLeaf)
 	if err != nil {
 		b.pager.Unpin(childPage.PageID())
 		return false, err
@@ -78,13 +88,17 @@ func (b *Btree) deleteOnInternal(key []byte, p *page.Page) (bool, error) {
 		return false, nil
 	}
 
-	if err := b.steal(p, childIdx); err != nil {
-		b.pager.Unpin(childPage.PageID())
-		return false, err
-	}
-	if childPage.BytesUntilUnderflow() >= 0 {
-		b.pager.Unpin(childPage.PageID())
-		return false, nil
+	// An emptied leaf always merges, which frees it; stealing into it
+	// first would only copy records that the merge then moves back.
+	if childPage.PageType() == page.TypeInternal || childPage.RecordCount() > 0 {
+		if err := b.steal(p, childIdx); err != nil {
+			b.pager.Unpin(childPage.PageID())
+			return false, err
+		}
+		if childPage.BytesUntilUnderflow() >= 0 {
+			b.pager.Unpin(childPage.PageID())
+			return false, nil
+		}
 	}
 
 	return b.merge(p, childPage, childIdx)
@@ -135,6 +149,7 @@ func (b *Btree) steal(parent *page.Page, childIdx uint16) error {
 func (b *Btree) stealFromLeft(parent, child, left *page.Page, childIdx uint16) {
 	stolenKey := left.KeyByIndex(left.RecordCount() - 1)
 	stolenValue := left.ValueByIndex(left.RecordCount() - 1)
+	stolenOverflow := left.OverflowByIndex(left.RecordCount() - 1)
 	separator := parent.KeyByIndex(childIdx - 1)
 
 	b.pager.MarkDirty(left.PageID())
@@ -146,7 +161,7 @@ func (b *Btree) stealFromLeft(parent, child, left *page.Page, childIdx uint16) {
 
 	if child.PageType() == page.TypeLeaf {
 		// target gains the last record of the left sibling
-		child.InsertRecord(stolenKey, stolenValue)
+		insertLeafRecord(child, stolenKey, stolenValue, stolenOverflow)
 
 		// parent separator updated to the new minimum of target (the stolen key)
 		parent.DeleteRecord(separator)
@@ -177,6 +192,7 @@ func (b *Btree) stealFromLeft(parent, child, left *page.Page, childIdx uint16) {
 func (b *Btree) stealFromRight(parent, child, right *page.Page, childIdx uint16) {
 	stolenKey := right.KeyByIndex(0)
 	stolenValue := right.ValueByIndex(0)
+	stolenOverflow := right.OverflowByIndex(0)
 	separator := parent.KeyByIndex(childIdx)
 
 	b.pager.MarkDirty(right.PageID())
@@ -187,7 +203,7 @@ func (b *Btree) stealFromRight(parent, child, right *page.Page, childIdx uint16)
 
 	if child.PageType() == page.TypeLeaf {
 		// target gains the first record of the right sibling
-		child.InsertRecord(stolenKey, stolenValue)
+		insertLeafRecord(child, stolenKey, stolenValue, stolenOverflow)
 
 		// parent separator updated to the new minimum of the right sibling
 		parent.DeleteRecord(separator)
@@ -364,9 +380,16 @@ func (b *Btree) unlinkLeaf(p *page.Page) error {
 }
 
 func (b *Btree) deleteOnLeaf(key []byte, p *page.Page) (bool, error) {
-	if !p.DeleteRecord(key) {
+	i, found := p.SearchKey(key)
+	if !found {
 		return false, ErrKeyNotFound
 	}
+	if p.OverflowByIndex(i) {
+		if err := b.freeOverflow(p.ValueByIndex(i)); err != nil {
+			return false, err
+		}
+	}
+	p.DeleteRecord(key)
 	b.pager.MarkDirty(p.PageID())
 	return p.BytesUntilUnderflow() < 0, nil
 }
@@ -0,0 +1,131 @@
+package btree
+
+import (
+	"bytes"
+
+	"github.com/guiwoch/toyDB/internal/storage/page"
+)
+
+// DeleteRange deletes every record with a key in [lo, hi) and returns how
+// many it removed. A nil bound means unbounded on that side.
+//
+// Leaves that lie entirely inside the range are dropped whole: their
+// overflow chains are freed, the leaf is merged out of its parent and its
+// page is returned to the pager, without deleting records one at a time.
+// Only the leaves holding a bound are trimmed key by key.
+func (b *Btree) DeleteRange(lo, hi []byte) (int, error) {
+	deleted := 0
+	for {
+		p, i, err := b.rangeStart(lo)
+		if err != nil {
+			return deleted, err
+		}
+		if p == nil {
+			return deleted, nil
+		}
+
+		n := p.RecordCount()
+		if hi != nil && bytes.Compare(p.KeyByIndex(i), hi) >= 0 {
+			b.pager.Unpin(p.PageID())
+			return deleted, nil
+		}
+
+		if i == 0 && (hi == nil || bytes.Compare(p.KeyByIndex(n-1), hi) < 0) {
+			first := bytes.Clone(p.KeyByIndex(0))
+			b.pager.Unpin(p.PageID())
+			if err := b.deleteWith(first, b.emptyLeaf); err != nil {
+				return deleted, err
+			}
+			deleted += int(n)
+			continue
+		}
+
+		// A bound falls inside this leaf; delete its keys in range singly.
+		var keys [][]byte
+		j := i
+		for ; j < n; j++ {
+			key := p.KeyByIndex(j)
+			if hi != nil && bytes.Compare(key, hi) >= 0 {
+				break
+			}
+			keys = append(keys, bytes.Clone(key))
+		}
+		b.pager.Unpin(p.PageID())
+		for _, key := range keys {
+			if err := b.Delete(key); err != nil {
+				return deleted, err
+			}
+			deleted++
+		}
+		if j < n { // reached hi
+			return deleted, nil
+		}
+	}
+}
+
+// rangeStart returns the pinned leaf holding the first key >= lo and that
+// key's slot, or a nil page if no such key exists.
+func (b *Btree) rangeStart(lo []byte) (*page.Page, uint16, error) {
+	var p *page.Page
+	var err error
+	if lo == nil {
+		var first uint32
+		if first, err = b.firstLeaf(); err == nil {
+			p, err = b.pager.Get(first)
+		}
+	} else {
+		p, err = b.findLeaf(lo)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	i, _ := p.SearchKey(lo)
+	if i < p.RecordCount() {
+		return p, i, nil
+	}
+	next := p.NextLeaf()
+	b.pager.Unpin(p.PageID())
+	if next == 0 {
+		return nil, 0, nil
+	}
+	if p, err = b.pager.Get(next); err != nil {
+		return nil, 0, err
+	}
+	return p, 0, nil
+}
+
+// emptyLeaf removes every record from leaf p, freeing their overflow
+// chains. The emptied leaf always underflows, so deleteOnInternal merges it
+// away; a root leaf is simply left empty.
+func (b *Btree) emptyLeaf(_ []byte, p *page.Page) (bool, error) {
+	for i := range p.RecordCount() {
+		if !p.OverflowByIndex(i) {
+			continue
+		}
+		if err := b.freeOverflow(p.ValueByIndex(i)); err != nil {
+			return false, err
+		}
+	}
+	prev, next := p.PrevLeaf(), p.NextLeaf()
+	*p = *page.NewPage(p.PageID(), page.TypeLeaf)
+	p.SetPrevLeaf(prev)
+	p.SetNextLeaf(next)
+	b.pager.MarkDirty(p.PageID())
+	return true, nil
+}
+
+// Truncate frees every page in the tree and starts over with an empty root
+// leaf. Unlike Destroy, the tree stays usable; its root ID changes.
+func (b *Btree) Truncate() error {
+	if err := b.Destroy(); err != nil {
+		return err
+	}
+	root, err := b.pager.Allocate(page.TypeLeaf)
+	if err != nil {
+		return err
+	}
+	rootID := root.PageID()
+	b.pager.Unpin(rootID)
+	return b.Reopen(rootID)
+}
@@ -0,0 +1,146 @@
+package btree_test
+
+import (
+	"encoding/binary"
+	"slices"
+	"testing"
+)
+
+func TestDeleteRange(t *testing.T) {
+	t.Parallel()
+	between := func(i int) []byte { return binary.BigEndian.AppendUint32(nil, uint32(i)*3+1) }
+	tests := []struct {
+		name   string
+		n      int
+		keyLen int
+		// lo and hi index the loaded records; -1 leaves the bound open.
+		lo, hi int
+		// gap places the bounds between keys instead of on them.
+		gap bool
+	}{
+		{"everything", 20_000, 4, -1, -1, false},
+		{"prefix", 20_000, 4, -1, 12_345, false},
+		{"suffix", 20_000, 4, 7_000, -1, false},
+		{"middle", 20_000, 4, 3_000, 17_000, false},
+		{"between keys", 20_000, 4, 3_000, 17_000, true},
+		{"within one leaf", 20_000, 4, 100, 105, false},
+		{"empty range", 20_000, 4, 500, 500, false},
+		{"root leaf", 100, 4, 10, 90, false},
+		// Few keys fit on a page, so whole subtrees empty out.
+		{"long keys", 3000, 1000, 200, 2900, false},
+		{"long keys, everything", 3000, 1000, -1, -1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			tree := newTestTree(t)
+			records := sortedRecords(tt.n, tt.keyLen)
+			if err := tree.BulkLoad(seqOf(records), 0); err != nil {
+				t.Fatal(err)
+			}
+
+			bound := func(i int) []byte {
+				if i < 0 {
+					return nil
+				}
+				if tt.gap {
+					return between(i - 1)
+				}
+				return records[i].Key
+			}
+			lo, hi := bound(tt.lo), bound(tt.hi)
+			from, to := tt.lo, tt.hi
+			if from < 0 {
+				from = 0
+			}
+			if to < 0 {
+				to = tt.n
+			}
+			want := slices.Concat(records[:from], records[to:])
+
+			n, err := tree.DeleteRange(lo, hi)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != to-from {
+				t.Errorf("expected %d records deleted, got %d", to-from, n)
+			}
+
+			got := collectRange(t, tree.AscendingRange(nil, nil))
+			if !slices.EqualFunc(got, want, recordsEqual) {
+				t.Fatalf("ascending range returned %d records, want %d", len(got), len(want))
+			}
+			got = collectRange(t, tree.DescendingRange(nil, nil))
+			slices.Reverse(got)
+			if !slices.EqualFunc(got, want, recordsEqual) {
+				t.Fatalf("descending range returned %d records, want %d", len(got), len(want))
+			}
+
+			// The tree stays balanced enough to keep taking writes.
+			for _, r := range records[from:to] {
+				if err := tree.Insert(r.Key, r.Value); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got = collectRange(t, tree.AscendingRange(nil, nil))
+			if !slices.EqualFunc(got, records, recordsEqual) {
+				t.Fatalf("expected all %d records after reinserting, got %d", len(records), len(got))
+			}
+		})
+	}
+}
+
+func TestDeleteRangeFreesPages(t *testing.T) {
+	t.Parallel()
+	tree, p := newTestTreeAndPager(t)
+	records := sortedRecords(20_000, 4)
+	if err := tree.BulkLoad(seqOf(records), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.DeleteRange(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	grown := p.NewID()
+	for _, r := range records {
+		if err := tree.Insert(r.Key, r.Value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Inserting leaves pages half full, so it needs more pages than the
+	// load did, but it should start with the ones the delete freed.
+	loaded, fresh := newTestTreeAndPager(t)
+	if err := loaded.BulkLoad(seqOf(records), 0); err != nil {
+		t.Fatal(err)
+	}
+	if used := p.NewID() - grown; used >= fresh.NewID() {
+		t.Errorf("expected reinsertion to reuse freed pages, file grew by %d pages", used)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	t.Parallel()
+	tree, p := newTestTreeAndPager(t)
+	records := sortedRecords(20_000, 4)
+	if err := tree.BulkLoad(seqOf(records), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Truncate(); err != nil {
+		t.Fatal(err)
+	}
+	if got := collectRange(t, tree.AscendingRange(nil, nil)); len(got) != 0 {
+		t.Fatalf("expected an empty tree after truncate, got %d records", len(got))
+	}
+
+	grown := p.NewID()
+	if err := tree.BulkLoad(seqOf(records), 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.NewID(); got != grown {
+		t.Errorf("expected reloading to reuse the truncated pages, file grew from %d to %d pages", grown, got)
+	}
+	got := collectRange(t, tree.AscendingRange(nil, nil))
+	if !slices.EqualFunc(got, records, recordsEqual) {
+		t.Fatalf("expected %d records after reloading, got %d", len(records), len(got))
+	}
+}
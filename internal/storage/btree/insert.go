@@ -9,20 +9,42 @@ import (
 	"github.com/guiwoch/toyDB/internal/storage/page"
 )
 
+// ErrDuplicateKey is returned by Insert when the key is already present.
+var ErrDuplicateKey = page.ErrDuplicateKey
+
 type splitResult struct {
 	promotedKey []byte
 	left, right *page.Page
 	oldPageID   uint32
 }
 
+// Insert adds a record to the tree. Values too large to keep inline are
+// stored in overflow pages. Returns ErrKeyTooLarge if the key is longer
+// than MaxKeySize, or ErrDuplicateKey if the key is already present.
 func (b *Btree) Insert(key, value []byte) error {
+	if len(key) > MaxKeySize {
+		return fmt.Errorf("%w: %d bytes, max %d", ErrKeyTooLarge, len(key), MaxKeySize)
+	}
+	overflow := needsOverflow(key, value)
+	if overflow {
+		ref, err := b.writeOverflow(value)
+		if err != nil {
+			return err
+		}
+		value = ref
+	}
 	root, err := b.pager.Get(b.rootID)
 	if err != nil {
 		return err
 	}
-	splitRes, err := b.insert(key, value, root)
+	splitRes, err := b.insert(key, value, overflow, root)
 	b.pager.Unpin(root.PageID())
 	if err != nil {
+		if overflow {
+			if freeErr := b.freeOverflow(value); freeErr != nil {
+				return errors.Join(err, freeErr)
+			}
+		}
 		return err
 	}
 	if splitRes != nil {
@@ -42,23 +64,23 @@ func (b *Btree) Insert(key, value []byte) error {
 	return nil
 }
 
-func (b *Btree) insert(key, value []byte, p *page.Page) (*splitResult, error) {
+func (b *Btree) insert(key, value []byte, overflow bool, p *page.Page) (*splitResult, error) {
 	switch p.PageType() {
 	case page.TypeInternal:
-		return b.insertIntoInternal(key, value, p)
+		return b.insertIntoInternal(key, value, overflow, p)
 	case page.TypeLeaf:
-		return b.insertIntoLeaf(key, value, p)
+		return b.insertIntoLeaf(key, value, overflow, p)
 	}
 	panic("Unknown page type")
 }
 
-func (b *Btree) insertIntoInternal(key, value []byte, p *page.Page) (*splitResult, error) {
+func (b *Btree) insertIntoInternal(key, value []byte, overflow bool, p *page.Page) (*splitResult, error) {
 	// decent: go towards the leaf
 	nextPage, err := b.findChild(key, p)
 	if err != nil {
 		return nil, err
 	}
-	splitRes, err := b.insert(key, value, nextPage)
+	splitRes, err := b.insert(key, value, overflow, nextPage)
 	b.pager.Unpin(nextPage.PageID())
 	if err != nil {
 		return nil, err
@@ -155,8 +177,8 @@ func (b *Btree) splitInternal(pendingSplit *splitResult, p *page.Page) (*splitRe
 	return split, nil
 }
 
-func (b *Btree) insertIntoLeaf(key, value []byte, p *page.Page) (*splitResult, error) {
-	err := p.InsertRecord(key, value)
+func (b *Btree) insertIntoLeaf(key, value []byte, overflow bool, p *page.Page) (*splitResult, error) {
+	err := insertLeafRecord(p, key, value, overflow)
 	if !errors.Is(err, page.ErrPageFull) {
 		if err == nil {
 			b.pager.MarkDirty(p.PageID())
@@ -164,10 +186,10 @@ func (b *Btree) insertIntoLeaf(key, value []byte, p *page.Page) (*splitResult, e
 		return nil, err
 	}
 
-	return b.splitLeaf(key, value, p)
+	return b.splitLeaf(key, value, overflow, p)
 }
 
-func (b *Btree) splitLeaf(key, value []byte, p *page.Page) (*splitResult, error) {
+func (b *Btree) splitLeaf(key, value []byte, overflow bool, p *page.Page) (*splitResult, error) {
 	split := &splitResult{}
 	splitIdx := p.BalancedSplitIndex()
 	splitKey := p.KeyByIndex(splitIdx)
@@ -219,9 +241,9 @@ func (b *Btree) splitLeaf(key, value []byte, p *page.Page) (*splitResult, error)
 
 	// insert the key into the correct half
 	if bytes.Compare(key, splitKey) >= 0 {
-		err = split.right.InsertRecord(key, value)
+		err = insertLeafRecord(split.right, key, value, overflow)
 	} else {
-		err = split.left.InsertRecord(key, value)
+		err = insertLeafRecord(split.left, key, value, overflow)
 	}
 	if err != nil {
 		panic("no space for insert after leaf split")
@@ -0,0 +1,123 @@
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/guiwoch/toyDB/internal/storage/page"
+)
+
+// maxInlineRecord bounds the page footprint of a leaf record. A value that
+// would take its record past it is moved to a chain of overflow pages and
+// the leaf keeps a reference to the chain instead. Keeping every record
+// within a quarter of a page guarantees that either half of a split has
+// room for the record that caused it.
+const maxInlineRecord = (page.PageSize - page.PageHeaderSize) / 4
+
+// An overflow reference is the value's total length followed by the ID of
+// the first page of its chain.
+const overflowRefSize = 8
+
+// MaxKeySize is the largest key the tree accepts: one whose record still
+// fits inline with an overflow reference as its value.
+const MaxKeySize = maxInlineRecord - overflowRefSize - 8
+
+// ErrKeyTooLarge is returned by Insert for keys longer than MaxKeySize.
+var ErrKeyTooLarge = errors.New("key too large")
+
+// needsOverflow reports whether a record with this key and value must store
+// its value in an overflow chain.
+func needsOverflow(key, value []byte) bool {
+	return page.RecordFootprint(len(key), len(value)) > maxInlineRecord
+}
+
+// writeOverflow stores value in a new chain of overflow pages and returns
+// the reference to it. The chain is written back to front so each page is
+// complete, next pointer included, when it is unpinned.
+func (b *Btree) writeOverflow(value []byte) ([]byte, error) {
+	var next uint32
+	for end := len(value); end > 0; {
+		start := (end - 1) / page.OverflowCapacity * page.OverflowCapacity
+		p, err := b.pager.Allocate(page.TypeOverflow)
+		if err != nil {
+			if next != 0 {
+				err = errors.Join(err, b.freeOverflowChain(next))
+			}
+			return nil, err
+		}
+		p.SetOverflowData(value[start:end])
+		p.SetOverflowNext(next)
+		next = p.PageID()
+		b.pager.Unpin(next)
+		end = start
+	}
+	ref := make([]byte, overflowRefSize)
+	binary.BigEndian.PutUint32(ref[0:4], uint32(len(value)))
+	binary.BigEndian.PutUint32(ref[4:8], next)
+	return ref, nil
+}
+
+// readOverflow returns the value an overflow reference points to.
+func (b *Btree) readOverflow(ref []byte) ([]byte, error) {
+	if len(ref) != overflowRefSize {
+		return nil, fmt.Errorf("overflow reference of %d bytes, want %d", len(ref), overflowRefSize)
+	}
+	length := int(binary.BigEndian.Uint32(ref[0:4]))
+	value := make([]byte, 0, length)
+	for id := binary.BigEndian.Uint32(ref[4:8]); id != 0; {
+		p, err := b.src.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		if p.PageType() != page.TypeOverflow {
+			b.src.Unpin(id)
+			return nil, fmt.Errorf("overflow chain reaches page %d of type %d", id, p.PageType())
+		}
+		value = append(value, p.OverflowData()...)
+		next := p.OverflowNext()
+		b.src.Unpin(id)
+		id = next
+	}
+	if len(value) != length {
+		return nil, fmt.Errorf("overflow chain holds %d bytes, reference says %d", len(value), length)
+	}
+	return value, nil
+}
+
+// freeOverflow frees the chain an overflow reference points to.
+func (b *Btree) freeOverflow(ref []byte) error {
+	return b.freeOverflowChain(binary.BigEndian.Uint32(ref[4:8]))
+}
+
+func (b *Btree) freeOverflowChain(id uint32) error {
+	for id != 0 {
+		p, err := b.pager.Get(id)
+		if err != nil {
+			return err
+		}
+		next := p.OverflowNext()
+		b.pager.Free(id)
+		id = next
+	}
+	return nil
+}
+
+// leafValue returns the value of the record at slot i of leaf p, reading it
+// from its overflow chain if it has one.
+func (b *Btree) leafValue(p *page.Page, i uint16) ([]byte, error) {
+	value := p.ValueByIndex(i)
+	if !p.OverflowByIndex(i) {
+		return value, nil
+	}
+	return b.readOverflow(value)
+}
+
+// insertLeafRecord inserts a record into leaf p, flagging it as an overflow
+// reference if overflow is set.
+func insertLeafRecord(p *page.Page, key, value []byte, overflow bool) error {
+	if overflow {
+		return p.InsertOverflowRecord(key, value)
+	}
+	return p.InsertRecord(key, value)
+}
@@ -0,0 +1,142 @@
+package btree_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/guiwoch/toyDB/internal/storage/btree"
+	"github.com/guiwoch/toyDB/internal/storage/page"
+	"github.com/guiwoch/toyDB/internal/storage/pager"
+)
+
+// largeValue returns n bytes derived from seed, so a value read back from
+// the wrong chain or in the wrong order does not compare equal.
+func largeValue(seed uint32, n int) []byte {
+	rng := rand.New(rand.NewSource(int64(seed)))
+	value := make([]byte, n)
+	rng.Read(value)
+	return value
+}
+
+func TestOverflowValuesRoundTrip(t *testing.T) {
+	t.Parallel()
+	tree := newTestTree(t)
+	sizes := []int{0, 100, 2000, 2100, page.OverflowCapacity, page.OverflowCapacity + 1, 50_000, 300_000}
+	for i, size := range sizes {
+		key := binary.BigEndian.AppendUint32(nil, uint32(i))
+		if err := tree.Insert(key, largeValue(uint32(i), size)); err != nil {
+			t.Fatalf("insert %d-byte value: %v", size, err)
+		}
+	}
+
+	for i, size := range sizes {
+		key := binary.BigEndian.AppendUint32(nil, uint32(i))
+		got, err := tree.Search(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, largeValue(uint32(i), size)) {
+			t.Errorf("search: %d-byte value read back as %d different bytes", size, len(got))
+		}
+	}
+	for i, r := range collectRange(t, tree.AscendingRange(nil, nil)) {
+		if !bytes.Equal(r.Value, largeValue(uint32(i), sizes[i])) {
+			t.Errorf("ascending range: %d-byte value read back as %d different bytes", sizes[i], len(r.Value))
+		}
+	}
+	for i, r := range collectRange(t, tree.DescendingRange(nil, nil)) {
+		j := len(sizes) - 1 - i
+		if !bytes.Equal(r.Value, largeValue(uint32(j), sizes[j])) {
+			t.Errorf("descending range: %d-byte value read back as %d different bytes", sizes[j], len(r.Value))
+		}
+	}
+}
+
+func TestOverflowMixedSizesWithDeletes(t *testing.T) {
+	t.Parallel()
+	tree := newTestTree(t)
+	const n = 2000
+	rng := rand.New(rand.NewSource(42))
+	sizes := make([]int, n)
+	for i := range sizes {
+		sizes[i] = rng.Intn(20_000)
+		key := binary.BigEndian.AppendUint32(nil, uint32(i))
+		if err := tree.Insert(key, largeValue(uint32(i), sizes[i])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Deleting every other record forces steals and merges between leaves
+	// that mix inline and overflow records.
+	for i := 0; i < n; i += 2 {
+		if err := tree.Delete(binary.BigEndian.AppendUint32(nil, uint32(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := range n {
+		key := binary.BigEndian.AppendUint32(nil, uint32(i))
+		got, err := tree.Search(key)
+		if i%2 == 0 {
+			if !errors.Is(err, btree.ErrKeyNotFound) {
+				t.Errorf("expected key %d to be deleted, got err=%v", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, largeValue(uint32(i), sizes[i])) {
+			t.Errorf("key %d: %d-byte value read back as %d different bytes", i, sizes[i], len(got))
+		}
+	}
+}
+
+func TestOverflowPagesFreedOnDelete(t *testing.T) {
+	p, _, err := pager.Open(t.TempDir() + "/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	root, err := p.Allocate(page.TypeLeaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Unpin(root.PageID())
+	tree, err := btree.Open(p, root.PageID())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	insertAll := func() {
+		for i := range uint32(50) {
+			if err := tree.Insert(binary.BigEndian.AppendUint32(nil, i), largeValue(i, 30_000)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	insertAll()
+	for i := range uint32(50) {
+		if err := tree.Delete(binary.BigEndian.AppendUint32(nil, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	grown := p.NewID()
+	insertAll()
+	if got := p.NewID(); got != grown {
+		t.Errorf("expected reinsertion to reuse freed overflow pages, file grew from %d to %d pages", grown, got)
+	}
+}
+
+func TestInsertKeyTooLarge(t *testing.T) {
+	t.Parallel()
+	tree := newTestTree(t)
+	if err := tree.Insert(make([]byte, btree.MaxKeySize), nil); err != nil {
+		t.Fatalf("expected key of MaxKeySize to be accepted, got %v", err)
+	}
+	if err := tree.Insert(make([]byte, btree.MaxKeySize+1), nil); !errors.Is(err, btree.ErrKeyTooLarge) {
+		t.Errorf("expected ErrKeyTooLarge, got %v", err)
+	}
+}
@@ -16,7 +16,10 @@ func (b *Btree) AscendingRange(lo, hi []byte) iter.Seq2[Record, error] {
 		var p *page.Page
 		var err error
 		if lo == nil { // use the first page
-			p, err = b.pager.Get(b.firstLeafID)
+			var first uint32
+			if first, err = b.firstLeaf(); err == nil {
+				p, err = b.src.Get(first)
+			}
 		} else {
 			p, err = b.findLeaf(lo)
 		}
@@ -26,11 +29,25 @@ func (b *Btree) AscendingRange(lo, hi []byte) iter.Seq2[Record, error] {
 		}
 
 		if p.RecordCount() == 0 {
-			b.pager.Unpin(p.PageID())
+			b.src.Unpin(p.PageID())
 			return
 		}
 
 		i, _ := p.SearchKey(lo)
+		// lo can sort after every key in its leaf, in the gap before the
+		// next leaf's first key; the range then starts at the next leaf.
+		if i == p.RecordCount() {
+			next := p.NextLeaf()
+			b.src.Unpin(p.PageID())
+			if next == 0 {
+				return
+			}
+			if p, err = b.src.Get(next); err != nil {
+				yield(Record{}, err)
+				return
+			}
+			i = 0
+		}
 
 		var key []byte
 		for {
@@ -38,10 +55,15 @@ func (b *Btree) AscendingRange(lo, hi []byte) iter.Seq2[Record, error] {
 			if hi != nil && bytes.Compare(key, hi) >= 0 {
 				break
 			}
-			value := p.ValueByIndex(i)
+			value, err := b.leafValue(p, i)
+			if err != nil {
+				b.src.Unpin(p.PageID())
+				yield(Record{}, err)
+				return
+			}
 
 			if !yield(Record{Key: key, Value: value}, nil) {
-				b.pager.Unpin(p.PageID())
+				b.src.Unpin(p.PageID())
 				return
 			}
 
@@ -50,8 +72,8 @@ func (b *Btree) AscendingRange(lo, hi []byte) iter.Seq2[Record, error] {
 					break
 				}
 
-				nextPage, err := b.pager.Get(p.NextLeaf())
-				b.pager.Unpin(p.PageID())
+				nextPage, err := b.src.Get(p.NextLeaf())
+				b.src.Unpin(p.PageID())
 				if err != nil {
 					yield(Record{}, err)
 					return
@@ -62,7 +84,7 @@ func (b *Btree) AscendingRange(lo, hi []byte) iter.Seq2[Record, error] {
 				i++
 			}
 		}
-		b.pager.Unpin(p.PageID())
+		b.src.Unpin(p.PageID())
 	}
 }
 
@@ -74,13 +96,16 @@ func (b *Btree) DescendingRange(lo, hi []byte) iter.Seq2[Record, error] {
 		var err error
 
 		if hi == nil { // use the last page
-			p, err = b.pager.Get(b.lastLeafID)
+			var last uint32
+			if last, err = b.lastLeaf(); err == nil {
+				p, err = b.src.Get(last)
+			}
 			if err != nil {
 				yield(Record{}, err)
 				return
 			}
 			if p.RecordCount() == 0 {
-				b.pager.Unpin(p.PageID())
+				b.src.Unpin(p.PageID())
 				return
 			}
 			hi = p.KeyByIndex(p.RecordCount() - 1)
@@ -91,13 +116,27 @@ func (b *Btree) DescendingRange(lo, hi []byte) iter.Seq2[Record, error] {
 				return
 			}
 			if p.RecordCount() == 0 {
-				b.pager.Unpin(p.PageID())
+				b.src.Unpin(p.PageID())
 				return
 			}
 		}
 
 		i, found := p.SearchKey(hi)
 		if !found {
+			// hi can sort before every key in its leaf; the range then
+			// starts at the previous leaf's last key.
+			if i == 0 {
+				prev := p.PrevLeaf()
+				b.src.Unpin(p.PageID())
+				if prev == 0 {
+					return
+				}
+				if p, err = b.src.Get(prev); err != nil {
+					yield(Record{}, err)
+					return
+				}
+				i = p.RecordCount()
+			}
 			i--
 		}
 
@@ -106,9 +145,14 @@ func (b *Btree) DescendingRange(lo, hi []byte) iter.Seq2[Record, error] {
 			if lo != nil && bytes.Compare(key, lo) <= 0 {
 				break
 			}
-			value := p.ValueByIndex(i)
+			value, err := b.leafValue(p, i)
+			if err != nil {
+				b.src.Unpin(p.PageID())
+				yield(Record{}, err)
+				return
+			}
 			if !yield(Record{Key: key, Value: value}, nil) {
-				b.pager.Unpin(p.PageID())
+				b.src.Unpin(p.PageID())
 				return
 			}
 
@@ -117,8 +161,8 @@ func (b *Btree) DescendingRange(lo, hi []byte) iter.Seq2[Record, error] {
 					break
 				}
 
-				prevPage, err := b.pager.Get(p.PrevLeaf())
-				b.pager.Unpin(p.PageID())
+				prevPage, err := b.src.Get(p.PrevLeaf())
+				b.src.Unpin(p.PageID())
 				if err != nil {
 					yield(Record{}, err)
 					return
@@ -129,6 +173,24 @@ func (b *Btree) DescendingRange(lo, hi []byte) iter.Seq2[Record, error] {
 				i--
 			}
 		}
-		b.pager.Unpin(p.PageID())
+		b.src.Unpin(p.PageID())
+	}
+}
+
+// firstLeaf returns the leftmost leaf, descending to find it if the tree
+// was opened without caching it.
+func (b *Btree) firstLeaf() (uint32, error) {
+	if b.firstLeafID != 0 {
+		return b.firstLeafID, nil
+	}
+	return b.findLeftmostLeaf()
+}
+
+// lastLeaf returns the rightmost leaf, descending to find it if the tree
+// was opened without caching it.
+func (b *Btree) lastLeaf() (uint32, error) {
+	if b.lastLeafID != 0 {
+		return b.lastLeafID, nil
 	}
+	return b.findRightmostLeaf()
 }
@@ -2,6 +2,7 @@ package btree_test
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"iter"
 	"slices"
@@ -180,3 +181,29 @@ func TestDescendingRangePartialScan(t *testing.T) {
 		assertRangeEqual(t, got, []btree.Record{r7, r5})
 	})
 }
+
+// TestRangeBoundsBetweenLeaves scans from every bound between two adjacent
+// keys, which includes bounds that sort after every key of the leaf they
+// descend to and before every key of the next one.
+func TestRangeBoundsBetweenLeaves(t *testing.T) {
+	t.Parallel()
+	tree := newTestTree(t)
+	const n = 5000
+	value := make([]byte, 32)
+	for i := range uint32(n) {
+		key := binary.BigEndian.AppendUint32(nil, 2*i+1) // odd keys only
+		if err := tree.Insert(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := range uint32(n + 1) {
+		bound := binary.BigEndian.AppendUint32(nil, 2*i) // between keys
+		if got := len(collectRange(t, tree.AscendingRange(bound, nil))); got != int(n-i) {
+			t.Fatalf("ascending from %d: expected %d records, got %d", 2*i, n-i, got)
+		}
+		if got := len(collectRange(t, tree.DescendingRange(nil, bound))); got != int(i) {
+			t.Fatalf("descending from %d: expected %d records, got %d", 2*i, i, got)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package btree
+
+import (
+	"encoding/binary"
+
+	"github.com/guiwoch/toyDB/internal/storage/page"
+)
+
+// Stats describes the shape of a tree.
+type Stats struct {
+	Height        int // levels, counting the leaves; 1 for a lone leaf root
+	InternalPages int
+	LeafPages     int
+}
+
+// Stats walks the tree's internal pages to measure it. Leaves are counted
+// from their parents' child pointers, and only the first is read, so the
+// cost is about the number of internal pages, a small fraction of the tree.
+func (b *Btree) Stats() (Stats, error) {
+	s := Stats{Height: 1}
+	level := []uint32{b.rootID}
+	for {
+		var children []uint32
+		for _, id := range level {
+			p, err := b.src.Get(id)
+			if err != nil {
+				return Stats{}, err
+			}
+			if p.PageType() != page.TypeInternal {
+				b.src.Unpin(id)
+				s.LeafPages += len(level)
+				return s, nil
+			}
+			s.InternalPages++
+			for i := range p.RecordCount() {
+				children = append(children, binary.BigEndian.Uint32(p.ValueByIndex(i)))
+			}
+			children = append(children, p.RightPointer())
+			b.src.Unpin(id)
+		}
+		s.Height++
+		level = children
+	}
+}
+
+// EstimateRange estimates the share of the tree's records with keys in
+// [lo, hi), as a fraction between 0 and 1. A nil bound is unbounded on that
+// side. It descends the tree once for each bound, reading as many pages as
+// a Search, and places each bound by the child it descends into at every
+// level; the estimate is exact for a tree whose pages are evenly filled.
+func (b *Btree) EstimateRange(lo, hi []byte) (float64, error) {
+	from, to := 0.0, 1.0
+	var err error
+	if lo != nil {
+		if from, err = b.position(lo); err != nil {
+			return 0, err
+		}
+	}
+	if hi != nil {
+		if to, err = b.position(hi); err != nil {
+			return 0, err
+		}
+	}
+	return max(to-from, 0), nil
+}
+
+// position estimates the share of the tree's records with keys below key.
+func (b *Btree) position(key []byte) (float64, error) {
+	p, err := b.src.Get(b.rootID)
+	if err != nil {
+		return 0, err
+	}
+	pos, width := 0.0, 1.0
+	for p.PageType() == page.TypeInternal {
+		i, found := p.SearchKey(key)
+		if found {
+			i++
+		}
+		n := float64(p.RecordCount()) + 1
+		pos += width * float64(i) / n
+		width /= n
+		childID := b.findChildID(p, i)
+		b.src.Unpin(p.PageID())
+		if p, err = b.src.Get(childID); err != nil {
+			return 0, err
+		}
+	}
+	defer b.src.Unpin(p.PageID())
+	if n := p.RecordCount(); n > 0 {
+		i, _ := p.SearchKey(key)
+		pos += width * float64(i) / float64(n)
+	}
+	return pos, nil
+}
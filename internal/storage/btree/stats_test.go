@@ -0,0 +1,80 @@
+package btree_test
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	t.Parallel()
+	tree, p := newTestTreeAndPager(t)
+	s, err := tree.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Height != 1 || s.LeafPages != 1 || s.InternalPages != 0 {
+		t.Fatalf("empty tree: %+v", s)
+	}
+
+	// Long keys keep the fan-out low enough for several levels. A split
+	// frees the page it splits, and the next split reuses it, so the tree
+	// is every page the pager has handed out but one or two.
+	rng := rand.New(rand.NewPCG(1, 2))
+	for _, i := range rng.Perm(20_000) {
+		key := binary.BigEndian.AppendUint32(make([]byte, 0, 200), uint32(i))[:200]
+		if err := tree.Insert(key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s, err = tree.Stats(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Height < 3 {
+		t.Errorf("height %d, want at least 3", s.Height)
+	}
+	counted := uint64(s.InternalPages + s.LeafPages)
+	if total := p.Stats().TotalPages; counted > total || counted+2 < total {
+		t.Errorf("counted %d internal and %d leaf pages, pager has %d", s.InternalPages, s.LeafPages, total)
+	}
+}
+
+func TestEstimateRange(t *testing.T) {
+	t.Parallel()
+	tree := newTestTree(t)
+	const n = 40_000
+	rng := rand.New(rand.NewPCG(3, 4))
+	for _, i := range rng.Perm(n) {
+		key := binary.BigEndian.AppendUint32(nil, uint32(i))
+		if err := tree.Insert(key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	key := func(i int) []byte {
+		if i < 0 {
+			return nil
+		}
+		return binary.BigEndian.AppendUint32(nil, uint32(i))
+	}
+	tests := []struct{ lo, hi int }{
+		{-1, -1}, {-1, 10_000}, {30_000, -1}, {12_345, 23_456}, {500, 600}, {7, 7}, {n, -1}, {9_000, 8_000},
+	}
+	for _, tt := range tests {
+		got, err := tree.EstimateRange(key(tt.lo), key(tt.hi))
+		if err != nil {
+			t.Fatal(err)
+		}
+		lo, hi := max(tt.lo, 0), tt.hi
+		if hi < 0 {
+			hi = n
+		}
+		want := max(float64(hi-lo)/n, 0)
+		// Pages are not evenly filled after random inserts; allow for
+		// that.
+		if math.Abs(got-want) > 0.02 {
+			t.Errorf("EstimateRange(%d, %d) = %.4f, want about %.4f", tt.lo, tt.hi, got, want)
+		}
+	}
+}
@@ -12,6 +12,13 @@ import (
 // rooted on a freshly-allocated leaf. The pager is closed automatically on
 // test cleanup, with a pin-leak check.
 func newTestTree(t *testing.T) *btree.Btree {
+	t.Helper()
+	tree, _ := newTestTreeAndPager(t)
+	return tree
+}
+
+// newTestTreeAndPager is newTestTree for tests that also inspect the pager.
+func newTestTreeAndPager(t *testing.T) (*btree.Btree, *pager.Pager) {
 	t.Helper()
 	p, _, err := pager.Open(t.TempDir() + "/test")
 	if err != nil {
@@ -36,5 +43,5 @@ func newTestTree(t *testing.T) *btree.Btree {
 	if err != nil {
 		t.Fatal(err)
 	}
-	return tree
+	return tree, p
 }
@@ -0,0 +1,82 @@
+package btree
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/guiwoch/toyDB/internal/storage/page"
+)
+
+// Update replaces the value of an existing record. If the new value fits in
+// the record's leaf it is written in place in a single descent, leaving the
+// tree's shape untouched; otherwise the record is deleted and reinserted,
+// splitting as Insert does. Returns ErrKeyNotFound if the key is not
+// present.
+func (b *Btree) Update(key, value []byte) error {
+	if len(key) > MaxKeySize {
+		return fmt.Errorf("%w: %d bytes, max %d", ErrKeyTooLarge, len(key), MaxKeySize)
+	}
+	stored, overflow := value, needsOverflow(key, value)
+	if overflow {
+		ref, err := b.writeOverflow(value)
+		if err != nil {
+			return err
+		}
+		stored = ref
+	}
+	// freeNew releases the new value's chain when it is not installed.
+	freeNew := func(err error) error {
+		if !overflow {
+			return err
+		}
+		return errors.Join(err, b.freeOverflow(stored))
+	}
+
+	leaf, err := b.findLeaf(key)
+	if err != nil {
+		return freeNew(err)
+	}
+	i, found := leaf.SearchKey(key)
+	if !found {
+		b.pager.Unpin(leaf.PageID())
+		return freeNew(ErrKeyNotFound)
+	}
+	var oldRef []byte
+	if leaf.OverflowByIndex(i) {
+		oldRef = leaf.ValueByIndex(i)
+	}
+	err = updateLeafRecord(leaf, key, stored, overflow)
+	if err == nil {
+		b.pager.MarkDirty(leaf.PageID())
+	}
+	b.pager.Unpin(leaf.PageID())
+	switch {
+	case err == nil:
+		if oldRef != nil {
+			return b.freeOverflow(oldRef)
+		}
+		return nil
+	case !errors.Is(err, page.ErrPageFull):
+		return freeNew(err)
+	}
+
+	// The leaf has no room for the larger value. Inline values that big are
+	// rare, since large ones go to overflow chains, so fall back to a delete
+	// and an insert rather than splitting around the record in place.
+	if err := freeNew(nil); err != nil {
+		return err
+	}
+	if err := b.Delete(key); err != nil {
+		return err
+	}
+	return b.Insert(key, value)
+}
+
+// updateLeafRecord replaces the value of a record in leaf p, flagging it as
+// an overflow reference if overflow is set.
+func updateLeafRecord(p *page.Page, key, value []byte, overflow bool) error {
+	if overflow {
+		return p.UpdateOverflowRecord(key, value)
+	}
+	return p.UpdateRecord(key, value)
+}
@@ -0,0 +1,115 @@
+package btree_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/guiwoch/toyDB/internal/storage/btree"
+)
+
+func TestUpdateNotFound(t *testing.T) {
+	t.Parallel()
+	tree := newTestTree(t)
+	if err := tree.Insert([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Update([]byte("b"), []byte("2")); !errors.Is(err, btree.ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound, got %v", err)
+	}
+	if err := tree.Update([]byte("b"), largeValue(0, 50_000)); !errors.Is(err, btree.ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound for an overflow value, got %v", err)
+	}
+}
+
+func TestUpdateInPlace(t *testing.T) {
+	t.Parallel()
+	tree, p := newTestTreeAndPager(t)
+
+	records := uniqueRecords(2000)
+	for _, r := range records {
+		if err := tree.Insert(r.key[:], r.value[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rootID, pages := tree.RootID(), p.NewID()
+	for i := range records {
+		records[i].value[0]++
+		if err := tree.Update(records[i].key[:], records[i].value[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tree.RootID() != rootID || p.NewID() != pages {
+		t.Errorf("same-size updates changed the tree's shape: root %d -> %d, pages %d -> %d",
+			rootID, tree.RootID(), pages, p.NewID())
+	}
+	for _, r := range records {
+		got, err := tree.Search(r.key[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, r.value[:]) {
+			t.Errorf("key %x: expected %x, got %x", r.key, r.value, got)
+		}
+	}
+}
+
+func TestUpdateGrowingValues(t *testing.T) {
+	t.Parallel()
+	tree := newTestTree(t)
+	const n = 500
+	key := func(i int) []byte { return binary.BigEndian.AppendUint32(nil, uint32(i)) }
+	for i := range n {
+		if err := tree.Insert(key(i), []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Each value grows past what its leaf can hold in place, forcing splits.
+	for i := range n {
+		if err := tree.Update(key(i), largeValue(uint32(i), 1500)); err != nil {
+			t.Fatalf("update %d: %v", i, err)
+		}
+	}
+	got := collectRange(t, tree.AscendingRange(nil, nil))
+	if len(got) != n {
+		t.Fatalf("expected %d records, got %d", n, len(got))
+	}
+	for i, r := range got {
+		if !bytes.Equal(r.Key, key(i)) || !bytes.Equal(r.Value, largeValue(uint32(i), 1500)) {
+			t.Fatalf("record %d read back wrong", i)
+		}
+	}
+}
+
+func TestUpdateOverflowValues(t *testing.T) {
+	t.Parallel()
+	tree, p := newTestTreeAndPager(t)
+
+	key := []byte("k")
+	if err := tree.Insert(key, []byte("small")); err != nil {
+		t.Fatal(err)
+	}
+	var grown uint32
+	for round := range uint32(3) {
+		for _, value := range [][]byte{largeValue(round, 40_000), largeValue(round+1, 60_000), []byte("small again")} {
+			if err := tree.Update(key, value); err != nil {
+				t.Fatal(err)
+			}
+			got, err := tree.Search(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, value) {
+				t.Fatalf("round %d: %d-byte value read back as %d different bytes", round, len(value), len(got))
+			}
+		}
+		// Replaced chains are freed, so after the first round the file
+		// stops growing.
+		if round == 0 {
+			grown = p.NewID()
+		} else if got := p.NewID(); got != grown {
+			t.Errorf("round %d: expected replaced overflow pages to be reused, file grew from %d to %d pages", round, grown, got)
+		}
+	}
+}
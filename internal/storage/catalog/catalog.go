@@ -10,36 +10,192 @@ import (
 )
 
 // Row is the value stored per table in the catalog. SchemaBytes is the
-// serialized schema produced by the schema package.
+// serialized schema produced by the schema package, and SchemaVersion
+// counts the changes made to it.
+//
+// The remaining fields are table metadata, kept up to date by the caller.
+// Rows read in a format older than CurrentFormat leave them zero.
 type Row struct {
-	RootID      uint32
-	SchemaBytes []byte
+	RootID        uint32
+	SchemaBytes   []byte
+	Indexes       []Index
+	SchemaVersion uint32
+
+	Created  int64 // Unix nanoseconds; 0 if unknown
+	Modified int64 // Unix nanoseconds; 0 if never modified or unknown
+	RowCount uint64
+	Comment  string
+
+	// Format is the encoding the row was read in. Rows are always
+	// written in CurrentFormat.
+	Format uint8
+}
+
+// Row encodings. Format 0 rows are unversioned and start with the root ID;
+// later ones start with a zero word, which no root ID can be since page 0
+// holds the database header, followed by the format number.
+const (
+	formatMeta    = 1 // adds table metadata
+	CurrentFormat = formatMeta
+)
+
+// Index describes one secondary index of a table. Columns holds the
+// positions of the indexed columns in the table schema, in key order.
+type Index struct {
+	Name    string
+	RootID  uint32
+	Columns []uint8
 }
 
-// Layout: [rootID:4][schemaLen:4][schemaBytes:N]
+// Layout: [0:4][format:1][rootID:4][schemaLen:4][schemaBytes:N]
+// [indexCount:1], per index as in format 0, then [schemaVersion:4]
+// [created:8][modified:8][rowCount:8][commentLen:2][comment:N].
 func encodeRow(r Row) []byte {
-	buf := make([]byte, 8+len(r.SchemaBytes))
-	binary.BigEndian.PutUint32(buf[0:4], r.RootID)
-	binary.BigEndian.PutUint32(buf[4:8], uint32(len(r.SchemaBytes)))
-	copy(buf[8:], r.SchemaBytes)
+	buf := make([]byte, 5, 64+len(r.SchemaBytes)+len(r.Comment))
+	buf[4] = CurrentFormat
+	buf = binary.BigEndian.AppendUint32(buf, r.RootID)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(r.SchemaBytes)))
+	buf = append(buf, r.SchemaBytes...)
+	buf = appendIndexes(buf, r.Indexes)
+	buf = binary.BigEndian.AppendUint32(buf, r.SchemaVersion)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(r.Created))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(r.Modified))
+	buf = binary.BigEndian.AppendUint64(buf, r.RowCount)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(r.Comment)))
+	return append(buf, r.Comment...)
+}
+
+func appendIndexes(buf []byte, indexes []Index) []byte {
+	buf = append(buf, byte(len(indexes)))
+	for _, idx := range indexes {
+		buf = append(buf, byte(len(idx.Name)))
+		buf = append(buf, idx.Name...)
+		buf = binary.BigEndian.AppendUint32(buf, idx.RootID)
+		buf = append(buf, byte(len(idx.Columns)))
+		buf = append(buf, idx.Columns...)
+	}
 	return buf
 }
 
 func decodeRow(buf []byte) (Row, error) {
+	if len(buf) >= 5 && binary.BigEndian.Uint32(buf) == 0 {
+		if format := buf[4]; format != formatMeta {
+			return Row{}, fmt.Errorf("decode catalog row: unknown format %d", format)
+		}
+		return decodeRowMeta(buf[5:])
+	}
+	return decodeRowLegacy(buf)
+}
+
+// decodeRowMeta decodes a format 1 row, past its format header.
+func decodeRowMeta(buf []byte) (Row, error) {
+	row, offset, err := decodeRowBody(buf)
+	if err != nil {
+		return Row{}, err
+	}
+	if offset, err = decodeIndexes(&row, buf, offset); err != nil {
+		return Row{}, err
+	}
+	const fixed = 4 + 8 + 8 + 8 + 2
+	if len(buf)-offset < fixed {
+		return Row{}, fmt.Errorf("decode catalog row: metadata truncated, need %d bytes, have %d", fixed, len(buf)-offset)
+	}
+	row.SchemaVersion = binary.BigEndian.Uint32(buf[offset:])
+	row.Created = int64(binary.BigEndian.Uint64(buf[offset+4:]))
+	row.Modified = int64(binary.BigEndian.Uint64(buf[offset+12:]))
+	row.RowCount = binary.BigEndian.Uint64(buf[offset+20:])
+	commentLen := int(binary.BigEndian.Uint16(buf[offset+28:]))
+	offset += fixed
+	if len(buf)-offset != commentLen {
+		return Row{}, fmt.Errorf("decode catalog row: comment holds %d bytes, length says %d", len(buf)-offset, commentLen)
+	}
+	row.Comment = string(buf[offset:])
+	row.Format = formatMeta
+	return row, nil
+}
+
+// Format 0 layout: [rootID:4][schemaLen:4][schemaBytes:N], followed, only
+// if the table has indexes or a schema version, by [indexCount:1] and per
+// index:
+// - [1 byte]  name length (N)
+// - [N bytes] name
+// - [4 bytes] root ID
+// - [1 byte]  column count (C)
+// - [C bytes] column positions
+//
+// followed, only if the schema version is not 0, by [schemaVersion:4].
+//
+// Rows written before indexes existed end after the schema and decode with
+// no indexes; rows written before schemas could change end after the
+// indexes and decode with version 0.
+func decodeRowLegacy(buf []byte) (Row, error) {
+	row, offset, err := decodeRowBody(buf)
+	if err != nil {
+		return Row{}, err
+	}
+	if offset == len(buf) {
+		return row, nil
+	}
+	if offset, err = decodeIndexes(&row, buf, offset); err != nil {
+		return Row{}, err
+	}
+	if len(buf)-offset == 4 {
+		row.SchemaVersion = binary.BigEndian.Uint32(buf[offset:])
+		offset += 4
+	}
+	if offset != len(buf) {
+		return Row{}, fmt.Errorf("decode catalog row: %d trailing bytes after consuming %d", len(buf)-offset, offset)
+	}
+	return row, nil
+}
+
+// decodeRowBody decodes the root ID and schema every format starts with,
+// returning the offset just past them.
+func decodeRowBody(buf []byte) (Row, int, error) {
 	if len(buf) < 8 {
-		return Row{}, fmt.Errorf("decode catalog row: header truncated, got %d bytes, need 8", len(buf))
+		return Row{}, 0, fmt.Errorf("decode catalog row: header truncated, got %d bytes, need 8", len(buf))
 	}
 	rootID := binary.BigEndian.Uint32(buf[0:4])
 	schemaLen := binary.BigEndian.Uint32(buf[4:8])
 	if len(buf)-8 < int(schemaLen) {
-		return Row{}, fmt.Errorf("decode catalog row: schema truncated, need %d bytes, have %d", schemaLen, len(buf)-8)
-	}
-	if int(schemaLen) != len(buf)-8 {
-		return Row{}, fmt.Errorf("decode catalog row: %d trailing bytes after consuming %d", len(buf)-8-int(schemaLen), 8+schemaLen)
+		return Row{}, 0, fmt.Errorf("decode catalog row: schema truncated, need %d bytes, have %d", schemaLen, len(buf)-8)
 	}
 	schemaBytes := make([]byte, schemaLen)
 	copy(schemaBytes, buf[8:])
-	return Row{RootID: rootID, SchemaBytes: schemaBytes}, nil
+	return Row{RootID: rootID, SchemaBytes: schemaBytes}, 8 + int(schemaLen), nil
+}
+
+// decodeIndexes decodes the index list starting at offset into row,
+// returning the offset just past it.
+func decodeIndexes(row *Row, buf []byte, offset int) (int, error) {
+	if len(buf)-offset < 1 {
+		return 0, fmt.Errorf("decode catalog row: index count truncated")
+	}
+	count := int(buf[offset])
+	offset++
+	row.Indexes = make([]Index, count)
+	for i := range count {
+		if len(buf)-offset < 1 {
+			return 0, fmt.Errorf("decode catalog row: index %d truncated", i)
+		}
+		nameLen := int(buf[offset])
+		offset++
+		if len(buf)-offset < nameLen+5 {
+			return 0, fmt.Errorf("decode catalog row: index %d truncated", i)
+		}
+		row.Indexes[i].Name = string(buf[offset : offset+nameLen])
+		offset += nameLen
+		row.Indexes[i].RootID = binary.BigEndian.Uint32(buf[offset:])
+		offset += 4
+		colCount := int(buf[offset])
+		offset++
+		if len(buf)-offset < colCount {
+			return 0, fmt.Errorf("decode catalog row: index %d columns truncated", i)
+		}
+		row.Indexes[i].Columns = append([]uint8(nil), buf[offset:offset+colCount]...)
+		offset += colCount
+	}
+	return offset, nil
 }
 
 type Catalog struct {
@@ -75,11 +231,31 @@ func (c *Catalog) Upsert(name string, row Row) error {
 	return c.tree.Insert(key, encodeRow(row))
 }
 
+// Reopen points the catalog at the tree rooted at rootID, discarding any
+// in-memory state. Used after a rollback.
+func (c *Catalog) Reopen(rootID uint32) error {
+	return c.tree.Reopen(rootID)
+}
+
 // RootID returns the current root page ID of the catalog tree.
 func (c *Catalog) RootID() uint32 {
 	return c.tree.RootID()
 }
 
+// Rename moves the entry for oldName to newName. Returns
+// btree.ErrKeyNotFound if there is no entry for oldName, and
+// btree.ErrDuplicateKey if there already is one for newName.
+func (c *Catalog) Rename(oldName, newName string) error {
+	value, err := c.tree.Search([]byte(oldName))
+	if err != nil {
+		return err
+	}
+	if err := c.tree.Insert([]byte(newName), value); err != nil {
+		return err
+	}
+	return c.tree.Delete([]byte(oldName))
+}
+
 // Delete removes the catalog entry for name. Returns btree.ErrKeyNotFound
 // if the entry does not exist.
 func (c *Catalog) Delete(name string) error {
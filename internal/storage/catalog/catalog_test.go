@@ -0,0 +1,145 @@
+package catalog_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/guiwoch/toyDB/internal/storage/btree"
+	"github.com/guiwoch/toyDB/internal/storage/catalog"
+	"github.com/guiwoch/toyDB/internal/storage/page"
+	"github.com/guiwoch/toyDB/internal/storage/pager"
+)
+
+// newTestCatalog returns a catalog on a fresh tree, and the tree, for
+// tests that write raw rows into it.
+func newTestCatalog(t *testing.T) (*catalog.Catalog, *btree.Btree) {
+	t.Helper()
+	p, _, err := pager.Open(t.TempDir() + "/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = p.Close() })
+	root, err := p.Allocate(page.TypeLeaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Unpin(root.PageID())
+	tree, err := btree.Open(p, root.PageID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return catalog.Open(tree), tree
+}
+
+// legacyRow encodes a format 0 row: the root and schema, then whatever
+// tail the version of toyDB that wrote it appended.
+func legacyRow(rootID uint32, schema string, tail ...byte) []byte {
+	buf := binary.BigEndian.AppendUint32(nil, rootID)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(schema)))
+	buf = append(buf, schema...)
+	return append(buf, tail...)
+}
+
+func TestLookupFormat0(t *testing.T) {
+	t.Parallel()
+	// One index, "ix", rooted at page 9 on columns 1 and 2.
+	index := []byte{1, 2, 'i', 'x', 0, 0, 0, 9, 2, 1, 2}
+	tests := []struct {
+		name string
+		raw  []byte
+		want catalog.Row
+	}{
+		{"schema only", legacyRow(7, "abc"),
+			catalog.Row{RootID: 7, SchemaBytes: []byte("abc")}},
+		{"indexes", legacyRow(7, "abc", index...),
+			catalog.Row{RootID: 7, SchemaBytes: []byte("abc"), Indexes: []catalog.Index{{Name: "ix", RootID: 9, Columns: []uint8{1, 2}}}}},
+		{"schema version", legacyRow(7, "abc", 0, 0, 0, 0, 3),
+			catalog.Row{RootID: 7, SchemaBytes: []byte("abc"), Indexes: []catalog.Index{}, SchemaVersion: 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			c, tree := newTestCatalog(t)
+			if err := tree.Insert([]byte("t"), tt.raw); err != nil {
+				t.Fatal(err)
+			}
+			got, ok, err := c.Lookup("t")
+			if err != nil || !ok {
+				t.Fatalf("Lookup: %v, %v", ok, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpsertFormat1(t *testing.T) {
+	t.Parallel()
+	c, tree := newTestCatalog(t)
+	// Stored over a format 0 row, which Upsert replaces.
+	if err := tree.Insert([]byte("t"), legacyRow(7, "abc")); err != nil {
+		t.Fatal(err)
+	}
+	row := catalog.Row{
+		RootID:        8,
+		SchemaBytes:   []byte("abcd"),
+		Indexes:       []catalog.Index{{Name: "ix", RootID: 9, Columns: []uint8{1}}},
+		SchemaVersion: 2,
+		Created:       -1,
+		Modified:      1 << 40,
+		RowCount:      12,
+		Comment:       "people",
+	}
+	if err := c.Upsert("t", row); err != nil {
+		t.Fatal(err)
+	}
+	got, ok, err := c.Lookup("t")
+	if err != nil || !ok {
+		t.Fatalf("Lookup: %v, %v", ok, err)
+	}
+	row.Format = catalog.CurrentFormat
+	if !reflect.DeepEqual(got, row) {
+		t.Errorf("got %+v\nwant %+v", got, row)
+	}
+
+	if err := c.Rename("t", "u"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := c.Lookup("t"); ok {
+		t.Error("old name still found after Rename")
+	}
+	if got, _, err := c.Lookup("u"); err != nil || !reflect.DeepEqual(got, row) {
+		t.Errorf("after Rename got %+v, %v\nwant %+v", got, err, row)
+	}
+	if err := c.Rename("missing", "v"); !errors.Is(err, btree.ErrKeyNotFound) {
+		t.Errorf("Rename of a missing row: got %v, want ErrKeyNotFound", err)
+	}
+}
+
+func TestLookupCorrupt(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		raw  []byte
+	}{
+		{"unknown format", []byte{0, 0, 0, 0, 9}},
+		{"truncated schema", legacyRow(7, "abc")[:9]},
+		{"truncated metadata", append([]byte{0, 0, 0, 0, catalog.CurrentFormat}, legacyRow(7, "abc", 0)...)},
+		{"format 0 trailing bytes", legacyRow(7, "abc", 0, 1, 2)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			c, tree := newTestCatalog(t)
+			if err := tree.Insert([]byte("t"), tt.raw); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := c.Lookup("t"); err == nil {
+				t.Error("Lookup decoded a corrupt row")
+			}
+		})
+	}
+}
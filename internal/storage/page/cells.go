@@ -8,18 +8,27 @@ const (
 	cellKeySizeOff       = 0
 	cellValueOrIdSizeOff = 2
 	cellHeaderSize       = 4
+
+	// cellOverflowFlag is set in the value size field of a cell whose value
+	// is a reference to an overflow chain rather than the value itself.
+	// Sizes never reach it, since they are bounded by PageSize.
+	cellOverflowFlag = 0x8000
 )
 
 // writeCell writes a new cell.
 // No explicit delete operation is needed: cells without a corresponding slot
 // are deleted during compaction.
-func (p *Page) writeCell(key, valueOrID []byte) uint16 {
+func (p *Page) writeCell(key, valueOrID []byte, overflow bool) uint16 {
 	keySize, valueOrIDSize := uint16(len(key)), uint16(len(valueOrID))
 	cellSize := cellHeaderSize + keySize + valueOrIDSize
 	offset := p.cellAlloc() - cellSize
 
+	sizeField := valueOrIDSize
+	if overflow {
+		sizeField |= cellOverflowFlag
+	}
 	binary.BigEndian.PutUint16(p[offset+cellKeySizeOff:], keySize)
-	binary.BigEndian.PutUint16(p[offset+cellValueOrIdSizeOff:], valueOrIDSize)
+	binary.BigEndian.PutUint16(p[offset+cellValueOrIdSizeOff:], sizeField)
 	copy(p[offset+cellHeaderSize:], key)
 	copy(p[offset+cellHeaderSize+keySize:], valueOrID)
 
@@ -76,7 +85,13 @@ func (p *Page) cellKey(slotIndex uint16) []byte {
 func (p *Page) cellValue(slotIndex uint16) []byte {
 	cellOffset := int(p.getCellOffset(slotIndex))
 	keySize := binary.BigEndian.Uint16(p[cellOffset+cellKeySizeOff:])
-	valueSize := binary.BigEndian.Uint16(p[cellOffset+cellValueOrIdSizeOff:])
+	valueSize := binary.BigEndian.Uint16(p[cellOffset+cellValueOrIdSizeOff:]) &^ cellOverflowFlag
 	valueOffset := cellOffset + cellHeaderSize + int(keySize)
 	return p[valueOffset : valueOffset+int(valueSize)]
 }
+
+// cellOverflow reports whether a cell's value is an overflow reference.
+func (p *Page) cellOverflow(slotIndex uint16) bool {
+	cellOffset := int(p.getCellOffset(slotIndex))
+	return binary.BigEndian.Uint16(p[cellOffset+cellValueOrIdSizeOff:])&cellOverflowFlag != 0
+}
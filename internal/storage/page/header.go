@@ -8,6 +8,7 @@ import (
 const (
 	TypeInternal = 1
 	TypeLeaf     = 2
+	TypeOverflow = 3
 )
 
 const (
@@ -16,12 +17,14 @@ const (
 	hdrSlotAllocOff = 6  // uint16 (first free byte after slot directory, grows ->)
 	hdrCellAllocOff = 8  // uint16 (first free byte before cell data, grows <-)
 	hdrFreeSpaceOff = 10 // uint16 (total free space)
-	hdrPageTypeOff  = 12 // uint8  (internal=1, leaf=2)
+	hdrPageTypeOff  = 12 // uint8  (internal=1, leaf=2, overflow=3)
 	hdrChecksumOff  = 14 // uint32
 	hdrRightPointer = 18 // uint32
 	hdrNextLeaf     = 22 // uint32
 	hdrPrevLeaf     = 26 // uint32
 	hdrNextFree     = 30 // uint32 (next pointer when page is on the freelist; undefined otherwise)
+	hdrOverflowNext = 34 // uint32 (next page of an overflow chain; 0 on the last page)
+	hdrOverflowLen  = 38 // uint16 (bytes of value data on an overflow page)
 )
 
 func (p *Page) PageID() uint32 {
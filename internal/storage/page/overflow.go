@@ -0,0 +1,37 @@
+package page
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// OverflowCapacity is the number of value bytes an overflow page holds.
+// Overflow pages carry no slots: everything past the header is data.
+const OverflowCapacity = PageSize - PageHeaderSize
+
+// OverflowNext returns the ID of the next page in the overflow chain, or 0
+// if this is the last one.
+func (p *Page) OverflowNext() uint32 {
+	return binary.BigEndian.Uint32(p[hdrOverflowNext:])
+}
+
+func (p *Page) SetOverflowNext(id uint32) {
+	binary.BigEndian.PutUint32(p[hdrOverflowNext:], id)
+}
+
+// OverflowData returns the value bytes stored on an overflow page. The
+// slice aliases the page.
+func (p *Page) OverflowData() []byte {
+	n := binary.BigEndian.Uint16(p[hdrOverflowLen:])
+	return p[PageHeaderSize : PageHeaderSize+int(n)]
+}
+
+// SetOverflowData stores data, at most OverflowCapacity bytes, as the page's
+// share of an overflow value.
+func (p *Page) SetOverflowData(data []byte) {
+	if len(data) > OverflowCapacity {
+		panic(fmt.Sprintf("overflow data of %d bytes exceeds page capacity %d", len(data), OverflowCapacity))
+	}
+	binary.BigEndian.PutUint16(p[hdrOverflowLen:], uint16(len(data)))
+	copy(p[PageHeaderSize:], data)
+}
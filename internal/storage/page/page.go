@@ -112,12 +112,24 @@ func MergeRecords(left, right *Records) *Records {
 var (
 	ErrPageFull     = errors.New("insufficient space on page")
 	ErrDuplicateKey = errors.New("key already exists")
+	ErrKeyNotFound  = errors.New("key not found")
 )
 
 // InsertRecord adds a new key-value pair to the page in sorted order.
 // Returns ErrDuplicateKey if the key already exists.
 // Returns ErrPageFull if insufficient space even after compaction.
 func (p *Page) InsertRecord(key, valueOrID []byte) error {
+	return p.insertRecord(key, valueOrID, false)
+}
+
+// InsertOverflowRecord is InsertRecord for a record whose value lives in an
+// overflow chain: ref is the caller's reference to the chain, and the
+// record is flagged so that OverflowByIndex reports it.
+func (p *Page) InsertOverflowRecord(key, ref []byte) error {
+	return p.insertRecord(key, ref, true)
+}
+
+func (p *Page) insertRecord(key, valueOrID []byte, overflow bool) error {
 	i, found := p.SearchKey(key)
 	if found {
 		return ErrDuplicateKey
@@ -134,11 +146,64 @@ func (p *Page) InsertRecord(key, valueOrID []byte) error {
 		}
 	}
 
-	offset := p.writeCell(key, valueOrID)
+	offset := p.writeCell(key, valueOrID, overflow)
 	p.writeSlot(offset, cellSize, i)
 	return nil
 }
 
+// UpdateRecord replaces the value of the record with the given key,
+// keeping its slot. A value no larger than the old one is written over it in
+// place. Returns ErrKeyNotFound if the key does not exist, or ErrPageFull if
+// the larger value does not fit even after compaction, in which case the
+// page is unchanged.
+func (p *Page) UpdateRecord(key, valueOrID []byte) error {
+	return p.updateRecord(key, valueOrID, false)
+}
+
+// UpdateOverflowRecord is UpdateRecord for a new value that lives in an
+// overflow chain, as for InsertOverflowRecord.
+func (p *Page) UpdateOverflowRecord(key, ref []byte) error {
+	return p.updateRecord(key, ref, true)
+}
+
+func (p *Page) updateRecord(key, valueOrID []byte, overflow bool) error {
+	i, found := p.SearchKey(key)
+	if !found {
+		return ErrKeyNotFound
+	}
+
+	oldSize := p.getCellSize(i)
+	cellSize := cellHeaderSize + uint16(len(key)) + uint16(len(valueOrID))
+	if cellSize <= oldSize {
+		// Overwrite the cell from its start; the tail it no longer uses is
+		// reclaimed by the next compaction.
+		offset := p.getCellOffset(i)
+		sizeField := uint16(len(valueOrID))
+		if overflow {
+			sizeField |= cellOverflowFlag
+		}
+		binary.BigEndian.PutUint16(p[offset+cellValueOrIdSizeOff:], sizeField)
+		copy(p[offset+cellHeaderSize+uint16(len(key)):], valueOrID)
+		p.writeSlotLength(i, cellSize)
+		p.setFreeSpace(p.FreeSpace() + oldSize - cellSize)
+		return nil
+	}
+
+	if cellSize-oldSize > p.FreeSpace() {
+		return ErrPageFull
+	}
+	// Release the old cell before compacting so its space is reclaimed.
+	p.writeSlotLength(i, 0)
+	p.setFreeSpace(p.FreeSpace() + oldSize)
+	if cellSize > p.cellAlloc()-p.slotAlloc() {
+		p.compactCells()
+	}
+	offset := p.writeCell(key, valueOrID, overflow)
+	p.updateOffsetSlot(i, offset)
+	p.writeSlotLength(i, cellSize)
+	return nil
+}
+
 // DeleteRecord deletes the record with the given key and compacts the slot directory.
 // Returns false if the key is not found.
 func (p *Page) DeleteRecord(key []byte) bool {
@@ -148,6 +213,9 @@ func (p *Page) DeleteRecord(key []byte) bool {
 	}
 
 	slotOff := PageHeaderSize + i*slotSize
+	// Read the size before the slot directory shifts slot i onto the next
+	// record.
+	cellSize := p.getCellSize(i)
 
 	isLastSlot := i == p.slotCount()-1
 	if !isLastSlot {
@@ -156,7 +224,6 @@ func (p *Page) DeleteRecord(key []byte) bool {
 
 	p.setSlotAlloc(p.slotAlloc() - slotSize)
 	p.setSlotCount(p.slotCount() - 1)
-	cellSize := uint16(cellHeaderSize + len(p.cellKey(i)) + len(p.cellValue(i)))
 	p.setFreeSpace(p.FreeSpace() + slotSize + cellSize)
 	return true
 }
@@ -183,6 +250,16 @@ func (p *Page) ValueByIndex(slotIndex uint16) []byte {
 	return value
 }
 
+// OverflowByIndex reports whether the record at the given slot index was
+// inserted with InsertOverflowRecord, so that its value is an overflow
+// reference.
+func (p *Page) OverflowByIndex(slotIndex uint16) bool {
+	if slotIndex >= p.slotCount() {
+		panic(fmt.Sprintf("slot index %d out of bounds [0, %d)", slotIndex, p.slotCount()))
+	}
+	return p.cellOverflow(slotIndex)
+}
+
 // KeyByIndex returns the key at the given slot index.
 // It returns a copy of the key, so its safe to use across page mutations.
 func (p *Page) KeyByIndex(slotIndex uint16) []byte {
@@ -2,6 +2,7 @@ package page_test
 
 import (
 	"bytes"
+	"errors"
 	"testing"
 
 	"github.com/guiwoch/toyDB/internal/storage/page"
@@ -158,3 +159,120 @@ func TestDeleteRecord(t *testing.T) {
 		})
 	}
 }
+
+func TestDeleteRecordRestoresFreeSpace(t *testing.T) {
+	t.Parallel()
+	p := newTestPage(t, records{
+		{[]byte("a"), []byte("1")},
+		{[]byte("b"), []byte("a much longer value")},
+	})
+	before := p.FreeSpace()
+	if !p.DeleteRecord([]byte("a")) {
+		t.Fatal("DeleteRecord key not found")
+	}
+	want := before + uint16(page.RecordFootprint(1, 1))
+	if got := p.FreeSpace(); got != want {
+		t.Errorf("expected free space %d after delete, got %d", want, got)
+	}
+}
+
+func TestOverflowRecordKeepsFlag(t *testing.T) {
+	t.Parallel()
+	p := page.NewPage(0, page.TypeLeaf)
+	if err := p.InsertRecord([]byte("a"), []byte("inline")); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.InsertOverflowRecord([]byte("b"), []byte{0, 0, 0x40, 0, 0, 0, 0, 7}); err != nil {
+		t.Fatal(err)
+	}
+	if !p.DeleteRecord([]byte("a")) {
+		t.Fatal("expected record to be deleted")
+	}
+	if err := p.InsertRecord([]byte("c"), []byte("inline")); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []bool{true, false} {
+		if got := p.OverflowByIndex(uint16(i)); got != want {
+			t.Errorf("slot %d: expected overflow=%v, got %v", i, want, got)
+		}
+	}
+	if got := p.ValueByIndex(0); !bytes.Equal(got, []byte{0, 0, 0x40, 0, 0, 0, 0, 7}) {
+		t.Errorf("expected overflow reference to read back unchanged, got %v", got)
+	}
+}
+
+func TestUpdateRecord(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name  string
+		value []byte
+	}{
+		{"shorter value", []byte("x")},
+		{"same length value", []byte("22")},
+		{"longer value", bytes.Repeat([]byte("z"), 100)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			p := newTestPage(t, records{
+				{[]byte("a"), []byte("11")},
+				{[]byte("b"), []byte("22")},
+				{[]byte("c"), []byte("33")},
+			})
+			before := p.FreeSpace()
+			if err := p.UpdateRecord([]byte("b"), tt.value); err != nil {
+				t.Fatalf("UpdateRecord: %v", err)
+			}
+			if got, _ := p.Get([]byte("b")); !bytes.Equal(got, tt.value) {
+				t.Errorf("expected %q, got %q", tt.value, got)
+			}
+			for _, k := range []string{"a", "c"} {
+				if got, _ := p.Get([]byte(k)); !bytes.Equal(got, []byte{k[0] - 'a' + '1', k[0] - 'a' + '1'}) {
+					t.Errorf("neighbour %q changed to %q", k, got)
+				}
+			}
+			want := int(before) + 2 - len(tt.value)
+			if got := int(p.FreeSpace()); got != want {
+				t.Errorf("expected free space %d, got %d", want, got)
+			}
+		})
+	}
+}
+
+func TestUpdateRecordCompactsOrFails(t *testing.T) {
+	t.Parallel()
+	p := page.NewPage(0, page.TypeLeaf)
+	big := bytes.Repeat([]byte("v"), 1000)
+	for k := byte('a'); k < 'h'; k++ {
+		if err := p.InsertRecord([]byte{k}, big); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Free space exists only as holes left by the deletes, so the update
+	// must compact to fit.
+	p.DeleteRecord([]byte("b"))
+	p.DeleteRecord([]byte("d"))
+	grown := bytes.Repeat([]byte("w"), 2500)
+	if err := p.UpdateRecord([]byte("c"), grown); err != nil {
+		t.Fatalf("UpdateRecord: %v", err)
+	}
+	if got, _ := p.Get([]byte("c")); !bytes.Equal(got, grown) {
+		t.Error("updated value did not read back")
+	}
+	if got, _ := p.Get([]byte("e")); !bytes.Equal(got, big) {
+		t.Error("neighbour changed by compaction")
+	}
+
+	before := p.FreeSpace()
+	if err := p.UpdateRecord([]byte("a"), make([]byte, 8000)); !errors.Is(err, page.ErrPageFull) {
+		t.Fatalf("expected ErrPageFull, got %v", err)
+	}
+	if got, _ := p.Get([]byte("a")); !bytes.Equal(got, big) || p.FreeSpace() != before {
+		t.Error("failed update changed the page")
+	}
+	if err := p.UpdateRecord([]byte("zz"), nil); !errors.Is(err, page.ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound, got %v", err)
+	}
+}
@@ -36,6 +36,12 @@ func (p *Page) updateOffsetSlot(i, offset uint16) {
 	binary.BigEndian.PutUint16(p[slotOffset:], offset)
 }
 
+// writeSlotLength updates the cell length stored at slot i.
+func (p *Page) writeSlotLength(i, length uint16) {
+	slotOffset := PageHeaderSize + i*slotSize
+	binary.BigEndian.PutUint16(p[slotOffset+slotLengthOff:], length)
+}
+
 // getCellOffset returns the cell offset stored in the given slot.
 func (p *Page) getCellOffset(slotIndex uint16) uint16 {
 	slotOff := PageHeaderSize + slotIndex*slotSize
@@ -4,7 +4,6 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"slices"
 
 	"github.com/guiwoch/toyDB/internal/storage/page"
 )
@@ -34,36 +33,30 @@ func (pager *Pager) readPage(id uint32) (*page.Page, error) {
 	return &p, nil
 }
 
-// Flush writes all dirty pages to disk and fsyncs. Does not reset the dirty set.
+// Flush commits all dirty pages without touching page 0 and checkpoints the
+// write-ahead log, so every page is in the database file when it returns.
 func (pager *Pager) Flush() error {
-	// sort the dirty pages improves the disk write
-	pageIDs := make([]uint32, 0, len(pager.dirty))
-	for k := range pager.dirty {
-		pageIDs = append(pageIDs, k)
-	}
-	slices.Sort(pageIDs)
-
-	for _, id := range pageIDs {
-		p := pager.pages[id]
-		p.SetChecksum()
-		_, err := pager.file.WriteAt(p[:], int64(id)*int64(page.PageSize))
-		if err != nil {
-			return fmt.Errorf("page flush error: page id %v - %w", id, err)
-		}
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	if err := pager.commit(nil); err != nil {
+		return err
 	}
-	pager.dirty = make(map[uint32]struct{})
-	return pager.file.Sync()
+	return pager.checkpoint()
 }
 
 // ReadPage0 reads the raw bytes of page 0 into buf. Page 0 is not managed by
 // the buffer pool; it stores the DB header.
 func (pager *Pager) ReadPage0(buf []byte) error {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
 	_, err := pager.file.ReadAt(buf, 0)
 	return err
 }
 
 // WritePage0 writes buf to page 0 and fsyncs. Callers must size buf appropriately.
 func (pager *Pager) WritePage0(buf []byte) error {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
 	_, err := pager.file.WriteAt(buf, 0)
 	if err != nil {
 		return err
@@ -71,8 +64,20 @@ func (pager *Pager) WritePage0(buf []byte) error {
 	return pager.file.Sync()
 }
 
-// Close closes the underlying file. Callers must Flush and WritePage0 before
-// calling Close to guarantee durability.
+// Close checkpoints the write-ahead log and closes the underlying files.
+// Changes that were never committed are discarded; callers must Commit
+// before calling Close to guarantee durability.
 func (pager *Pager) Close() error {
-	return pager.file.Close()
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	pager.walSize = pager.walCommitted
+	if err := pager.checkpoint(); err != nil {
+		pager.closeFiles()
+		return err
+	}
+	return pager.closeFiles()
+}
+
+func (pager *Pager) closeFiles() error {
+	return errors.Join(pager.wal.Close(), pager.file.Close())
 }
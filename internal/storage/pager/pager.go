@@ -5,6 +5,7 @@ import (
 	"container/list"
 	"fmt"
 	"os"
+	"sync"
 
 	"github.com/guiwoch/toyDB/internal/storage/page"
 )
@@ -12,14 +13,39 @@ import (
 // DefaultCacheSize caps the buffer pool at 4096 pages (~32 MiB) by default.
 const DefaultCacheSize = 4096
 
+// Pager is safe for concurrent use: a single mutex guards the buffer pool,
+// pin counts, LRU list, allocator, and write-ahead log state. Pages handed
+// out by Get and Allocate are not themselves synchronized; callers must not
+// modify a page while another goroutine reads it.
 type Pager struct {
-	pins         []uint8
+	mu sync.Mutex
+	// fileMu is held exclusively by checkpoints, which rewrite the database
+	// file and truncate the log, and shared by snapshot reads made outside
+	// mu. It is always acquired after mu.
+	fileMu sync.RWMutex
+
+	pins         []uint32
 	pages        map[uint32]*page.Page
 	dirty        map[uint32]struct{}
 	file         *os.File
 	newID        uint32
 	freeListHead uint32
 
+	wal          *os.File
+	walSize      int64
+	walCommitted int64
+	walIndex     map[uint32][]int64 // committed frames: page ID -> payload offsets, oldest first
+	walPending   map[uint32]int64   // frames of pages evicted since the last commit
+	header       []byte             // page 0 image to install on checkpoint
+	walGen       uint64             // incremented by every checkpoint
+	commitSeq    uint64             // incremented by every commit
+	snapshots    map[uint64]int     // open snapshots per commitSeq they were taken at
+	recovered    bool               // Open found committed frames in the log
+
+	// allocator state as of the last commit, restored by Rollback
+	committedNewID        uint32
+	committedFreeListHead uint32
+
 	cacheCap    int
 	lru         *list.List
 	lruNodes    map[uint32]*list.Element
@@ -38,14 +64,16 @@ type Stats struct {
 	TotalPages  uint64
 }
 
-func (p *Pager) Stats() Stats {
-	cachedPages := uint64(len(p.pages))
+func (pager *Pager) Stats() Stats {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	cachedPages := uint64(len(pager.pages))
 	return Stats{
-		Hits:        p.hits,
-		Misses:      p.misses,
-		Evictions:   p.evictions,
+		Hits:        pager.hits,
+		Misses:      pager.misses,
+		Evictions:   pager.evictions,
 		CachedPages: cachedPages,
-		TotalPages:  uint64(p.newID - 1),
+		TotalPages:  uint64(pager.newID - 1),
 	}
 }
 
@@ -59,49 +87,84 @@ func WithCacheSize(n int) Option {
 }
 
 // Open opens (or creates) a pager-backed file. wasFresh reports whether the
-// file was created by this call. Page 0 is reserved for the DB header and is
-// not managed by the buffer pool.
+// file was created by this call, or was left empty by a crash before its
+// first commit. Page 0 is reserved for the DB header and is not managed by
+// the buffer pool.
+//
+// Any committed frames in the write-ahead log are replayed into the file
+// before Open returns, so the file reflects the last successful Commit.
 func Open(filename string, opts ...Option) (*Pager, bool, error) {
 	file, created, err := openFile(filename)
 	if err != nil {
 		return nil, false, err
 	}
-	newID := uint32(1)
-	if !created {
-		stat, err := file.Stat()
-		if err != nil {
-			file.Close()
-			return nil, false, err
-		}
-		if n := uint32(stat.Size() / int64(page.PageSize)); n > 1 {
-			newID = n
-		}
+	wal, err := openWAL(filename, created)
+	if err != nil {
+		file.Close()
+		return nil, false, err
 	}
 	p := &Pager{
-		pages:    make(map[uint32]*page.Page),
-		dirty:    make(map[uint32]struct{}),
-		file:     file,
-		newID:    newID,
-		cacheCap: DefaultCacheSize,
-		lru:      list.New(),
-		lruNodes: make(map[uint32]*list.Element),
+		pages:      make(map[uint32]*page.Page),
+		dirty:      make(map[uint32]struct{}),
+		file:       file,
+		newID:      1,
+		wal:        wal,
+		walIndex:   make(map[uint32][]int64),
+		walPending: make(map[uint32]int64),
+		snapshots:  make(map[uint64]int),
+		cacheCap:   DefaultCacheSize,
+		lru:        list.New(),
+		lruNodes:   make(map[uint32]*list.Element),
 	}
 	for _, opt := range opts {
 		opt(p)
 	}
-	return p, created, nil
+	if created {
+		p.committedNewID = p.newID
+		return p, true, nil
+	}
+
+	if err := p.recover(); err != nil {
+		p.closeFiles()
+		return nil, false, err
+	}
+	if err := p.Checkpoint(); err != nil {
+		p.closeFiles()
+		return nil, false, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		p.closeFiles()
+		return nil, false, err
+	}
+	if n := uint32(stat.Size() / int64(page.PageSize)); n > 1 {
+		p.newID = n
+	}
+	p.committedNewID = p.newID
+	return p, stat.Size() == 0, nil
 }
 
 // FreeListHead returns the head of the on-disk freelist.
-func (pager *Pager) FreeListHead() uint32 { return pager.freeListHead }
+func (pager *Pager) FreeListHead() uint32 {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	return pager.freeListHead
+}
 
 // SetFreeListHead seeds the freelist head from a persisted value (typically
 // the DB header in page 0). Call once on Open for an existing file.
-func (pager *Pager) SetFreeListHead(h uint32) { pager.freeListHead = h }
+func (pager *Pager) SetFreeListHead(h uint32) {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	pager.freeListHead = h
+	pager.committedFreeListHead = h
+}
 
 // NewID returns the next page ID that would be allocated from fresh space
 // (excluding the free list). DB persists this in page 0 on close.
 func (pager *Pager) NewID() uint32 {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
 	return pager.newID
 }
 
@@ -138,7 +201,7 @@ func (pager *Pager) peekNextFree(id uint32) (uint32, error) {
 	if cached, ok := pager.pages[id]; ok {
 		return cached.NextFree(), nil
 	}
-	pg, err := pager.readPage(id)
+	pg, err := pager.loadPage(id)
 	if err != nil {
 		return 0, err
 	}
@@ -146,6 +209,8 @@ func (pager *Pager) peekNextFree(id uint32) (uint32, error) {
 }
 
 func (pager *Pager) Allocate(pageType uint8) (*page.Page, error) {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
 	if err := pager.evictIfNeeded(); err != nil {
 		return nil, err
 	}
@@ -160,6 +225,8 @@ func (pager *Pager) Allocate(pageType uint8) (*page.Page, error) {
 }
 
 func (pager *Pager) AllocateFromRecords(pageType uint8, records *page.Records) (*page.Page, error) {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
 	if err := pager.evictIfNeeded(); err != nil {
 		return nil, err
 	}
@@ -177,6 +244,8 @@ func (pager *Pager) AllocateFromRecords(pageType uint8, records *page.Records) (
 // stays in memory and dirty so its NextFree pointer reaches disk on flush;
 // because its pin is cleared, the LRU may evict it before flush.
 func (pager *Pager) Free(id uint32) bool {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
 	p, ok := pager.pages[id]
 	if !ok {
 		return false
@@ -195,6 +264,8 @@ func (pager *Pager) Free(id uint32) bool {
 }
 
 func (pager *Pager) Get(id uint32) (*page.Page, error) {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
 	if pg, ok := pager.pages[id]; ok {
 		if elem, inLRU := pager.lruNodes[id]; inLRU {
 			pager.lru.Remove(elem)
@@ -210,7 +281,7 @@ func (pager *Pager) Get(id uint32) (*page.Page, error) {
 	if err := pager.evictIfNeeded(); err != nil {
 		return nil, err
 	}
-	pg, err := pager.readPage(id)
+	pg, err := pager.loadPage(id)
 	if err != nil {
 		return nil, err
 	}
@@ -225,6 +296,8 @@ func (pager *Pager) Get(id uint32) (*page.Page, error) {
 }
 
 func (pager *Pager) Unpin(id uint32) {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
 	if pager.pins[id] == 0 {
 		return
 	}
@@ -238,6 +311,8 @@ func (pager *Pager) Unpin(id uint32) {
 }
 
 func (pager *Pager) MarkDirty(id uint32) {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
 	pager.dirty[id] = struct{}{}
 }
 
@@ -260,17 +335,18 @@ func (pager *Pager) evictIfNeeded() error {
 	return nil
 }
 
-// evictPage flushes a dirty page before dropping it from the cache.
-// Does not fsync — Flush is the sync point.
+// evictPage spills a dirty page to the write-ahead log before dropping it
+// from the cache. The frame stays uncommitted until the next Commit, and the
+// database file is untouched. Does not fsync — Commit is the sync point.
 func (pager *Pager) evictPage(id uint32, elem *list.Element) error {
 	pager.lru.Remove(elem)
 	delete(pager.lruNodes, id)
 	if _, isDirty := pager.dirty[id]; isDirty {
-		pg := pager.pages[id]
-		pg.SetChecksum()
-		if _, err := pager.file.WriteAt(pg[:], int64(id)*int64(page.PageSize)); err != nil {
+		off, err := pager.appendPageFrame(pager.pages[id])
+		if err != nil {
 			return fmt.Errorf("evict page %d: %w", id, err)
 		}
+		pager.walPending[id] = off
 		delete(pager.dirty, id)
 	}
 	delete(pager.pages, id)
@@ -279,7 +355,11 @@ func (pager *Pager) evictPage(id uint32, elem *list.Element) error {
 }
 
 // PinnedCount returns the number of pages currently pinned.
-func (pager *Pager) PinnedCount() int { return pager.pinnedCount }
+func (pager *Pager) PinnedCount() int {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	return pager.pinnedCount
+}
 
 // dropFromCache removes an id from pages/dirty/lru without writing.
 // Used when allocateID is about to reinitialize the page.
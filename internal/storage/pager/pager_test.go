@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"os"
+	"sync"
 	"testing"
 
 	"github.com/guiwoch/toyDB/internal/storage/page"
@@ -230,3 +231,46 @@ func TestFreelistSurvivesReopen(t *testing.T) {
 	}
 	_ = b
 }
+
+func TestConcurrentGetUnpin(t *testing.T) {
+	p, _, err := Open(t.TempDir()+"/test", WithCacheSize(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	var ids []uint32
+	for range 16 {
+		ids = append(ids, allocID(t, p))
+	}
+	for _, id := range ids {
+		p.Unpin(id)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for g := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 1000 {
+				id := ids[(g+i)%len(ids)]
+				pg, err := p.Get(id)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if pg.PageID() != id {
+					t.Errorf("Get(%d) returned page %d", id, pg.PageID())
+				}
+				p.Unpin(id)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := p.PinnedCount(); n != 0 {
+		t.Errorf("expected no pinned pages, have %d", n)
+	}
+}
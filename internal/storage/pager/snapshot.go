@@ -0,0 +1,75 @@
+package pager
+
+import (
+	"slices"
+
+	"github.com/guiwoch/toyDB/internal/storage/page"
+)
+
+// Snapshot is a read-only view of every page as of the commit that was
+// current when it was taken. Later commits, and changes not yet committed,
+// are invisible to it. Pages it returns are private copies read from the
+// log or the database file, never the buffer pool's, so a writer may keep
+// modifying cached pages while the snapshot is in use.
+//
+// The versions a snapshot reads stay in the log until it is closed:
+// checkpoints are deferred while a snapshot of an earlier commit is open.
+type Snapshot struct {
+	pager *Pager
+	mark  int64  // end of the log as of the snapshot's commit
+	seq   uint64 // commitSeq the snapshot was taken at
+	gen   uint64 // walGen mark refers to
+}
+
+// Snapshot opens a view of the last commit. It must be closed.
+func (pager *Pager) Snapshot() *Snapshot {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	pager.snapshots[pager.commitSeq]++
+	return &Snapshot{
+		pager: pager,
+		mark:  pager.walCommitted,
+		seq:   pager.commitSeq,
+		gen:   pager.walGen,
+	}
+}
+
+// Get returns the page's image as of the snapshot: the newest committed
+// frame logged before the snapshot was taken, or else the database file.
+func (s *Snapshot) Get(id uint32) (*page.Page, error) {
+	p := s.pager
+	var off int64
+	p.mu.Lock()
+	// If the log was checkpointed since, the snapshot was of the latest
+	// commit at the time, which is what the database file now holds.
+	if s.gen == p.walGen {
+		offs := p.walIndex[id]
+		// Frames at or past mark were committed after the snapshot.
+		if i, _ := slices.BinarySearch(offs, s.mark); i > 0 {
+			off = offs[i-1]
+		}
+	}
+	// Hold off checkpoints, but not other pager work, during the read.
+	p.fileMu.RLock()
+	defer p.fileMu.RUnlock()
+	p.mu.Unlock()
+
+	if off == 0 {
+		return p.readPage(id)
+	}
+	return p.readFrame(id, off)
+}
+
+// Unpin is a no-op; snapshot pages are private copies.
+func (s *Snapshot) Unpin(uint32) {}
+
+// Close releases the snapshot and runs any checkpoint it was holding back.
+func (s *Snapshot) Close() error {
+	p := s.pager
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.snapshots[s.seq]--; p.snapshots[s.seq] == 0 {
+		delete(p.snapshots, s.seq)
+	}
+	return p.maybeCheckpoint()
+}
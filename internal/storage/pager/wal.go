@@ -0,0 +1,349 @@
+package pager
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/guiwoch/toyDB/internal/storage/page"
+)
+
+// The write-ahead log lives next to the database file, at the same path with
+// a "-wal" suffix. Page images are appended to it before they may reach the
+// database file, so the database file only ever changes during a checkpoint,
+// when every logged page is known to be committed.
+//
+// The log is a sequence of frames:
+// [1 byte]  frame kind (page or commit)
+// [3 bytes] reserved
+// [4 bytes] page ID (page frames only)
+// [4 bytes] payload length (N)
+// [4 bytes] CRC-32 of the preceding 12 bytes and the payload
+// [N bytes] payload: a page image, or the page 0 header for a commit frame
+//
+// A commit frame commits every page frame since the previous commit frame.
+// Frames after the last commit frame belong to an unfinished transaction
+// and are discarded on recovery.
+const (
+	frameHeaderSize = 16
+	frameKindPage   = 1
+	frameKindCommit = 2
+
+	frameKindOff   = 0
+	framePageIDOff = 4
+	frameLengthOff = 8
+	frameCRCOff    = 12
+)
+
+// checkpointSize is the number of logged page frames after which Commit
+// copies the log into the database file and truncates it.
+const checkpointSize = 1024
+
+func walPath(filename string) string {
+	return filename + "-wal"
+}
+
+func appendFrame(dst []byte, kind uint8, id uint32, payload []byte) []byte {
+	var hdr [frameHeaderSize]byte
+	hdr[frameKindOff] = kind
+	binary.BigEndian.PutUint32(hdr[framePageIDOff:], id)
+	binary.BigEndian.PutUint32(hdr[frameLengthOff:], uint32(len(payload)))
+	crc := crc32.NewIEEE()
+	crc.Write(hdr[:frameCRCOff])
+	crc.Write(payload)
+	binary.BigEndian.PutUint32(hdr[frameCRCOff:], crc.Sum32())
+	dst = append(dst, hdr[:]...)
+	return append(dst, payload...)
+}
+
+// appendPageFrame writes a page image to the end of the log without syncing
+// and returns the offset of its payload.
+func (pager *Pager) appendPageFrame(pg *page.Page) (int64, error) {
+	pg.SetChecksum()
+	buf := appendFrame(nil, frameKindPage, pg.PageID(), pg[:])
+	if _, err := pager.wal.WriteAt(buf, pager.walSize); err != nil {
+		return 0, fmt.Errorf("wal: append page %d: %w", pg.PageID(), err)
+	}
+	off := pager.walSize + frameHeaderSize
+	pager.walSize += int64(len(buf))
+	return off, nil
+}
+
+// Commit makes every change since the previous commit durable. Dirty pages
+// are appended to the log followed by a commit frame carrying header, the
+// page 0 image to install on checkpoint, and the log is fsynced. A nil
+// header leaves page 0 unchanged. The database file is not written unless
+// the log has grown past the checkpoint threshold.
+func (pager *Pager) Commit(header []byte) error {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	return pager.commit(header)
+}
+
+func (pager *Pager) commit(header []byte) error {
+	pageIDs := make([]uint32, 0, len(pager.dirty))
+	for id := range pager.dirty {
+		pageIDs = append(pageIDs, id)
+	}
+	slices.Sort(pageIDs)
+
+	buf := make([]byte, 0, len(pageIDs)*(frameHeaderSize+page.PageSize)+frameHeaderSize+len(header))
+	for _, id := range pageIDs {
+		pg := pager.pages[id]
+		pg.SetChecksum()
+		pager.walPending[id] = pager.walSize + int64(len(buf)) + frameHeaderSize
+		buf = appendFrame(buf, frameKindPage, id, pg[:])
+	}
+	buf = appendFrame(buf, frameKindCommit, 0, header)
+	if _, err := pager.wal.WriteAt(buf, pager.walSize); err != nil {
+		return fmt.Errorf("wal: commit: %w", err)
+	}
+	if err := pager.wal.Sync(); err != nil {
+		return fmt.Errorf("wal: commit: %w", err)
+	}
+	pager.walSize += int64(len(buf))
+	pager.walCommitted = pager.walSize
+
+	for id, off := range pager.walPending {
+		pager.walIndex[id] = append(pager.walIndex[id], off)
+	}
+	clear(pager.walPending)
+	clear(pager.dirty)
+	pager.commitSeq++
+	pager.committedNewID = pager.newID
+	pager.committedFreeListHead = pager.freeListHead
+	if header != nil {
+		pager.header = slices.Clone(header)
+	}
+
+	return pager.maybeCheckpoint()
+}
+
+// CheckpointDue reports whether committing now would log enough pages for
+// the commit to checkpoint, counting the pages modified so far. It ignores
+// open snapshots, which may defer the checkpoint to a later commit.
+func (pager *Pager) CheckpointDue() bool {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	n := len(pager.walIndex)
+	for id := range pager.dirty {
+		if _, ok := pager.walIndex[id]; !ok {
+			n++
+		}
+	}
+	for id := range pager.walPending {
+		_, logged := pager.walIndex[id]
+		_, dirty := pager.dirty[id]
+		if !logged && !dirty {
+			n++
+		}
+	}
+	return n >= checkpointSize
+}
+
+// Recovered reports whether Open found transactions committed to the log
+// but never checkpointed, as it does after a crash.
+func (pager *Pager) Recovered() bool {
+	return pager.recovered
+}
+
+// maybeCheckpoint checkpoints once the log holds checkpointSize pages and no
+// snapshot still reads the versions it would overwrite.
+func (pager *Pager) maybeCheckpoint() error {
+	if len(pager.walIndex) < checkpointSize {
+		return nil
+	}
+	for seq := range pager.snapshots {
+		// A snapshot of the latest commit sees exactly what the checkpoint
+		// writes, so only older ones hold it back.
+		if seq != pager.commitSeq {
+			return nil
+		}
+	}
+	return pager.checkpoint()
+}
+
+// Rollback discards every change since the previous commit: dirty pages,
+// and pages spilled to the log since then, are dropped from the cache,
+// those frames are truncated away, and the allocator is restored to its
+// committed state. Pins on discarded pages are released, so callers must
+// not keep using pages they fetched before the rollback.
+func (pager *Pager) Rollback() error {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	// Pages evicted to the log since the last commit may have been read
+	// back into the cache as clean copies of the uncommitted image.
+	drop := func(id uint32) {
+		if pager.pins[id] > 0 {
+			pager.pinnedCount--
+			pager.pins[id] = 0
+		}
+		pager.dropFromCache(id)
+	}
+	for id := range pager.dirty {
+		drop(id)
+	}
+	for id := range pager.walPending {
+		drop(id)
+	}
+	clear(pager.walPending)
+	if err := pager.wal.Truncate(pager.walCommitted); err != nil {
+		return fmt.Errorf("wal: rollback: %w", err)
+	}
+	pager.walSize = pager.walCommitted
+	pager.newID = pager.committedNewID
+	pager.freeListHead = pager.committedFreeListHead
+	return nil
+}
+
+// Checkpoint copies every committed page from the log into the database
+// file, installs the last committed header in page 0, fsyncs, and truncates
+// the log. Frames of an unfinished transaction are kept, in which case the
+// checkpoint is deferred to a later commit. Callers must not checkpoint
+// while snapshots of earlier commits are open, since it overwrites the
+// versions they read.
+func (pager *Pager) Checkpoint() error {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	return pager.checkpoint()
+}
+
+func (pager *Pager) checkpoint() error {
+	if pager.walSize != pager.walCommitted {
+		return nil
+	}
+	pager.fileMu.Lock()
+	defer pager.fileMu.Unlock()
+	pageIDs := make([]uint32, 0, len(pager.walIndex))
+	for id := range pager.walIndex {
+		pageIDs = append(pageIDs, id)
+	}
+	slices.Sort(pageIDs)
+
+	var buf page.Page
+	for _, id := range pageIDs {
+		offs := pager.walIndex[id]
+		if _, err := pager.wal.ReadAt(buf[:], offs[len(offs)-1]); err != nil {
+			return fmt.Errorf("wal: checkpoint page %d: %w", id, err)
+		}
+		if _, err := pager.file.WriteAt(buf[:], int64(id)*int64(page.PageSize)); err != nil {
+			return fmt.Errorf("wal: checkpoint page %d: %w", id, err)
+		}
+	}
+	if pager.header != nil {
+		if _, err := pager.file.WriteAt(pager.header, 0); err != nil {
+			return fmt.Errorf("wal: checkpoint header: %w", err)
+		}
+	}
+	if err := pager.file.Sync(); err != nil {
+		return err
+	}
+	if err := pager.wal.Truncate(0); err != nil {
+		return err
+	}
+	if err := pager.wal.Sync(); err != nil {
+		return err
+	}
+	pager.walSize = 0
+	pager.walCommitted = 0
+	pager.walGen++
+	pager.header = nil
+	clear(pager.walIndex)
+	return nil
+}
+
+// recover scans the log and rebuilds the index of committed frames,
+// stopping at the first torn or corrupt frame. Anything past the last
+// commit frame is dropped from the log.
+func (pager *Pager) recover() error {
+	pending := make(map[uint32]int64)
+	var hdr [frameHeaderSize]byte
+	var off int64
+	for {
+		if _, err := pager.wal.ReadAt(hdr[:], off); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return fmt.Errorf("wal: recover: %w", err)
+		}
+		kind := hdr[frameKindOff]
+		id := binary.BigEndian.Uint32(hdr[framePageIDOff:])
+		length := binary.BigEndian.Uint32(hdr[frameLengthOff:])
+		if (kind != frameKindPage && kind != frameKindCommit) ||
+			(kind == frameKindPage && length != page.PageSize) ||
+			length > page.PageSize {
+			break
+		}
+		payload := make([]byte, length)
+		if _, err := pager.wal.ReadAt(payload, off+frameHeaderSize); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return fmt.Errorf("wal: recover: %w", err)
+		}
+		crc := crc32.NewIEEE()
+		crc.Write(hdr[:frameCRCOff])
+		crc.Write(payload)
+		if crc.Sum32() != binary.BigEndian.Uint32(hdr[frameCRCOff:]) {
+			break
+		}
+
+		if kind == frameKindPage {
+			pending[id] = off + frameHeaderSize
+		} else {
+			for id, pageOff := range pending {
+				pager.walIndex[id] = append(pager.walIndex[id], pageOff)
+			}
+			clear(pending)
+			if length > 0 {
+				pager.header = payload
+			}
+			pager.walCommitted = off + frameHeaderSize + int64(length)
+		}
+		off += frameHeaderSize + int64(length)
+	}
+	pager.walSize = pager.walCommitted
+	pager.recovered = pager.walCommitted > 0
+	return pager.wal.Truncate(pager.walCommitted)
+}
+
+// loadPage reads the current image of a page, preferring the latest logged
+// frame over the database file.
+func (pager *Pager) loadPage(id uint32) (*page.Page, error) {
+	off, ok := pager.walPending[id]
+	if !ok {
+		if offs := pager.walIndex[id]; len(offs) > 0 {
+			off, ok = offs[len(offs)-1], true
+		}
+	}
+	if !ok {
+		return pager.readPage(id)
+	}
+	return pager.readFrame(id, off)
+}
+
+// readFrame reads the page image logged at payload offset off.
+func (pager *Pager) readFrame(id uint32, off int64) (*page.Page, error) {
+	var p page.Page
+	if _, err := pager.wal.ReadAt(p[:], off); err != nil {
+		return nil, err
+	}
+	if !p.VerifyChecksum() {
+		return nil, fmt.Errorf("%w: page id %v (wal)", ErrChecksumMismatch, id)
+	}
+	return &p, nil
+}
+
+func openWAL(filename string, fresh bool) (*os.File, error) {
+	flags := os.O_RDWR | os.O_CREATE
+	if fresh {
+		// A log left behind by a deleted database file must not be replayed
+		// into the new one.
+		flags |= os.O_TRUNC
+	}
+	return os.OpenFile(walPath(filename), flags, 0o644)
+}
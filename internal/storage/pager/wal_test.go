@@ -0,0 +1,429 @@
+package pager
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/guiwoch/toyDB/internal/storage/page"
+)
+
+// crash closes the pager's files without checkpointing, leaving the
+// database file and the log as a killed process would.
+func crash(t *testing.T, p *Pager) {
+	t.Helper()
+	if err := p.closeFiles(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCommitSurvivesCrash(t *testing.T) {
+	path := t.TempDir() + "/test"
+
+	p, _, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pg, err := p.Allocate(page.TypeLeaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := pg.PageID()
+	if err := pg.InsertRecord([]byte("k"), []byte("committed")); err != nil {
+		t.Fatal(err)
+	}
+	p.Unpin(id)
+	header := []byte("header")
+	if err := p.Commit(header); err != nil {
+		t.Fatal(err)
+	}
+	crash(t, p)
+
+	p2, fresh, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p2.Close()
+	if fresh {
+		t.Fatal("reopened file reported as fresh")
+	}
+	got, err := p2.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p2.Unpin(id)
+	if v, ok := got.Get([]byte("k")); !ok || string(v) != "committed" {
+		t.Errorf("expected committed record after recovery, got %q (found=%v)", v, ok)
+	}
+	buf := make([]byte, len(header))
+	if err := p2.ReadPage0(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, header) {
+		t.Errorf("expected header %q after recovery, got %q", header, buf)
+	}
+}
+
+func TestUncommittedChangesDiscardedOnCrash(t *testing.T) {
+	path := t.TempDir() + "/test"
+
+	p, _, err := Open(path, WithCacheSize(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pg, err := p.Allocate(page.TypeLeaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := pg.PageID()
+	if err := pg.InsertRecord([]byte("k"), []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	p.Unpin(id)
+	if err := p.Commit(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Modify the page, then force it out of the cache so the uncommitted
+	// image is spilled to the log.
+	pg, err = p.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pg.DeleteRecord([]byte("k"))
+	if err := pg.InsertRecord([]byte("k"), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	p.MarkDirty(id)
+	p.Unpin(id)
+	for range 4 {
+		other, err := p.Allocate(page.TypeLeaf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Unpin(other.PageID())
+	}
+	if _, cached := p.pages[id]; cached {
+		t.Fatalf("expected page %d to be evicted", id)
+	}
+	// The spilled image is visible within the session.
+	pg, err = p.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := pg.Get([]byte("k")); string(v) != "new" {
+		t.Errorf("expected spilled record before crash, got %q", v)
+	}
+	p.Unpin(id)
+	crash(t, p)
+
+	p2, _, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p2.Close()
+	pg, err = p2.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p2.Unpin(id)
+	if v, _ := pg.Get([]byte("k")); string(v) != "old" {
+		t.Errorf("expected committed record after recovery, got %q", v)
+	}
+}
+
+func TestTornLogTailIgnored(t *testing.T) {
+	path := t.TempDir() + "/test"
+
+	p, _, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pg, err := p.Allocate(page.TypeLeaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := pg.PageID()
+	if err := pg.InsertRecord([]byte("k"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	p.Unpin(id)
+	if err := p.Commit(nil); err != nil {
+		t.Fatal(err)
+	}
+	committed := p.walCommitted
+	crash(t, p)
+
+	// Append half a frame, as if the process died mid-write.
+	f, err := os.OpenFile(walPath(path), os.O_RDWR, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	garbage := appendFrame(nil, frameKindPage, id+1, make([]byte, page.PageSize))
+	if _, err := f.WriteAt(garbage[:len(garbage)/2], committed); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	p2, _, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p2.Close()
+	pg, err = p2.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p2.Unpin(id)
+	if v, ok := pg.Get([]byte("k")); !ok || string(v) != "v" {
+		t.Errorf("expected committed record, got %q (found=%v)", v, ok)
+	}
+	if stat, err := os.Stat(walPath(path)); err != nil {
+		t.Fatal(err)
+	} else if stat.Size() != 0 {
+		t.Errorf("expected log truncated after recovery, has %d bytes", stat.Size())
+	}
+}
+
+func TestRollbackRestoresCommittedState(t *testing.T) {
+	p, _, err := Open(t.TempDir()+"/test", WithCacheSize(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	pg, err := p.Allocate(page.TypeLeaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := pg.PageID()
+	if err := pg.InsertRecord([]byte("k"), []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	p.Unpin(id)
+	if err := p.Commit(nil); err != nil {
+		t.Fatal(err)
+	}
+	committedNewID := p.NewID()
+
+	pg, err = p.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pg.DeleteRecord([]byte("k"))
+	p.MarkDirty(id)
+	p.Free(id)
+	for range 4 {
+		other, err := p.Allocate(page.TypeLeaf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Unpin(other.PageID())
+	}
+
+	if err := p.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.NewID(); got != committedNewID {
+		t.Errorf("expected next fresh id %d after rollback, got %d", committedNewID, got)
+	}
+	if head := p.FreeListHead(); head != 0 {
+		t.Errorf("expected empty freelist after rollback, head is %d", head)
+	}
+	if n := p.PinnedCount(); n != 0 {
+		t.Errorf("expected no pinned pages after rollback, have %d", n)
+	}
+	pg, err = p.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Unpin(id)
+	if v, ok := pg.Get([]byte("k")); !ok || string(v) != "old" {
+		t.Errorf("expected committed record after rollback, got %q (found=%v)", v, ok)
+	}
+}
+
+func TestRollbackDropsEvictedPagesReadBack(t *testing.T) {
+	p, _, err := Open(t.TempDir()+"/test", WithCacheSize(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	pg, err := p.Allocate(page.TypeLeaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := pg.PageID()
+	if err := pg.InsertRecord([]byte("k"), []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	p.Unpin(id)
+	if err := p.Commit(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	pg, err = p.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pg.DeleteRecord([]byte("k"))
+	if err := pg.InsertRecord([]byte("k"), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	p.MarkDirty(id)
+	p.Unpin(id)
+	// Evict the dirty page to the log, then read it back into the cache.
+	for range 4 {
+		other, err := p.Allocate(page.TypeLeaf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Unpin(other.PageID())
+	}
+	pg, err = p.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := pg.Get([]byte("k")); !ok || string(v) != "new" {
+		t.Fatalf("expected uncommitted record before rollback, got %q (found=%v)", v, ok)
+	}
+	p.Unpin(id)
+
+	if err := p.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	pg, err = p.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Unpin(id)
+	if v, ok := pg.Get([]byte("k")); !ok || string(v) != "old" {
+		t.Errorf("expected committed record after rollback, got %q (found=%v)", v, ok)
+	}
+}
+
+func TestSnapshotSeesCommitAtCreation(t *testing.T) {
+	p, _, err := Open(t.TempDir() + "/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	pg, err := p.Allocate(page.TypeLeaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := pg.PageID()
+	if err := pg.InsertRecord([]byte("k"), []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	p.Unpin(id)
+	if err := p.Commit(nil); err != nil {
+		t.Fatal(err)
+	}
+	snap := p.Snapshot()
+
+	pg, err = p.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pg.DeleteRecord([]byte("k"))
+	if err := pg.InsertRecord([]byte("k"), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	p.MarkDirty(id)
+	p.Unpin(id)
+	if err := p.Commit(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Force a checkpoint attempt; the open snapshot must hold it back.
+	for range checkpointSize {
+		p.Unpin(allocID(t, p))
+	}
+	if err := p.Commit(nil); err != nil {
+		t.Fatal(err)
+	}
+	if p.walSize == 0 {
+		t.Fatal("expected checkpoint deferred while snapshot is open")
+	}
+
+	got, err := snap.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := got.Get([]byte("k")); string(v) != "old" {
+		t.Errorf("expected snapshot to see %q, got %q", "old", v)
+	}
+	if err := snap.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if p.walSize != 0 {
+		t.Errorf("expected deferred checkpoint to run on snapshot close, log has %d bytes", p.walSize)
+	}
+
+	pg, err = p.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Unpin(id)
+	if v, _ := pg.Get([]byte("k")); string(v) != "new" {
+		t.Errorf("expected latest commit %q, got %q", "new", v)
+	}
+}
+
+func TestRecoveredAndCheckpointDue(t *testing.T) {
+	path := t.TempDir() + "/test"
+
+	p, _, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range checkpointSize - 1 {
+		pg, err := p.Allocate(page.TypeLeaf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Unpin(pg.PageID())
+		if i == 0 {
+			if err := p.Commit(nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if p.CheckpointDue() {
+		t.Fatalf("CheckpointDue with %d pages to log, want false below %d", checkpointSize-1, checkpointSize)
+	}
+	pg, err := p.Allocate(page.TypeLeaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Unpin(pg.PageID())
+	if !p.CheckpointDue() {
+		t.Fatalf("CheckpointDue with %d pages to log, want true", checkpointSize)
+	}
+	// The first commit is still in the log.
+	crash(t, p)
+
+	p, _, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Recovered() {
+		t.Error("Recovered after a crash = false, want true")
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	p, _, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if p.Recovered() {
+		t.Error("Recovered after Close = true, want false")
+	}
+}
@@ -0,0 +1,132 @@
+package toydb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// JSONValue is the [Value] for [TypeJSON] columns: a JSON document, kept as
+// the text it was given. Insert and Update return ErrSchemaMismatch for
+// text that is not valid JSON.
+type JSONValue string
+
+func (v JSONValue) encode(dst []byte) []byte {
+	return appendEscaped(dst, v)
+}
+
+// Extract returns the value at path inside the document, for filtering
+// rows as they are scanned:
+//
+//	for row, err := range t.Scan(nil, nil) {
+//		// ...
+//		if city, _ := row[2].(toydb.JSONValue).Extract("address.city"); city == toydb.TextValue("Lisbon") {
+//			// ...
+//		}
+//	}
+//
+// A path is a dot-separated list of object keys, each optionally followed
+// by array indexes in brackets, such as "items[0].price"; the empty path is
+// the whole document. Strings are returned as [TextValue], integers as
+// [IntValue], other numbers as [FloatValue], booleans as [BoolValue], and
+// objects and arrays as JSONValue. A path that does not exist, or leads to
+// a JSON null, returns [Null]. Extract returns an error only if the path or
+// the document is malformed.
+func (v JSONValue) Extract(path string) (Value, error) {
+	steps, err := parseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(strings.NewReader(string(v)))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("extract %q: %w", path, err)
+	}
+	for _, step := range steps {
+		switch node := doc.(type) {
+		case map[string]any:
+			if step.index >= 0 {
+				return Null, nil
+			}
+			doc = node[step.key]
+		case []any:
+			if step.index < 0 || step.index >= len(node) {
+				return Null, nil
+			}
+			doc = node[step.index]
+		default:
+			return Null, nil
+		}
+	}
+	return jsonToValue(doc)
+}
+
+// jsonStep is one step of a path: an object key, or an array index if
+// index is not negative.
+type jsonStep struct {
+	key   string
+	index int
+}
+
+func parseJSONPath(path string) ([]jsonStep, error) {
+	if path == "" {
+		return nil, nil
+	}
+	var steps []jsonStep
+	for _, part := range strings.Split(path, ".") {
+		key, rest, _ := strings.Cut(part, "[")
+		if key == "" && rest == "" {
+			return nil, fmt.Errorf("json path %q: empty key", path)
+		}
+		if key != "" {
+			steps = append(steps, jsonStep{key: key, index: -1})
+		}
+		for rest != "" {
+			digits, after, ok := strings.Cut(rest, "]")
+			i, err := strconv.Atoi(digits)
+			if !ok || err != nil || i < 0 {
+				return nil, fmt.Errorf("json path %q: invalid index in %q", path, part)
+			}
+			steps = append(steps, jsonStep{index: i})
+			if after == "" {
+				break
+			}
+			if rest, ok = strings.CutPrefix(after, "["); !ok {
+				return nil, fmt.Errorf("json path %q: unexpected %q after index", path, after)
+			}
+		}
+	}
+	return steps, nil
+}
+
+// jsonToValue converts a document decoded with UseNumber to a Value.
+func jsonToValue(doc any) (Value, error) {
+	switch node := doc.(type) {
+	case nil:
+		return Null, nil
+	case string:
+		return TextValue(node), nil
+	case bool:
+		return BoolValue(node), nil
+	case json.Number:
+		if n, err := node.Int64(); err == nil {
+			return IntValue(n), nil
+		}
+		f, err := node.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return FloatValue(f), nil
+	default:
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(node); err != nil {
+			return nil, err
+		}
+		return JSONValue(bytes.TrimSuffix(buf.Bytes(), []byte("\n"))), nil
+	}
+}
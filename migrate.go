@@ -0,0 +1,146 @@
+package toydb
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// File format versions. Each bump changes how values are encoded; Open
+// migrates older files by rewriting every table in the current encoding.
+//
+//   - 1: text is length-prefixed.
+//   - 2: text is escaped and terminated, so text keys sort as strings.
+//   - 3: timestamps have their sign bit flipped, so times before 1970 sort
+//     first.
+//   - 4: rows start with a column count and a null bitmap.
+//   - 5: catalog rows carry table metadata, including a row count. Values
+//     are unchanged; the tables are only counted.
+const minVersion = 1
+
+// legacyDecoder returns the decoder for values written by a file of the
+// given version.
+func legacyDecoder(version uint32) valueDecoder {
+	return func(buf []byte, t ColType) (Value, int, error) {
+		switch {
+		case t == TypeText && version < 2:
+			return decodeTextV1(buf)
+		case t == TypeTimestamp && version < 3:
+			return decodeTimestampV2(buf)
+		}
+		return decodeValue(buf, t)
+	}
+}
+
+// decodeTextV1 decodes version 1 text: a 4-byte big-endian length followed
+// by the bytes.
+func decodeTextV1(buf []byte) (Value, int, error) {
+	if len(buf) < 4 {
+		return nil, 0, fmt.Errorf("decode text length: need 4 bytes, have %d", len(buf))
+	}
+	length := binary.BigEndian.Uint32(buf[:4])
+	if len(buf) < 4+int(length) {
+		return nil, 0, fmt.Errorf("decode text body: length %d but only %d bytes available", length, len(buf)-4)
+	}
+	return TextValue(buf[4 : 4+length]), 4 + int(length), nil
+}
+
+// migrate rewrites a file of an older version in the current format and
+// commits it with the current version.
+func (d *DB) migrate() error {
+	version := d.header.version
+	return d.autocommit(func() error {
+		names, err := d.catalog.Names()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			t, err := d.openTable(name)
+			if err != nil {
+				return err
+			}
+			if version < 4 {
+				if err := t.rewrite(version); err != nil {
+					return fmt.Errorf("migrate table %q: %w", name, err)
+				}
+			}
+			if err := t.count(); err != nil {
+				return fmt.Errorf("migrate table %q: %w", name, err)
+			}
+		}
+		d.header.version = currentVersion
+		return nil
+	})
+}
+
+// rewrite re-encodes every row of the table, written by a file of the given
+// version, into a new tree, and rebuilds its indexes from it. The old trees
+// are freed.
+func (t *Table) rewrite(version uint32) error {
+	decode := legacyDecoder(version)
+	tree, err := t.db.newTree()
+	if err != nil {
+		return err
+	}
+	for r, err := range t.tree.AscendingRange(nil, nil) {
+		if err != nil {
+			return err
+		}
+		pk, err := t.schema.decodeKeyWith(decode, r.Key)
+		if err != nil {
+			return err
+		}
+		var row Row
+		if version < 4 {
+			// No header, and no nulls to record in one.
+			row, err = t.schema.decodeValues(decode, pk, len(t.schema.stored), nil, r.Value)
+		} else {
+			row, err = t.schema.decodeRow(pk, r.Value)
+		}
+		if err != nil {
+			return err
+		}
+		if err := tree.Insert(t.schema.encodeKeyFromRow(row), t.schema.encodeRow(row)); err != nil {
+			return err
+		}
+	}
+	if err := t.tree.Destroy(); err != nil {
+		return err
+	}
+	t.tree = tree
+	for _, idx := range t.indexes {
+		if err := idx.tree.Destroy(); err != nil {
+			return err
+		}
+		if idx.tree, err = t.db.newTree(); err != nil {
+			return err
+		}
+		if err := t.buildIndex(idx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// count records the table's row count in its metadata, for files written
+// before it was kept there and by DB.recount.
+func (t *Table) count() error {
+	n := 0
+	for _, err := range t.tree.AscendingRange(nil, nil) {
+		if err != nil {
+			return err
+		}
+		n++
+	}
+	t.meta.rows = int64(n)
+	t.meta.dirty = true
+	return nil
+}
+
+// decodeTimestampV2 decodes timestamps written before version 3: raw 8-byte
+// big-endian Unix nanoseconds.
+func decodeTimestampV2(buf []byte) (Value, int, error) {
+	if len(buf) < 8 {
+		return nil, 0, fmt.Errorf("decode timestamp: need 8 bytes, have %d", len(buf))
+	}
+	return TimestampValue(binary.BigEndian.Uint64(buf[:8])), 8, nil
+}
@@ -0,0 +1,262 @@
+package toydb
+
+import (
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/guiwoch/toyDB/internal/storage/btree"
+	"github.com/guiwoch/toyDB/internal/storage/catalog"
+	"github.com/guiwoch/toyDB/internal/storage/pager"
+)
+
+// legacyColumns are the columns of the table writeLegacyDB writes: events
+// keyed by (name, at), with an index on note.
+var legacyColumns = []Column{
+	{Name: "name", Type: TypeText},
+	{Name: "at", Type: TypeTimestamp},
+	{Name: "note", Type: TypeText},
+	{Name: "n", Type: TypeInt},
+}
+
+// appendLegacy appends v as a file of the given version encoded it.
+func appendLegacy(dst []byte, v Value, version uint32) []byte {
+	switch v := v.(type) {
+	case TextValue:
+		if version < 2 {
+			dst = binary.BigEndian.AppendUint32(dst, uint32(len(v)))
+			return append(dst, v...)
+		}
+	case TimestampValue:
+		if version < 3 {
+			return binary.BigEndian.AppendUint64(dst, uint64(v))
+		}
+	}
+	return v.encode(dst)
+}
+
+// writeLegacyDB writes a file of the given version, before 4, holding rows
+// of legacyColumns in that version's encodings, with a format 0 catalog
+// row and a version 1 schema. The index on note is left empty, as
+// migration rebuilds it.
+func writeLegacyDB(t *testing.T, path string, version uint32, rows []Row) {
+	t.Helper()
+	d, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := d.newTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		key := appendLegacy(appendLegacy(nil, row[0], version), row[1], version)
+		value := appendLegacy(appendLegacy(nil, row[2], version), row[3], version)
+		if err := tree.Insert(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	index, err := d.newTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A version 1 schema: [column count][pk index], each column as
+	// [type][name length][name], then the composite key's columns.
+	schema := []byte{byte(len(legacyColumns)), 0}
+	for _, c := range legacyColumns {
+		schema = append(schema, byte(c.Type), byte(len(c.Name)))
+		schema = append(schema, c.Name...)
+	}
+	schema = append(schema, 2, 0, 1)
+	// A format 0 catalog row: [root][schema length][schema], then the
+	// indexes as [count], [name length][name][root][column count][columns].
+	entry := binary.BigEndian.AppendUint32(nil, tree.RootID())
+	entry = binary.BigEndian.AppendUint32(entry, uint32(len(schema)))
+	entry = append(entry, schema...)
+	entry = append(entry, 1, byte(len("by_note")))
+	entry = append(entry, "by_note"...)
+	entry = binary.BigEndian.AppendUint32(entry, index.RootID())
+	entry = append(entry, 1, 2)
+
+	cat, err := d.newTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cat.Insert([]byte("events"), entry); err != nil {
+		t.Fatal(err)
+	}
+	d.catalog = catalog.Open(cat)
+	d.header.catalogRootID = cat.RootID()
+	d.header.version = version
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// legacyRows returns rows whose order the old encodings got wrong: text
+// keys of different lengths, and times on both sides of 1970.
+func legacyRows(n int) []Row {
+	var rows []Row
+	for i := range n {
+		at := TimestampValue(int64(i-n/2) * 1e15)
+		name := TextValue(fmt.Sprintf("%*s", 1+i%3, "b"))
+		rows = append(rows, Row{name, at, TextValue(fmt.Sprintf("note-%d", i%7)), IntValue(i)})
+	}
+	return rows
+}
+
+// sortedRows returns rows in primary key order: by name, then by time.
+func sortedRows(rows []Row) []Row {
+	rows = slices.Clone(rows)
+	slices.SortFunc(rows, func(a, b Row) int {
+		if c := cmp.Compare(a[0].(TextValue), b[0].(TextValue)); c != 0 {
+			return c
+		}
+		return cmp.Compare(a[1].(TimestampValue), b[1].(TimestampValue))
+	})
+	return rows
+}
+
+// checkMigrated checks that d holds rows, upgraded to the
+// current version.
+func checkMigrated(t *testing.T, d *DB, rows []Row) {
+	t.Helper()
+	if d.header.version != currentVersion {
+		t.Errorf("header version %d, want %d", d.header.version, currentVersion)
+	}
+	tbl, err := d.OpenTable("events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []Row
+	for row, err := range tbl.Scan(nil, nil) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, row)
+	}
+	want := sortedRows(rows)
+	if !slices.EqualFunc(got, want, func(a, b Row) bool { return slices.EqualFunc(a, b, valuesEqual) }) {
+		t.Errorf("Scan\n got %v\nwant %v", got, want)
+	}
+	for _, row := range rows {
+		got, err := tbl.Get(Key{row[0], row[1]})
+		if err != nil {
+			t.Errorf("Get %v: %v", row[:2], err)
+		} else if !slices.EqualFunc(got, row, valuesEqual) {
+			t.Errorf("Get %v: got %v, want %v", row[:2], got, row)
+		}
+	}
+	// Times before 1970 come first.
+	var times []Row
+	for row, err := range tbl.Scan(Key{TextValue("b")}, Key{TextValue("b"), TimestampValue(0)}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		times = append(times, row)
+	}
+	for _, row := range times {
+		if row[1].(TimestampValue) >= 0 {
+			t.Errorf("Scan before 1970 yielded %v", row)
+		}
+	}
+	n := 0
+	for _, err := range tbl.Lookup("by_note", TextValue("note-3")) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		n++
+	}
+	if want := (len(rows) + 3) / 7; n != want {
+		t.Errorf("Lookup through rebuilt index: %d rows, want %d", n, want)
+	}
+	info, err := d.TableInfo("events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Rows != int64(len(rows)) {
+		t.Errorf("TableInfo rows %d, want %d", info.Rows, len(rows))
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	t.Parallel()
+	for version := uint32(1); version < 4; version++ {
+		t.Run(fmt.Sprint("version ", version), func(t *testing.T) {
+			t.Parallel()
+			path := filepath.Join(t.TempDir(), "test.db")
+			rows := legacyRows(60)
+			writeLegacyDB(t, path, version, rows)
+
+			d, err := Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkMigrated(t, d, rows)
+			if err := d.Close(); err != nil {
+				t.Fatal(err)
+			}
+			d, err = Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close()
+			checkMigrated(t, d, rows)
+		})
+	}
+}
+
+// TestMigrateInterrupted checks that a migration that stops before it
+// commits, with some of its pages already spilled to the log, leaves the
+// file as it was, so that the next Open migrates it from the start.
+func TestMigrateInterrupted(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "test.db")
+	rows := legacyRows(2000)
+	writeLegacyDB(t, path, 1, rows)
+
+	// Open as Open does, but rewrite the table with a small cache and stop
+	// short of the commit, as a crash would.
+	p, _, err := pager.Open(path, pager.WithCacheSize(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, headerSize)
+	if err := p.ReadPage0(buf); err != nil {
+		t.Fatal(err)
+	}
+	h, err := decodeHeader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetFreeListHead(h.freeListHead)
+	cat, err := btree.Open(p, h.catalogRootID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &DB{pager: p, open: make(map[string]*Table), header: h, catalog: catalog.Open(cat)}
+	tbl, err := d.openTable("events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tbl.rewrite(h.version); err != nil {
+		t.Fatal(err)
+	}
+	if p.Stats().Evictions == 0 {
+		t.Fatal("no pages were spilled to the log")
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	checkMigrated(t, d, rows)
+}
@@ -0,0 +1,575 @@
+package query
+
+import (
+	"fmt"
+	"hash/fnv"
+	"iter"
+	"math"
+	"slices"
+	"strings"
+
+	toydb "github.com/guiwoch/toyDB"
+)
+
+// groupShare is the share of a grouping's input rows assumed to start a
+// group of their own, for want of a count of distinct values.
+const groupShare = 1.0 / 10
+
+const (
+	// spillPartitions is the number of runs hash aggregation splits its
+	// groups into when they do not fit in memory. Each run holds groups
+	// with the same hash, and is aggregated on its own afterwards.
+	spillPartitions = 8
+	// maxSpillDepth bounds how many times the groups of a run are split
+	// again; past it they are held in memory whatever their size.
+	maxSpillDepth = 4
+	// groupOverhead is the memory assumed for a group besides its key and
+	// state.
+	groupOverhead = 64
+)
+
+// grouping is the GROUP BY of a SELECT and the aggregates it computes.
+// Each group yields one row: its GROUP BY values, then the result of each
+// aggregate, which scope names by their SQL.
+type grouping struct {
+	exprs  []Expr // GROUP BY
+	keys   []evalFunc
+	aggs   []*aggregate
+	width  int // of the state of a group
+	scope  *scope
+	source *scope
+	memory int
+}
+
+// aggregate is one aggregate function of a grouping. Its part of the state
+// of a group starts at at.
+type aggregate struct {
+	*Aggregate
+	arg evalFunc // nil for COUNT(*)
+	at  int
+}
+
+// hasAggregate reports whether e holds an aggregate function.
+func hasAggregate(e Expr) bool {
+	switch e := e.(type) {
+	case *Aggregate:
+		return true
+	case *Unary:
+		return hasAggregate(e.X)
+	case *Binary:
+		return hasAggregate(e.L) || hasAggregate(e.R)
+	case *IsNull:
+		return hasAggregate(e.X)
+	}
+	return false
+}
+
+// newGrouping returns the grouping of rows of scope sc by the GROUP BY
+// expressions, for the aggregates found in exprs.
+func newGrouping(groupBy, exprs []Expr, sc *scope, args []toydb.Value, memory int) (*grouping, error) {
+	g := &grouping{source: sc, scope: &scope{}, memory: memory}
+	for _, e := range groupBy {
+		// Repeats add nothing, and would make their name ambiguous.
+		if slices.ContainsFunc(g.exprs, func(x Expr) bool { return x.String() == e.String() }) {
+			continue
+		}
+		key, err := compile(e, sc, args)
+		if err != nil {
+			return nil, err
+		}
+		g.exprs = append(g.exprs, e)
+		g.keys = append(g.keys, key)
+		g.scope.columns = append(g.scope.columns, e.String())
+	}
+	var err error
+	var find func(Expr)
+	find = func(e Expr) {
+		switch e := e.(type) {
+		case *Aggregate:
+			if err != nil || slices.Contains(g.scope.columns[len(g.keys):], e.String()) {
+				return
+			}
+			a := &aggregate{Aggregate: e, at: g.width}
+			if e.Arg != nil {
+				if a.arg, err = compile(e.Arg, sc, args); err != nil {
+					return
+				}
+			}
+			g.aggs = append(g.aggs, a)
+			g.width += a.stateWidth()
+			g.scope.columns = append(g.scope.columns, e.String())
+		case *Unary:
+			find(e.X)
+		case *Binary:
+			find(e.L)
+			find(e.R)
+		case *IsNull:
+			find(e.X)
+		}
+	}
+	for _, e := range exprs {
+		find(e)
+	}
+	if err != nil {
+		return nil, err
+	}
+	g.scope.tables = make([]string, len(g.scope.columns))
+	return g, nil
+}
+
+// rewrite returns e as an expression over the rows the grouping yields.
+// Outside aggregates, e may only refer to columns through the GROUP BY
+// expressions.
+func (g *grouping) rewrite(e Expr) (Expr, error) {
+	for i, x := range g.exprs {
+		if x.String() == e.String() || g.sameColumn(x, e) {
+			return &ColumnRef{Name: g.scope.columns[i]}, nil
+		}
+	}
+	switch e := e.(type) {
+	case *Aggregate:
+		return &ColumnRef{Name: e.String()}, nil
+	case *ColumnRef:
+		return nil, fmt.Errorf("column %s must appear in GROUP BY or be used in an aggregate", e)
+	case *Unary:
+		x, err := g.rewrite(e.X)
+		return &Unary{Op: e.Op, X: x}, err
+	case *Binary:
+		l, err := g.rewrite(e.L)
+		if err != nil {
+			return nil, err
+		}
+		r, err := g.rewrite(e.R)
+		return &Binary{Op: e.Op, L: l, R: r}, err
+	case *IsNull:
+		x, err := g.rewrite(e.X)
+		return &IsNull{X: x, Not: e.Not}, err
+	}
+	return e, nil
+}
+
+// sameColumn reports whether a and b are references to the same column.
+func (g *grouping) sameColumn(a, b Expr) bool {
+	ra, ok := a.(*ColumnRef)
+	rb, ok2 := b.(*ColumnRef)
+	if !ok || !ok2 {
+		return false
+	}
+	i, err := g.source.resolve(ra)
+	j, err2 := g.source.resolve(rb)
+	return err == nil && err2 == nil && i == j
+}
+
+// order returns the ORDER BY under which rows of the first of the tables
+// from come grouped, or nil if there is none the planner can pick a path
+// for: the GROUP BY columns, when they are all primary key columns of that
+// table, in primary key order.
+func (g *grouping) order(from []*fromTable) []OrderItem {
+	pk := keyColumns(from[0].table.Schema(), from[0].scope)
+	refs := make([]*ColumnRef, len(pk))
+	for _, e := range g.exprs {
+		ref, ok := e.(*ColumnRef)
+		if !ok {
+			return nil
+		}
+		i, err := g.source.resolve(ref)
+		if err != nil || !slices.Contains(pk, i) {
+			return nil
+		}
+		refs[slices.Index(pk, i)] = ref
+	}
+	var order []OrderItem
+	for _, ref := range refs {
+		if ref != nil {
+			order = append(order, OrderItem{Expr: ref})
+		}
+	}
+	return order
+}
+
+// explain returns the plan of the grouping over in.
+func (g *grouping) explain(in *Plan, streamed bool) *Plan {
+	plan := &Plan{Op: "Hash Aggregate", Rows: 1, Pages: in.Pages, Inputs: []*Plan{in}}
+	if streamed {
+		plan.Op = "Stream Aggregate"
+	}
+	aggs := make([]string, len(g.aggs))
+	for i, a := range g.aggs {
+		aggs[i] = a.String()
+	}
+	plan.Detail = strings.Join(aggs, ", ")
+	if len(g.exprs) > 0 {
+		exprs := make([]string, len(g.exprs))
+		for i, e := range g.exprs {
+			exprs[i] = e.String()
+		}
+		plan.Detail = strings.TrimPrefix(plan.Detail+" GROUP BY "+strings.Join(exprs, ", "), " ")
+		plan.Rows = max(1, int64(math.Round(float64(in.Rows)*groupShare)))
+	}
+	return plan
+}
+
+// group is a group's key, the values of its GROUP BY expressions, and the
+// state of its aggregates.
+type group struct {
+	key   []toydb.Value
+	state []toydb.Value
+}
+
+// start returns the group of row, with row added to its state.
+func (g *grouping) start(row toydb.Row) (*group, error) {
+	grp := &group{key: make([]toydb.Value, len(g.keys)), state: make([]toydb.Value, g.width)}
+	for i, key := range g.keys {
+		var err error
+		if grp.key[i], err = key(row); err != nil {
+			return nil, err
+		}
+	}
+	for _, a := range g.aggs {
+		a.init(grp.state[a.at:])
+		if err := a.add(grp.state[a.at:], row); err != nil {
+			return nil, err
+		}
+	}
+	return grp, nil
+}
+
+// result returns the row a group yields.
+func (g *grouping) result(grp *group) (toydb.Row, error) {
+	row := slices.Grow(slices.Clone(grp.key), len(g.aggs))
+	for _, a := range g.aggs {
+		v, err := a.result(grp.state[a.at:])
+		if err != nil {
+			return nil, err
+		}
+		row = append(row, v)
+	}
+	return row, nil
+}
+
+// stream groups rows that come with the rows of each group together. With
+// no GROUP BY, every row, or none, makes up one group.
+func (g *grouping) stream(rows iter.Seq2[toydb.Row, error]) iter.Seq2[toydb.Row, error] {
+	return func(yield func(toydb.Row, error) bool) {
+		var cur *group
+		for row, err := range rows {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			next, err := g.start(row)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if cur != nil {
+				if c, err := compareKeys(cur.key, seq(len(g.keys)), next.key, seq(len(g.keys))); c == 0 || err != nil {
+					if err == nil {
+						err = g.merge(cur, next)
+					}
+					if err != nil {
+						yield(nil, err)
+						return
+					}
+					continue
+				}
+				out, err := g.result(cur)
+				if !yield(out, err) || err != nil {
+					return
+				}
+			}
+			cur = next
+		}
+		if cur == nil && len(g.keys) == 0 {
+			cur = &group{state: make([]toydb.Value, g.width)}
+			for _, a := range g.aggs {
+				a.init(cur.state[a.at:])
+			}
+		}
+		if cur != nil {
+			yield(g.result(cur))
+		}
+	}
+}
+
+// seq returns the numbers from 0 to n-1.
+func seq(n int) []int {
+	s := make([]int, n)
+	for i := range s {
+		s[i] = i
+	}
+	return s
+}
+
+// merge folds the state of other into that of grp.
+func (g *grouping) merge(grp, other *group) error {
+	for _, a := range g.aggs {
+		if err := a.merge(grp.state[a.at:], other.state[a.at:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hash groups rows in memory, by a hash of their keys. Groups that do not
+// fit in the grouping's memory are spilled to a temporary file.
+func (g *grouping) hash(rows iter.Seq2[toydb.Row, error]) iter.Seq2[toydb.Row, error] {
+	return func(yield func(toydb.Row, error) bool) {
+		groups := func(yield func(*group, error) bool) {
+			for row, err := range rows {
+				if err != nil {
+					yield(nil, err)
+					return
+				}
+				if !yield(g.start(row)) {
+					return
+				}
+			}
+		}
+		var spill *spillFile
+		defer func() {
+			if spill != nil {
+				spill.close()
+			}
+		}()
+		g.hashGroups(groups, 0, &spill, yield)
+	}
+}
+
+// hashGroups merges groups with equal keys and yields their rows. When the
+// groups held in memory outgrow it, they are written to runs of *spill,
+// opened then, by the hash of their keys; each run is then grouped on its
+// own, one level deeper. It reports whether to go on.
+func (g *grouping) hashGroups(groups iter.Seq2[*group, error], depth int, spill **spillFile, yield func(toydb.Row, error) bool) bool {
+	held := make(map[string]*group)
+	size := 0
+	var runs []*spillRun
+	for grp, err := range groups {
+		if err != nil {
+			yield(nil, err)
+			return false
+		}
+		key := string(appendValues(nil, grp.key))
+		if cur, ok := held[key]; ok {
+			if err := g.merge(cur, grp); err != nil {
+				yield(nil, err)
+				return false
+			}
+			continue
+		}
+		if size += groupSize(key, grp); size > g.memory && len(held) > 0 && depth < maxSpillDepth {
+			if runs == nil {
+				if *spill == nil {
+					if *spill, err = newSpillFile(); err != nil {
+						yield(nil, err)
+						return false
+					}
+				}
+				for range spillPartitions {
+					runs = append(runs, (*spill).newRun())
+				}
+			}
+			if err := g.spill(held, runs, depth); err != nil {
+				yield(nil, err)
+				return false
+			}
+			clear(held)
+			size = groupSize(key, grp)
+		}
+		held[key] = grp
+	}
+
+	if runs == nil {
+		for _, grp := range held {
+			if !yield(g.result(grp)) {
+				return false
+			}
+		}
+		return true
+	}
+	if err := g.spill(held, runs, depth); err != nil {
+		yield(nil, err)
+		return false
+	}
+	for _, run := range runs {
+		if err := run.finish(); err != nil {
+			yield(nil, err)
+			return false
+		}
+	}
+	for _, run := range runs {
+		if !g.hashGroups(g.readGroups(run), depth+1, spill, yield) {
+			return false
+		}
+	}
+	return true
+}
+
+// groupSize estimates the memory a group with the encoded key takes.
+func groupSize(key string, grp *group) int {
+	n := 2*len(key) + groupOverhead
+	for _, v := range grp.state {
+		n += 16
+		switch v := v.(type) {
+		case toydb.TextValue:
+			n += len(v)
+		case toydb.BytesValue:
+			n += len(v)
+		case toydb.JSONValue:
+			n += len(v)
+		}
+	}
+	return n
+}
+
+// spill writes groups to runs, picked by the hash of their keys at depth.
+func (g *grouping) spill(groups map[string]*group, runs []*spillRun, depth int) error {
+	var rec []byte
+	for key, grp := range groups {
+		h := fnv.New32a()
+		h.Write([]byte{byte(depth)})
+		h.Write([]byte(key))
+		rec = appendValues(rec[:0], grp.key)
+		rec = appendValues(rec, grp.state)
+		if err := runs[h.Sum32()%uint32(len(runs))].write(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readGroups reads back the groups spilled to run.
+func (g *grouping) readGroups(run *spillRun) iter.Seq2[*group, error] {
+	return func(yield func(*group, error) bool) {
+		for rec, err := range run.records() {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			values := make([]toydb.Value, 0, len(g.keys)+g.width)
+			for len(rec) > 0 {
+				v, n, err := readValue(rec)
+				if err != nil {
+					yield(nil, err)
+					return
+				}
+				values = append(values, v)
+				rec = rec[n:]
+			}
+			if len(values) != len(g.keys)+g.width {
+				yield(nil, fmt.Errorf("%w: %d values in a spilled group", errBadValue, len(values)))
+				return
+			}
+			if !yield(&group{key: values[:len(g.keys):len(g.keys)], state: values[len(g.keys):]}, nil) {
+				return
+			}
+		}
+	}
+}
+
+// appendValues appends each of values to dst with appendValue.
+func appendValues(dst []byte, values []toydb.Value) []byte {
+	for _, v := range values {
+		dst = appendValue(dst, v)
+	}
+	return dst
+}
+
+// stateWidth is the number of values in the aggregate's part of a group's
+// state: a running count, sum, minimum or maximum, or, for AVG, a sum and
+// a count.
+func (a *aggregate) stateWidth() int {
+	if a.Func == "AVG" {
+		return 2
+	}
+	return 1
+}
+
+// init sets the aggregate's state for a group without rows.
+func (a *aggregate) init(state []toydb.Value) {
+	switch a.Func {
+	case "COUNT":
+		state[0] = toydb.IntValue(0)
+	case "AVG":
+		state[0], state[1] = toydb.Null, toydb.IntValue(0)
+	default:
+		state[0] = toydb.Null
+	}
+}
+
+// add adds a row of the group to the aggregate's state. Rows for which
+// the argument is null are left out.
+func (a *aggregate) add(state []toydb.Value, row toydb.Row) error {
+	if a.arg == nil {
+		state[0] = state[0].(toydb.IntValue) + 1
+		return nil
+	}
+	v, err := a.arg(row)
+	if err != nil || v == toydb.Null {
+		return err
+	}
+	switch a.Func {
+	case "COUNT":
+		state[0] = state[0].(toydb.IntValue) + 1
+		return nil
+	case "SUM", "AVG":
+		if !isNumeric(valueType(v)) {
+			return fmt.Errorf("%w: %s of %s", ErrType, a.Func, typeName(v))
+		}
+		if a.Func == "AVG" {
+			state[1] = state[1].(toydb.IntValue) + 1
+		}
+	}
+	return a.fold(state, v)
+}
+
+// merge folds the aggregate's state from other rows of the group into
+// state.
+func (a *aggregate) merge(state, other []toydb.Value) error {
+	switch a.Func {
+	case "COUNT":
+		state[0] = state[0].(toydb.IntValue) + other[0].(toydb.IntValue)
+		return nil
+	case "AVG":
+		state[1] = state[1].(toydb.IntValue) + other[1].(toydb.IntValue)
+	}
+	if other[0] == toydb.Null {
+		return nil
+	}
+	return a.fold(state, other[0])
+}
+
+// fold folds v, a value or a partial sum, minimum or maximum, into the
+// state of SUM, AVG, MIN or MAX.
+func (a *aggregate) fold(state []toydb.Value, v toydb.Value) error {
+	if state[0] == toydb.Null {
+		state[0] = v
+		return nil
+	}
+	switch a.Func {
+	case "SUM", "AVG":
+		sum, err := arith("+", state[0], v)
+		if err != nil {
+			return err
+		}
+		state[0] = sum
+	case "MIN", "MAX":
+		c, err := compareValues(v, state[0])
+		if err != nil {
+			return err
+		}
+		if c < 0 && a.Func == "MIN" || c > 0 && a.Func == "MAX" {
+			state[0] = v
+		}
+	}
+	return nil
+}
+
+// result returns the aggregate's value from its state. Aggregates other
+// than COUNT are null for a group with no non-null values.
+func (a *aggregate) result(state []toydb.Value) (toydb.Value, error) {
+	if a.Func != "AVG" || state[0] == toydb.Null {
+		return state[0], nil
+	}
+	return toydb.FloatValue(toFloat(state[0]) / float64(state[1].(toydb.IntValue))), nil
+}
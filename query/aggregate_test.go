@@ -0,0 +1,237 @@
+package query_test
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	toydb "github.com/guiwoch/toyDB"
+	"github.com/guiwoch/toyDB/query"
+)
+
+// aggregateOp returns the op of the aggregation in a plan.
+func aggregateOp(p *query.Plan) string {
+	for ; p != nil; p = first(p.Inputs) {
+		if strings.HasSuffix(p.Op, "Aggregate") {
+			return p.Op
+		}
+	}
+	return ""
+}
+
+func TestAggregate(t *testing.T) {
+	t.Parallel()
+	s, _ := newTestSession(t)
+	setupEvents(t, s, 20)
+
+	tests := []struct {
+		src  string
+		op   string
+		want []string
+	}{
+		{
+			"SELECT COUNT(*), COUNT(weight), SUM(weight), MIN(msg), MAX(seq), AVG(seq) FROM events",
+			"Stream Aggregate",
+			[]string{"60 54 270 a-0 19 9.5"},
+		},
+		// With no GROUP BY, there is a row even for no rows.
+		{
+			"SELECT COUNT(*), SUM(seq), MAX(msg), AVG(weight) FROM events WHERE seq > 100",
+			"Stream Aggregate",
+			[]string{"0 null null null"},
+		},
+		{
+			"SELECT host, COUNT(*), SUM(seq), AVG(weight) FROM events GROUP BY host",
+			"Stream Aggregate",
+			[]string{"a 20 190 5", "b 20 190 5", "c 20 190 5"},
+		},
+		{
+			"SELECT host, COUNT(*) FROM events WHERE seq > 100 GROUP BY host",
+			"Stream Aggregate",
+			nil,
+		},
+		{
+			"SELECT seq, host, MAX(weight) FROM events WHERE seq < 2 GROUP BY seq, events.host",
+			"Stream Aggregate",
+			[]string{"0 a null", "1 a 0.5", "0 b null", "1 b 0.5", "0 c null", "1 c 0.5"},
+		},
+		{
+			"SELECT seq % 3, COUNT(*) FROM events GROUP BY seq % 3 ORDER BY 1",
+			"Hash Aggregate",
+			[]string{"0 21", "1 21", "2 18"},
+		},
+		// Nulls make up one group.
+		{
+			"SELECT weight, COUNT(*) AS n FROM events GROUP BY weight ORDER BY n DESC, weight LIMIT 3",
+			"Hash Aggregate",
+			[]string{"null 6", "0.5 3", "1 3"},
+		},
+		{
+			"SELECT MIN(host) || '-' || MAX(host), SUM(seq) * 2 FROM events WHERE msg > 'b-5'",
+			"Stream Aggregate",
+			[]string{"b-c 440"},
+		},
+	}
+	for _, tt := range tests {
+		if got := exec(t, s, tt.src); !slices.Equal(got, tt.want) {
+			t.Errorf("%s\n got %q\nwant %q", tt.src, got, tt.want)
+		}
+		p, err := s.Explain(tt.src)
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		if op := aggregateOp(p); op != tt.op {
+			t.Errorf("%s: plan\n%s\nwant a %s", tt.src, p, tt.op)
+		}
+	}
+}
+
+func TestExplainAggregate(t *testing.T) {
+	t.Parallel()
+	s, _ := newTestSession(t)
+	setupEvents(t, s, 20)
+
+	p, err := s.Explain("SELECT msg, COUNT(*), AVG(weight) FROM events WHERE seq > 5 GROUP BY msg ORDER BY COUNT(*) DESC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for line := range strings.Lines(p.String()) {
+		lines = append(lines, strings.TrimRight(line[:strings.Index(line, "  (")], " "))
+	}
+	want := []string{
+		"Sort: COUNT(*) DESC",
+		"  Hash Aggregate: COUNT(*), AVG(weight) GROUP BY msg",
+		"    Filter: (seq > 5)",
+		"      Full Scan: events",
+	}
+	if !slices.Equal(lines, want) {
+		t.Errorf("plan\n%s\nwant\n%s", p, strings.Join(want, "\n"))
+	}
+}
+
+// TestAggregateSpill cannot run in parallel, as it points TMPDIR at a
+// directory of its own to watch for spill files.
+func TestAggregateSpill(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	d, err := toydb.Open(t.TempDir() + "/test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = d.Close() })
+	s := query.NewSession(d, query.WithAggregateMemory(4096))
+	setupEvents(t, s, 3000)
+
+	spilled := func() bool {
+		t.Helper()
+		files, err := filepath.Glob(filepath.Join(tmp, "toydb-spill-*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(files) > 0
+	}
+	res, err := s.Exec("SELECT seq, COUNT(*), MIN(host), SUM(weight), AVG(seq) FROM events GROUP BY seq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[toydb.Value]bool)
+	for row, err := range res.Rows {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(seen) == 0 && !spilled() {
+			t.Error("groups were not spilled")
+		}
+		seq := row[0].(toydb.IntValue)
+		var sum toydb.Value = toydb.FloatValue(float64(seq) / 2 * 3)
+		if seq%10 == 0 {
+			sum = toydb.Null
+		}
+		if want := (toydb.Row{seq, toydb.IntValue(3), toydb.TextValue("a"), sum, toydb.FloatValue(seq)}); !slices.Equal(row, want) {
+			t.Errorf("got %v, want %v", row, want)
+		}
+		if seen[seq] {
+			t.Errorf("group %v yielded twice", seq)
+		}
+		seen[seq] = true
+	}
+	if len(seen) != 3000 {
+		t.Errorf("got %d groups, want 3000", len(seen))
+	}
+	if spilled() {
+		t.Error("spill files left behind")
+	}
+
+	// Abandoning the rows removes the spill files too.
+	res, err = s.Exec("SELECT msg, COUNT(*) FROM events GROUP BY msg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range res.Rows {
+		break
+	}
+	if spilled() {
+		t.Error("spill files left behind by abandoned rows")
+	}
+}
+
+func TestAggregateErrors(t *testing.T) {
+	t.Parallel()
+	s, _ := newTestSession(t)
+	setupEvents(t, s, 20)
+
+	for _, src := range []string{
+		"SELECT host, seq FROM events GROUP BY host",
+		"SELECT host, COUNT(*) FROM events",
+		"SELECT host FROM events GROUP BY host ORDER BY msg",
+		"SELECT msg FROM events WHERE COUNT(*) > 1",
+		"SELECT host FROM events GROUP BY COUNT(*)",
+		"SELECT SUM(COUNT(*)) FROM events",
+		"SELECT COUNT(nope) FROM events",
+	} {
+		if err := execErr(s, src); err == nil {
+			t.Errorf("%s: no error", src)
+		}
+	}
+	for _, src := range []string{
+		"SELECT SUM(msg) FROM events",
+		"SELECT host, AVG(host) FROM events GROUP BY host",
+	} {
+		if err := execErr(s, src); !errors.Is(err, query.ErrType) {
+			t.Errorf("%s: got %v, want ErrType", src, err)
+		}
+	}
+}
+
+func TestAggregateJoin(t *testing.T) {
+	t.Parallel()
+	s, _ := newTestSession(t)
+	setupShop(t, s)
+
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{
+			"SELECT c.id, COUNT(*), SUM(o.total) FROM customers AS c JOIN orders AS o ON o.customer_id = c.id WHERE c.id <= 2 GROUP BY c.id",
+			[]string{"1 10 13510", "2 10 13520"},
+		},
+		{
+			"SELECT c.id, COUNT(o.id) FROM customers AS c LEFT JOIN orders AS o ON o.customer_id = c.id GROUP BY c.id ORDER BY 2, 1 LIMIT 2",
+			[]string{"301 0", "302 0"},
+		},
+		{
+			"SELECT COUNT(*), MAX(i.sku) FROM orders AS o JOIN items AS i ON i.order_id = o.id WHERE o.customer_id = 7",
+			[]string{"20 sku-907-2"},
+		},
+	}
+	for _, tt := range tests {
+		if got := exec(t, s, tt.src); !slices.Equal(got, tt.want) {
+			t.Errorf("%s\n got %q\nwant %q", tt.src, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package query
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	toydb "github.com/guiwoch/toyDB"
+)
+
+// Stmt is a parsed statement: one of *CreateTable, *CreateIndex,
+// *DropTable, *Insert, *Select, *Update, *Delete, *Explain, *Begin, *Commit
+// or *Rollback.
+type Stmt interface {
+	stmt()
+}
+
+// CreateTable is CREATE TABLE. PrimaryKey names the key columns, in key
+// order.
+type CreateTable struct {
+	Name       string
+	Columns    []ColumnDef
+	PrimaryKey []string
+}
+
+// ColumnDef is one column of a CREATE TABLE. Columns are nullable unless
+// declared NOT NULL or part of the primary key.
+type ColumnDef struct {
+	Name    string
+	Type    toydb.ColType
+	NotNull bool
+}
+
+// CreateIndex is CREATE INDEX ... ON. Columns names the indexed columns, in
+// key order.
+type CreateIndex struct {
+	Name    string
+	Table   string
+	Columns []string
+}
+
+// DropTable is DROP TABLE.
+type DropTable struct {
+	Name string
+}
+
+// Insert is INSERT INTO ... VALUES. Columns is nil when the statement does
+// not list them, in which case each row gives every column in table order.
+type Insert struct {
+	Table   string
+	Columns []string
+	Rows    [][]Expr
+}
+
+// Select is SELECT. From names the first table, and Alias its alias if it
+// has one; Joins joins further tables to it, in order. GroupBy groups the
+// rows that pass Where, for the aggregates in Items and OrderBy. Where,
+// Limit and Offset are nil when absent.
+type Select struct {
+	Items   []SelectItem
+	From    string
+	Alias   string
+	Joins   []Join
+	Where   Expr
+	GroupBy []Expr
+	OrderBy []OrderItem
+	Limit   Expr
+	Offset  Expr
+}
+
+// Join is a JOIN clause: [INNER] JOIN, or LEFT [OUTER] JOIN if Left is set,
+// of a table with an optional alias, ON a condition.
+type Join struct {
+	Left  bool
+	Table string
+	Alias string
+	On    Expr
+}
+
+// SelectItem is one entry of a select list: an expression with an optional
+// alias, or * for every column. With Table set, * stands for every column
+// of the table with that name or alias.
+type SelectItem struct {
+	Star  bool
+	Table string
+	Expr  Expr
+	Alias string
+}
+
+// OrderItem is one entry of an ORDER BY.
+type OrderItem struct {
+	Expr Expr
+	Desc bool
+}
+
+// Update is UPDATE ... SET.
+type Update struct {
+	Table string
+	Set   []Assignment
+	Where Expr
+}
+
+// Assignment is one column = value of an UPDATE.
+type Assignment struct {
+	Column string
+	Value  Expr
+}
+
+// Delete is DELETE FROM.
+type Delete struct {
+	Table string
+	Where Expr
+}
+
+// Explain is EXPLAIN followed by a SELECT, INSERT, UPDATE or DELETE, which
+// it plans without running.
+type Explain struct {
+	Stmt Stmt
+}
+
+// Begin, Commit and Rollback control a [Session]'s transaction.
+type (
+	Begin    struct{}
+	Commit   struct{}
+	Rollback struct{}
+)
+
+func (*CreateTable) stmt() {}
+func (*CreateIndex) stmt() {}
+func (*DropTable) stmt()   {}
+func (*Insert) stmt()      {}
+func (*Select) stmt()      {}
+func (*Update) stmt()      {}
+func (*Delete) stmt()      {}
+func (*Explain) stmt()     {}
+func (*Begin) stmt()       {}
+func (*Commit) stmt()      {}
+func (*Rollback) stmt()    {}
+
+// Expr is a parsed expression: one of *Literal, *Param, *ColumnRef,
+// *Unary, *Binary, *IsNull or *Aggregate. String formats it back as SQL.
+type Expr interface {
+	expr()
+	String() string
+}
+
+// Literal is a constant.
+type Literal struct {
+	Value toydb.Value
+}
+
+// Param is a ? placeholder. Index counts the placeholders of the
+// statement from 0.
+type Param struct {
+	Index int
+}
+
+// ColumnRef names a column, qualified by the name or alias of its table if
+// Table is set.
+type ColumnRef struct {
+	Table string
+	Name  string
+}
+
+// Unary is -X or NOT X.
+type Unary struct {
+	Op string
+	X  Expr
+}
+
+// Binary is a binary operator: OR, AND, =, <>, <, <=, >, >=, +, -, *, /,
+// % or ||.
+type Binary struct {
+	Op   string
+	L, R Expr
+}
+
+// IsNull is X IS NULL, or X IS NOT NULL if Not is set.
+type IsNull struct {
+	X   Expr
+	Not bool
+}
+
+// Aggregate is an aggregate function of the rows of a group: COUNT, SUM,
+// MIN, MAX or AVG of Arg, or COUNT(*) if Arg is nil.
+type Aggregate struct {
+	Func string
+	Arg  Expr
+}
+
+func (*Literal) expr()   {}
+func (*Param) expr()     {}
+func (*ColumnRef) expr() {}
+func (*Unary) expr()     {}
+func (*Binary) expr()    {}
+func (*IsNull) expr()    {}
+func (*Aggregate) expr() {}
+
+func (e *Literal) String() string { return formatLiteral(e.Value) }
+func (e *Param) String() string   { return "?" }
+
+func (e *ColumnRef) String() string {
+	if e.Table != "" {
+		return quoteIdent(e.Table) + "." + quoteIdent(e.Name)
+	}
+	return quoteIdent(e.Name)
+}
+
+func (e *Unary) String() string {
+	if e.Op == "NOT" {
+		return "NOT " + e.X.String()
+	}
+	return e.Op + e.X.String()
+}
+
+func (e *Binary) String() string {
+	return "(" + e.L.String() + " " + e.Op + " " + e.R.String() + ")"
+}
+
+func (e *IsNull) String() string {
+	if e.Not {
+		return e.X.String() + " IS NOT NULL"
+	}
+	return e.X.String() + " IS NULL"
+}
+
+func (e *Aggregate) String() string {
+	if e.Arg == nil {
+		return e.Func + "(*)"
+	}
+	return e.Func + "(" + e.Arg.String() + ")"
+}
+
+// formatLiteral formats v as a SQL literal.
+func formatLiteral(v toydb.Value) string {
+	switch v := v.(type) {
+	case toydb.NullValue:
+		return "NULL"
+	case toydb.BoolValue:
+		if v {
+			return "TRUE"
+		}
+		return "FALSE"
+	case toydb.IntValue:
+		return strconv.FormatInt(int64(v), 10)
+	case toydb.FloatValue:
+		return strconv.FormatFloat(float64(v), 'g', -1, 64)
+	case toydb.TextValue:
+		return quoteString(string(v))
+	case toydb.BytesValue:
+		return "x'" + hex.EncodeToString(v) + "'"
+	case toydb.TimestampValue:
+		return quoteString(v.Time().Format(time.RFC3339Nano))
+	}
+	return quoteString(fmt.Sprint(v))
+}
+
+func quoteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// quoteIdent quotes name if it could not be written bare.
+func quoteIdent(name string) string {
+	bare := name != "" && isIdentStart(name[0]) && !keywords[strings.ToUpper(name)]
+	for i := range len(name) {
+		bare = bare && isIdentPart(name[i])
+	}
+	if bare {
+		return name
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,290 @@
+package query
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	toydb "github.com/guiwoch/toyDB"
+)
+
+// evalFunc computes an expression over one row.
+type evalFunc func(row toydb.Row) (toydb.Value, error)
+
+// scope resolves column names to row positions. A scope covers the
+// columns of one table or, for a join, of each joined table in turn.
+type scope struct {
+	columns []string
+	tables  []string // the name or alias of each column's table
+}
+
+// newScope returns the scope of the rows of a table with schema s, known
+// by the given name.
+func newScope(s *toydb.Schema, table string) *scope {
+	var sc scope
+	for _, c := range s.Columns() {
+		sc.columns = append(sc.columns, c.Name)
+		sc.tables = append(sc.tables, table)
+	}
+	return &sc
+}
+
+// join returns the scope of rows made of a row of sc followed by a row of
+// other.
+func (sc *scope) join(other *scope) *scope {
+	return &scope{
+		columns: append(slices.Clip(sc.columns), other.columns...),
+		tables:  append(slices.Clip(sc.tables), other.tables...),
+	}
+}
+
+// lookup returns the position of the named column.
+func (sc *scope) lookup(name string) (int, error) {
+	return sc.resolve(&ColumnRef{Name: name})
+}
+
+// resolve returns the position of the column ref names. A name matches a
+// column spelled the same way or, failing that, the one column it equals
+// ignoring case. A qualified name only matches columns of the table it
+// names, ignoring case.
+func (sc *scope) resolve(ref *ColumnRef) (int, error) {
+	name := ref.Name
+	if ref.Table != "" {
+		name = ref.Table + "." + ref.Name
+	}
+	exact, folded := -1, -1
+	var nExact, nFolded int
+	for i, c := range sc.columns {
+		if ref.Table != "" && !strings.EqualFold(sc.tables[i], ref.Table) {
+			continue
+		}
+		if c == ref.Name {
+			exact = i
+			nExact++
+		} else if strings.EqualFold(c, ref.Name) {
+			folded = i
+			nFolded++
+		}
+	}
+	switch {
+	case nExact == 1:
+		return exact, nil
+	case nExact > 1 || nExact == 0 && nFolded > 1:
+		return 0, fmt.Errorf("%w: %q is ambiguous", ErrNoColumn, name)
+	case nFolded == 1:
+		return folded, nil
+	}
+	return 0, fmt.Errorf("%w: %q", ErrNoColumn, name)
+}
+
+// compile turns e into a function of a row. Placeholders take their values
+// from args. A nil scope compiles a constant expression, in which column
+// references are an error.
+func compile(e Expr, sc *scope, args []toydb.Value) (evalFunc, error) {
+	switch e := e.(type) {
+	case *Literal:
+		v := e.Value
+		return func(toydb.Row) (toydb.Value, error) { return v, nil }, nil
+	case *Param:
+		if e.Index >= len(args) {
+			return nil, fmt.Errorf("%w: statement needs more than %d", ErrArgCount, len(args))
+		}
+		v := args[e.Index]
+		if v == nil {
+			v = toydb.Null
+		}
+		return func(toydb.Row) (toydb.Value, error) { return v, nil }, nil
+	case *ColumnRef:
+		if sc == nil {
+			return nil, fmt.Errorf("column %s cannot be used here", e)
+		}
+		i, err := sc.resolve(e)
+		if err != nil {
+			return nil, err
+		}
+		return func(row toydb.Row) (toydb.Value, error) { return row[i], nil }, nil
+	case *IsNull:
+		x, err := compile(e.X, sc, args)
+		if err != nil {
+			return nil, err
+		}
+		not := e.Not
+		return func(row toydb.Row) (toydb.Value, error) {
+			v, err := x(row)
+			if err != nil {
+				return nil, err
+			}
+			return toydb.BoolValue((v == toydb.Null) != not), nil
+		}, nil
+	case *Unary:
+		x, err := compile(e.X, sc, args)
+		if err != nil {
+			return nil, err
+		}
+		if e.Op == "NOT" {
+			return func(row toydb.Row) (toydb.Value, error) {
+				v, err := x(row)
+				if err != nil || v == toydb.Null {
+					return v, err
+				}
+				b, ok := v.(toydb.BoolValue)
+				if !ok {
+					return nil, fmt.Errorf("%w: NOT applied to %s", ErrType, typeName(v))
+				}
+				return !b, nil
+			}, nil
+		}
+		return func(row toydb.Row) (toydb.Value, error) {
+			v, err := x(row)
+			if err != nil || v == toydb.Null {
+				return v, err
+			}
+			return arith("-", toydb.IntValue(0), v)
+		}, nil
+	case *Binary:
+		l, err := compile(e.L, sc, args)
+		if err != nil {
+			return nil, err
+		}
+		r, err := compile(e.R, sc, args)
+		if err != nil {
+			return nil, err
+		}
+		switch e.Op {
+		case "AND", "OR":
+			return logical(e.Op, l, r), nil
+		}
+		op := e.Op
+		return func(row toydb.Row) (toydb.Value, error) {
+			a, err := l(row)
+			if err != nil {
+				return nil, err
+			}
+			b, err := r(row)
+			if err != nil {
+				return nil, err
+			}
+			if a == toydb.Null || b == toydb.Null {
+				return toydb.Null, nil
+			}
+			return binary(op, a, b)
+		}, nil
+	case *Aggregate:
+		return nil, fmt.Errorf("aggregate %s can only be used in the select list and ORDER BY", e)
+	}
+	return nil, fmt.Errorf("unsupported expression %T", e)
+}
+
+// logical compiles AND and OR with SQL's three-valued logic: a null operand
+// is unknown, which AND with false is still false and OR with true still
+// true.
+func logical(op string, l, r evalFunc) evalFunc {
+	short := toydb.BoolValue(op == "OR")
+	operand := func(f evalFunc, row toydb.Row) (toydb.Value, error) {
+		v, err := f(row)
+		if err != nil || v == toydb.Null {
+			return v, err
+		}
+		if _, ok := v.(toydb.BoolValue); !ok {
+			return nil, fmt.Errorf("%w: %s applied to %s", ErrType, op, typeName(v))
+		}
+		return v, nil
+	}
+	return func(row toydb.Row) (toydb.Value, error) {
+		a, err := operand(l, row)
+		if err != nil || a == short {
+			return a, err
+		}
+		b, err := operand(r, row)
+		if err != nil || b == short {
+			return b, err
+		}
+		if a == toydb.Null || b == toydb.Null {
+			return toydb.Null, nil
+		}
+		return !short, nil
+	}
+}
+
+// binary applies a comparison, arithmetic or concatenation operator to two
+// non-null values.
+func binary(op string, a, b toydb.Value) (toydb.Value, error) {
+	switch op {
+	case "+", "-", "*", "/", "%":
+		return arith(op, a, b)
+	case "||":
+		sa, ok1 := a.(toydb.TextValue)
+		sb, ok2 := b.(toydb.TextValue)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("%w: cannot apply || to %s and %s", ErrType, typeName(a), typeName(b))
+		}
+		return sa + sb, nil
+	}
+	c, err := compareValues(a, b)
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case "=":
+		return toydb.BoolValue(c == 0), nil
+	case "<>":
+		return toydb.BoolValue(c != 0), nil
+	case "<":
+		return toydb.BoolValue(c < 0), nil
+	case "<=":
+		return toydb.BoolValue(c <= 0), nil
+	case ">":
+		return toydb.BoolValue(c > 0), nil
+	case ">=":
+		return toydb.BoolValue(c >= 0), nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", op)
+}
+
+// predicate compiles a WHERE condition, under which a row passes only if
+// the condition is true: false and null both reject it. A nil condition
+// passes every row.
+func predicate(e Expr, sc *scope, args []toydb.Value) (func(toydb.Row) (bool, error), error) {
+	if e == nil {
+		return func(toydb.Row) (bool, error) { return true, nil }, nil
+	}
+	f, err := compile(e, sc, args)
+	if err != nil {
+		return nil, err
+	}
+	return func(row toydb.Row) (bool, error) {
+		v, err := f(row)
+		if err != nil || v == toydb.Null {
+			return false, err
+		}
+		b, ok := v.(toydb.BoolValue)
+		if !ok {
+			return false, fmt.Errorf("%w: WHERE condition is %s, not bool", ErrType, typeName(v))
+		}
+		return bool(b), nil
+	}, nil
+}
+
+// constant evaluates an expression that refers to no columns.
+func constant(e Expr, args []toydb.Value) (toydb.Value, error) {
+	f, err := compile(e, nil, args)
+	if err != nil {
+		return nil, err
+	}
+	return f(nil)
+}
+
+// isConstant reports whether e refers to no columns.
+func isConstant(e Expr) bool {
+	switch e := e.(type) {
+	case *ColumnRef, *Aggregate:
+		return false
+	case *Unary:
+		return isConstant(e.X)
+	case *Binary:
+		return isConstant(e.L) && isConstant(e.R)
+	case *IsNull:
+		return isConstant(e.X)
+	}
+	return true
+}
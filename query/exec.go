@@ -0,0 +1,484 @@
+// Package query runs SQL statements against a toydb database.
+//
+// It understands a small subset of SQL: CREATE TABLE with a PRIMARY KEY,
+// CREATE INDEX, DROP TABLE, INSERT, SELECT with WHERE, GROUP BY, ORDER BY,
+// LIMIT and OFFSET, from one table or from several joined by [INNER] JOIN
+// and LEFT [OUTER] JOIN, UPDATE, DELETE, EXPLAIN, and BEGIN, COMMIT and
+// ROLLBACK. A SELECT may compute COUNT, SUM, MIN, MAX and AVG over all its
+// rows or over each group. Statements are run by a [Session], which maps
+// them onto the toydb API. A cost-based planner picks how to read the rows a
+// WHERE clause can match: a SELECT, UPDATE or DELETE whose WHERE clause
+// fixes the leading columns of the primary key or of a secondary index, or
+// bounds the next one, reads only that part of the table or index through
+// [toydb.Table.Get], [toydb.Table.Scan], [toydb.Table.Lookup] or
+// [toydb.Table.ScanIndex], rather than every row. It also picks how to join
+// each table: by primary key lookups for each joined row, by merging two
+// primary key scans, or by hashing the table's rows in memory. Groups whose
+// GROUP BY columns lead the primary key are aggregated as the rows stream
+// past in key order; others are hashed, and spilled to a temporary file past
+// [WithAggregateMemory]. Plans are compared by the pages they are estimated
+// to read, from [toydb.Table.Stats] and [toydb.Table.EstimateRange].
+// EXPLAIN, or [Session.Explain], shows the chosen plan.
+//
+// Column types are the toydb ones, named INT, TEXT, BOOL, TIMESTAMP, FLOAT,
+// BYTES, DECIMAL, UUID and JSON (with the usual synonyms, such as INTEGER
+// and VARCHAR). Timestamps, decimals, UUIDs and JSON documents are written
+// as string literals and converted to the column's type.
+package query
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"strings"
+
+	toydb "github.com/guiwoch/toyDB"
+)
+
+// Sentinel errors returned when running statements. Errors from the
+// database itself, such as toydb.ErrDuplicateKey, are passed through.
+var (
+	// ErrType is returned when an operator or column is given a value of a
+	// type it cannot take, such as text added to an integer.
+	ErrType = errors.New("type mismatch")
+
+	// ErrNoColumn is returned when a statement names a column the table
+	// does not have.
+	ErrNoColumn = errors.New("no such column")
+
+	// ErrArgCount is returned when a statement has more placeholders than
+	// it was given arguments.
+	ErrArgCount = errors.New("not enough arguments for placeholders")
+
+	// ErrTxInProgress is returned by BEGIN when the session already has a
+	// transaction open.
+	ErrTxInProgress = errors.New("transaction already in progress")
+
+	// ErrNoTx is returned by COMMIT and ROLLBACK when the session has no
+	// transaction open.
+	ErrNoTx = errors.New("no transaction in progress")
+)
+
+// Session runs statements against a DB. Between BEGIN and COMMIT or
+// ROLLBACK, its statements belong to one transaction; otherwise each
+// statement that writes commits on its own, all of its changes or none.
+//
+// A Session must be used by one goroutine at a time, and a transaction it
+// holds open blocks writes from elsewhere in the same way as a [toydb.Tx].
+type Session struct {
+	db *toydb.DB
+	tx *toydb.Tx
+
+	aggregateMemory int
+}
+
+// Option configures optional Session behavior.
+type Option func(*Session)
+
+// DefaultAggregateMemory is the memory, in bytes, that hash aggregation
+// holds groups in before it spills them to temporary pages.
+const DefaultAggregateMemory = 16 << 20
+
+// WithAggregateMemory sets the memory, in bytes, that a GROUP BY not
+// streamed in primary key order holds groups in. Groups past it are
+// spilled to a temporary file and aggregated a part at a time. Defaults
+// to [DefaultAggregateMemory].
+func WithAggregateMemory(n int) Option {
+	return func(s *Session) {
+		s.aggregateMemory = n
+	}
+}
+
+// NewSession returns a session on d with no transaction open.
+func NewSession(d *toydb.DB, opts ...Option) *Session {
+	s := &Session{db: d, aggregateMemory: DefaultAggregateMemory}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Result is the outcome of a statement. For a SELECT, Columns names the
+// result columns and Rows yields the rows; for other statements Rows is nil
+// and RowsAffected counts the rows written.
+//
+// Rows reads the table as it iterates, so it must be consumed, or
+// abandoned, before the session runs its next statement.
+type Result struct {
+	Columns      []string
+	Rows         iter.Seq2[toydb.Row, error]
+	RowsAffected int
+}
+
+// InTx reports whether the session has a transaction open.
+func (s *Session) InTx() bool {
+	return s.tx != nil
+}
+
+// Close rolls back the session's open transaction, if any.
+func (s *Session) Close() error {
+	if s.tx == nil {
+		return nil
+	}
+	err := s.tx.Rollback()
+	s.tx = nil
+	return err
+}
+
+// Exec parses and runs one statement. Its ? placeholders take the values of
+// args in order.
+//
+// Inside a transaction, a statement that fails part way keeps the writes it
+// had already made; roll the transaction back to undo them.
+func (s *Session) Exec(src string, args ...toydb.Value) (*Result, error) {
+	stmt, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	return s.ExecStmt(stmt, args...)
+}
+
+// ExecStmt runs a statement returned by [Parse], as for [Session.Exec].
+func (s *Session) ExecStmt(stmt Stmt, args ...toydb.Value) (*Result, error) {
+	switch stmt := stmt.(type) {
+	case *CreateTable:
+		return s.createTable(stmt)
+	case *CreateIndex:
+		return s.createIndex(stmt)
+	case *DropTable:
+		if s.tx != nil {
+			return nil, toydb.ErrSchemaChangeInTx
+		}
+		return &Result{}, s.db.DropTable(stmt.Name)
+	case *Insert:
+		return s.insert(stmt, args)
+	case *Select:
+		return s.selectRows(stmt, args)
+	case *Update:
+		return s.update(stmt, args)
+	case *Delete:
+		return s.delete(stmt, args)
+	case *Explain:
+		plan, err := s.explain(stmt.Stmt, args)
+		if err != nil {
+			return nil, err
+		}
+		lines := strings.Split(plan.String(), "\n")
+		return &Result{
+			Columns: []string{"plan"},
+			Rows: func(yield func(toydb.Row, error) bool) {
+				for _, line := range lines {
+					if !yield(toydb.Row{toydb.TextValue(line)}, nil) {
+						return
+					}
+				}
+			},
+		}, nil
+	case *Begin:
+		if s.tx != nil {
+			return nil, ErrTxInProgress
+		}
+		tx, err := s.db.Begin()
+		if err != nil {
+			return nil, err
+		}
+		s.tx = tx
+		return &Result{}, nil
+	case *Commit, *Rollback:
+		if s.tx == nil {
+			return nil, ErrNoTx
+		}
+		tx := s.tx
+		s.tx = nil
+		if _, ok := stmt.(*Commit); ok {
+			return &Result{}, tx.Commit()
+		}
+		return &Result{}, tx.Rollback()
+	}
+	return nil, fmt.Errorf("unsupported statement %T", stmt)
+}
+
+// table opens the named table, through the session's transaction if it
+// has one.
+func (s *Session) table(name string) (*toydb.Table, error) {
+	if s.tx != nil {
+		return s.tx.Table(name)
+	}
+	return s.db.OpenTable(name)
+}
+
+// write runs fn on the named table inside the session's transaction or, if
+// it has none, inside one of its own that commits if fn succeeds.
+func (s *Session) write(name string, fn func(t *toydb.Table) (int, error)) (*Result, error) {
+	if s.tx != nil {
+		t, err := s.tx.Table(name)
+		if err != nil {
+			return nil, err
+		}
+		n, err := fn(t)
+		if err != nil {
+			return nil, err
+		}
+		return &Result{RowsAffected: n}, nil
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	t, err := tx.Table(name)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	n, err := fn(t)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &Result{RowsAffected: n}, nil
+}
+
+func (s *Session) createTable(stmt *CreateTable) (*Result, error) {
+	if s.tx != nil {
+		return nil, toydb.ErrSchemaChangeInTx
+	}
+	sc := &scope{}
+	columns := make([]toydb.Column, len(stmt.Columns))
+	for i, c := range stmt.Columns {
+		columns[i] = toydb.Column{Name: c.Name, Type: c.Type, Nullable: !c.NotNull}
+		sc.columns = append(sc.columns, c.Name)
+	}
+	pk := make([]int, len(stmt.PrimaryKey))
+	for i, name := range stmt.PrimaryKey {
+		j := slices.Index(sc.columns, name)
+		if j < 0 {
+			return nil, fmt.Errorf("%w: primary key column %q", ErrNoColumn, name)
+		}
+		pk[i] = j
+		columns[j].Nullable = false
+	}
+	schema, err := toydb.NewCompositeSchema(pk, columns)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.db.CreateTable(stmt.Name, schema); err != nil {
+		return nil, err
+	}
+	return &Result{}, nil
+}
+
+func (s *Session) createIndex(stmt *CreateIndex) (*Result, error) {
+	if s.tx != nil {
+		return nil, toydb.ErrSchemaChangeInTx
+	}
+	t, err := s.db.OpenTable(stmt.Table)
+	if err != nil {
+		return nil, err
+	}
+	sc := newScope(t.Schema(), stmt.Table)
+	columns := make([]string, len(stmt.Columns))
+	for i, name := range stmt.Columns {
+		c, err := sc.lookup(name)
+		if err != nil {
+			return nil, err
+		}
+		columns[i] = sc.columns[c]
+	}
+	return &Result{}, t.CreateIndex(stmt.Name, columns...)
+}
+
+func (s *Session) insert(stmt *Insert, args []toydb.Value) (*Result, error) {
+	return s.write(stmt.Table, func(t *toydb.Table) (int, error) {
+		columns := t.Schema().Columns()
+		sc := newScope(t.Schema(), stmt.Table)
+		positions := make([]int, len(columns))
+		for i := range positions {
+			positions[i] = i
+		}
+		if stmt.Columns != nil {
+			positions = positions[:0]
+			for _, name := range stmt.Columns {
+				i, err := sc.lookup(name)
+				if err != nil {
+					return 0, err
+				}
+				if slices.Contains(positions, i) {
+					return 0, fmt.Errorf("column %q listed more than once", name)
+				}
+				positions = append(positions, i)
+			}
+		}
+
+		// Work out every row before writing any, so that a bad value
+		// does not leave the statement half done.
+		rows := make([]toydb.Row, len(stmt.Rows))
+		for r, exprs := range stmt.Rows {
+			if len(exprs) != len(positions) {
+				return 0, fmt.Errorf("%w: row %d has %d values for %d columns", toydb.ErrSchemaMismatch, r+1, len(exprs), len(positions))
+			}
+			row := make(toydb.Row, len(columns))
+			for i := range row {
+				row[i] = toydb.Null
+			}
+			for i, e := range exprs {
+				v, err := constant(e, args)
+				if err != nil {
+					return 0, err
+				}
+				col := positions[i]
+				if row[col], err = coerce(v, columns[col].Type); err != nil {
+					return 0, fmt.Errorf("column %q: %w", columns[col].Name, err)
+				}
+			}
+			rows[r] = row
+		}
+		for _, row := range rows {
+			if err := t.Insert(row); err != nil {
+				return 0, err
+			}
+		}
+		return len(rows), nil
+	})
+}
+
+// matching returns the rows of t that satisfy where, reading only the part
+// of the table or of an index that the planner picks.
+func matching(t *toydb.Table, where Expr, sc *scope, args []toydb.Value) ([]toydb.Row, error) {
+	p, err := choose(t, where, sc, args, nil, -1, false)
+	if err != nil {
+		return nil, err
+	}
+	return filterAccess(t, p.access, where, sc, args)
+}
+
+// filterAccess returns the rows of t in the access's range that satisfy
+// where.
+func filterAccess(t *toydb.Table, a *keyAccess, where Expr, sc *scope, args []toydb.Value) ([]toydb.Row, error) {
+	filter, err := predicate(where, sc, args)
+	if err != nil {
+		return nil, err
+	}
+	var rows []toydb.Row
+	for row, err := range a.rows(t, false) {
+		if err != nil {
+			return nil, err
+		}
+		ok, err := filter(row)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
+
+// primaryKey returns the primary key of row, whose key columns are at
+// positions key.
+func primaryKey(row toydb.Row, key []int) toydb.Key {
+	k := make(toydb.Key, len(key))
+	for i, col := range key {
+		k[i] = row[col]
+	}
+	return k
+}
+
+func (s *Session) update(stmt *Update, args []toydb.Value) (*Result, error) {
+	return s.write(stmt.Table, func(t *toydb.Table) (int, error) {
+		schema := t.Schema()
+		columns := schema.Columns()
+		sc := newScope(schema, stmt.Table)
+		key := keyColumns(schema, sc)
+
+		set := make([]evalFunc, len(columns))
+		keyChanged := false
+		for _, a := range stmt.Set {
+			i, err := sc.lookup(a.Column)
+			if err != nil {
+				return 0, err
+			}
+			if set[i] != nil {
+				return 0, fmt.Errorf("column %q assigned more than once", a.Column)
+			}
+			if set[i], err = compile(a.Value, sc, args); err != nil {
+				return 0, err
+			}
+			keyChanged = keyChanged || slices.Contains(key, i)
+		}
+
+		// The rows to change are all found before any is written, so
+		// that the writes do not disturb the search.
+		rows, err := matching(t, stmt.Where, sc, args)
+		if err != nil {
+			return 0, err
+		}
+		updated := make([]toydb.Row, len(rows))
+		for r, row := range rows {
+			updated[r] = slices.Clone(row)
+			for i, f := range set {
+				if f == nil {
+					continue
+				}
+				v, err := f(row)
+				if err != nil {
+					return 0, err
+				}
+				if updated[r][i], err = coerce(v, columns[i].Type); err != nil {
+					return 0, fmt.Errorf("column %q: %w", columns[i].Name, err)
+				}
+			}
+		}
+
+		if !keyChanged {
+			for _, row := range updated {
+				if err := t.Update(row); err != nil {
+					return 0, err
+				}
+			}
+			return len(rows), nil
+		}
+		// Rows whose key changes are moved by deleting them all before
+		// inserting any, so that SET id = id + 1 does not collide with the
+		// next row's old key.
+		for _, row := range rows {
+			if err := t.Delete(primaryKey(row, key)); err != nil {
+				return 0, err
+			}
+		}
+		for _, row := range updated {
+			if err := t.Insert(row); err != nil {
+				return 0, err
+			}
+		}
+		return len(rows), nil
+	})
+}
+
+func (s *Session) delete(stmt *Delete, args []toydb.Value) (*Result, error) {
+	return s.write(stmt.Table, func(t *toydb.Table) (int, error) {
+		sc := newScope(t.Schema(), stmt.Table)
+		p, err := choose(t, stmt.Where, sc, args, nil, -1, false)
+		if err != nil {
+			return 0, err
+		}
+		if lo, hi, ok := p.access.deleteBounds(); ok {
+			return t.DeleteRange(lo, hi)
+		}
+		rows, err := filterAccess(t, p.access, stmt.Where, sc, args)
+		if err != nil {
+			return 0, err
+		}
+		key := keyColumns(t.Schema(), sc)
+		for _, row := range rows {
+			if err := t.Delete(primaryKey(row, key)); err != nil {
+				return 0, err
+			}
+		}
+		return len(rows), nil
+	})
+}
@@ -0,0 +1,322 @@
+package query_test
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	toydb "github.com/guiwoch/toyDB"
+	"github.com/guiwoch/toyDB/query"
+)
+
+// newTestSession opens a fresh DB in a temp dir and returns a session on
+// it. Both are closed on test cleanup.
+func newTestSession(t *testing.T) (*query.Session, *toydb.DB) {
+	t.Helper()
+	d, err := toydb.Open(t.TempDir() + "/test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := query.NewSession(d)
+	t.Cleanup(func() {
+		_ = s.Close()
+		_ = d.Close()
+	})
+	return s, d
+}
+
+// exec runs a statement that must succeed and returns its result rows, each
+// formatted as its values separated by spaces.
+func exec(t *testing.T, s *query.Session, src string, args ...toydb.Value) []string {
+	t.Helper()
+	res, err := s.Exec(src, args...)
+	if err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+	if res.Rows == nil {
+		return nil
+	}
+	var rows []string
+	for row, err := range res.Rows {
+		if err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+		rows = append(rows, formatRow(row))
+	}
+	return rows
+}
+
+// execErr runs a statement and returns the error it fails with, whether
+// from Exec or from reading its rows.
+func execErr(s *query.Session, src string, args ...toydb.Value) error {
+	res, err := s.Exec(src, args...)
+	if err != nil || res.Rows == nil {
+		return err
+	}
+	for _, err := range res.Rows {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatRow(row toydb.Row) string {
+	fields := make([]string, len(row))
+	for i, v := range row {
+		fields[i] = fmt.Sprint(v)
+	}
+	return strings.Join(fields, " ")
+}
+
+// setupEvents creates a table keyed by (host, seq) holding n rows for each
+// of the hosts a, b and c.
+func setupEvents(t *testing.T, s *query.Session, n int) {
+	t.Helper()
+	exec(t, s, `CREATE TABLE events (
+		host TEXT, seq INT, msg TEXT NOT NULL, weight FLOAT,
+		PRIMARY KEY (host, seq))`)
+	exec(t, s, "BEGIN")
+	for _, host := range []string{"a", "b", "c"} {
+		for i := range n {
+			weight := toydb.Value(toydb.FloatValue(float64(i) / 2))
+			if i%10 == 0 {
+				weight = toydb.Null
+			}
+			exec(t, s, "INSERT INTO events VALUES (?, ?, ?, ?)",
+				toydb.TextValue(host), toydb.IntValue(i), toydb.TextValue(fmt.Sprintf("%s-%d", host, i)), weight)
+		}
+	}
+	exec(t, s, "COMMIT")
+}
+
+func TestSelect(t *testing.T) {
+	t.Parallel()
+	s, _ := newTestSession(t)
+	setupEvents(t, s, 20)
+
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{"SELECT msg FROM events WHERE host = 'b' AND seq = 7", []string{"b-7"}},
+		{"SELECT msg FROM events WHERE host = 'b' AND seq = 70", nil},
+		{"SELECT seq FROM events WHERE host = 'a' AND seq >= 17", []string{"17", "18", "19"}},
+		{"SELECT seq FROM events WHERE host = 'a' AND seq > 17", []string{"18", "19"}},
+		{"SELECT seq FROM events WHERE host = 'a' AND seq < 2", []string{"0", "1"}},
+		{"SELECT seq FROM events WHERE host = 'a' AND seq <= 2 AND seq > 0", []string{"1", "2"}},
+		{"SELECT seq FROM events WHERE host = 'a' AND 3 > seq", []string{"0", "1", "2"}},
+		{"SELECT host, seq FROM events WHERE host > 'b' LIMIT 2", []string{"c 0", "c 1"}},
+		{"SELECT host, seq FROM events WHERE host >= 'b' AND host < 'c' ORDER BY host DESC, seq DESC LIMIT 2", []string{"b 19", "b 18"}},
+		{"SELECT seq FROM events WHERE host = 'c' ORDER BY seq DESC LIMIT 3 OFFSET 1", []string{"18", "17", "16"}},
+		{"SELECT seq FROM events WHERE host = 'c' AND seq <= 5 ORDER BY seq DESC", []string{"5", "4", "3", "2", "1", "0"}},
+		{"SELECT seq FROM events WHERE host = 'c' AND seq > 15 ORDER BY host, seq DESC", []string{"19", "18", "17", "16"}},
+		{"SELECT seq, weight FROM events WHERE host = 'a' AND weight > 8", []string{"17 8.5", "18 9", "19 9.5"}},
+		{"SELECT seq FROM events WHERE host = 'a' AND weight IS NULL", []string{"0", "10"}},
+		{"SELECT seq FROM events WHERE host = 'a' AND NOT (weight < 9) ORDER BY weight DESC", []string{"19", "18"}},
+		{"SELECT seq FROM events WHERE host = 'a' AND (seq = 3 OR seq = 4 OR weight = 9)", []string{"3", "4", "18"}},
+		{"SELECT seq * 2 + 1 AS odd, msg || '!' FROM events WHERE host = 'b' AND seq = 4", []string{"9 b-4!"}},
+		{"SELECT seq FROM events WHERE host = 'b' AND seq < 5 ORDER BY seq % 2, seq", []string{"0", "2", "4", "1", "3"}},
+		{"SELECT seq AS n FROM events WHERE host = 'b' AND seq < 3 ORDER BY n DESC", []string{"2", "1", "0"}},
+		{"SELECT host, seq FROM events WHERE seq = 19 ORDER BY 1 DESC", []string{"c 19", "b 19", "a 19"}},
+		{"SELECT seq FROM events WHERE host = 'b' ORDER BY weight LIMIT 3", []string{"0", "10", "1"}},
+		{"SELECT * FROM events WHERE host = 'a' AND seq = 1", []string{"a 1 a-1 0.5"}},
+		{"SELECT seq FROM events WHERE host = 'a' AND seq = 1 AND seq = 2", nil},
+		{"SELECT seq FROM events WHERE host = 'a' AND seq > 5 AND seq < 3", nil},
+		{"SELECT seq FROM events WHERE host = 'a' AND seq = NULL", nil},
+	}
+	for _, tt := range tests {
+		if got := exec(t, s, tt.src); !slices.Equal(got, tt.want) {
+			t.Errorf("%s\n got %q\nwant %q", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestSelectUsesKey(t *testing.T) {
+	t.Parallel()
+	s, d := newTestSession(t)
+	setupEvents(t, s, 5000)
+
+	reads := func(src string) uint64 {
+		t.Helper()
+		before := d.Stats()
+		exec(t, s, src)
+		after := d.Stats()
+		return after.Hits + after.Misses - before.Hits - before.Misses
+	}
+	full := reads("SELECT seq FROM events WHERE msg = 'b-10'")
+	for _, src := range []string{
+		"SELECT seq FROM events WHERE host = 'b' AND seq = 10",
+		"SELECT seq FROM events WHERE host = 'b' AND seq >= 10 AND seq < 20",
+		"SELECT seq FROM events WHERE seq < 20 AND host = 'b' AND msg <> ''",
+		"SELECT seq FROM events WHERE host = 'b' AND seq > 4980 ORDER BY seq DESC",
+		"SELECT seq FROM events WHERE host = 'a' AND seq <= 10 ORDER BY seq DESC",
+		"SELECT seq FROM events WHERE host = 'c' LIMIT 5",
+	} {
+		if n := reads(src); n*10 > full {
+			t.Errorf("%s: read %d pages, a full scan reads %d", src, n, full)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	t.Parallel()
+	s, _ := newTestSession(t)
+	setupEvents(t, s, 10)
+
+	affected := func(src string, args ...toydb.Value) int {
+		t.Helper()
+		res, err := s.Exec(src, args...)
+		if err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+		return res.RowsAffected
+	}
+	if n := affected("UPDATE events SET msg = msg || '*', weight = NULL WHERE host = 'a' AND seq < 3"); n != 3 {
+		t.Fatalf("update affected %d rows, want 3", n)
+	}
+	if got := exec(t, s, "SELECT msg, weight FROM events WHERE host = 'a' AND seq <= 3"); !slices.Equal(got, []string{"a-0* null", "a-1* null", "a-2* null", "a-3 1.5"}) {
+		t.Fatalf("after update: %q", got)
+	}
+	// Moving rows to keys other rows had must not collide.
+	if n := affected("UPDATE events SET seq = seq + 1 WHERE host = 'b'"); n != 10 {
+		t.Fatalf("key update affected %d rows, want 10", n)
+	}
+	if got := exec(t, s, "SELECT seq, msg FROM events WHERE host = 'b' AND seq < 3"); !slices.Equal(got, []string{"1 b-0", "2 b-1"}) {
+		t.Fatalf("after key update: %q", got)
+	}
+
+	if n := affected("DELETE FROM events WHERE host >= 'b'"); n != 20 {
+		t.Fatalf("range delete affected %d rows, want 20", n)
+	}
+	if n := affected("DELETE FROM events WHERE host = 'a' AND weight > 2"); n != 5 {
+		t.Fatalf("filtered delete affected %d rows, want 5", n)
+	}
+	if n := affected("DELETE FROM events WHERE host = 'a' AND seq = ?", toydb.IntValue(3)); n != 1 {
+		t.Fatalf("point delete affected %d rows, want 1", n)
+	}
+	if got := exec(t, s, "SELECT seq FROM events"); !slices.Equal(got, []string{"0", "1", "2", "4"}) {
+		t.Fatalf("after deletes: %q", got)
+	}
+
+	// A failing statement outside a transaction writes nothing.
+	if _, err := s.Exec("INSERT INTO events (host, seq, msg) VALUES ('z', 1, 'new'), ('a', 0, 'dup')"); !errors.Is(err, toydb.ErrDuplicateKey) {
+		t.Fatalf("duplicate insert: got %v, want ErrDuplicateKey", err)
+	}
+	if got := exec(t, s, "SELECT seq FROM events WHERE host = 'z'"); got != nil {
+		t.Fatalf("failed insert left %q", got)
+	}
+}
+
+func TestTransactions(t *testing.T) {
+	t.Parallel()
+	s, _ := newTestSession(t)
+	exec(t, s, "CREATE TABLE t (id INT PRIMARY KEY, v TEXT)")
+
+	exec(t, s, "BEGIN")
+	exec(t, s, "INSERT INTO t VALUES (1, 'one'), (2, 'two')")
+	if got := exec(t, s, "SELECT v FROM t"); len(got) != 2 {
+		t.Fatalf("inside transaction: %q", got)
+	}
+	if _, err := s.Exec("CREATE TABLE u (id INT PRIMARY KEY)"); !errors.Is(err, toydb.ErrSchemaChangeInTx) {
+		t.Fatalf("CREATE TABLE in transaction: got %v, want ErrSchemaChangeInTx", err)
+	}
+	if _, err := s.Exec("BEGIN"); !errors.Is(err, query.ErrTxInProgress) {
+		t.Fatalf("nested BEGIN: got %v, want ErrTxInProgress", err)
+	}
+	exec(t, s, "ROLLBACK")
+	if got := exec(t, s, "SELECT v FROM t"); got != nil {
+		t.Fatalf("after rollback: %q", got)
+	}
+	if _, err := s.Exec("COMMIT"); !errors.Is(err, query.ErrNoTx) {
+		t.Fatalf("COMMIT without BEGIN: got %v, want ErrNoTx", err)
+	}
+
+	exec(t, s, "BEGIN")
+	exec(t, s, "INSERT INTO t (id) VALUES (3)")
+	exec(t, s, "COMMIT")
+	if got := exec(t, s, "SELECT id, v FROM t"); !slices.Equal(got, []string{"3 null"}) {
+		t.Fatalf("after commit: %q", got)
+	}
+}
+
+func TestTypes(t *testing.T) {
+	t.Parallel()
+	s, _ := newTestSession(t)
+	exec(t, s, `CREATE TABLE things (
+		id UUID PRIMARY KEY, at TIMESTAMP, price DECIMAL, doc JSON, raw BYTES, ok BOOLEAN)`)
+	exec(t, s, `INSERT INTO things VALUES
+		('00000000-0000-0000-0000-000000000001', '2024-05-01T12:00:00Z', '19.99', '{"a": 1}', x'cafe', TRUE),
+		('00000000-0000-0000-0000-000000000002', '2024-06-01', 5, '[]', x'', FALSE)`)
+
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{"SELECT price FROM things WHERE at < '2024-05-15'", []string{"19.99"}},
+		{"SELECT price * 2 FROM things WHERE price > 10.5", []string{"39.98"}},
+		{"SELECT ok FROM things WHERE id = '00000000-0000-0000-0000-000000000002'", []string{"false"}},
+		{"SELECT price FROM things WHERE ok", []string{"19.99"}},
+		{"SELECT price FROM things ORDER BY price", []string{"5", "19.99"}},
+	}
+	for _, tt := range tests {
+		if got := exec(t, s, tt.src); !slices.Equal(got, tt.want) {
+			t.Errorf("%s\n got %q\nwant %q", tt.src, got, tt.want)
+		}
+	}
+
+	for _, src := range []string{
+		"INSERT INTO things (id, price) VALUES ('not a uuid', 1)",
+		"INSERT INTO things (id, ok) VALUES ('00000000-0000-0000-0000-000000000003', 'yes')",
+		"SELECT id FROM things WHERE price + 'x' > 1",
+	} {
+		if err := execErr(s, src); !errors.Is(err, query.ErrType) {
+			t.Errorf("%s: got %v, want ErrType", src, err)
+		}
+	}
+}
+
+func TestDecimalArithmetic(t *testing.T) {
+	t.Parallel()
+	s, _ := newTestSession(t)
+	exec(t, s, "CREATE TABLE d (id INT PRIMARY KEY, a DECIMAL, b DECIMAL)")
+	exec(t, s, `INSERT INTO d VALUES
+		(1, '0.5', '100000000'),
+		(2, '-0.5', '100000000'),
+		(3, '3000000', '3000000'),
+		(4, '0.000001', '0.5'),
+		(5, '9007199254740.993', '1'),
+		(6, '2', '3'),
+		(7, '-10', '4'),
+		(8, '1', '-3'),
+		(9, '9000000000000', '0.000001')`)
+
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		// The products of 1-3 overflow int64 millionths before the
+		// division by the unit brings them back in range.
+		{"SELECT a * b FROM d WHERE id <= 4 ORDER BY id", []string{"50000000", "-50000000", "9000000000000", "0.000001"}},
+		// 5 is beyond a float64's 53 bits of precision.
+		{"SELECT a / b FROM d WHERE id >= 5 AND id <= 8 ORDER BY id", []string{"9007199254740.993", "0.666667", "-2.5", "-0.333333"}},
+		{"SELECT a / b FROM d WHERE id = 1", []string{"0"}},
+	}
+	for _, tt := range tests {
+		if got := exec(t, s, tt.src); !slices.Equal(got, tt.want) {
+			t.Errorf("%s\n got %q\nwant %q", tt.src, got, tt.want)
+		}
+	}
+
+	for _, tt := range []struct{ src, want string }{
+		{"SELECT a * a FROM d WHERE id = 5", "overflow"},
+		{"SELECT a / b FROM d WHERE id = 9", "overflow"},
+		{"SELECT a / 0 FROM d WHERE id = 1", "division by zero"},
+	} {
+		if err := execErr(s, tt.src); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tt.src, err, tt.want)
+		}
+	}
+}
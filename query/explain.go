@@ -0,0 +1,200 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	toydb "github.com/guiwoch/toyDB"
+)
+
+// Plan is one step of how a statement runs, as EXPLAIN shows it. A step
+// works on the rows its inputs produce; the innermost steps read the table.
+// Estimates come from the table's statistics and are rough: WHERE
+// conditions that the access path does not use are assumed to pass a third
+// of the rows each.
+type Plan struct {
+	Op     string // such as "Key Range", "Index Lookup", "Filter" or "Sort"
+	Detail string // what the step reads, or the expression it applies
+	Rows   int64  // estimated rows the step produces
+	Pages  int64  // estimated pages read by the step and its inputs
+	Inputs []*Plan
+}
+
+// String formats the plan as a tree, one step per line, with the inputs of
+// each step indented under it.
+func (p *Plan) String() string {
+	var b strings.Builder
+	p.format(&b, 0)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func (p *Plan) format(b *strings.Builder, depth int) {
+	b.WriteString(strings.Repeat("  ", depth))
+	b.WriteString(p.Op)
+	if p.Detail != "" {
+		b.WriteString(": " + p.Detail)
+	}
+	fmt.Fprintf(b, "  (rows=%d pages=%d)\n", p.Rows, p.Pages)
+	for _, in := range p.Inputs {
+		in.format(b, depth+1)
+	}
+}
+
+// Explain parses a SELECT, INSERT, UPDATE or DELETE, optionally written
+// with EXPLAIN in front, and returns the plan it would run with, without
+// running it. Its ? placeholders take the values of args in order, as for
+// [Session.Exec], since they can change the plan.
+func (s *Session) Explain(src string, args ...toydb.Value) (*Plan, error) {
+	stmt, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := stmt.(*Explain); ok {
+		stmt = e.Stmt
+	}
+	return s.explain(stmt, args)
+}
+
+func (s *Session) explain(stmt Stmt, args []toydb.Value) (*Plan, error) {
+	switch stmt := stmt.(type) {
+	case *Select:
+		_, plan, err := s.planSelect(stmt, args, true)
+		return plan, err
+	case *Insert:
+		t, err := s.table(stmt.Table)
+		if err != nil {
+			return nil, err
+		}
+		stats, err := t.Stats()
+		if err != nil {
+			return nil, err
+		}
+		n := int64(len(stmt.Rows))
+		return &Plan{Op: "Insert", Detail: quoteIdent(stmt.Table), Rows: n, Pages: n * int64(stats.Height)}, nil
+	case *Update:
+		in, _, err := s.explainWhere(stmt.Table, stmt.Where, args)
+		if err != nil {
+			return nil, err
+		}
+		return &Plan{Op: "Update", Detail: quoteIdent(stmt.Table), Rows: in.Rows, Pages: in.Pages, Inputs: []*Plan{in}}, nil
+	case *Delete:
+		in, p, err := s.explainWhere(stmt.Table, stmt.Where, args)
+		if err != nil {
+			return nil, err
+		}
+		if _, _, ok := p.access.deleteBounds(); ok {
+			return &Plan{Op: "Delete Range", Detail: in.Detail, Rows: in.Rows, Pages: in.Pages}, nil
+		}
+		return &Plan{Op: "Delete", Detail: quoteIdent(stmt.Table), Rows: in.Rows, Pages: in.Pages, Inputs: []*Plan{in}}, nil
+	}
+	return nil, errors.New("EXPLAIN supports only SELECT, INSERT, UPDATE and DELETE")
+}
+
+// explainWhere plans the search of an UPDATE or DELETE for the rows of the
+// named table that match where.
+func (s *Session) explainWhere(table string, where Expr, args []toydb.Value) (*Plan, *path, error) {
+	t, err := s.table(table)
+	if err != nil {
+		return nil, nil, err
+	}
+	sc := newScope(t.Schema(), table)
+	p, err := choose(t, where, sc, args, nil, -1, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	return accessPlan(quoteIdent(table), p, where, sc), p, nil
+}
+
+// accessPlan returns the steps that read the rows of a path through a
+// table, shown as name, and filter them by where.
+func accessPlan(name string, p *path, where Expr, sc *scope) *Plan {
+	a := p.access
+	ranged := len(a.eq) > 0 || a.lo != nil || a.hi != nil
+	plan := &Plan{Rows: int64(math.Round(p.rows)), Pages: int64(math.Ceil(p.pages))}
+	switch {
+	case a.point():
+		plan.Op = "Key Lookup"
+	case a.index == "" && ranged:
+		plan.Op = "Key Range"
+	case a.index == "":
+		plan.Op = "Full Scan"
+	case a.lo != nil || a.hi != nil:
+		plan.Op = "Index Range"
+	case ranged:
+		plan.Op = "Index Lookup"
+	default:
+		plan.Op = "Index Scan"
+	}
+
+	plan.Detail = name
+	if a.index != "" {
+		plan.Detail += " using " + quoteIdent(a.index)
+	}
+	var conds []string
+	for i, v := range a.eq {
+		conds = append(conds, quoteIdent(sc.columns[a.key[i]])+" = "+formatLiteral(v))
+	}
+	for _, b := range []struct {
+		bound *keyBound
+		op    string
+	}{{a.lo, ">"}, {a.hi, "<"}} {
+		if b.bound == nil {
+			continue
+		}
+		op := b.op
+		if b.bound.inclusive {
+			op += "="
+		}
+		conds = append(conds, quoteIdent(sc.columns[a.key[len(a.eq)]])+" "+op+" "+formatLiteral(b.bound.v))
+	}
+	if conds != nil {
+		plan.Detail += " (" + strings.Join(conds, " AND ") + ")"
+	}
+	if p.desc {
+		plan.Detail += ", descending"
+	}
+
+	if a.exact() {
+		return plan
+	}
+	return &Plan{
+		Op:     "Filter",
+		Detail: where.String(),
+		Rows:   int64(math.Round(p.filtered())),
+		Pages:  plan.Pages,
+		Inputs: []*Plan{plan},
+	}
+}
+
+// limitPlan adds a LIMIT and OFFSET step over in. early is the pages read
+// when reading can stop once the limit is reached, or -1.
+func limitPlan(in *Plan, limit, offset int, early int64) *Plan {
+	plan := &Plan{Op: "Limit", Rows: max(in.Rows-int64(offset), 0), Pages: in.Pages, Inputs: []*Plan{in}}
+	if limit >= 0 {
+		plan.Detail = fmt.Sprint(limit)
+		plan.Rows = min(plan.Rows, int64(limit))
+		if early >= 0 {
+			plan.Pages = early
+		}
+	} else {
+		plan.Detail = "ALL"
+	}
+	if offset > 0 {
+		plan.Detail += fmt.Sprintf(" OFFSET %d", offset)
+	}
+	return plan
+}
+
+// formatOrder formats ORDER BY items as SQL.
+func formatOrder(order []OrderItem) string {
+	items := make([]string, len(order))
+	for i, o := range order {
+		items[i] = o.Expr.String()
+		if o.Desc {
+			items[i] += " DESC"
+		}
+	}
+	return strings.Join(items, ", ")
+}
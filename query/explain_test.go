@@ -0,0 +1,182 @@
+package query_test
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	toydb "github.com/guiwoch/toyDB"
+	"github.com/guiwoch/toyDB/query"
+)
+
+// setupIndexedEvents is setupEvents with indexes on msg and weight.
+func setupIndexedEvents(t *testing.T, s *query.Session, n int) {
+	t.Helper()
+	setupEvents(t, s, n)
+	exec(t, s, "CREATE INDEX by_msg ON events (msg)")
+	exec(t, s, "CREATE INDEX by_weight ON events (weight)")
+}
+
+// leaf returns the innermost step of a plan, the one that reads the table.
+func leaf(p *query.Plan) *query.Plan {
+	for len(p.Inputs) > 0 {
+		p = p.Inputs[0]
+	}
+	return p
+}
+
+func TestPlanner(t *testing.T) {
+	t.Parallel()
+	s, _ := newTestSession(t)
+	setupIndexedEvents(t, s, 5000)
+
+	tests := []struct {
+		src    string
+		op     string // of the step reading the table
+		detail string
+	}{
+		{"SELECT * FROM events WHERE host = 'b' AND seq = 10", "Key Lookup", `events (host = 'b' AND seq = 10)`},
+		{"SELECT * FROM events WHERE host = 'b' AND seq >= 10 AND seq < 20", "Key Range", `events (host = 'b' AND seq >= 10 AND seq < 20)`},
+		{"SELECT * FROM events WHERE host = 'c' ORDER BY seq DESC LIMIT 5", "Key Range", `events (host = 'c'), descending`},
+		{"SELECT * FROM events WHERE msg = 'b-10'", "Index Lookup", `events using by_msg (msg = 'b-10')`},
+		{"SELECT * FROM events WHERE weight > 10 AND weight <= 11", "Index Range", `events using by_weight (weight > 10 AND weight <= 11)`},
+		{"SELECT * FROM events ORDER BY msg LIMIT 3", "Index Scan", `events using by_msg`},
+		// Most rows match, so reading them through the index, a table
+		// lookup per row, costs more than reading the table.
+		{"SELECT * FROM events WHERE weight > 100", "Full Scan", `events`},
+		{"SELECT * FROM events WHERE msg >= 'a'", "Full Scan", `events`},
+		{"SELECT * FROM events ORDER BY msg", "Full Scan", `events`},
+		{"UPDATE events SET weight = 0 WHERE msg = 'c-7'", "Index Lookup", `events using by_msg (msg = 'c-7')`},
+		{"DELETE FROM events WHERE host = 'a' AND seq < 10", "Delete Range", `events (host = 'a' AND seq < 10)`},
+		{"INSERT INTO events VALUES ('d', 1, 'd-1', NULL)", "Insert", `events`},
+	}
+	for _, tt := range tests {
+		p, err := s.Explain(tt.src)
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		if l := leaf(p); l.Op != tt.op || l.Detail != tt.detail {
+			t.Errorf("%s: plan\n%s\nwant %s: %s", tt.src, p, tt.op, tt.detail)
+		}
+	}
+
+	// Estimates come from the shape of the trees, so they are close
+	// rather than exact.
+	p, err := s.Explain("SELECT * FROM events WHERE host = ?", toydb.TextValue("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Rows < 4500 || p.Rows > 5500 {
+		t.Errorf("estimated %d rows for one host, want about 5000", p.Rows)
+	}
+	p, err = s.Explain("SELECT * FROM events WHERE host = 'b' AND weight IS NULL ORDER BY weight DESC LIMIT 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ops []string
+	for ; p != nil; p = first(p.Inputs) {
+		ops = append(ops, p.Op)
+	}
+	if want := []string{"Limit", "Sort", "Filter", "Key Range"}; !slices.Equal(ops, want) {
+		t.Errorf("plan steps %q, want %q", ops, want)
+	}
+}
+
+func first(plans []*query.Plan) *query.Plan {
+	if len(plans) == 0 {
+		return nil
+	}
+	return plans[0]
+}
+
+func TestIndexAccess(t *testing.T) {
+	t.Parallel()
+	s, d := newTestSession(t)
+	setupIndexedEvents(t, s, 2000)
+
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{"SELECT host, seq FROM events WHERE msg = 'b-10'", []string{"b 10"}},
+		{"SELECT seq FROM events WHERE msg = 'b-10' AND host = 'a'", nil},
+		{"SELECT msg FROM events WHERE weight > 10 AND weight <= 11", []string{"a-21", "b-21", "c-21", "a-22", "b-22", "c-22"}},
+		{"SELECT msg FROM events WHERE weight >= 11 AND weight < 11.5 AND host <> 'b'", []string{"a-22", "c-22"}},
+		{"SELECT msg FROM events WHERE weight < 1", []string{"a-1", "b-1", "c-1"}},
+		{"SELECT msg FROM events ORDER BY msg LIMIT 3", []string{"a-0", "a-1", "a-10"}},
+		{"SELECT msg FROM events WHERE host = 'c' ORDER BY msg DESC LIMIT 2", []string{"c-999", "c-998"}},
+	}
+	for _, tt := range tests {
+		if got := exec(t, s, tt.src); !slices.Equal(got, tt.want) {
+			t.Errorf("%s\n got %q\nwant %q", tt.src, got, tt.want)
+		}
+	}
+
+	reads := func(src string) uint64 {
+		t.Helper()
+		before := d.Stats()
+		exec(t, s, src)
+		after := d.Stats()
+		return after.Hits + after.Misses - before.Hits - before.Misses
+	}
+	full := reads("SELECT seq FROM events WHERE msg || '' = 'b-10'")
+	if n := reads("SELECT seq FROM events WHERE msg = 'b-10'"); n*10 > full {
+		t.Errorf("index lookup read %d pages, a full scan reads %d", n, full)
+	}
+
+	// Writes through an index path find the same rows.
+	if res, err := s.Exec("UPDATE events SET weight = -1 WHERE msg = 'c-7'"); err != nil || res.RowsAffected != 1 {
+		t.Fatalf("update: %v, %v; want 1 row", res, err)
+	}
+	if got := exec(t, s, "SELECT msg FROM events WHERE weight < 0"); !slices.Equal(got, []string{"c-7"}) {
+		t.Fatalf("after update: %q", got)
+	}
+	res, err := s.Exec("DELETE FROM events WHERE weight >= 10 AND weight < 10.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.RowsAffected != 0 {
+		t.Fatalf("deleted %d rows whose weight is null, want 0", res.RowsAffected)
+	}
+	if res, err = s.Exec("DELETE FROM events WHERE weight = 10.5"); err != nil || res.RowsAffected != 3 {
+		t.Fatalf("delete: %v, %v; want 3 rows", res, err)
+	}
+	if got := exec(t, s, "SELECT msg FROM events WHERE msg = 'a-21'"); got != nil {
+		t.Fatalf("after delete: %q", got)
+	}
+}
+
+func TestExplainStatement(t *testing.T) {
+	t.Parallel()
+	s, _ := newTestSession(t)
+	setupIndexedEvents(t, s, 100)
+
+	res, err := s.Exec("EXPLAIN SELECT seq FROM events WHERE msg = ? ORDER BY seq", toydb.TextValue("a-7"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(res.Columns, []string{"plan"}) {
+		t.Fatalf("columns %q", res.Columns)
+	}
+	var lines []string
+	for row, err := range res.Rows {
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, formatRow(row))
+	}
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "Sort: seq  (rows=1 pages=") ||
+		!strings.HasPrefix(lines[1], "  Index Lookup: events using by_msg (msg = 'a-7')  (rows=1 pages=") {
+		t.Fatalf("EXPLAIN output:\n%s", strings.Join(lines, "\n"))
+	}
+
+	// EXPLAIN does not run the statement.
+	exec(t, s, "EXPLAIN DELETE FROM events")
+	if got := exec(t, s, "SELECT msg FROM events WHERE host = 'a' AND seq = 7"); !slices.Equal(got, []string{"a-7"}) {
+		t.Fatalf("after EXPLAIN DELETE: %q", got)
+	}
+	if _, err := s.Explain("CREATE TABLE t (a INT PRIMARY KEY)"); err == nil {
+		t.Fatal("explaining CREATE TABLE: no error")
+	}
+}
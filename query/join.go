@@ -0,0 +1,696 @@
+package query
+
+import (
+	"fmt"
+	"iter"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	toydb "github.com/guiwoch/toyDB"
+)
+
+// fromTable is one table of a FROM clause.
+type fromTable struct {
+	table  *toydb.Table
+	name   string // the table's alias, or its name
+	shown  string // the table as EXPLAIN shows it
+	left   bool   // joined by LEFT JOIN
+	on     Expr
+	scope  *scope // of the table's own rows
+	offset int    // position of the table's first column in joined rows
+}
+
+// openFrom opens the tables of a SELECT and returns them with the scope of
+// the rows they join into: the columns of each table, in FROM order.
+func (s *Session) openFrom(stmt *Select) ([]*fromTable, *scope, error) {
+	joins := append([]Join{{Table: stmt.From, Alias: stmt.Alias}}, stmt.Joins...)
+	from := make([]*fromTable, len(joins))
+	sc := &scope{}
+	for i, j := range joins {
+		t, err := s.table(j.Table)
+		if err != nil {
+			return nil, nil, err
+		}
+		f := &fromTable{table: t, name: j.Table, shown: quoteIdent(j.Table), left: j.Left, on: j.On, offset: len(sc.columns)}
+		if j.Alias != "" {
+			f.name = j.Alias
+			f.shown += " " + quoteIdent(j.Alias)
+		}
+		for _, other := range from[:i] {
+			if strings.EqualFold(other.name, f.name) {
+				return nil, nil, fmt.Errorf("table name %q is used more than once in FROM", f.name)
+			}
+		}
+		f.scope = newScope(t.Schema(), f.name)
+		sc = sc.join(f.scope)
+		from[i] = f
+	}
+	return from, sc, nil
+}
+
+// tableOf returns the FROM position of the table holding the column at
+// position i of joined rows.
+func tableOf(from []*fromTable, i int) int {
+	t := len(from) - 1
+	for from[t].offset > i {
+		t--
+	}
+	return t
+}
+
+// columnType returns the type of the column at position i of joined rows.
+func columnType(from []*fromTable, i int) toydb.ColType {
+	f := from[tableOf(from, i)]
+	return f.table.Schema().Columns()[i-f.offset].Type
+}
+
+// source is the planned FROM and WHERE of a SELECT: the rows that pass the
+// WHERE clause, joined from every table.
+type source struct {
+	rows iter.Seq2[toydb.Row, error]
+	// inOrder is set when the rows come in the ORDER BY order.
+	inOrder bool
+	// plan is the plan for EXPLAIN, and early the pages read if reading
+	// stops once a LIMIT is reached, or -1.
+	plan  *Plan
+	early int64
+}
+
+// Join methods, as EXPLAIN names them.
+const (
+	nestedLoopJoin = "Nested Loop"
+	mergeJoin      = "Merge"
+	hashJoin       = "Hash"
+)
+
+// joinStep joins one more table to the rows joined so far. Each joined row
+// is followed by the rows of the table whose columns at rightKeys equal its
+// own columns at leftKeys and that pass match, or with no keys by every row
+// of the table that passes match. For a LEFT JOIN, a joined row that no row
+// of the table matches is followed by nulls. The rows that result are then
+// filtered by after.
+//
+// The table's rows pass filter, the conditions on its own columns. They are
+// read through path, except by a nested loop join with keys, which looks
+// them up by a prefix of the primary key for each joined row.
+type joinStep struct {
+	*fromTable
+	method              string
+	lookup              bool
+	path                *path
+	leftKeys, rightKeys []int
+
+	where, matchExpr, afterExpr Expr
+	filter, match, after        func(toydb.Row) (bool, error)
+
+	// Estimates: the rows joined so far, the rows of the table a lookup
+	// join fetches and the pages it reads for each joined row, the rows
+	// that pass match, and the pages read by the join and its inputs.
+	leftRows, fetched, probe, rows, cost float64
+}
+
+// keyPair is an equality between a column of joined rows, at left, and one
+// of the table a join adds, at right in its own rows.
+type keyPair struct{ left, right int }
+
+// conjunct is a WHERE or ON condition with the tables it refers to.
+type conjunct struct {
+	expr   Expr
+	tables []int // FROM positions, ascending
+}
+
+// conjunctsOf splits e on its top-level ANDs and finds the tables each part
+// refers to.
+func conjunctsOf(e Expr, from []*fromTable, sc *scope) ([]conjunct, error) {
+	var conds []conjunct
+	for _, c := range conjuncts(e) {
+		var tables []int
+		var err error
+		columnRefs(c, func(ref *ColumnRef) {
+			i, rerr := sc.resolve(ref)
+			if rerr != nil {
+				if err == nil {
+					err = rerr
+				}
+				return
+			}
+			if t := tableOf(from, i); !slices.Contains(tables, t) {
+				tables = append(tables, t)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		slices.Sort(tables)
+		conds = append(conds, conjunct{c, tables})
+	}
+	return conds, nil
+}
+
+// columnRefs calls fn for every column reference in e.
+func columnRefs(e Expr, fn func(*ColumnRef)) {
+	switch e := e.(type) {
+	case *ColumnRef:
+		fn(e)
+	case *Unary:
+		columnRefs(e.X, fn)
+	case *Binary:
+		columnRefs(e.L, fn)
+		columnRefs(e.R, fn)
+	case *IsNull:
+		columnRefs(e.X, fn)
+	case *Aggregate:
+		if e.Arg != nil {
+			columnRefs(e.Arg, fn)
+		}
+	}
+}
+
+// conjoin joins conditions with AND, returning nil for none.
+func conjoin(conds []Expr) Expr {
+	var e Expr
+	for _, c := range conds {
+		if e == nil {
+			e = c
+		} else {
+			e = &Binary{Op: "AND", L: e, R: c}
+		}
+	}
+	return e
+}
+
+// filterRows returns the rows that pass filter.
+func filterRows(rows iter.Seq2[toydb.Row, error], filter func(toydb.Row) (bool, error)) iter.Seq2[toydb.Row, error] {
+	return func(yield func(toydb.Row, error) bool) {
+		for row, err := range rows {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			ok, err := filter(row)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if ok && !yield(row, nil) {
+				return
+			}
+		}
+	}
+}
+
+// planFrom plans how to read and join the tables of a SELECT, keeping the
+// rows that pass its WHERE clause. Tables are joined in FROM order, each to
+// the rows joined so far, and every join keeps the order of those rows, so
+// the first table is read in the ORDER BY order when that is cheap.
+//
+// Conditions are applied as early as they can be: one on the columns of a
+// single table filters its rows as they are read, and narrows the part of
+// the table that is read; one across tables decides which rows join. For
+// each join the planner picks the method that reads the fewest pages, by
+// the tables' statistics: looking up the table's rows by primary key for
+// each joined row (a nested loop join), reading the first two tables side
+// by side in primary key order (a merge join), or reading the table once
+// into memory, hashed on the columns it is joined by (a hash join, or a
+// nested loop join when there are none).
+func planFrom(from []*fromTable, stmt *Select, sc *scope, args []toydb.Value, order []OrderItem, want int, explain bool) (*source, error) {
+	first := from[0]
+	if len(from) == 1 {
+		p, err := choose(first.table, stmt.Where, sc, args, order, want, explain)
+		if err != nil {
+			return nil, err
+		}
+		filter, err := predicate(stmt.Where, sc, args)
+		if err != nil {
+			return nil, err
+		}
+		src := &source{rows: filterRows(p.access.rows(first.table, p.desc), filter), inOrder: p.inOrder, early: -1}
+		if explain {
+			src.plan = accessPlan(first.shown, p, stmt.Where, sc)
+			if p.inOrder {
+				src.early = int64(math.Ceil(p.cost))
+			}
+		}
+		return src, nil
+	}
+
+	// Conditions go to base, on the rows of one table; to match, deciding
+	// which rows a join joins; or, when they refer to a table that a LEFT
+	// JOIN can fill with nulls, to after, filtering the rows it joins.
+	base := make([][]Expr, len(from))
+	match := make([][]Expr, len(from))
+	after := make([][]Expr, len(from))
+	place := func(c conjunct) {
+		if len(c.tables) == 0 {
+			base[0] = append(base[0], c.expr)
+			return
+		}
+		last := c.tables[len(c.tables)-1]
+		switch {
+		case slices.ContainsFunc(c.tables, func(t int) bool { return from[t].left }):
+			after[last] = append(after[last], c.expr)
+		case len(c.tables) == 1:
+			base[last] = append(base[last], c.expr)
+		default:
+			match[last] = append(match[last], c.expr)
+		}
+	}
+	where, err := conjunctsOf(stmt.Where, from, sc)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range where {
+		place(c)
+	}
+	for k, f := range from {
+		on, err := conjunctsOf(f.on, from, sc)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range on {
+			switch {
+			case len(c.tables) > 0 && c.tables[len(c.tables)-1] > k:
+				return nil, fmt.Errorf("ON clause of %s refers to a table joined after it", f.name)
+			case !f.left:
+				// ON and WHERE mean the same for an inner join.
+				place(c)
+			case slices.Equal(c.tables, []int{k}):
+				base[k] = append(base[k], c.expr)
+			default:
+				match[k] = append(match[k], c.expr)
+			}
+		}
+	}
+
+	stats := make([]toydb.TableStats, len(from))
+	for i, f := range from {
+		if stats[i], err = f.table.Stats(); err != nil {
+			return nil, err
+		}
+	}
+	where0 := conjoin(base[0])
+	filter0, err := predicate(where0, first.scope, args)
+	if err != nil {
+		return nil, err
+	}
+	keyConds0, err := keyConditions(where0, first.table.Schema(), first.scope, args)
+	if err != nil {
+		return nil, err
+	}
+	p0, err := chooseWith(first.table, stats[0], keyConds0, first.scope, order, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	steps := make([]*joinStep, 0, len(from)-1)
+	leftRows, leftPages := p0.filtered(), p0.pages
+	var plan *Plan
+	for k := 1; k < len(from); k++ {
+		f := from[k]
+		j := &joinStep{fromTable: f, where: conjoin(base[k]), matchExpr: conjoin(match[k]), afterExpr: conjoin(after[k]), leftRows: leftRows}
+		if j.filter, err = predicate(j.where, f.scope, args); err != nil {
+			return nil, err
+		}
+		if j.match, err = predicate(j.matchExpr, sc, args); err != nil {
+			return nil, err
+		}
+		if j.after, err = predicate(j.afterExpr, sc, args); err != nil {
+			return nil, err
+		}
+		keyConds, err := keyConditions(j.where, f.table.Schema(), f.scope, args)
+		if err != nil {
+			return nil, err
+		}
+		if j.path, err = chooseWith(f.table, stats[k], keyConds, f.scope, nil, -1); err != nil {
+			return nil, err
+		}
+		pairs := keyPairs(match[k], f, sc)
+		sameType := func(p keyPair) bool {
+			return columnType(from, p.left) == f.table.Schema().Columns()[p.right].Type
+		}
+
+		// The pairs that cover a prefix of the table's primary key let a
+		// nested loop join look its rows up.
+		pk := keyColumns(f.table.Schema(), f.scope)
+		var lookup []int
+		for _, col := range pk {
+			i := slices.IndexFunc(pairs, func(p keyPair) bool { return p.right == col })
+			if i < 0 {
+				break
+			}
+			lookup = append(lookup, pairs[i].left)
+		}
+
+		// Each joined row matches a share of the table's rows: at most one
+		// when joined on the whole primary key and, on other columns, about
+		// as many as make the larger side match once each.
+		n, right := float64(stats[k].Rows), j.path.filtered()
+		j.rows = leftRows * right
+		switch {
+		case len(lookup) == len(pk) && n > 0:
+			j.rows = leftRows * right / n
+		case len(pairs) > 0:
+			j.rows = max(leftRows, right)
+		}
+		j.rows *= math.Pow(residualSelectivity, float64(len(match[k])-len(pairs)))
+		if f.left {
+			j.rows = max(j.rows, leftRows)
+		}
+
+		// Pick the cheapest method, preferring the earlier one on ties.
+		j.cost = math.Inf(1)
+		if lookup != nil {
+			j.probe, j.fetched = float64(stats[k].Height), min(leftRows, leftRows*n)
+			if len(lookup) < len(pk) {
+				j.probe++
+				j.fetched = max(leftRows, n)
+			}
+			j.method, j.lookup, j.cost = nestedLoopJoin, true, leftPages+leftRows*j.probe
+			j.leftKeys, j.rightKeys = lookup, pk[:len(lookup)]
+		}
+		if k == 1 {
+			pk0 := keyColumns(first.table.Schema(), first.scope)
+			merge := 0
+			for merge < min(len(pk0), len(pk)) && slices.ContainsFunc(pairs, func(p keyPair) bool {
+				return p.left == pk0[merge] && p.right == pk[merge] && sameType(p)
+			}) {
+				merge++
+			}
+			if merge > 0 {
+				l := newPath(matchKey("", pk0, keyConds0), order, first.scope)
+				r := newPath(matchKey("", pk, keyConds), nil, f.scope)
+				if err := l.estimate(first.table, stats[0], -1); err != nil {
+					return nil, err
+				}
+				if err := r.estimate(f.table, stats[k], -1); err != nil {
+					return nil, err
+				}
+				if c := l.pages + r.pages; c < j.cost {
+					if l.desc {
+						l.desc, l.inOrder = false, false
+					}
+					p0, j.path = l, r
+					j.method, j.lookup, j.cost = mergeJoin, false, c
+					j.leftKeys, j.rightKeys = pk0[:merge], pk[:merge]
+				}
+			}
+		}
+		if c := leftPages + j.path.pages; c < j.cost {
+			j.method, j.lookup, j.cost = nestedLoopJoin, false, c
+			j.leftKeys, j.rightKeys = nil, nil
+			for _, p := range pairs {
+				if sameType(p) {
+					j.method = hashJoin
+					j.leftKeys = append(j.leftKeys, p.left)
+					j.rightKeys = append(j.rightKeys, p.right)
+				}
+			}
+		}
+
+		if explain {
+			if plan == nil {
+				plan = accessPlan(first.shown, p0, where0, first.scope)
+			}
+			plan = j.explain(plan, sc)
+		}
+		leftRows = j.rows * math.Pow(residualSelectivity, float64(len(after[k])))
+		leftPages = j.cost
+		if explain && j.afterExpr != nil {
+			plan = &Plan{Op: "Filter", Detail: j.afterExpr.String(), Rows: int64(math.Round(leftRows)), Pages: plan.Pages, Inputs: []*Plan{plan}}
+		}
+		steps = append(steps, j)
+	}
+
+	src := &source{rows: filterRows(p0.access.rows(first.table, p0.desc), filter0), inOrder: p0.inOrder, plan: plan, early: -1}
+	for _, j := range steps {
+		src.rows = j.join(src.rows)
+	}
+	return src, nil
+}
+
+// keyPairs returns the equalities among a join's match conditions between
+// a column of the table f and one of the rows it is joined to.
+func keyPairs(match []Expr, f *fromTable, sc *scope) []keyPair {
+	var pairs []keyPair
+	for _, c := range match {
+		b, ok := c.(*Binary)
+		if !ok || b.Op != "=" {
+			continue
+		}
+		l, lok := b.L.(*ColumnRef)
+		r, rok := b.R.(*ColumnRef)
+		if !lok || !rok {
+			continue
+		}
+		li, lerr := sc.resolve(l)
+		ri, rerr := sc.resolve(r)
+		if lerr != nil || rerr != nil {
+			continue
+		}
+		if li > ri {
+			li, ri = ri, li
+		}
+		if li < f.offset && ri >= f.offset {
+			pairs = append(pairs, keyPair{li, ri - f.offset})
+		}
+	}
+	return pairs
+}
+
+// explain returns the plan of the join, given the plan of the rows joined
+// so far.
+func (j *joinStep) explain(left *Plan, sc *scope) *Plan {
+	op := j.method + " Join"
+	if j.left {
+		op = j.method + " Left Join"
+	}
+	plan := &Plan{Op: op, Rows: int64(math.Round(j.rows)), Pages: int64(math.Ceil(j.cost)), Inputs: []*Plan{left, nil}}
+	if j.matchExpr != nil {
+		plan.Detail = j.matchExpr.String()
+	}
+	if !j.lookup {
+		plan.Inputs[1] = accessPlan(j.shown, j.path, j.where, j.scope)
+		return plan
+	}
+
+	right := &Plan{Op: "Key Range", Rows: int64(math.Round(j.fetched)), Pages: int64(math.Ceil(j.leftRows * j.probe))}
+	if len(j.rightKeys) == len(j.table.Schema().PrimaryKeyColumns()) {
+		right.Op = "Key Lookup"
+	}
+	conds := make([]string, len(j.rightKeys))
+	for i, col := range j.rightKeys {
+		l := j.leftKeys[i]
+		conds[i] = quoteIdent(j.scope.columns[col]) + " = " + (&ColumnRef{Table: sc.tables[l], Name: sc.columns[l]}).String()
+	}
+	right.Detail = j.shown + " (" + strings.Join(conds, " AND ") + ")"
+	if j.where != nil {
+		right = &Plan{
+			Op:     "Filter",
+			Detail: j.where.String(),
+			Rows:   int64(math.Round(j.fetched * math.Pow(residualSelectivity, float64(len(conjuncts(j.where)))))),
+			Pages:  right.Pages,
+			Inputs: []*Plan{right},
+		}
+	}
+	plan.Inputs[1] = right
+	return plan
+}
+
+// join returns the rows of the join, given the rows joined so far.
+func (j *joinStep) join(left iter.Seq2[toydb.Row, error]) iter.Seq2[toydb.Row, error] {
+	var rows iter.Seq2[toydb.Row, error]
+	switch {
+	case j.lookup:
+		rows = j.lookupJoin(left)
+	case j.method == mergeJoin:
+		rows = j.mergeJoin(left)
+	default:
+		rows = j.hashJoin(left)
+	}
+	return filterRows(rows, j.after)
+}
+
+// tableRows returns the table's rows read through the step's path that
+// pass its filter.
+func (j *joinStep) tableRows() iter.Seq2[toydb.Row, error] {
+	return filterRows(j.path.access.rows(j.table, j.path.desc), j.filter)
+}
+
+// joinRow yields row joined with each of candidates that passes match or,
+// for a LEFT JOIN, with nulls if none does. It reports whether to go on.
+func (j *joinStep) joinRow(row toydb.Row, candidates []toydb.Row, yield func(toydb.Row, error) bool) bool {
+	matched := false
+	for _, c := range candidates {
+		joined := append(slices.Clip(row), c...)
+		ok, err := j.match(joined)
+		if err != nil {
+			yield(nil, err)
+			return false
+		}
+		if ok {
+			matched = true
+			if !yield(joined, nil) {
+				return false
+			}
+		}
+	}
+	if !matched && j.left {
+		return yield(append(slices.Clip(row), slices.Repeat(toydb.Row{toydb.Null}, len(j.scope.columns))...), nil)
+	}
+	return true
+}
+
+// lookupJoin is a nested loop join that looks up the rows of the table
+// matching each joined row by a prefix of its primary key.
+func (j *joinStep) lookupJoin(left iter.Seq2[toydb.Row, error]) iter.Seq2[toydb.Row, error] {
+	schema := j.table.Schema()
+	key := keyColumns(schema, j.scope)
+	return func(yield func(toydb.Row, error) bool) {
+		for row, err := range left {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			// A null, or a value that no key column can hold, equals no
+			// key.
+			a := &keyAccess{key: key}
+			for i, col := range j.leftKeys {
+				v := row[col]
+				if v != toydb.Null {
+					v, err = coerce(v, schema.Columns()[j.rightKeys[i]].Type)
+				}
+				if v == toydb.Null || err != nil {
+					a = nil
+					break
+				}
+				a.eq = append(a.eq, v)
+			}
+			var candidates []toydb.Row
+			if a != nil {
+				for c, err := range filterRows(a.rows(j.table, false), j.filter) {
+					if err != nil {
+						yield(nil, err)
+						return
+					}
+					candidates = append(candidates, c)
+				}
+			}
+			if !j.joinRow(row, candidates, yield) {
+				return
+			}
+		}
+	}
+}
+
+// mergeJoin joins rows that come in order of their columns at leftKeys
+// with the table's rows, read in primary key order, advancing through both
+// together.
+func (j *joinStep) mergeJoin(left iter.Seq2[toydb.Row, error]) iter.Seq2[toydb.Row, error] {
+	return func(yield func(toydb.Row, error) bool) {
+		next, stop := iter.Pull2(j.tableRows())
+		defer stop()
+		pending, err, more := next()
+
+		// group holds the table's rows whose keys equal those of last, the
+		// previous joined row; pending is the table's first row after them.
+		var group []toydb.Row
+		var last toydb.Row
+		for row, lerr := range left {
+			if lerr != nil {
+				yield(nil, lerr)
+				return
+			}
+			c := 1
+			if last != nil {
+				if c, lerr = compareKeys(row, j.leftKeys, last, j.leftKeys); lerr != nil {
+					yield(nil, lerr)
+					return
+				}
+			}
+			if c != 0 {
+				group = nil
+				for more && err == nil {
+					if c, err = compareKeys(pending, j.rightKeys, row, j.leftKeys); err != nil || c > 0 {
+						break
+					}
+					if c == 0 {
+						group = append(group, pending)
+					}
+					pending, err, more = next()
+				}
+				if err != nil {
+					yield(nil, err)
+					return
+				}
+				last = row
+			}
+			if !j.joinRow(row, group, yield) {
+				return
+			}
+		}
+	}
+}
+
+// compareKeys orders rows a and b by their columns at aKeys and bKeys.
+func compareKeys(a toydb.Row, aKeys []int, b toydb.Row, bKeys []int) (int, error) {
+	for i := range aKeys {
+		if c, err := sortCompare(a[aKeys[i]], b[bKeys[i]]); c != 0 || err != nil {
+			return c, err
+		}
+	}
+	return 0, nil
+}
+
+// hashJoin reads the table's rows into memory, by their columns at
+// rightKeys, and joins each joined row to those whose keys equal its own.
+// With no keys, it is a nested loop join over the rows in memory.
+func (j *joinStep) hashJoin(left iter.Seq2[toydb.Row, error]) iter.Seq2[toydb.Row, error] {
+	return func(yield func(toydb.Row, error) bool) {
+		table := make(map[string][]toydb.Row)
+		for row, err := range j.tableRows() {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if h, ok := hashKey(row, j.rightKeys); ok {
+				table[h] = append(table[h], row)
+			}
+		}
+		for row, err := range left {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			var candidates []toydb.Row
+			if h, ok := hashKey(row, j.leftKeys); ok {
+				candidates = table[h]
+			}
+			if !j.joinRow(row, candidates, yield) {
+				return
+			}
+		}
+	}
+}
+
+// hashKey encodes the columns of row at keys so that values of one type
+// that are equal encode the same. It reports false if one is null, since
+// null equals nothing.
+func hashKey(row toydb.Row, keys []int) (string, bool) {
+	var b strings.Builder
+	for _, col := range keys {
+		v := row[col]
+		if v == toydb.Null {
+			return "", false
+		}
+		if f, ok := v.(toydb.FloatValue); ok && f == 0 {
+			v = toydb.FloatValue(0) // and not -0
+		}
+		s := fmt.Sprint(v)
+		b.WriteString(strconv.Itoa(len(s)))
+		b.WriteByte(':')
+		b.WriteString(s)
+	}
+	return b.String(), true
+}
@@ -0,0 +1,203 @@
+package query_test
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	toydb "github.com/guiwoch/toyDB"
+	"github.com/guiwoch/toyDB/query"
+)
+
+// setupShop creates customers 1 to 310, each with a long address, orders 1
+// to 3000, placed by customers 1 to 300 in turn, and two items for each
+// order.
+func setupShop(t *testing.T, s *query.Session) {
+	t.Helper()
+	exec(t, s, "CREATE TABLE customers (id INT PRIMARY KEY, name TEXT NOT NULL, credit INT, address TEXT)")
+	exec(t, s, "CREATE TABLE orders (id INT PRIMARY KEY, customer_id INT, total INT)")
+	exec(t, s, "CREATE TABLE items (order_id INT, line INT, sku TEXT, PRIMARY KEY (order_id, line))")
+	exec(t, s, "BEGIN")
+	for id := 1; id <= 310; id++ {
+		exec(t, s, "INSERT INTO customers VALUES (?, ?, ?, ?)",
+			toydb.IntValue(id), toydb.TextValue(fmt.Sprintf("c-%d", id)), toydb.IntValue(id*10), toydb.TextValue(strings.Repeat("x", 500)))
+	}
+	for id := 1; id <= 3000; id++ {
+		exec(t, s, "INSERT INTO orders VALUES (?, ?, ?)", toydb.IntValue(id), toydb.IntValue((id-1)%300+1), toydb.IntValue(id))
+		for line := 1; line <= 2; line++ {
+			exec(t, s, "INSERT INTO items VALUES (?, ?, ?)", toydb.IntValue(id), toydb.IntValue(line), toydb.TextValue(fmt.Sprintf("sku-%d-%d", id, line)))
+		}
+	}
+	exec(t, s, "COMMIT")
+}
+
+// joinOp returns the op of the outermost join step of a plan.
+func joinOp(p *query.Plan) string {
+	for ; p != nil; p = first(p.Inputs) {
+		if strings.HasSuffix(p.Op, "Join") {
+			return p.Op
+		}
+	}
+	return ""
+}
+
+func TestJoin(t *testing.T) {
+	t.Parallel()
+	s, _ := newTestSession(t)
+	setupShop(t, s)
+
+	tests := []struct {
+		src  string
+		op   string // of the last join
+		want []string
+	}{
+		// A few orders: look their customers up by primary key.
+		{
+			"SELECT o.id, c.name FROM orders AS o JOIN customers AS c ON c.id = o.customer_id WHERE o.id < 3",
+			"Nested Loop Join",
+			[]string{"1 c-1", "2 c-2"},
+		},
+		// Every order: read the customers once.
+		{
+			"SELECT o.id FROM orders AS o JOIN customers AS c ON o.customer_id = c.id WHERE c.credit = 70",
+			"Hash Join",
+			[]string{"7", "307", "607", "907", "1207", "1507", "1807", "2107", "2407", "2707"},
+		},
+		// Every order and its items, both in primary key order.
+		{
+			"SELECT o.id, i.sku FROM orders AS o JOIN items AS i ON i.order_id = o.id WHERE o.total > 2998",
+			"Merge Join",
+			[]string{"2999 sku-2999-1", "2999 sku-2999-2", "3000 sku-3000-1", "3000 sku-3000-2"},
+		},
+		// No equality to join on.
+		{
+			"SELECT c.id FROM orders AS o JOIN customers AS c ON c.credit < o.total WHERE o.id = 25",
+			"Nested Loop Join",
+			[]string{"1", "2"},
+		},
+		{
+			"SELECT c.id, o.id FROM customers AS c LEFT JOIN orders AS o ON o.customer_id = c.id AND o.id > 2990 WHERE c.id >= 298 AND c.id <= 302",
+			"Hash Left Join",
+			[]string{"298 2998", "299 2999", "300 3000", "301 null", "302 null"},
+		},
+		{
+			"SELECT o.id, c.id FROM orders AS o LEFT JOIN customers AS c ON c.id = o.customer_id AND c.credit > 20 WHERE o.id < 5",
+			"Nested Loop Left Join",
+			[]string{"1 null", "2 null", "3 3", "4 4"},
+		},
+		{
+			"SELECT c.id, o.total FROM customers AS c LEFT JOIN orders AS o ON o.id = c.id AND o.total < 3 LIMIT 3",
+			"Merge Left Join",
+			[]string{"1 1", "2 2", "3 null"},
+		},
+		// WHERE conditions on a LEFT JOIN's table apply after its nulls.
+		{
+			"SELECT c.id FROM customers AS c LEFT JOIN orders AS o ON o.customer_id = c.id WHERE o.id IS NULL",
+			"Hash Left Join",
+			[]string{"301", "302", "303", "304", "305", "306", "307", "308", "309", "310"},
+		},
+		{
+			"SELECT c.name, i.sku FROM orders AS o JOIN customers AS c ON c.id = o.customer_id JOIN items AS i ON i.order_id = o.id WHERE o.id = 301",
+			"Nested Loop Join",
+			[]string{"c-1 sku-301-1", "c-1 sku-301-2"},
+		},
+		{
+			"SELECT o.id, c.name FROM orders AS o JOIN customers AS c ON c.id = o.customer_id ORDER BY c.name DESC, o.id LIMIT 2",
+			"Hash Join",
+			[]string{"99 c-99", "399 c-99"},
+		},
+		{
+			"SELECT o.*, c.name FROM orders AS o JOIN customers AS c ON c.id = o.customer_id WHERE o.id = 5",
+			"Nested Loop Join",
+			[]string{"5 5 5 c-5"},
+		},
+		{
+			"SELECT * FROM items JOIN orders ON orders.id = items.order_id WHERE items.order_id = 5 AND line = 1",
+			"Nested Loop Join",
+			[]string{"5 1 sku-5-1 5 5 5"},
+		},
+		{
+			"SELECT name FROM orders AS o JOIN customers AS c ON c.id = customer_id WHERE o.id = 5",
+			"Nested Loop Join",
+			[]string{"c-5"},
+		},
+	}
+	for _, tt := range tests {
+		if got := exec(t, s, tt.src); !slices.Equal(got, tt.want) {
+			t.Errorf("%s\n got %q\nwant %q", tt.src, got, tt.want)
+		}
+		p, err := s.Explain(tt.src)
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		if op := joinOp(p); op != tt.op {
+			t.Errorf("%s: plan\n%s\nwant a %s", tt.src, p, tt.op)
+		}
+	}
+
+	// Every method joins every row.
+	for _, tt := range []struct {
+		src  string
+		want int
+	}{
+		{"SELECT o.id FROM orders AS o JOIN customers AS c ON c.id = o.customer_id", 3000},
+		{"SELECT o.id FROM orders AS o JOIN items AS i ON i.order_id = o.id", 6000},
+		{"SELECT c.id FROM customers AS c LEFT JOIN orders AS o ON o.customer_id = c.id", 3010},
+		{"SELECT c.id FROM customers AS c JOIN orders AS o ON o.id = c.id", 310},
+	} {
+		if got := exec(t, s, tt.src); len(got) != tt.want {
+			t.Errorf("%s: %d rows, want %d", tt.src, len(got), tt.want)
+		}
+	}
+}
+
+func TestExplainJoin(t *testing.T) {
+	t.Parallel()
+	s, _ := newTestSession(t)
+	setupShop(t, s)
+
+	p, err := s.Explain("SELECT o.id, c.name FROM orders AS o JOIN customers AS c ON c.id = o.customer_id WHERE o.id < 3 AND c.credit > 10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for line := range strings.Lines(p.String()) {
+		lines = append(lines, strings.TrimRight(line[:strings.Index(line, "  (")], " "))
+	}
+	want := []string{
+		"Nested Loop Join: (c.id = o.customer_id)",
+		"  Key Range: orders o (id < 3)",
+		"  Filter: (c.credit > 10)",
+		"    Key Lookup: customers c (id = o.customer_id)",
+	}
+	if !slices.Equal(lines, want) {
+		t.Errorf("plan\n%s\nwant\n%s", p, strings.Join(want, "\n"))
+	}
+}
+
+func TestJoinErrors(t *testing.T) {
+	t.Parallel()
+	s, _ := newTestSession(t)
+	setupShop(t, s)
+
+	for _, src := range []string{
+		"SELECT id FROM orders JOIN customers ON customers.id = customer_id",
+		"SELECT o.id FROM orders AS o JOIN customers AS c ON c.id = orders.customer_id",
+		"SELECT x.* FROM orders",
+	} {
+		if err := execErr(s, src); !errors.Is(err, query.ErrNoColumn) {
+			t.Errorf("%s: got %v, want ErrNoColumn", src, err)
+		}
+	}
+	for _, src := range []string{
+		"SELECT * FROM orders JOIN orders ON id = id",
+		"SELECT * FROM orders AS o JOIN customers AS c ON c.id = i.order_id JOIN items AS i ON i.line = 1",
+	} {
+		if err := execErr(s, src); err == nil {
+			t.Errorf("%s: no error", src)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SyntaxError reports a statement that does not parse. Offset is the byte
+// offset in the statement where the problem was found.
+type SyntaxError struct {
+	Offset int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at offset %d: %s", e.Offset, e.Msg)
+}
+
+type tokenKind uint8
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokKeyword
+	tokInt
+	tokFloat
+	tokString
+	tokBlob   // x'...'
+	tokParam  // ?
+	tokSymbol // punctuation and operators
+)
+
+type token struct {
+	kind tokenKind
+	// text is the keyword in upper case, the identifier or string with
+	// quotes removed, the hex digits of a blob, or the literal text of a
+	// number or symbol.
+	text   string
+	offset int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of statement"
+	case tokString:
+		return fmt.Sprintf("'%s'", t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// keywords are the reserved words; they cannot be used as unquoted
+// identifiers.
+var keywords = map[string]bool{
+	"AND": true, "AS": true, "ASC": true, "BEGIN": true, "BY": true,
+	"COMMIT": true, "CREATE": true, "CROSS": true, "DELETE": true,
+	"DESC": true, "DROP": true, "EXPLAIN": true, "FALSE": true, "FROM": true,
+	"FULL": true, "GROUP": true, "INDEX": true, "INNER": true, "INSERT": true, "INTO": true,
+	"IS": true, "JOIN": true, "KEY": true, "LEFT": true, "LIMIT": true,
+	"NOT": true, "NULL": true, "OFFSET": true, "ON": true, "OR": true,
+	"ORDER": true, "OUTER": true, "PRIMARY": true, "RIGHT": true,
+	"ROLLBACK": true, "SELECT": true, "SET": true, "TABLE": true,
+	"TRUE": true, "UPDATE": true, "VALUES": true, "WHERE": true,
+}
+
+// symbols are the multi-character symbols, longest first.
+var symbols = []string{"<=", ">=", "<>", "!=", "||"}
+
+// lex splits src into tokens, ending with a tokEOF.
+func lex(src string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for {
+		for i < len(src) {
+			r, size := utf8.DecodeRuneInString(src[i:])
+			if unicode.IsSpace(r) {
+				i += size
+				continue
+			}
+			if strings.HasPrefix(src[i:], "--") {
+				end := strings.IndexByte(src[i:], '\n')
+				if end < 0 {
+					i = len(src)
+				} else {
+					i += end
+				}
+				continue
+			}
+			break
+		}
+		if i == len(src) {
+			return append(tokens, token{kind: tokEOF, offset: i}), nil
+		}
+
+		start := i
+		c := src[i]
+		switch {
+		case (c == 'x' || c == 'X') && i+1 < len(src) && src[i+1] == '\'':
+			text, n, err := lexQuoted(src[i+1:], '\'', start)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokBlob, text: text, offset: start})
+			i += 1 + n
+		case isIdentStart(c):
+			for i < len(src) && isIdentPart(src[i]) {
+				i++
+			}
+			word := src[start:i]
+			if upper := strings.ToUpper(word); keywords[upper] {
+				tokens = append(tokens, token{kind: tokKeyword, text: upper, offset: start})
+			} else {
+				tokens = append(tokens, token{kind: tokIdent, text: word, offset: start})
+			}
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9':
+			kind := tokInt
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.') {
+				if src[i] == '.' {
+					if kind == tokFloat {
+						return nil, &SyntaxError{start, "malformed number"}
+					}
+					kind = tokFloat
+				}
+				i++
+			}
+			if i < len(src) && (src[i] == 'e' || src[i] == 'E') {
+				kind = tokFloat
+				i++
+				if i < len(src) && (src[i] == '+' || src[i] == '-') {
+					i++
+				}
+				digits := i
+				for i < len(src) && src[i] >= '0' && src[i] <= '9' {
+					i++
+				}
+				if i == digits {
+					return nil, &SyntaxError{start, "malformed number"}
+				}
+			}
+			if i < len(src) && isIdentPart(src[i]) {
+				return nil, &SyntaxError{start, "malformed number"}
+			}
+			tokens = append(tokens, token{kind: kind, text: src[start:i], offset: start})
+		case c == '\'':
+			text, n, err := lexQuoted(src[i:], '\'', start)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokString, text: text, offset: start})
+			i += n
+		case c == '"':
+			text, n, err := lexQuoted(src[i:], '"', start)
+			if err != nil {
+				return nil, err
+			}
+			if text == "" {
+				return nil, &SyntaxError{start, "empty quoted identifier"}
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: text, offset: start})
+			i += n
+		case c == '?':
+			tokens = append(tokens, token{kind: tokParam, text: "?", offset: start})
+			i++
+		default:
+			sym := ""
+			for _, s := range symbols {
+				if strings.HasPrefix(src[i:], s) {
+					sym = s
+					break
+				}
+			}
+			if sym == "" {
+				if !strings.ContainsRune("(),;*=<>+-/%.", rune(c)) {
+					r, _ := utf8.DecodeRuneInString(src[i:])
+					return nil, &SyntaxError{start, fmt.Sprintf("unexpected character %q", r)}
+				}
+				sym = src[i : i+1]
+			}
+			tokens = append(tokens, token{kind: tokSymbol, text: sym, offset: start})
+			i += len(sym)
+		}
+	}
+}
+
+// lexQuoted reads a literal quoted with q at the start of s, in which a
+// doubled quote stands for one. It returns the unquoted text and the
+// length of the literal.
+func lexQuoted(s string, q byte, offset int) (string, int, error) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		if s[i] != q {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == q {
+			b.WriteByte(q)
+			i++
+			continue
+		}
+		return b.String(), i + 1, nil
+	}
+	return "", 0, &SyntaxError{offset, "unterminated quoted literal"}
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9'
+}
@@ -0,0 +1,679 @@
+package query
+
+import (
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	toydb "github.com/guiwoch/toyDB"
+)
+
+// Parse parses a single statement, optionally ending with a semicolon.
+// It returns a *SyntaxError if src does not parse.
+func Parse(src string) (Stmt, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	stmt, err := p.statement()
+	if err != nil {
+		return nil, err
+	}
+	p.acceptSymbol(";")
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t, "unexpected %s after statement", t)
+	}
+	return stmt, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	params int // placeholders seen so far
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) errorf(t token, format string, args ...any) error {
+	return &SyntaxError{Offset: t.offset, Msg: fmt.Sprintf(format, args...)}
+}
+
+// acceptKeyword consumes the next token if it is one of the keywords, and
+// reports which.
+func (p *parser) acceptKeyword(words ...string) (string, bool) {
+	t := p.peek()
+	if t.kind == tokKeyword && slices.Contains(words, t.text) {
+		p.pos++
+		return t.text, true
+	}
+	return "", false
+}
+
+func (p *parser) expectKeyword(word string) error {
+	if _, ok := p.acceptKeyword(word); !ok {
+		t := p.peek()
+		return p.errorf(t, "expected %s, found %s", word, t)
+	}
+	return nil
+}
+
+func (p *parser) acceptSymbol(sym string) bool {
+	t := p.peek()
+	if t.kind == tokSymbol && t.text == sym {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectSymbol(sym string) error {
+	if !p.acceptSymbol(sym) {
+		t := p.peek()
+		return p.errorf(t, "expected %q, found %s", sym, t)
+	}
+	return nil
+}
+
+func (p *parser) ident() (string, error) {
+	t := p.next()
+	if t.kind != tokIdent {
+		return "", p.errorf(t, "expected a name, found %s", t)
+	}
+	return t.text, nil
+}
+
+// identList parses a parenthesized, comma-separated list of names.
+func (p *parser) identList() ([]string, error) {
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	var names []string
+	for {
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	return names, p.expectSymbol(")")
+}
+
+func (p *parser) statement() (Stmt, error) {
+	t := p.next()
+	if t.kind != tokKeyword {
+		return nil, p.errorf(t, "expected a statement, found %s", t)
+	}
+	switch t.text {
+	case "CREATE":
+		if _, ok := p.acceptKeyword("INDEX"); ok {
+			return p.createIndex()
+		}
+		return p.createTable()
+	case "DROP":
+		if err := p.expectKeyword("TABLE"); err != nil {
+			return nil, err
+		}
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		return &DropTable{Name: name}, nil
+	case "INSERT":
+		return p.insert()
+	case "SELECT":
+		return p.selectStmt()
+	case "UPDATE":
+		return p.update()
+	case "DELETE":
+		return p.delete()
+	case "EXPLAIN":
+		t := p.peek()
+		stmt, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		switch stmt.(type) {
+		case *Select, *Insert, *Update, *Delete:
+			return &Explain{Stmt: stmt}, nil
+		}
+		return nil, p.errorf(t, "cannot explain %s", t)
+	case "BEGIN":
+		return &Begin{}, nil
+	case "COMMIT":
+		return &Commit{}, nil
+	case "ROLLBACK":
+		return &Rollback{}, nil
+	}
+	return nil, p.errorf(t, "expected a statement, found %s", t)
+}
+
+// typeNames maps the accepted column type names to column types.
+var typeNames = map[string]toydb.ColType{
+	"INT": toydb.TypeInt, "INTEGER": toydb.TypeInt, "BIGINT": toydb.TypeInt, "SMALLINT": toydb.TypeInt,
+	"TEXT": toydb.TypeText, "VARCHAR": toydb.TypeText, "CHAR": toydb.TypeText, "STRING": toydb.TypeText,
+	"BOOL": toydb.TypeBool, "BOOLEAN": toydb.TypeBool,
+	"TIMESTAMP": toydb.TypeTimestamp, "DATETIME": toydb.TypeTimestamp,
+	"FLOAT": toydb.TypeFloat, "REAL": toydb.TypeFloat, "DOUBLE": toydb.TypeFloat,
+	"BYTES": toydb.TypeBytes, "BLOB": toydb.TypeBytes, "BYTEA": toydb.TypeBytes,
+	"DECIMAL": toydb.TypeDecimal, "NUMERIC": toydb.TypeDecimal,
+	"UUID": toydb.TypeUUID,
+	"JSON": toydb.TypeJSON,
+}
+
+func (p *parser) createIndex() (Stmt, error) {
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("ON"); err != nil {
+		return nil, err
+	}
+	table, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	columns, err := p.identList()
+	if err != nil {
+		return nil, err
+	}
+	return &CreateIndex{Name: name, Table: table, Columns: columns}, nil
+}
+
+func (p *parser) createTable() (Stmt, error) {
+	if err := p.expectKeyword("TABLE"); err != nil {
+		return nil, err
+	}
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	stmt := &CreateTable{Name: name}
+	for {
+		t := p.peek()
+		if _, ok := p.acceptKeyword("PRIMARY"); ok {
+			if err := p.expectKeyword("KEY"); err != nil {
+				return nil, err
+			}
+			if stmt.PrimaryKey != nil {
+				return nil, p.errorf(t, "primary key declared more than once")
+			}
+			if stmt.PrimaryKey, err = p.identList(); err != nil {
+				return nil, err
+			}
+		} else {
+			col, err := p.columnDef(stmt)
+			if err != nil {
+				return nil, err
+			}
+			stmt.Columns = append(stmt.Columns, col)
+		}
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	if stmt.PrimaryKey == nil {
+		return nil, p.errorf(p.peek(), "table %s has no primary key", quoteIdent(name))
+	}
+	return stmt, nil
+}
+
+func (p *parser) columnDef(stmt *CreateTable) (ColumnDef, error) {
+	name, err := p.ident()
+	if err != nil {
+		return ColumnDef{}, err
+	}
+	t := p.next()
+	typ, ok := typeNames[strings.ToUpper(t.text)]
+	if t.kind != tokIdent || !ok {
+		return ColumnDef{}, p.errorf(t, "expected a column type, found %s", t)
+	}
+	if strings.EqualFold(t.text, "DOUBLE") {
+		if next := p.peek(); next.kind == tokIdent && strings.EqualFold(next.text, "PRECISION") {
+			p.pos++
+		}
+	}
+	if typ == toydb.TypeText && p.acceptSymbol("(") {
+		// A length limit is accepted but not enforced.
+		if t := p.next(); t.kind != tokInt {
+			return ColumnDef{}, p.errorf(t, "expected a length, found %s", t)
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return ColumnDef{}, err
+		}
+	}
+	col := ColumnDef{Name: name, Type: typ}
+	for {
+		t := p.peek()
+		if _, ok := p.acceptKeyword("NOT"); ok {
+			if err := p.expectKeyword("NULL"); err != nil {
+				return ColumnDef{}, err
+			}
+			col.NotNull = true
+		} else if _, ok := p.acceptKeyword("NULL"); ok {
+		} else if _, ok := p.acceptKeyword("PRIMARY"); ok {
+			if err := p.expectKeyword("KEY"); err != nil {
+				return ColumnDef{}, err
+			}
+			if stmt.PrimaryKey != nil {
+				return ColumnDef{}, p.errorf(t, "primary key declared more than once")
+			}
+			stmt.PrimaryKey = []string{name}
+		} else {
+			return col, nil
+		}
+	}
+}
+
+func (p *parser) insert() (Stmt, error) {
+	if err := p.expectKeyword("INTO"); err != nil {
+		return nil, err
+	}
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	stmt := &Insert{Table: name}
+	if t := p.peek(); t.kind == tokSymbol && t.text == "(" {
+		if stmt.Columns, err = p.identList(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expectKeyword("VALUES"); err != nil {
+		return nil, err
+	}
+	for {
+		if err := p.expectSymbol("("); err != nil {
+			return nil, err
+		}
+		row, err := p.exprList()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+		stmt.Rows = append(stmt.Rows, row)
+		if !p.acceptSymbol(",") {
+			return stmt, nil
+		}
+	}
+}
+
+func (p *parser) exprList() ([]Expr, error) {
+	var exprs []Expr
+	for {
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, e)
+		if !p.acceptSymbol(",") {
+			return exprs, nil
+		}
+	}
+}
+
+func (p *parser) selectStmt() (Stmt, error) {
+	stmt := &Select{}
+	for {
+		if p.acceptSymbol("*") {
+			stmt.Items = append(stmt.Items, SelectItem{Star: true})
+		} else if table, ok := p.tableStar(); ok {
+			stmt.Items = append(stmt.Items, SelectItem{Star: true, Table: table})
+		} else {
+			e, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			item := SelectItem{Expr: e}
+			if _, ok := p.acceptKeyword("AS"); ok {
+				if item.Alias, err = p.ident(); err != nil {
+					return nil, err
+				}
+			} else if t := p.peek(); t.kind == tokIdent {
+				item.Alias = p.next().text
+			}
+			stmt.Items = append(stmt.Items, item)
+		}
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	var err error
+	if stmt.From, stmt.Alias, err = p.tableRef(); err != nil {
+		return nil, err
+	}
+	for {
+		var join Join
+		if _, ok := p.acceptKeyword("LEFT"); ok {
+			p.acceptKeyword("OUTER")
+			join.Left = true
+			if err := p.expectKeyword("JOIN"); err != nil {
+				return nil, err
+			}
+		} else if _, ok := p.acceptKeyword("INNER"); ok {
+			if err := p.expectKeyword("JOIN"); err != nil {
+				return nil, err
+			}
+		} else if _, ok := p.acceptKeyword("JOIN"); !ok {
+			break
+		}
+		if join.Table, join.Alias, err = p.tableRef(); err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("ON"); err != nil {
+			return nil, err
+		}
+		if join.On, err = p.expr(); err != nil {
+			return nil, err
+		}
+		stmt.Joins = append(stmt.Joins, join)
+	}
+	if stmt.Where, err = p.where(); err != nil {
+		return nil, err
+	}
+	if _, ok := p.acceptKeyword("GROUP"); ok {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		if stmt.GroupBy, err = p.exprList(); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := p.acceptKeyword("ORDER"); ok {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			e, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			dir, _ := p.acceptKeyword("ASC", "DESC")
+			stmt.OrderBy = append(stmt.OrderBy, OrderItem{Expr: e, Desc: dir == "DESC"})
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
+	if _, ok := p.acceptKeyword("LIMIT"); ok {
+		if stmt.Limit, err = p.expr(); err != nil {
+			return nil, err
+		}
+		if _, ok := p.acceptKeyword("OFFSET"); ok {
+			if stmt.Offset, err = p.expr(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return stmt, nil
+}
+
+// tableStar consumes a name followed by .* and returns the name, if the
+// next tokens are one.
+func (p *parser) tableStar() (string, bool) {
+	if p.pos+2 >= len(p.tokens) {
+		return "", false
+	}
+	t, dot, star := p.tokens[p.pos], p.tokens[p.pos+1], p.tokens[p.pos+2]
+	if t.kind != tokIdent || dot.kind != tokSymbol || dot.text != "." || star.kind != tokSymbol || star.text != "*" {
+		return "", false
+	}
+	p.pos += 3
+	return t.text, true
+}
+
+// tableRef parses a table name with an optional AS alias.
+func (p *parser) tableRef() (name, alias string, err error) {
+	if name, err = p.ident(); err != nil {
+		return "", "", err
+	}
+	if _, ok := p.acceptKeyword("AS"); ok {
+		alias, err = p.ident()
+	}
+	return name, alias, err
+}
+
+func (p *parser) where() (Expr, error) {
+	if _, ok := p.acceptKeyword("WHERE"); !ok {
+		return nil, nil
+	}
+	return p.expr()
+}
+
+func (p *parser) update() (Stmt, error) {
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("SET"); err != nil {
+		return nil, err
+	}
+	stmt := &Update{Table: name}
+	for {
+		col, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol("="); err != nil {
+			return nil, err
+		}
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Set = append(stmt.Set, Assignment{Column: col, Value: e})
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	if stmt.Where, err = p.where(); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+func (p *parser) delete() (Stmt, error) {
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	stmt := &Delete{Table: name}
+	if stmt.Where, err = p.where(); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+// Expressions, loosest binding first: OR; AND; NOT; comparisons and IS
+// NULL; + - and ||; * / %; unary minus.
+
+func (p *parser) expr() (Expr, error) {
+	return p.binary(0)
+}
+
+// binaryLevels lists the binary operators by precedence, loosest first.
+// The level after the comparisons is where NOT is parsed.
+var binaryLevels = [][]string{
+	{"OR"},
+	{"AND"},
+	nil, // NOT
+	{"=", "<>", "!=", "<", "<=", ">", ">="},
+	{"+", "-", "||"},
+	{"*", "/", "%"},
+}
+
+func (p *parser) binary(level int) (Expr, error) {
+	if level == len(binaryLevels) {
+		return p.unary()
+	}
+	if binaryLevels[level] == nil {
+		if _, ok := p.acceptKeyword("NOT"); ok {
+			x, err := p.binary(level)
+			if err != nil {
+				return nil, err
+			}
+			return &Unary{Op: "NOT", X: x}, nil
+		}
+		return p.binary(level + 1)
+	}
+	l, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		op := t.text
+		if t.kind != tokSymbol && t.kind != tokKeyword || !slices.Contains(binaryLevels[level], op) {
+			if level == 3 {
+				return p.isNull(l)
+			}
+			return l, nil
+		}
+		p.pos++
+		if op == "!=" {
+			op = "<>"
+		}
+		r, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		l = &Binary{Op: op, L: l, R: r}
+	}
+}
+
+// isNull parses an optional IS [NOT] NULL after x.
+func (p *parser) isNull(x Expr) (Expr, error) {
+	if _, ok := p.acceptKeyword("IS"); !ok {
+		return x, nil
+	}
+	_, not := p.acceptKeyword("NOT")
+	if err := p.expectKeyword("NULL"); err != nil {
+		return nil, err
+	}
+	return &IsNull{X: x, Not: not}, nil
+}
+
+func (p *parser) unary() (Expr, error) {
+	if p.acceptSymbol("-") {
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		// Fold negative literals into constants.
+		if lit, ok := x.(*Literal); ok {
+			switch v := lit.Value.(type) {
+			case toydb.IntValue:
+				return &Literal{Value: -v}, nil
+			case toydb.FloatValue:
+				return &Literal{Value: -v}, nil
+			}
+		}
+		return &Unary{Op: "-", X: x}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (Expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokInt:
+		n, err := strconv.ParseInt(t.text, 10, 64)
+		if err != nil {
+			return nil, p.errorf(t, "integer %s out of range", t.text)
+		}
+		return &Literal{Value: toydb.IntValue(n)}, nil
+	case tokFloat:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, p.errorf(t, "malformed number %s", t.text)
+		}
+		return &Literal{Value: toydb.FloatValue(f)}, nil
+	case tokString:
+		return &Literal{Value: toydb.TextValue(t.text)}, nil
+	case tokBlob:
+		b, err := hex.DecodeString(t.text)
+		if err != nil {
+			return nil, p.errorf(t, "malformed hex literal")
+		}
+		return &Literal{Value: toydb.BytesValue(b)}, nil
+	case tokParam:
+		p.params++
+		return &Param{Index: p.params - 1}, nil
+	case tokIdent:
+		if p.acceptSymbol("(") {
+			return p.aggregate(t)
+		}
+		if !p.acceptSymbol(".") {
+			return &ColumnRef{Name: t.text}, nil
+		}
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		return &ColumnRef{Table: t.text, Name: name}, nil
+	case tokKeyword:
+		switch t.text {
+		case "NULL":
+			return &Literal{Value: toydb.Null}, nil
+		case "TRUE":
+			return &Literal{Value: toydb.BoolValue(true)}, nil
+		case "FALSE":
+			return &Literal{Value: toydb.BoolValue(false)}, nil
+		}
+	case tokSymbol:
+		if t.text == "(" {
+			e, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			return e, p.expectSymbol(")")
+		}
+	}
+	return nil, p.errorf(t, "expected an expression, found %s", t)
+}
+
+// aggregates are the aggregate functions.
+var aggregates = map[string]bool{"COUNT": true, "SUM": true, "MIN": true, "MAX": true, "AVG": true}
+
+// aggregate parses the argument of an aggregate function, named by t, after
+// its opening parenthesis. Only COUNT takes *.
+func (p *parser) aggregate(t token) (Expr, error) {
+	e := &Aggregate{Func: strings.ToUpper(t.text)}
+	if !aggregates[e.Func] {
+		return nil, p.errorf(t, "unknown function %s", t.text)
+	}
+	if e.Func != "COUNT" || !p.acceptSymbol("*") {
+		var err error
+		if e.Arg, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	return e, p.expectSymbol(")")
+}
@@ -0,0 +1,195 @@
+package query_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	toydb "github.com/guiwoch/toyDB"
+	"github.com/guiwoch/toyDB/query"
+)
+
+func TestParseExpr(t *testing.T) {
+	t.Parallel()
+	// Each condition is parsed as a WHERE clause and printed back, which
+	// shows how it was grouped.
+	tests := []struct {
+		src, want string
+	}{
+		{"a = 1 AND b = 2 OR c = 3", "(((a = 1) AND (b = 2)) OR (c = 3))"},
+		{"a = 1 AND (b = 2 OR c = 3)", "((a = 1) AND ((b = 2) OR (c = 3)))"},
+		{"NOT a = 1 AND b", "(NOT (a = 1) AND b)"},
+		{"a + b * c - d / 2 % 3", "((a + (b * c)) - ((d / 2) % 3))"},
+		{"a || 'x' <> 'it''s'", "((a || 'x') <> 'it''s')"},
+		{"a != -1.5", "(a <> -1.5)"},
+		{"-a < - -2", "(-a < 2)"},
+		{"a IS NOT NULL AND b IS NULL", "(a IS NOT NULL AND b IS NULL)"},
+		{`"select" >= ? AND "we""ird" <= ?`, `(("select" >= ?) AND ("we""ird" <= ?))`},
+		{"b = x'00ff' OR c = TRUE OR d = NULL", "(((b = x'00ff') OR (c = TRUE)) OR (d = NULL))"},
+		{"a = 1e3 -- trailing comment", "(a = 1000)"},
+	}
+	for _, tt := range tests {
+		stmt, err := query.Parse("DELETE FROM t WHERE " + tt.src)
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		got := stmt.(*query.Delete).Where.String()
+		if got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestParseStatements(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		src  string
+		want query.Stmt
+	}{
+		{
+			"create table t (id int primary key, name varchar(20) not null, at timestamp null);",
+			&query.CreateTable{
+				Name: "t",
+				Columns: []query.ColumnDef{
+					{Name: "id", Type: toydb.TypeInt},
+					{Name: "name", Type: toydb.TypeText, NotNull: true},
+					{Name: "at", Type: toydb.TypeTimestamp},
+				},
+				PrimaryKey: []string{"id"},
+			},
+		},
+		{
+			"CREATE TABLE t (a TEXT, b DOUBLE PRECISION, PRIMARY KEY (b, a))",
+			&query.CreateTable{
+				Name:       "t",
+				Columns:    []query.ColumnDef{{Name: "a", Type: toydb.TypeText}, {Name: "b", Type: toydb.TypeFloat}},
+				PrimaryKey: []string{"b", "a"},
+			},
+		},
+		{
+			"INSERT INTO t (a, b) VALUES (1, ?), (?, 'x')",
+			&query.Insert{
+				Table:   "t",
+				Columns: []string{"a", "b"},
+				Rows: [][]query.Expr{
+					{&query.Literal{Value: toydb.IntValue(1)}, &query.Param{Index: 0}},
+					{&query.Param{Index: 1}, &query.Literal{Value: toydb.TextValue("x")}},
+				},
+			},
+		},
+		{
+			"SELECT *, a AS x, b y FROM t WHERE a > 1 ORDER BY b DESC, a LIMIT 10 OFFSET 5",
+			&query.Select{
+				Items: []query.SelectItem{
+					{Star: true},
+					{Expr: &query.ColumnRef{Name: "a"}, Alias: "x"},
+					{Expr: &query.ColumnRef{Name: "b"}, Alias: "y"},
+				},
+				From:    "t",
+				Where:   &query.Binary{Op: ">", L: &query.ColumnRef{Name: "a"}, R: &query.Literal{Value: toydb.IntValue(1)}},
+				OrderBy: []query.OrderItem{{Expr: &query.ColumnRef{Name: "b"}, Desc: true}, {Expr: &query.ColumnRef{Name: "a"}}},
+				Limit:   &query.Literal{Value: toydb.IntValue(10)},
+				Offset:  &query.Literal{Value: toydb.IntValue(5)},
+			},
+		},
+		{
+			"SELECT o.*, c.name FROM orders AS o JOIN customers AS c ON c.id = o.customer LEFT OUTER JOIN notes AS n ON n.order_id = o.id",
+			&query.Select{
+				Items: []query.SelectItem{
+					{Star: true, Table: "o"},
+					{Expr: &query.ColumnRef{Table: "c", Name: "name"}},
+				},
+				From:  "orders",
+				Alias: "o",
+				Joins: []query.Join{
+					{Table: "customers", Alias: "c", On: &query.Binary{Op: "=", L: &query.ColumnRef{Table: "c", Name: "id"}, R: &query.ColumnRef{Table: "o", Name: "customer"}}},
+					{Left: true, Table: "notes", Alias: "n", On: &query.Binary{Op: "=", L: &query.ColumnRef{Table: "n", Name: "order_id"}, R: &query.ColumnRef{Table: "o", Name: "id"}}},
+				},
+			},
+		},
+		{
+			"SELECT a, count(*), SUM(b + 1) FROM t WHERE b > 0 GROUP BY a ORDER BY MAX(b)",
+			&query.Select{
+				Items: []query.SelectItem{
+					{Expr: &query.ColumnRef{Name: "a"}},
+					{Expr: &query.Aggregate{Func: "COUNT"}},
+					{Expr: &query.Aggregate{Func: "SUM", Arg: &query.Binary{Op: "+", L: &query.ColumnRef{Name: "b"}, R: &query.Literal{Value: toydb.IntValue(1)}}}},
+				},
+				From:    "t",
+				Where:   &query.Binary{Op: ">", L: &query.ColumnRef{Name: "b"}, R: &query.Literal{Value: toydb.IntValue(0)}},
+				GroupBy: []query.Expr{&query.ColumnRef{Name: "a"}},
+				OrderBy: []query.OrderItem{{Expr: &query.Aggregate{Func: "MAX", Arg: &query.ColumnRef{Name: "b"}}}},
+			},
+		},
+		{
+			"UPDATE t SET a = a + 1, b = NULL",
+			&query.Update{
+				Table: "t",
+				Set: []query.Assignment{
+					{Column: "a", Value: &query.Binary{Op: "+", L: &query.ColumnRef{Name: "a"}, R: &query.Literal{Value: toydb.IntValue(1)}}},
+					{Column: "b", Value: &query.Literal{Value: toydb.Null}},
+				},
+			},
+		},
+		{"CREATE INDEX by_name ON t (name, id)", &query.CreateIndex{Name: "by_name", Table: "t", Columns: []string{"name", "id"}}},
+		{"DELETE FROM t", &query.Delete{Table: "t"}},
+		{"EXPLAIN DELETE FROM t", &query.Explain{Stmt: &query.Delete{Table: "t"}}},
+		{"DROP TABLE t", &query.DropTable{Name: "t"}},
+		{"begin", &query.Begin{}},
+		{"COMMIT;", &query.Commit{}},
+		{"rollback", &query.Rollback{}},
+	}
+	for _, tt := range tests {
+		got, err := query.Parse(tt.src)
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s:\n got %#v\nwant %#v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		src    string
+		offset int
+	}{
+		{"SELEC * FROM t", 0},
+		{"SELECT * FROM", 13},
+		{"SELECT * FROM t WHERE", 21},
+		{"SELECT * FROM t WHERE a = 'open", 26},
+		{"SELECT * FROM t garbage", 16},
+		{"SELECT a FROM t; SELECT b FROM t", 17},
+		{"CREATE TABLE t (a INT)", 22},
+		{"CREATE TABLE t (a WIDGET PRIMARY KEY)", 18},
+		{"CREATE TABLE t (a INT PRIMARY KEY, PRIMARY KEY (a))", 35},
+		{"INSERT INTO t VALUES (1, 2", 26},
+		{"SELECT 99999999999999999999 FROM t", 7},
+		{"SELECT 1.2.3 FROM t", 7},
+		{"SELECT a FROM t WHERE a = #", 26},
+		{"SELECT select FROM t", 7},
+		{"CREATE INDEX i t (a)", 15},
+		{"EXPLAIN BEGIN", 8},
+		{"SELECT * FROM a JOIN b", 22},
+		{"SELECT * FROM a LEFT b ON x", 21},
+		{"SELECT NOW() FROM t", 7},
+		{"SELECT SUM(*) FROM t", 11},
+		{"SELECT COUNT(a FROM t", 15},
+		{"SELECT a FROM t GROUP a", 22},
+	}
+	for _, tt := range tests {
+		_, err := query.Parse(tt.src)
+		var syntaxErr *query.SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("%s: got %v, want a syntax error", tt.src, err)
+			continue
+		}
+		if syntaxErr.Offset != tt.offset {
+			t.Errorf("%s: error at offset %d, want %d: %v", tt.src, syntaxErr.Offset, tt.offset, err)
+		}
+	}
+}
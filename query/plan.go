@@ -0,0 +1,449 @@
+package query
+
+import (
+	"errors"
+	"iter"
+	"math"
+	"slices"
+
+	toydb "github.com/guiwoch/toyDB"
+)
+
+// keyAccess is how a statement reaches the rows its WHERE clause can
+// match, found from the conditions on the columns of the primary key or of
+// a secondary index: equality on the leading columns, then a range on the
+// next one. Rows are still filtered by the whole WHERE clause; the access
+// only narrows the part of the table that is read.
+type keyAccess struct {
+	index  string        // name of the secondary index, or "" for the primary key
+	key    []int         // schema positions of the key's columns
+	eq     []toydb.Value // values of the leading key columns
+	lo, hi *keyBound     // range on the key column after eq
+	// residual counts the WHERE conjuncts not turned into eq, lo and hi.
+	// When there are none, every row in the range matches.
+	residual int
+}
+
+type keyBound struct {
+	v         toydb.Value
+	inclusive bool
+}
+
+// keyCondition is a WHERE conjunct of the form column op constant.
+type keyCondition struct {
+	column int // schema position
+	op     string
+	value  toydb.Value
+}
+
+// flipped gives the comparison that means the same with its operands
+// swapped.
+var flipped = map[string]string{"=": "=", "<": ">", "<=": ">=", ">": "<", ">=": "<="}
+
+// conjuncts splits a condition on its top-level ANDs.
+func conjuncts(e Expr) []Expr {
+	if e == nil {
+		return nil
+	}
+	if b, ok := e.(*Binary); ok && b.Op == "AND" {
+		return append(conjuncts(b.L), conjuncts(b.R)...)
+	}
+	return []Expr{e}
+}
+
+// keyConditions returns, for each conjunct of where, its keyCondition or
+// nil.
+func keyConditions(where Expr, s *toydb.Schema, sc *scope, args []toydb.Value) ([]*keyCondition, error) {
+	conds := conjuncts(where)
+	keyConds := make([]*keyCondition, len(conds))
+	for i, c := range conds {
+		kc, err := asKeyCondition(c, s, sc, args)
+		if err != nil {
+			return nil, err
+		}
+		keyConds[i] = kc
+	}
+	return keyConds, nil
+}
+
+// keyColumns returns the schema positions of the primary key columns.
+func keyColumns(s *toydb.Schema, sc *scope) []int {
+	var key []int
+	for _, name := range s.PrimaryKeyColumns() {
+		i, _ := sc.lookup(name)
+		key = append(key, i)
+	}
+	return key
+}
+
+// matchKey finds the access through the named index, or the primary key,
+// whose columns are key, from the key conditions of a WHERE clause.
+func matchKey(index string, key []int, keyConds []*keyCondition) *keyAccess {
+	a := &keyAccess{index: index, key: key}
+
+	// Equalities extend the key prefix column by column; the first key
+	// column without one takes the range conditions on it.
+	used := make([]bool, len(keyConds))
+	for _, col := range a.key {
+		eq := -1
+		for i, kc := range keyConds {
+			if kc != nil && kc.column == col && kc.op == "=" {
+				eq = i
+				break
+			}
+		}
+		if eq >= 0 {
+			a.eq = append(a.eq, keyConds[eq].value)
+			used[eq] = true
+			continue
+		}
+		for i, kc := range keyConds {
+			if kc == nil || kc.column != col {
+				continue
+			}
+			// Keep the tighter of two bounds on the same side. The values
+			// were coerced to the column type, so they compare.
+			b := &keyBound{kc.value, kc.op == ">=" || kc.op == "<="}
+			switch kc.op {
+			case ">", ">=":
+				if a.lo == nil || tighter(b, a.lo, 1) {
+					a.lo = b
+				}
+			case "<", "<=":
+				if a.hi == nil || tighter(b, a.hi, -1) {
+					a.hi = b
+				}
+			}
+			used[i] = true
+		}
+		break
+	}
+
+	for _, u := range used {
+		if !u {
+			a.residual++
+		}
+	}
+	return a
+}
+
+// tighter reports whether bound b excludes more than old, where dir is 1
+// for lower bounds and -1 for upper ones.
+func tighter(b, old *keyBound, dir int) bool {
+	c, err := compareValues(b.v, old.v)
+	if err != nil {
+		return false
+	}
+	return c*dir > 0 || c == 0 && !b.inclusive
+}
+
+// asKeyCondition reports c as a keyCondition if it compares a column with
+// a constant, or returns nil. The constant is converted to the column's
+// type; a constant that is null or does not convert gives no key condition
+// and is left to the row filter.
+func asKeyCondition(c Expr, s *toydb.Schema, sc *scope, args []toydb.Value) (*keyCondition, error) {
+	b, ok := c.(*Binary)
+	if !ok || flipped[b.Op] == "" {
+		return nil, nil
+	}
+	ref, ok := b.L.(*ColumnRef)
+	value, op := b.R, b.Op
+	if !ok {
+		ref, ok = b.R.(*ColumnRef)
+		value, op = b.L, flipped[b.Op]
+	}
+	if !ok || !isConstant(value) {
+		return nil, nil
+	}
+	col, err := sc.resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+	v, err := constant(value, args)
+	if err != nil {
+		return nil, err
+	}
+	if v == toydb.Null {
+		return nil, nil
+	}
+	v, err = coerce(v, s.Columns()[col].Type)
+	if err != nil {
+		return nil, nil
+	}
+	return &keyCondition{col, op, v}, nil
+}
+
+// point reports whether the access names a single row by its full primary
+// key.
+func (a *keyAccess) point() bool {
+	return a.index == "" && len(a.eq) == len(a.key)
+}
+
+// exact reports whether every row in the access's range matches the WHERE
+// clause.
+func (a *keyAccess) exact() bool {
+	return a.residual == 0
+}
+
+// bound returns the key holding the equality values followed by b's value,
+// or the equality values alone if b is nil, or nil if both are missing.
+func (a *keyAccess) bound(b *keyBound) toydb.Value {
+	values := a.values(b)
+	if values == nil {
+		return nil
+	}
+	return toydb.Key(values)
+}
+
+// values is bound for an index, whose bounds are lists of values.
+func (a *keyAccess) values(b *keyBound) []toydb.Value {
+	if b == nil {
+		if len(a.eq) == 0 {
+			return nil
+		}
+		return a.eq
+	}
+	return append(slices.Clone(a.eq), b.v)
+}
+
+// rows returns the rows of t in the access's range, in key order or, if
+// desc is set, descending. Only the primary key can be read descending.
+func (a *keyAccess) rows(t *toydb.Table, desc bool) iter.Seq2[toydb.Row, error] {
+	if a.point() {
+		return func(yield func(toydb.Row, error) bool) {
+			row, err := t.Get(toydb.Key(a.eq))
+			if errors.Is(err, toydb.ErrNotFound) {
+				return
+			}
+			yield(row, err)
+		}
+	}
+	if a.index != "" && a.lo == nil && a.hi == nil {
+		return t.Lookup(a.index, a.eq...)
+	}
+
+	// The scan starts at the bound on the side it starts from and stops at
+	// the first row past the other, which past finds. Bounds that the scan
+	// cannot express exactly are widened; the WHERE filter removes the
+	// extra rows.
+	var scan iter.Seq2[toydb.Row, error]
+	switch {
+	case a.index != "":
+		var hi []toydb.Value
+		if a.hi != nil && !a.hi.inclusive {
+			hi = a.values(a.hi)
+		}
+		scan = t.ScanIndex(a.index, a.values(a.lo), hi)
+	case desc:
+		var lo toydb.Value
+		if a.lo != nil && !a.lo.inclusive {
+			lo = a.bound(a.lo)
+		}
+		scan = t.ScanDescending(lo, a.bound(a.hi))
+	default:
+		var hi toydb.Value
+		if a.hi != nil && !a.hi.inclusive {
+			hi = a.bound(a.hi)
+		}
+		scan = t.Scan(a.bound(a.lo), hi)
+	}
+	return func(yield func(toydb.Row, error) bool) {
+		for row, err := range scan {
+			if err == nil && a.past(row, desc) {
+				return
+			}
+			if !yield(row, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// past reports whether row, met in a scan in the given direction, is beyond
+// the access's range, so that every later row is too. Index columns may
+// hold nulls, which an index sorts first.
+func (a *keyAccess) past(row toydb.Row, desc bool) bool {
+	for i, v := range a.eq {
+		if c, _ := sortCompare(row[a.key[i]], v); c != 0 {
+			return true
+		}
+	}
+	end, dir := a.hi, 1
+	if desc {
+		end, dir = a.lo, -1
+	}
+	if end == nil {
+		return false
+	}
+	c, _ := sortCompare(row[a.key[len(a.eq)]], end.v)
+	return c*dir > 0 || c == 0 && !end.inclusive
+}
+
+// deleteBounds returns the bounds to pass to DeleteRange to delete exactly
+// the rows the access covers, if there are such bounds.
+func (a *keyAccess) deleteBounds() (lo, hi toydb.Value, ok bool) {
+	if a.index != "" || !a.exact() || a.point() ||
+		a.lo != nil && !a.lo.inclusive || a.hi != nil && a.hi.inclusive ||
+		len(a.eq) > 0 && a.hi == nil {
+		return nil, nil, false
+	}
+	return a.bound(a.lo), a.bound(a.hi), true
+}
+
+// residualSelectivity is the share of rows assumed to pass each WHERE
+// conjunct that the access leaves to the row filter. Without statistics on
+// column values, it is a guess.
+const residualSelectivity = 1.0 / 3
+
+// path is a way to read the rows a statement can match: an access, the
+// direction to read it in and, once estimated, what reading it costs.
+type path struct {
+	access  *keyAccess
+	desc    bool // read the primary key descending
+	inOrder bool // the rows come in the ORDER BY order
+
+	// rows estimates the rows read from the access and pages the pages
+	// read to find them. cost is pages cut short by a LIMIT, when the
+	// rows come in order and reading can stop early.
+	rows, pages, cost float64
+}
+
+// filtered estimates the rows of the path that pass the WHERE clause.
+func (p *path) filtered() float64 {
+	return p.rows * math.Pow(residualSelectivity, float64(p.access.residual))
+}
+
+// choose picks the cheapest path to the rows of t that where can match,
+// read in the given order, among the primary key and the table's indexes.
+// want is the number of rows the statement needs in that order, or -1 for
+// all of them. Paths are compared by the pages they read, estimated from
+// the table's statistics; with estimate unset, they are only estimated when
+// there is a choice to make.
+func choose(t *toydb.Table, where Expr, sc *scope, args []toydb.Value, order []OrderItem, want int, estimate bool) (*path, error) {
+	s := t.Schema()
+	keyConds, err := keyConditions(where, s, sc, args)
+	if err != nil {
+		return nil, err
+	}
+	if !estimate {
+		p := newPath(matchKey("", keyColumns(s, sc), keyConds), order, sc)
+		if p.access.point() || len(t.Indexes()) == 0 {
+			return p, nil
+		}
+	}
+	stats, err := t.Stats()
+	if err != nil {
+		return nil, err
+	}
+	return chooseWith(t, stats, keyConds, sc, order, want)
+}
+
+// chooseWith is choose for a table with the given statistics and key
+// conditions.
+func chooseWith(t *toydb.Table, stats toydb.TableStats, keyConds []*keyCondition, sc *scope, order []OrderItem, want int) (*path, error) {
+	best := newPath(matchKey("", keyColumns(t.Schema(), sc), keyConds), order, sc)
+	if err := best.estimate(t, stats, want); err != nil {
+		return nil, err
+	}
+	if best.access.point() {
+		return best, nil
+	}
+	for _, is := range stats.Indexes {
+		key := make([]int, len(is.Columns))
+		for i, name := range is.Columns {
+			var err error
+			if key[i], err = sc.lookup(name); err != nil {
+				return nil, err
+			}
+		}
+		p := newPath(matchKey(is.Name, key, keyConds), order, sc)
+		if err := p.estimate(t, stats, want); err != nil {
+			return nil, err
+		}
+		// Ties go to the earlier path, so the primary key wins them.
+		if p.cost < best.cost {
+			best = p
+		}
+	}
+	return best, nil
+}
+
+func newPath(a *keyAccess, order []OrderItem, sc *scope) *path {
+	desc, inOrder := scanOrder(order, a, sc)
+	return &path{access: a, desc: desc, inOrder: inOrder}
+}
+
+// estimate fills in the path's estimates from the statistics of its table
+// t. A range of a tree costs the pages of one descent to its first leaf
+// plus its share of the other leaves; reading through an index adds a
+// descent of the table for every row.
+func (p *path) estimate(t *toydb.Table, stats toydb.TableStats, want int) error {
+	a := p.access
+	n := float64(stats.Rows)
+	if a.point() {
+		p.rows, p.pages, p.cost = min(n, 1), float64(stats.Height), float64(stats.Height)
+		return nil
+	}
+
+	var share float64
+	var err error
+	height, pages := stats.Height, stats.Pages
+	if a.index == "" {
+		share, err = t.EstimateRange(a.bound(a.lo), a.bound(a.hi))
+	} else {
+		share, err = t.EstimateIndexRange(a.index, a.values(a.lo), a.values(a.hi))
+		for _, is := range stats.Indexes {
+			if is.Name == a.index {
+				height, pages = is.Height, is.Pages
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	p.rows = share * n
+	p.pages = float64(height-1) + max(1, math.Ceil(share*float64(pages)))
+	if a.index != "" {
+		p.pages += p.rows * float64(stats.Height)
+	}
+
+	p.cost = p.pages
+	if out := p.filtered(); p.inOrder && want >= 0 && out > float64(want) {
+		p.cost = max(float64(height), p.pages*float64(want)/out)
+	}
+	return nil
+}
+
+// scanOrder reports whether reading the access's rows in key order already
+// gives the order asked for, and if so whether to read them descending.
+// That holds when the ORDER BY lists the key columns after the ones fixed
+// by equalities, all in the same direction; fixed columns may appear
+// anywhere, since they do not change from row to row. Indexes are read
+// ascending only.
+func scanOrder(order []OrderItem, a *keyAccess, sc *scope) (desc, ok bool) {
+	next := len(a.eq)
+	directed := false
+	for _, o := range order {
+		ref, isRef := o.Expr.(*ColumnRef)
+		if !isRef {
+			return false, false
+		}
+		col, err := sc.resolve(ref)
+		if err != nil {
+			return false, false
+		}
+		switch k := slices.Index(a.key, col); {
+		case k >= 0 && k < len(a.eq):
+			continue
+		case k == next && (!directed || o.Desc == desc):
+			desc, directed = o.Desc, true
+			next++
+		default:
+			return false, false
+		}
+	}
+	if desc && a.index != "" {
+		return false, false
+	}
+	return desc, true
+}
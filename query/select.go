@@ -0,0 +1,301 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	toydb "github.com/guiwoch/toyDB"
+)
+
+func (s *Session) selectRows(stmt *Select, args []toydb.Value) (*Result, error) {
+	res, _, err := s.planSelect(stmt, args, false)
+	return res, err
+}
+
+// planSelect prepares a SELECT, returning its result, whose rows are read
+// as they are iterated, and, if explain is set, the plan it follows.
+func (s *Session) planSelect(stmt *Select, args []toydb.Value, explain bool) (*Result, *Plan, error) {
+	from, sc, err := s.openFrom(stmt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Expand * and name the result columns.
+	var items []SelectItem
+	var names []string
+	for _, item := range stmt.Items {
+		if item.Star {
+			n := len(items)
+			for i, name := range sc.columns {
+				if item.Table == "" || strings.EqualFold(sc.tables[i], item.Table) {
+					items = append(items, SelectItem{Expr: &ColumnRef{Table: sc.tables[i], Name: name}})
+					names = append(names, name)
+				}
+			}
+			if len(items) == n {
+				return nil, nil, fmt.Errorf("%w: no table %q in FROM", ErrNoColumn, item.Table)
+			}
+			continue
+		}
+		items = append(items, item)
+		switch e := item.Expr.(type) {
+		case *ColumnRef:
+			if item.Alias == "" {
+				i, err := sc.resolve(e)
+				if err != nil {
+					return nil, nil, err
+				}
+				names = append(names, sc.columns[i])
+				continue
+			}
+		default:
+			if item.Alias == "" {
+				names = append(names, e.String())
+				continue
+			}
+		}
+		names = append(names, item.Alias)
+	}
+	order, err := orderBy(stmt.OrderBy, items, names)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// With GROUP BY or aggregates, the select list and ORDER BY are
+	// evaluated over the rows of each group instead.
+	rowScope, shown := sc, order // shown is the ORDER BY EXPLAIN shows
+	var g *grouping
+	if len(stmt.GroupBy) > 0 || slices.ContainsFunc(items, func(item SelectItem) bool { return hasAggregate(item.Expr) }) ||
+		slices.ContainsFunc(order, func(o OrderItem) bool { return hasAggregate(o.Expr) }) {
+		exprs := make([]Expr, 0, len(items)+len(order))
+		for _, item := range items {
+			exprs = append(exprs, item.Expr)
+		}
+		for _, o := range order {
+			exprs = append(exprs, o.Expr)
+		}
+		if g, err = newGrouping(stmt.GroupBy, exprs, sc, args, s.aggregateMemory); err != nil {
+			return nil, nil, err
+		}
+		items, order = slices.Clone(items), slices.Clone(order)
+		for i := range items {
+			if items[i].Expr, err = g.rewrite(items[i].Expr); err != nil {
+				return nil, nil, err
+			}
+		}
+		for i := range order {
+			if order[i].Expr, err = g.rewrite(order[i].Expr); err != nil {
+				return nil, nil, err
+			}
+		}
+		rowScope = g.scope
+	}
+	project := make([]evalFunc, len(items))
+	for i, item := range items {
+		if project[i], err = compile(item.Expr, rowScope, args); err != nil {
+			return nil, nil, err
+		}
+	}
+	sortKeys := make([]evalFunc, len(order))
+	for i, o := range order {
+		if sortKeys[i], err = compile(o.Expr, rowScope, args); err != nil {
+			return nil, nil, err
+		}
+	}
+	limit, err := count(stmt.Limit, "LIMIT", args)
+	if err != nil {
+		return nil, nil, err
+	}
+	offset, err := count(stmt.Offset, "OFFSET", args)
+	if err != nil {
+		return nil, nil, err
+	}
+	offset = max(offset, 0)
+	want := -1
+	if limit >= 0 {
+		want = offset + limit
+	}
+	var src *source
+	if g == nil {
+		src, err = planFrom(from, stmt, sc, args, order, want, explain)
+	} else {
+		src, err = s.planGroups(g, from, stmt, sc, args, len(order) == 0, explain)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows := func(yield func(toydb.Row, error) bool) {
+		// matched yields the rows that pass the filter, in result order.
+		matched := src.rows
+		if !src.inOrder {
+			sorted, err := sortRows(matched, sortKeys, order)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			matched = func(yield func(toydb.Row, error) bool) {
+				for _, row := range sorted {
+					if !yield(row, nil) {
+						return
+					}
+				}
+			}
+		}
+
+		n := 0
+		for row, err := range matched {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if n++; n <= offset {
+				continue
+			}
+			if limit >= 0 && n > offset+limit {
+				return
+			}
+			out := make(toydb.Row, len(project))
+			for i, f := range project {
+				if out[i], err = f(row); err != nil {
+					yield(nil, err)
+					return
+				}
+			}
+			if !yield(out, nil) {
+				return
+			}
+		}
+	}
+	res := &Result{Columns: names, Rows: rows}
+	if !explain {
+		return res, nil, nil
+	}
+	plan := src.plan
+	if !src.inOrder && len(order) > 0 {
+		plan = &Plan{Op: "Sort", Detail: formatOrder(shown), Rows: plan.Rows, Pages: plan.Pages, Inputs: []*Plan{plan}}
+	}
+	if limit >= 0 || offset > 0 {
+		plan = limitPlan(plan, limit, offset, src.early)
+	}
+	return res, plan, nil
+}
+
+// planGroups plans the rows of a grouped SELECT, one for each group. If
+// its GROUP BY columns are primary key columns of the first table in FROM,
+// and the chosen path reads the rows in key order, groups are aggregated
+// as they stream past; otherwise they are hashed. The groups
+// come in order if inOrder is set, as for a SELECT with no ORDER BY.
+func (s *Session) planGroups(g *grouping, from []*fromTable, stmt *Select, sc *scope, args []toydb.Value, inOrder, explain bool) (*source, error) {
+	order := g.order(from)
+	src, err := planFrom(from, stmt, sc, args, order, -1, explain)
+	if err != nil {
+		return nil, err
+	}
+	streamed := len(g.exprs) == 0 || order != nil && src.inOrder
+	grouped := &source{rows: g.hash(src.rows), inOrder: inOrder, early: -1}
+	if streamed {
+		grouped.rows = g.stream(src.rows)
+	}
+	if explain {
+		grouped.plan = g.explain(src.plan, streamed)
+	}
+	return grouped, nil
+}
+
+// orderBy resolves ORDER BY items to expressions over the table's rows. An
+// item may name a result column by its alias or give its position,
+// counting from 1.
+func orderBy(order []OrderItem, items []SelectItem, names []string) ([]OrderItem, error) {
+	resolved := make([]OrderItem, len(order))
+	for i, o := range order {
+		resolved[i] = o
+		switch e := o.Expr.(type) {
+		case *Literal:
+			n, ok := e.Value.(toydb.IntValue)
+			if !ok {
+				continue
+			}
+			if n < 1 || int(n) > len(items) {
+				return nil, fmt.Errorf("ORDER BY position %d is not in the select list", n)
+			}
+			resolved[i].Expr = items[n-1].Expr
+		case *ColumnRef:
+			for j, item := range items {
+				if item.Alias != "" && strings.EqualFold(names[j], e.Name) {
+					resolved[i].Expr = item.Expr
+					break
+				}
+			}
+		}
+	}
+	return resolved, nil
+}
+
+// sortRows collects rows and sorts them by the ORDER BY keys, nulls first
+// when ascending. The sort is stable, so rows with equal keys stay in
+// primary key order.
+func sortRows(rows func(yield func(toydb.Row, error) bool), keys []evalFunc, order []OrderItem) ([]toydb.Row, error) {
+	type keyed struct {
+		row  toydb.Row
+		keys []toydb.Value
+	}
+	var all []keyed
+	for row, err := range rows {
+		if err != nil {
+			return nil, err
+		}
+		k := keyed{row: row, keys: make([]toydb.Value, len(keys))}
+		for i, f := range keys {
+			if k.keys[i], err = f(row); err != nil {
+				return nil, err
+			}
+		}
+		all = append(all, k)
+	}
+	var sortErr error
+	slices.SortStableFunc(all, func(a, b keyed) int {
+		for i := range keys {
+			c, err := sortCompare(a.keys[i], b.keys[i])
+			if err != nil {
+				if sortErr == nil {
+					sortErr = err
+				}
+				return 0
+			}
+			if order[i].Desc {
+				c = -c
+			}
+			if c != 0 {
+				return c
+			}
+		}
+		return 0
+	})
+	if sortErr != nil {
+		return nil, sortErr
+	}
+	sorted := make([]toydb.Row, len(all))
+	for i, k := range all {
+		sorted[i] = k.row
+	}
+	return sorted, nil
+}
+
+// count evaluates a LIMIT or OFFSET, returning -1 if it is absent.
+func count(e Expr, clause string, args []toydb.Value) (int, error) {
+	if e == nil {
+		return -1, nil
+	}
+	v, err := constant(e, args)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := v.(toydb.IntValue)
+	if !ok || n < 0 {
+		return 0, errors.New(clause + " must be a non-negative integer")
+	}
+	return int(n), nil
+}
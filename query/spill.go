@@ -0,0 +1,239 @@
+package query
+
+import (
+	binenc "encoding/binary"
+	"errors"
+	"fmt"
+	"iter"
+	"math"
+	"os"
+	"path/filepath"
+
+	toydb "github.com/guiwoch/toyDB"
+	"github.com/guiwoch/toyDB/internal/storage/page"
+	"github.com/guiwoch/toyDB/internal/storage/pager"
+)
+
+// spillCacheSize caps the pages of a spill file held in memory; the rest
+// are written out to it.
+const spillCacheSize = 64
+
+// spillFile holds what a statement cannot keep in memory on the pages of a
+// temporary file, through a pager of its own. Nothing in it is ever
+// committed, and it is removed, along with the log its pager writes
+// evicted pages to, when closed.
+//
+// It does not spill through the DB's pager: pages allocated there belong
+// to the writer's transaction, so a SELECT would have to wait for the
+// writer lock, and, run in a snapshot or outside a transaction, could not
+// roll them back without discarding the writer's changes too. Pages freed
+// there would also leave the database file grown for data thrown away
+// when the statement ends.
+type spillFile struct {
+	dir   string
+	pager *pager.Pager
+}
+
+func newSpillFile() (*spillFile, error) {
+	dir, err := os.MkdirTemp("", "toydb-spill-")
+	if err != nil {
+		return nil, err
+	}
+	p, _, err := pager.Open(filepath.Join(dir, "spill"), pager.WithCacheSize(spillCacheSize))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return &spillFile{dir: dir, pager: p}, nil
+}
+
+func (f *spillFile) close() error {
+	return errors.Join(f.pager.Close(), os.RemoveAll(f.dir))
+}
+
+// spillRun is a sequence of records on a chain of overflow pages of a spill
+// file, written once and then read back once. Records may straddle pages.
+type spillRun struct {
+	file  *spillFile
+	first uint32
+	last  *page.Page // the page before those still buffered, kept pinned
+	buf   []byte
+}
+
+func (f *spillFile) newRun() *spillRun {
+	return &spillRun{file: f}
+}
+
+// write appends a record to the run.
+func (r *spillRun) write(rec []byte) error {
+	r.buf = binenc.AppendUvarint(r.buf, uint64(len(rec)))
+	r.buf = append(r.buf, rec...)
+	for len(r.buf) >= page.OverflowCapacity {
+		if err := r.flush(page.OverflowCapacity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flush moves the first n buffered bytes to a new page at the end of the
+// chain.
+func (r *spillRun) flush(n int) error {
+	pg, err := r.file.pager.Allocate(page.TypeOverflow)
+	if err != nil {
+		return err
+	}
+	pg.SetOverflowData(r.buf[:n])
+	r.buf = append(r.buf[:0], r.buf[n:]...)
+	if r.last == nil {
+		r.first = pg.PageID()
+	} else {
+		r.last.SetOverflowNext(pg.PageID())
+		r.file.pager.Unpin(r.last.PageID())
+	}
+	r.last = pg
+	return nil
+}
+
+// finish writes out the records still buffered; the run takes no more.
+func (r *spillRun) finish() error {
+	if len(r.buf) > 0 || r.last == nil {
+		if err := r.flush(len(r.buf)); err != nil {
+			return err
+		}
+	}
+	r.file.pager.Unpin(r.last.PageID())
+	r.last = nil
+	return nil
+}
+
+// records reads back the records of a finished run, freeing its pages as
+// it goes. A record is only valid until the next one is read.
+func (r *spillRun) records() iter.Seq2[[]byte, error] {
+	return func(yield func([]byte, error) bool) {
+		var buf []byte
+		id := r.first
+		for {
+			for {
+				n, k := binenc.Uvarint(buf)
+				if k <= 0 || uint64(len(buf)-k) < n {
+					break
+				}
+				if !yield(buf[k:k+int(n)], nil) {
+					return
+				}
+				buf = buf[k+int(n):]
+			}
+			if id == 0 {
+				if len(buf) > 0 {
+					yield(nil, fmt.Errorf("spill run: %d bytes left after the last record", len(buf)))
+				}
+				return
+			}
+			pg, err := r.file.pager.Get(id)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			buf = append(buf, pg.OverflowData()...)
+			next := pg.OverflowNext()
+			r.file.pager.Free(id)
+			id = next
+		}
+	}
+}
+
+// appendValue appends v to dst tagged with its type, so that readValue can
+// read it back. Equal values of one type encode the same.
+func appendValue(dst []byte, v toydb.Value) []byte {
+	dst = append(dst, byte(valueType(v)))
+	switch v := v.(type) {
+	case toydb.IntValue:
+		dst = binenc.AppendVarint(dst, int64(v))
+	case toydb.TimestampValue:
+		dst = binenc.AppendVarint(dst, int64(v))
+	case toydb.DecimalValue:
+		dst = binenc.AppendVarint(dst, int64(v))
+	case toydb.FloatValue:
+		if v == 0 {
+			v = 0 // and not -0
+		}
+		dst = binenc.BigEndian.AppendUint64(dst, math.Float64bits(float64(v)))
+	case toydb.BoolValue:
+		if v {
+			dst = append(dst, 1)
+		} else {
+			dst = append(dst, 0)
+		}
+	case toydb.TextValue:
+		dst = binenc.AppendUvarint(dst, uint64(len(v)))
+		dst = append(dst, v...)
+	case toydb.JSONValue:
+		dst = binenc.AppendUvarint(dst, uint64(len(v)))
+		dst = append(dst, v...)
+	case toydb.BytesValue:
+		dst = binenc.AppendUvarint(dst, uint64(len(v)))
+		dst = append(dst, v...)
+	case toydb.UUIDValue:
+		dst = append(dst, v[:]...)
+	}
+	return dst
+}
+
+var errBadValue = errors.New("malformed spilled value")
+
+// readValue reads a value written by appendValue from the front of buf,
+// returning it and the number of bytes consumed.
+func readValue(buf []byte) (toydb.Value, int, error) {
+	if len(buf) == 0 {
+		return nil, 0, errBadValue
+	}
+	t, rest := toydb.ColType(buf[0]), buf[1:]
+	switch t {
+	case 0:
+		return toydb.Null, 1, nil
+	case toydb.TypeInt, toydb.TypeTimestamp, toydb.TypeDecimal:
+		n, k := binenc.Varint(rest)
+		if k <= 0 {
+			return nil, 0, errBadValue
+		}
+		switch t {
+		case toydb.TypeTimestamp:
+			return toydb.TimestampValue(n), 1 + k, nil
+		case toydb.TypeDecimal:
+			return toydb.DecimalValue(n), 1 + k, nil
+		}
+		return toydb.IntValue(n), 1 + k, nil
+	case toydb.TypeFloat:
+		if len(rest) < 8 {
+			return nil, 0, errBadValue
+		}
+		return toydb.FloatValue(math.Float64frombits(binenc.BigEndian.Uint64(rest))), 9, nil
+	case toydb.TypeBool:
+		if len(rest) < 1 {
+			return nil, 0, errBadValue
+		}
+		return toydb.BoolValue(rest[0] != 0), 2, nil
+	case toydb.TypeText, toydb.TypeJSON, toydb.TypeBytes:
+		n, k := binenc.Uvarint(rest)
+		if k <= 0 || uint64(len(rest)-k) < n {
+			return nil, 0, errBadValue
+		}
+		b := rest[k : k+int(n)]
+		switch t {
+		case toydb.TypeJSON:
+			return toydb.JSONValue(b), 1 + k + int(n), nil
+		case toydb.TypeBytes:
+			return toydb.BytesValue(append([]byte(nil), b...)), 1 + k + int(n), nil
+		}
+		return toydb.TextValue(b), 1 + k + int(n), nil
+	case toydb.TypeUUID:
+		var u toydb.UUIDValue
+		if len(rest) < len(u) {
+			return nil, 0, errBadValue
+		}
+		copy(u[:], rest)
+		return u, 1 + len(u), nil
+	}
+	return nil, 0, errBadValue
+}
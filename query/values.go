@@ -0,0 +1,373 @@
+package query
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+
+	toydb "github.com/guiwoch/toyDB"
+)
+
+// valueType returns the column type of v, or 0 for Null.
+func valueType(v toydb.Value) toydb.ColType {
+	switch v.(type) {
+	case toydb.IntValue:
+		return toydb.TypeInt
+	case toydb.TextValue:
+		return toydb.TypeText
+	case toydb.BoolValue:
+		return toydb.TypeBool
+	case toydb.TimestampValue:
+		return toydb.TypeTimestamp
+	case toydb.FloatValue:
+		return toydb.TypeFloat
+	case toydb.BytesValue:
+		return toydb.TypeBytes
+	case toydb.DecimalValue:
+		return toydb.TypeDecimal
+	case toydb.UUIDValue:
+		return toydb.TypeUUID
+	case toydb.JSONValue:
+		return toydb.TypeJSON
+	}
+	return 0
+}
+
+// typeName names the type of v for error messages.
+func typeName(v toydb.Value) string {
+	if v == toydb.Null {
+		return "null"
+	}
+	return valueType(v).String()
+}
+
+// timeLayouts are the text forms accepted for timestamps, tried in order.
+var timeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999", "2006-01-02"}
+
+// coerce converts v to a value of column type t where that loses nothing:
+// text to a timestamp, decimal, UUID or JSON document; an integer to a
+// float or decimal; a float with few enough digits to a decimal; and 16
+// bytes to a UUID. Null is returned as is. It returns an error wrapping
+// ErrType if v cannot be converted.
+func coerce(v toydb.Value, t toydb.ColType) (toydb.Value, error) {
+	from := valueType(v)
+	if from == t || v == toydb.Null {
+		return v, nil
+	}
+	fail := func(err error) (toydb.Value, error) {
+		if err != nil {
+			return nil, fmt.Errorf("%w: cannot convert %s to %s: %v", ErrType, formatLiteral(v), t, err)
+		}
+		return nil, fmt.Errorf("%w: cannot convert %s %s to %s", ErrType, from, formatLiteral(v), t)
+	}
+	switch v := v.(type) {
+	case toydb.TextValue:
+		switch t {
+		case toydb.TypeTimestamp:
+			for _, layout := range timeLayouts {
+				if ts, err := time.Parse(layout, string(v)); err == nil {
+					return toydb.TimestampValue(ts.UnixNano()), nil
+				}
+			}
+			return fail(errors.New("not a time in RFC 3339 form"))
+		case toydb.TypeDecimal:
+			d, err := toydb.ParseDecimal(string(v))
+			if err != nil {
+				return fail(err)
+			}
+			return d, nil
+		case toydb.TypeUUID:
+			u, err := toydb.ParseUUID(string(v))
+			if err != nil {
+				return fail(err)
+			}
+			return u, nil
+		case toydb.TypeJSON:
+			return toydb.JSONValue(v), nil
+		}
+	case toydb.IntValue:
+		switch t {
+		case toydb.TypeFloat:
+			if f := float64(v); f >= -(1<<53) && f <= 1<<53 {
+				return toydb.FloatValue(f), nil
+			}
+		case toydb.TypeDecimal:
+			if d, ok := intToDecimal(v); ok {
+				return d, nil
+			}
+		}
+	case toydb.FloatValue:
+		if t == toydb.TypeDecimal {
+			d, err := toydb.ParseDecimal(strconv.FormatFloat(float64(v), 'f', -1, 64))
+			if err != nil {
+				return fail(err)
+			}
+			return d, nil
+		}
+	case toydb.BytesValue:
+		if t == toydb.TypeUUID && len(v) == 16 {
+			return toydb.UUIDValue(v), nil
+		}
+	}
+	return fail(nil)
+}
+
+// intToDecimal converts an integer to a decimal, reporting false if it is
+// out of the decimal range.
+func intToDecimal(i toydb.IntValue) (toydb.DecimalValue, bool) {
+	const unit = 1_000_000 // 10^toydb.DecimalScale
+	if i > math.MaxInt64/unit || i < math.MinInt64/unit {
+		return 0, false
+	}
+	return toydb.DecimalValue(i * unit), true
+}
+
+// compareValues orders two non-null values: numbers of any type by value,
+// text with a timestamp, decimal, UUID or JSON value by converting the
+// text, and values of the other types only with their own type. It returns
+// an error wrapping ErrType for values that cannot be compared.
+func compareValues(a, b toydb.Value) (int, error) {
+	ta, tb := valueType(a), valueType(b)
+	if ta != tb {
+		if ta == toydb.TypeText {
+			if c, err := coerce(a, tb); err == nil {
+				return compareValues(c, b)
+			}
+		}
+		if tb == toydb.TypeText {
+			if c, err := coerce(b, ta); err == nil {
+				return compareValues(a, c)
+			}
+		}
+		if isNumeric(ta) && isNumeric(tb) {
+			return compareNumbers(a, b), nil
+		}
+		return 0, fmt.Errorf("%w: cannot compare %s with %s", ErrType, typeName(a), typeName(b))
+	}
+	switch a := a.(type) {
+	case toydb.IntValue:
+		return cmp.Compare(a, b.(toydb.IntValue)), nil
+	case toydb.TextValue:
+		return strings.Compare(string(a), string(b.(toydb.TextValue))), nil
+	case toydb.BoolValue:
+		return cmp.Compare(boolInt(bool(a)), boolInt(bool(b.(toydb.BoolValue)))), nil
+	case toydb.TimestampValue:
+		return cmp.Compare(a, b.(toydb.TimestampValue)), nil
+	case toydb.FloatValue:
+		return cmp.Compare(a, b.(toydb.FloatValue)), nil
+	case toydb.BytesValue:
+		return bytes.Compare(a, b.(toydb.BytesValue)), nil
+	case toydb.DecimalValue:
+		return cmp.Compare(a, b.(toydb.DecimalValue)), nil
+	case toydb.UUIDValue:
+		u := b.(toydb.UUIDValue)
+		return bytes.Compare(a[:], u[:]), nil
+	case toydb.JSONValue:
+		return strings.Compare(string(a), string(b.(toydb.JSONValue))), nil
+	}
+	return 0, fmt.Errorf("%w: cannot compare %s with %s", ErrType, typeName(a), typeName(b))
+}
+
+// sortCompare orders values for ORDER BY: as compareValues, with Null
+// before everything else.
+func sortCompare(a, b toydb.Value) (int, error) {
+	switch {
+	case a == toydb.Null && b == toydb.Null:
+		return 0, nil
+	case a == toydb.Null:
+		return -1, nil
+	case b == toydb.Null:
+		return 1, nil
+	}
+	return compareValues(a, b)
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func isNumeric(t toydb.ColType) bool {
+	return t == toydb.TypeInt || t == toydb.TypeFloat || t == toydb.TypeDecimal
+}
+
+// compareNumbers orders two numbers of different types.
+func compareNumbers(a, b toydb.Value) int {
+	if d, ok := a.(toydb.DecimalValue); ok {
+		if i, ok := b.(toydb.IntValue); ok {
+			if bd, ok := intToDecimal(i); ok {
+				return cmp.Compare(d, bd)
+			}
+			return -cmp.Compare(i, 0)
+		}
+	}
+	if _, ok := a.(toydb.IntValue); ok {
+		if _, ok := b.(toydb.DecimalValue); ok {
+			return -compareNumbers(b, a)
+		}
+	}
+	return cmp.Compare(toFloat(a), toFloat(b))
+}
+
+func toFloat(v toydb.Value) float64 {
+	switch v := v.(type) {
+	case toydb.IntValue:
+		return float64(v)
+	case toydb.FloatValue:
+		return float64(v)
+	case toydb.DecimalValue:
+		return float64(v) / 1_000_000
+	}
+	panic(fmt.Sprintf("toFloat: %T is not a number", v))
+}
+
+// arith applies an arithmetic operator to two non-null values. Integers
+// stay integers unless mixed with floats; decimals with integers stay
+// decimals.
+func arith(op string, a, b toydb.Value) (toydb.Value, error) {
+	ta, tb := valueType(a), valueType(b)
+	if !isNumeric(ta) || !isNumeric(tb) {
+		return nil, fmt.Errorf("%w: cannot apply %s to %s and %s", ErrType, op, typeName(a), typeName(b))
+	}
+	switch {
+	case ta == toydb.TypeInt && tb == toydb.TypeInt:
+		return arithInt(op, int64(a.(toydb.IntValue)), int64(b.(toydb.IntValue)))
+	case ta == toydb.TypeFloat || tb == toydb.TypeFloat:
+		return arithFloat(op, toFloat(a), toFloat(b))
+	}
+	// Decimals, possibly mixed with integers.
+	da, err := coerce(a, toydb.TypeDecimal)
+	if err != nil {
+		return nil, err
+	}
+	db, err := coerce(b, toydb.TypeDecimal)
+	if err != nil {
+		return nil, err
+	}
+	return arithDecimal(op, int64(da.(toydb.DecimalValue)), int64(db.(toydb.DecimalValue)))
+}
+
+var errOverflow = errors.New("numeric overflow")
+
+func arithInt(op string, a, b int64) (toydb.Value, error) {
+	var r int64
+	switch op {
+	case "+":
+		r = a + b
+		if (r > a) != (b > 0) {
+			return nil, errOverflow
+		}
+	case "-":
+		r = a - b
+		if (r < a) != (b > 0) {
+			return nil, errOverflow
+		}
+	case "*":
+		if a != 0 && b != 0 {
+			r = a * b
+			if r/b != a || a == -1 && b == math.MinInt64 || b == -1 && a == math.MinInt64 {
+				return nil, errOverflow
+			}
+		}
+	case "/", "%":
+		if b == 0 {
+			return nil, errors.New("division by zero")
+		}
+		if a == math.MinInt64 && b == -1 {
+			return nil, errOverflow
+		}
+		if op == "/" {
+			r = a / b
+		} else {
+			r = a % b
+		}
+	}
+	return toydb.IntValue(r), nil
+}
+
+func arithFloat(op string, a, b float64) (toydb.Value, error) {
+	var r float64
+	switch op {
+	case "+":
+		r = a + b
+	case "-":
+		r = a - b
+	case "*":
+		r = a * b
+	case "/":
+		if b == 0 {
+			return nil, errors.New("division by zero")
+		}
+		r = a / b
+	case "%":
+		if b == 0 {
+			return nil, errors.New("division by zero")
+		}
+		r = math.Mod(a, b)
+	}
+	if math.IsInf(r, 0) || math.IsNaN(r) {
+		return nil, errOverflow
+	}
+	return toydb.FloatValue(r), nil
+}
+
+// arithDecimal works on decimals as integer counts of millionths.
+func arithDecimal(op string, a, b int64) (toydb.Value, error) {
+	const unit = 1_000_000
+	switch op {
+	case "+", "-", "%":
+		r, err := arithInt(op, a, b)
+		if err != nil {
+			return nil, err
+		}
+		return toydb.DecimalValue(r.(toydb.IntValue)), nil
+	case "*":
+		return mulDivDecimal(a, b, unit)
+	case "/":
+		if b == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return mulDivDecimal(a, unit, b)
+	}
+	return nil, fmt.Errorf("unknown operator %s", op)
+}
+
+// mulDivDecimal returns a*b/c rounded half away from zero, computing the
+// product in 128 bits so that only a quotient outside int64 overflows.
+func mulDivDecimal(a, b, c int64) (toydb.Value, error) {
+	neg := (a < 0) != (b < 0) != (c < 0)
+	hi, lo := bits.Mul64(absUint(a), absUint(b))
+	uc := absUint(c)
+	if hi >= uc {
+		return nil, errOverflow
+	}
+	q, r := bits.Div64(hi, lo, uc)
+	if q > 1<<63 {
+		return nil, errOverflow
+	}
+	if r >= uc-r {
+		q++
+	}
+	switch {
+	case neg && q <= 1<<63:
+		return toydb.DecimalValue(-int64(q)), nil
+	case !neg && q <= math.MaxInt64:
+		return toydb.DecimalValue(q), nil
+	}
+	return nil, errOverflow
+}
+
+func absUint(v int64) uint64 {
+	if v < 0 {
+		return uint64(-v)
+	}
+	return uint64(v)
+}
@@ -1,8 +1,12 @@
 package toydb
 
 import (
+	"bytes"
 	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"math"
 	"slices"
 	"time"
 )
@@ -16,15 +20,35 @@ const (
 	// ([IntValue]). The sign bit is flipped before encoding so that
 	// lexicographic byte order matches signed numeric order.
 	TypeInt ColType = iota + 1
-	// TypeText stores values as length-prefixed UTF-8 strings ([TextValue]).
+	// TypeText stores values as UTF-8 strings ([TextValue]), with each 0x00
+	// byte escaped as 0x00 0xFF and a 0x00 0x01 terminator, so that
+	// lexicographic byte order matches string order.
 	TypeText
 	// TypeBool stores values as a single byte, 0 or 1 ([BoolValue]).
 	TypeBool
 	// TypeTimestamp stores values as 8-byte big-endian Unix nanoseconds
-	// ([TimestampValue]).
+	// ([TimestampValue]), sign bit flipped like [TypeInt] so that times
+	// before 1970 sort first.
 	TypeTimestamp
+	// TypeFloat stores values as 8-byte big-endian IEEE-754 doubles
+	// ([FloatValue]), with the sign bit flipped for positive numbers and
+	// every bit flipped for negative ones, so that lexicographic byte order
+	// matches numeric order. NaN is not a valid value.
+	TypeFloat
+	// TypeBytes stores values as raw bytes ([BytesValue]), escaped and
+	// terminated like [TypeText].
+	TypeBytes
+	// TypeDecimal stores fixed-point values with [DecimalScale] digits
+	// after the point ([DecimalValue]), encoded like [TypeInt].
+	TypeDecimal
+	// TypeUUID stores values as their 16 raw bytes ([UUIDValue]).
+	TypeUUID
+	// TypeJSON stores JSON documents as their text ([JSONValue]), escaped
+	// and terminated like [TypeText]. Values are validated on write.
+	TypeJSON
 	// numColTypes is one past the last declared ColType. New types must be
-	// appended above this sentinel so on-disk tags remain stable.
+	// appended above this sentinel so on-disk tags remain stable, and tags
+	// must stay below nullableTag.
 	numColTypes
 )
 
@@ -32,17 +56,67 @@ func (t ColType) valid() bool {
 	return t > 0 && t < numColTypes
 }
 
-// Column describes a single column in a [Schema] by name and type.
+// String returns the type's lowercase name, such as "int" or "timestamp".
+func (t ColType) String() string {
+	switch t {
+	case TypeInt:
+		return "int"
+	case TypeText:
+		return "text"
+	case TypeBool:
+		return "bool"
+	case TypeTimestamp:
+		return "timestamp"
+	case TypeFloat:
+		return "float"
+	case TypeBytes:
+		return "bytes"
+	case TypeDecimal:
+		return "decimal"
+	case TypeUUID:
+		return "uuid"
+	case TypeJSON:
+		return "json"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(t))
+	}
+}
+
+// Column describes a single column in a [Schema] by name and type. A
+// Nullable column also accepts [Null] in place of a value of its type.
 type Column struct {
-	Name string
-	Type ColType
+	Name     string
+	Type     ColType
+	Nullable bool
 }
 
 // Schema describes a table's columns and primary key. Construct one with
-// [NewSchema]; the fields are unexported so all schemas pass validation.
+// [NewSchema] or [NewCompositeSchema]; the fields are unexported so all
+// schemas pass validation.
 type Schema struct {
-	columns         []Column
-	primaryKeyIndex int
+	columns []Column
+
+	// primaryKey holds the positions of the primary key columns, in key
+	// order.
+	primaryKey []int
+
+	// stored lists the columns rows are encoded with, in encoding order. It
+	// starts out as one entry per column and only grows: [Table.AddColumn]
+	// appends to it and [Table.DropColumn] leaves a tombstone, so a row
+	// written under an earlier version of the schema is a prefix of the
+	// current layout.
+	stored []storedColumn
+
+	// version counts the changes made to the schema since its table was
+	// created.
+	version uint32
+}
+
+// storedColumn is one entry of a schema's row layout.
+type storedColumn struct {
+	typ    ColType
+	column int   // position in the schema's columns, or -1 once dropped
+	def    Value // read for rows written before the column was added
 }
 
 // Columns returns a copy of the schema's columns. Mutating the returned
@@ -51,9 +125,41 @@ func (s *Schema) Columns() []Column {
 	return slices.Clone(s.columns)
 }
 
-// PrimaryKey returns the name of the primary key column.
+// columnIndex returns the position of the named column.
+func (s *Schema) columnIndex(name string) (int, bool) {
+	for i, column := range s.columns {
+		if column.Name == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// PrimaryKey returns the name of the primary key column, or of its first
+// column if the key is composite.
 func (s *Schema) PrimaryKey() string {
-	return s.columns[s.primaryKeyIndex].Name
+	return s.columns[s.primaryKey[0]].Name
+}
+
+// PrimaryKeyColumns returns the names of the primary key columns, in key
+// order.
+func (s *Schema) PrimaryKeyColumns() []string {
+	names := make([]string, len(s.primaryKey))
+	for i, c := range s.primaryKey {
+		names[i] = s.columns[c].Name
+	}
+	return names
+}
+
+// Version returns the number of changes made to the table's schema since
+// it was created: 0 for a schema that was never altered.
+func (s *Schema) Version() uint32 {
+	return s.version
+}
+
+// isKeyColumn reports whether column i is part of the primary key.
+func (s *Schema) isKeyColumn(i int) bool {
+	return slices.Contains(s.primaryKey, i)
 }
 
 // Value is the closed set of column value types. The unexported method
@@ -76,6 +182,48 @@ type BoolValue bool
 // timestamps are safe to use as primary keys.
 type TimestampValue int64
 
+// FloatValue is the [Value] for [TypeFloat] columns. Negative zero is
+// stored as zero.
+type FloatValue float64
+
+// BytesValue is the [Value] for [TypeBytes] columns. Unlike the other
+// values it is not comparable with ==; use [bytes.Equal].
+type BytesValue []byte
+
+// String formats the bytes as hexadecimal so %v output is human-readable.
+func (v BytesValue) String() string {
+	return hex.EncodeToString(v)
+}
+
+// valuesEqual reports whether two values are equal, comparing BytesValue
+// by content.
+func valuesEqual(a, b Value) bool {
+	if a, ok := a.(BytesValue); ok {
+		b, ok := b.(BytesValue)
+		return ok && bytes.Equal(a, b)
+	}
+	return a == b
+}
+
+// NullValue is the type of [Null].
+type NullValue struct{}
+
+// Null is the [Value] of a nullable column that holds no value. It is not
+// valid in primary key columns, which cannot be nullable.
+var Null = NullValue{}
+
+// String returns "null" so %v output is human-readable.
+func (NullValue) String() string { return "null" }
+
+// Key is a primary key tuple: values for the primary key columns, in key
+// order. Get, Delete, and the scans take a Key, or a lone Value standing for
+// a one-value Key. A Key may hold values for only the leading columns of a
+// composite primary key, in which case it matches every row whose key
+// starts with them.
+//
+// Key is not a column type and cannot appear in a [Row].
+type Key []Value
+
 // TimestampNow returns a TimestampValue for the current wall-clock time.
 func TimestampNow() TimestampValue {
 	return TimestampValue(time.Now().UnixNano())
@@ -95,9 +243,59 @@ func (v IntValue) encode(dst []byte) []byte {
 	return binary.BigEndian.AppendUint64(dst, uint64(v)^(1<<63))
 }
 
+// Text escapes and terminator. The terminator sorts below any escaped or
+// literal byte, so a string sorts before every string it prefixes.
+const (
+	textEscape     = 0x00
+	textEscapedNul = 0xFF
+	textTerminator = 0x01
+)
+
 func (v TextValue) encode(dst []byte) []byte {
-	dst = binary.BigEndian.AppendUint32(dst, uint32(len(v)))
-	return append(dst, v...)
+	return appendEscaped(dst, v)
+}
+
+func (v BytesValue) encode(dst []byte) []byte {
+	return appendEscaped(dst, v)
+}
+
+// appendEscaped appends v with its 0x00 bytes escaped, and the terminator.
+func appendEscaped[T ~string | ~[]byte](dst []byte, v T) []byte {
+	for i := range len(v) {
+		if v[i] == textEscape {
+			dst = append(dst, textEscape, textEscapedNul)
+			continue
+		}
+		dst = append(dst, v[i])
+	}
+	return append(dst, textEscape, textTerminator)
+}
+
+// decodeEscaped decodes a value written by appendEscaped from the front of
+// buf, returning it and the number of bytes consumed. The result aliases
+// buf unless v held an escaped 0x00.
+func decodeEscaped(buf []byte) ([]byte, int, error) {
+	var v []byte
+	start := 0 // first byte not yet copied into v
+	for i := 0; i+1 < len(buf); i++ {
+		if buf[i] != textEscape {
+			continue
+		}
+		switch buf[i+1] {
+		case textTerminator:
+			if v == nil {
+				return buf[:i], i + 2, nil
+			}
+			return append(v, buf[start:i]...), i + 2, nil
+		case textEscapedNul:
+			v = append(v, buf[start:i+1]...)
+			start = i + 2
+			i++
+		default:
+			return nil, 0, fmt.Errorf("invalid escape 0x%02x at byte %d", buf[i+1], i+1)
+		}
+	}
+	return nil, 0, fmt.Errorf("missing terminator in %d bytes", len(buf))
 }
 
 func (v BoolValue) encode(dst []byte) []byte {
@@ -108,9 +306,47 @@ func (v BoolValue) encode(dst []byte) []byte {
 }
 
 func (v TimestampValue) encode(dst []byte) []byte {
-	return binary.BigEndian.AppendUint64(dst, uint64(v))
+	return binary.BigEndian.AppendUint64(dst, uint64(v)^(1<<63))
 }
 
+func (v FloatValue) encode(dst []byte) []byte {
+	if v == 0 {
+		v = 0 // negative zero sorts with zero
+	}
+	bits := math.Float64bits(float64(v))
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits ^= 1 << 63
+	}
+	return binary.BigEndian.AppendUint64(dst, bits)
+}
+
+func (v DecimalValue) encode(dst []byte) []byte {
+	return binary.BigEndian.AppendUint64(dst, uint64(v)^(1<<63))
+}
+
+// A null has no encoding of its own: rows record it in their null bitmap,
+// and index keys in a presence byte before the column's value.
+func (NullValue) encode(dst []byte) []byte {
+	return dst
+}
+
+// Every column encoding is order-preserving and self-delimiting, so
+// concatenating them orders keys by their first value, then their second,
+// and so on, and orders a key prefix before every key it starts.
+func (k Key) encode(dst []byte) []byte {
+	for _, v := range k {
+		dst = v.encode(dst)
+	}
+	return dst
+}
+
+// valueDecoder decodes one value of type t from the front of buf, returning
+// it and the number of bytes consumed. decodeValue reads the current
+// format; see legacyDecoder for files written by earlier versions.
+type valueDecoder func(buf []byte, t ColType) (Value, int, error)
+
 func decodeValue(buf []byte, t ColType) (Value, int, error) {
 	switch t {
 	case TypeInt:
@@ -121,14 +357,11 @@ func decodeValue(buf []byte, t ColType) (Value, int, error) {
 		return IntValue(int64(n)), 8, nil
 
 	case TypeText:
-		if len(buf) < 4 {
-			return nil, 0, fmt.Errorf("decode text length: need 4 bytes, have %d", len(buf))
-		}
-		length := binary.BigEndian.Uint32(buf[:4])
-		if len(buf) < 4+int(length) {
-			return nil, 0, fmt.Errorf("decode text body: length %d but only %d bytes available", length, len(buf)-4)
+		text, n, err := decodeEscaped(buf)
+		if err != nil {
+			return nil, 0, fmt.Errorf("decode text: %w", err)
 		}
-		return TextValue(buf[4 : 4+length]), 4 + int(length), nil
+		return TextValue(text), n, nil
 
 	case TypeBool:
 		if len(buf) < 1 {
@@ -140,8 +373,51 @@ func decodeValue(buf []byte, t ColType) (Value, int, error) {
 		if len(buf) < 8 {
 			return nil, 0, fmt.Errorf("decode timestamp: need 8 bytes, have %d", len(buf))
 		}
-		n := binary.BigEndian.Uint64(buf[:8])
-		return TimestampValue(n), 8, nil
+		n := binary.BigEndian.Uint64(buf[:8]) ^ (1 << 63)
+		return TimestampValue(int64(n)), 8, nil
+
+	case TypeFloat:
+		if len(buf) < 8 {
+			return nil, 0, fmt.Errorf("decode float: need 8 bytes, have %d", len(buf))
+		}
+		bits := binary.BigEndian.Uint64(buf[:8])
+		if bits&(1<<63) != 0 {
+			bits ^= 1 << 63
+		} else {
+			bits = ^bits
+		}
+		return FloatValue(math.Float64frombits(bits)), 8, nil
+
+	case TypeBytes:
+		b, n, err := decodeEscaped(buf)
+		if err != nil {
+			return nil, 0, fmt.Errorf("decode bytes: %w", err)
+		}
+		// Copy so the value does not share, or keep alive, the buffer of
+		// the whole row.
+		return BytesValue(bytes.Clone(b)), n, nil
+
+	case TypeDecimal:
+		if len(buf) < 8 {
+			return nil, 0, fmt.Errorf("decode decimal: need 8 bytes, have %d", len(buf))
+		}
+		n := binary.BigEndian.Uint64(buf[:8]) ^ (1 << 63)
+		return DecimalValue(int64(n)), 8, nil
+
+	case TypeUUID:
+		var u UUIDValue
+		if len(buf) < len(u) {
+			return nil, 0, fmt.Errorf("decode uuid: need %d bytes, have %d", len(u), len(buf))
+		}
+		copy(u[:], buf)
+		return u, len(u), nil
+
+	case TypeJSON:
+		doc, n, err := decodeEscaped(buf)
+		if err != nil {
+			return nil, 0, fmt.Errorf("decode json: %w", err)
+		}
+		return JSONValue(doc), n, nil
 
 	default:
 		return nil, 0, fmt.Errorf("decode value: unknown type %d", t)
@@ -152,23 +428,67 @@ func decodeValue(buf []byte, t ColType) (Value, int, error) {
 // in a Row corresponds to column i in the schema's column list.
 type Row []Value
 
-// decodeRow reconstructs a Row from the primary key value and the encoded
-// non-PK columns. The PK is not stored inside buf; it is spliced in at
-// PrimaryKeyIndex.
-func (s *Schema) decodeRow(pk Value, buf []byte) (Row, error) {
-	row := make(Row, 0, len(s.columns))
+// decodeRow reconstructs a Row from the primary key values and the encoded
+// non-PK columns. The PK is not stored inside buf; its values are spliced
+// in at the primary key columns' positions.
+//
+// A row written before columns were added holds fewer stored columns than
+// the schema; the added ones read as their defaults. Values of dropped
+// columns are skipped. Rows are brought up to date only when next written.
+func (s *Schema) decodeRow(pk Key, buf []byte) (Row, error) {
+	storedCount, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return nil, fmt.Errorf("decode row: invalid column count")
+	}
+	if storedCount > uint64(len(s.stored)) {
+		return nil, fmt.Errorf("decode row: %d columns, schema has %d", storedCount, len(s.stored))
+	}
+	bitmapLen := (int(storedCount) + 7) / 8
+	if len(buf)-n < bitmapLen {
+		return nil, fmt.Errorf("decode row: null bitmap truncated: need %d bytes, have %d", bitmapLen, len(buf)-n)
+	}
+	nulls := buf[n : n+bitmapLen]
+	return s.decodeValues(decodeValue, pk, int(storedCount), nulls, buf[n+bitmapLen:])
+}
+
+// decodeValues decodes the values in buf of the first storedCount stored
+// columns that are neither in the PK nor null in the bitmap, in the format
+// read by decode, and splices in pk, the nulls, and the defaults of later
+// columns. A nil bitmap has no nulls.
+func (s *Schema) decodeValues(decode valueDecoder, pk Key, storedCount int, nulls []byte, buf []byte) (Row, error) {
+	row := make(Row, len(s.columns))
+	for i, c := range s.primaryKey {
+		row[c] = pk[i]
+	}
 	offset := 0
-	for i, column := range s.columns {
-		if i == s.primaryKeyIndex {
-			row = append(row, pk)
+	for j, sc := range s.stored {
+		live := sc.column >= 0
+		if live && s.isKeyColumn(sc.column) {
 			continue
 		}
-		val, n, err := decodeValue(buf[offset:], column.Type)
+		if j >= storedCount {
+			if live {
+				row[sc.column] = sc.def
+			}
+			continue
+		}
+		if nulls != nil && nulls[j/8]&(1<<(j%8)) != 0 {
+			if live {
+				if column := s.columns[sc.column]; !column.Nullable {
+					return nil, fmt.Errorf("decode row: null in column %q, which is not nullable", column.Name)
+				}
+				row[sc.column] = Null
+			}
+			continue
+		}
+		val, n, err := decode(buf[offset:], sc.typ)
 		if err != nil {
 			return nil, err
 		}
 		offset += n
-		row = append(row, val)
+		if live {
+			row[sc.column] = val
+		}
 	}
 
 	if offset != len(buf) {
@@ -181,63 +501,124 @@ func (s *Schema) validateRow(row Row) error {
 	if len(row) != len(s.columns) {
 		return fmt.Errorf("%w: got %d values, schema has %d columns", ErrSchemaMismatch, len(row), len(s.columns))
 	}
-	for i, column := range s.columns {
-		switch column.Type {
-		case TypeInt:
-			if _, ok := row[i].(IntValue); !ok {
-				return fmt.Errorf("%w: column %d (%q) expects int, got %T", ErrSchemaMismatch, i, column.Name, row[i])
-			}
-		case TypeText:
-			if _, ok := row[i].(TextValue); !ok {
-				return fmt.Errorf("%w: column %d (%q) expects text, got %T", ErrSchemaMismatch, i, column.Name, row[i])
-			}
-		case TypeBool:
-			if _, ok := row[i].(BoolValue); !ok {
-				return fmt.Errorf("%w: column %d (%q) expects bool, got %T", ErrSchemaMismatch, i, column.Name, row[i])
-			}
-		case TypeTimestamp:
-			if _, ok := row[i].(TimestampValue); !ok {
-				return fmt.Errorf("%w: column %d (%q) expects timestamp, got %T", ErrSchemaMismatch, i, column.Name, row[i])
-			}
-		default:
-			return fmt.Errorf("%w: column %d (%q) has unknown type %d", ErrSchemaMismatch, i, column.Name, column.Type)
+	for i := range s.columns {
+		if err := s.validateValue(i, row[i], ErrSchemaMismatch); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-// validateKey checks that v's runtime type matches the primary key column type.
-func (s *Schema) validateKey(v Value) error {
-	pkType := s.columns[s.primaryKeyIndex].Type
-	switch pkType {
+// validateValue checks that v's runtime type matches column i's type,
+// wrapping sentinel on mismatch.
+func (s *Schema) validateValue(i int, v Value, sentinel error) error {
+	column := s.columns[i]
+	if _, ok := v.(NullValue); ok {
+		if !column.Nullable {
+			return fmt.Errorf("%w: column %d (%q) is not nullable", sentinel, i, column.Name)
+		}
+		return nil
+	}
+	switch column.Type {
 	case TypeInt:
 		if _, ok := v.(IntValue); !ok {
-			return fmt.Errorf("%w: primary key expects int, got %T", ErrKeyTypeMismatch, v)
+			return fmt.Errorf("%w: column %d (%q) expects int, got %T", sentinel, i, column.Name, v)
 		}
 	case TypeText:
 		if _, ok := v.(TextValue); !ok {
-			return fmt.Errorf("%w: primary key expects text, got %T", ErrKeyTypeMismatch, v)
+			return fmt.Errorf("%w: column %d (%q) expects text, got %T", sentinel, i, column.Name, v)
 		}
 	case TypeBool:
 		if _, ok := v.(BoolValue); !ok {
-			return fmt.Errorf("%w: primary key expects bool, got %T", ErrKeyTypeMismatch, v)
+			return fmt.Errorf("%w: column %d (%q) expects bool, got %T", sentinel, i, column.Name, v)
 		}
 	case TypeTimestamp:
 		if _, ok := v.(TimestampValue); !ok {
-			return fmt.Errorf("%w: primary key expects timestamp, got %T", ErrKeyTypeMismatch, v)
+			return fmt.Errorf("%w: column %d (%q) expects timestamp, got %T", sentinel, i, column.Name, v)
+		}
+	case TypeFloat:
+		f, ok := v.(FloatValue)
+		if !ok {
+			return fmt.Errorf("%w: column %d (%q) expects float, got %T", sentinel, i, column.Name, v)
+		}
+		if math.IsNaN(float64(f)) {
+			return fmt.Errorf("%w: column %d (%q) cannot hold NaN", sentinel, i, column.Name)
+		}
+	case TypeBytes:
+		if _, ok := v.(BytesValue); !ok {
+			return fmt.Errorf("%w: column %d (%q) expects bytes, got %T", sentinel, i, column.Name, v)
+		}
+	case TypeDecimal:
+		if _, ok := v.(DecimalValue); !ok {
+			return fmt.Errorf("%w: column %d (%q) expects decimal, got %T", sentinel, i, column.Name, v)
+		}
+	case TypeUUID:
+		if _, ok := v.(UUIDValue); !ok {
+			return fmt.Errorf("%w: column %d (%q) expects uuid, got %T", sentinel, i, column.Name, v)
+		}
+	case TypeJSON:
+		doc, ok := v.(JSONValue)
+		if !ok {
+			return fmt.Errorf("%w: column %d (%q) expects json, got %T", sentinel, i, column.Name, v)
+		}
+		if !json.Valid([]byte(doc)) {
+			return fmt.Errorf("%w: column %d (%q) holds invalid json", sentinel, i, column.Name)
 		}
 	default:
-		return fmt.Errorf("%w: primary key has unknown type %d", ErrKeyTypeMismatch, pkType)
+		return fmt.Errorf("%w: column %d (%q) has unknown type %d", sentinel, i, column.Name, column.Type)
 	}
 	return nil
 }
 
+// keyValues returns the values of a key argument: the values of a Key, or
+// v alone.
+func keyValues(v Value) Key {
+	if k, ok := v.(Key); ok {
+		return k
+	}
+	return Key{v}
+}
+
+// validateKey checks that v holds values for 1 to all of the primary key
+// columns, in key order, whose runtime types match the column types. It
+// reports whether v is a full key rather than a prefix of one.
+func (s *Schema) validateKey(v Value) (full bool, err error) {
+	k := keyValues(v)
+	if len(k) == 0 || len(k) > len(s.primaryKey) {
+		return false, fmt.Errorf("%w: primary key has %d columns, got %d values", ErrKeyTypeMismatch, len(s.primaryKey), len(k))
+	}
+	for i, value := range k {
+		if err := s.validateValue(s.primaryKey[i], value, ErrKeyTypeMismatch); err != nil {
+			return false, err
+		}
+	}
+	return len(k) == len(s.primaryKey), nil
+}
+
 // encodeRow serializes the non-PK columns of a row. The PK is omitted because
 // it is already stored as the B-tree key; decodeRow splices it back in.
+//
+// Layout, over the schema's stored columns:
+// [uvarint] stored column count (C)
+// [(C+7)/8 bytes] null bitmap: bit j%8 of byte j/8 is set if stored column
+// j is null or dropped
+// the encoded values of the non-PK stored columns that are not null, in
+// order
 func (s *Schema) encodeRow(row Row) []byte {
-	var buf []byte
-	for i, value := range row {
-		if i == s.primaryKeyIndex {
+	buf := binary.AppendUvarint(nil, uint64(len(s.stored)))
+	bitmap := len(buf)
+	buf = append(buf, make([]byte, (len(s.stored)+7)/8)...)
+	for j, sc := range s.stored {
+		if sc.column >= 0 && s.isKeyColumn(sc.column) {
+			continue
+		}
+		if sc.column < 0 {
+			buf[bitmap+j/8] |= 1 << (j % 8)
+			continue
+		}
+		value := row[sc.column]
+		if _, ok := value.(NullValue); ok {
+			buf[bitmap+j/8] |= 1 << (j % 8)
 			continue
 		}
 		buf = value.encode(buf)
@@ -246,42 +627,78 @@ func (s *Schema) encodeRow(row Row) []byte {
 }
 
 func (s *Schema) encodeKeyFromRow(row Row) []byte {
-	return row[s.primaryKeyIndex].encode(nil)
+	var buf []byte
+	for _, c := range s.primaryKey {
+		buf = row[c].encode(buf)
+	}
+	return buf
 }
 
+// encodeKeyFromValue encodes a key argument, which may be a prefix of the
+// primary key.
 func (s *Schema) encodeKeyFromValue(v Value) []byte {
-	return v.encode(nil)
+	return keyValues(v).encode(nil)
 }
 
-func (s *Schema) decodeKey(buf []byte) (Value, error) {
-	pkType := s.columns[s.primaryKeyIndex].Type
-	v, n, err := decodeValue(buf, pkType)
-	if err != nil {
-		return nil, err
+func (s *Schema) decodeKey(buf []byte) (Key, error) {
+	return s.decodeKeyWith(decodeValue, buf)
+}
+
+// decodeKeyWith is decodeKey for a key encoded in the format read by
+// decode.
+func (s *Schema) decodeKeyWith(decode valueDecoder, buf []byte) (Key, error) {
+	key := make(Key, len(s.primaryKey))
+	offset := 0
+	for i, c := range s.primaryKey {
+		v, n, err := decode(buf[offset:], s.columns[c].Type)
+		if err != nil {
+			return nil, err
+		}
+		key[i] = v
+		offset += n
 	}
-	if n != len(buf) {
-		return nil, fmt.Errorf("decode key: %d trailing bytes after consuming %d", len(buf)-n, n)
+	if offset != len(buf) {
+		return nil, fmt.Errorf("decode key: %d trailing bytes after consuming %d", len(buf)-offset, offset)
 	}
-	return v, nil
+	return key, nil
 }
 
-// maxSchemaByteField bounds the column count, primary key index, and each
+// maxSchemaByteField bounds the column count, primary key indexes, and each
 // column name length. The on-disk schema layout encodes these fields in a
 // single byte each.
 const maxSchemaByteField = 255
 
-// NewSchema constructs a Schema, validating that columns are non-empty,
-// names are unique, the primary key index is in range, and that all fields
-// fit within the on-disk schema encoding limits.
+// NewSchema constructs a Schema whose primary key is the single column at
+// pkIndex. See [NewCompositeSchema] for the validation it performs.
 func NewSchema(pkIndex int, columns []Column) (*Schema, error) {
+	return NewCompositeSchema([]int{pkIndex}, columns)
+}
+
+// NewCompositeSchema constructs a Schema whose primary key is made of the
+// columns at pkIndexes, in key order: rows are ordered by the first of them,
+// then by the second, and so on. It validates that columns are non-empty,
+// names are unique, the primary key indexes are in range and distinct, and
+// that all fields fit within the on-disk schema encoding limits.
+func NewCompositeSchema(pkIndexes []int, columns []Column) (*Schema, error) {
 	if len(columns) == 0 {
 		return nil, fmt.Errorf("create schema: must have at least one column")
 	}
 	if len(columns) > maxSchemaByteField {
 		return nil, fmt.Errorf("create schema: too many columns: %d (max %d)", len(columns), maxSchemaByteField)
 	}
-	if pkIndex < 0 || pkIndex >= len(columns) {
-		return nil, fmt.Errorf("create schema: primary key index %d out of range (%d columns)", pkIndex, len(columns))
+	if len(pkIndexes) == 0 {
+		return nil, fmt.Errorf("create schema: primary key must have at least one column")
+	}
+	for i, pkIndex := range pkIndexes {
+		if pkIndex < 0 || pkIndex >= len(columns) {
+			return nil, fmt.Errorf("create schema: primary key index %d out of range (%d columns)", pkIndex, len(columns))
+		}
+		if slices.Contains(pkIndexes[:i], pkIndex) {
+			return nil, fmt.Errorf("create schema: primary key lists column %d more than once", pkIndex)
+		}
+		if columns[pkIndex].Nullable {
+			return nil, fmt.Errorf("create schema: primary key column %q cannot be nullable", columns[pkIndex].Name)
+		}
 	}
 
 	seen := make(map[string]struct{}, len(columns))
@@ -300,44 +717,187 @@ func NewSchema(pkIndex int, columns []Column) (*Schema, error) {
 		}
 		seen[column.Name] = struct{}{}
 	}
-	return &Schema{columns: columns, primaryKeyIndex: pkIndex}, nil
+	stored := make([]storedColumn, len(columns))
+	for i, column := range columns {
+		stored[i] = storedColumn{typ: column.Type, column: i}
+	}
+	return &Schema{columns: columns, primaryKey: slices.Clone(pkIndexes), stored: stored}, nil
 }
 
+// clone returns a deep copy of the schema, for an alteration to work on.
+func (s *Schema) clone() *Schema {
+	return &Schema{
+		columns:    slices.Clone(s.columns),
+		primaryKey: slices.Clone(s.primaryKey),
+		stored:     slices.Clone(s.stored),
+		version:    s.version,
+	}
+}
+
+// nullableTag marks a nullable column in its marshaled type tag. Type tags
+// stay below it.
+const nullableTag = 0x80
+
+// Flags of a stored column in the marshaled schema.
+const (
+	storedDropped    = 0x01
+	storedHasDefault = 0x02
+	storedNullDef    = 0x04 // the default is Null; no value follows
+)
+
 // marshal serializes the schema into its binary on-disk representation.
 //
 // Layout:
-// [1 byte]  column count
-// [1 byte]  primary key index
-// per column:
-// - [1 byte]  type tag
-// - [1 byte]  name length (N)
+// [1 byte]  0, marking this layout; the original one (see
+// unmarshalSchemaV1) starts with a column count, which is never 0
+// [1 byte]  stored column count
+// per stored column, in row encoding order:
+// - [1 byte]  type tag, with the high bit set if the column is nullable
+// - [1 byte]  flags: dropped, has a default, default is Null
+// - [1 byte]  name length (N), 0 if dropped
 // - [N bytes] name
+// - the default value, in its key encoding, if it has one that is not Null
+// [1 byte]  primary key column count (K)
+// [K bytes] primary key indexes, in key order
+//
+// The columns are the stored columns that were not dropped, in order.
 //
-// Panics if any single-byte field would overflow. NewSchema is the only
-// supported constructor and rejects schemas that violate these invariants,
-// so reaching the panic means a Schema was constructed by some other path.
+// Panics if any single-byte field would overflow. NewSchema and the
+// alteration methods on Table reject schemas that violate these
+// invariants, so reaching the panic means a Schema was constructed by some
+// other path.
 func (s *Schema) marshal() []byte {
-	if len(s.columns) > maxSchemaByteField {
-		panic(fmt.Sprintf("schema: too many columns to marshal: %d", len(s.columns)))
+	if len(s.stored) > maxSchemaByteField {
+		panic(fmt.Sprintf("schema: too many columns to marshal: %d", len(s.stored)))
 	}
-	if s.primaryKeyIndex < 0 || s.primaryKeyIndex > maxSchemaByteField {
-		panic(fmt.Sprintf("schema: primary key index out of single-byte range: %d", s.primaryKeyIndex))
+	for _, pkIndex := range s.primaryKey {
+		if pkIndex < 0 || pkIndex > maxSchemaByteField {
+			panic(fmt.Sprintf("schema: primary key index out of single-byte range: %d", pkIndex))
+		}
 	}
-	var buf []byte
-	buf = append(buf, byte(len(s.columns)))
-	buf = append(buf, byte(s.primaryKeyIndex))
-	for i := range s.columns {
-		if len(s.columns[i].Name) > maxSchemaByteField {
-			panic(fmt.Sprintf("schema: column %d name too long to marshal: %d bytes", i, len(s.columns[i].Name)))
+	buf := []byte{0, byte(len(s.stored))}
+	for _, sc := range s.stored {
+		if sc.column < 0 {
+			buf = append(buf, byte(sc.typ), storedDropped, 0)
+			continue
+		}
+		column := s.columns[sc.column]
+		if len(column.Name) > maxSchemaByteField {
+			panic(fmt.Sprintf("schema: column %d name too long to marshal: %d bytes", sc.column, len(column.Name)))
+		}
+		tag := byte(column.Type)
+		if column.Nullable {
+			tag |= nullableTag
 		}
-		buf = append(buf, byte(s.columns[i].Type))
-		buf = append(buf, byte(len(s.columns[i].Name)))
-		buf = append(buf, s.columns[i].Name...)
+		var flags byte
+		switch sc.def.(type) {
+		case nil:
+		case NullValue:
+			flags = storedHasDefault | storedNullDef
+		default:
+			flags = storedHasDefault
+		}
+		buf = append(buf, tag, flags, byte(len(column.Name)))
+		buf = append(buf, column.Name...)
+		if sc.def != nil {
+			buf = sc.def.encode(buf)
+		}
+	}
+	buf = append(buf, byte(len(s.primaryKey)))
+	for _, pkIndex := range s.primaryKey {
+		buf = append(buf, byte(pkIndex))
 	}
 	return buf
 }
 
 func unmarshalSchema(data []byte) (*Schema, error) {
+	if len(data) > 0 && data[0] != 0 {
+		return unmarshalSchemaV1(data)
+	}
+	if len(data) < 2 {
+		return nil, fmt.Errorf("unmarshal schema: header truncated: got %d bytes, need 2", len(data))
+	}
+	storedCount := int(data[1])
+	offset := 2
+
+	var columns []Column
+	stored := make([]storedColumn, storedCount)
+	for j := range storedCount {
+		if len(data)-offset < 3 {
+			return nil, fmt.Errorf("unmarshal schema: column %d header truncated", j)
+		}
+		typ := ColType(data[offset] &^ nullableTag)
+		nullable := data[offset]&nullableTag != 0
+		flags := data[offset+1]
+		nameLen := int(data[offset+2])
+		offset += 3
+
+		if len(data)-offset < nameLen {
+			return nil, fmt.Errorf("unmarshal schema: column %d name truncated: need %d bytes, have %d", j, nameLen, len(data)-offset)
+		}
+		name := string(data[offset : offset+nameLen])
+		offset += nameLen
+
+		stored[j] = storedColumn{typ: typ, column: -1}
+		if flags&storedDropped != 0 {
+			continue
+		}
+		stored[j].column = len(columns)
+		columns = append(columns, Column{Name: name, Type: typ, Nullable: nullable})
+		switch {
+		case flags&storedNullDef != 0:
+			stored[j].def = Null
+		case flags&storedHasDefault != 0:
+			def, n, err := decodeValue(data[offset:], typ)
+			if err != nil {
+				return nil, fmt.Errorf("unmarshal schema: column %q default: %w", name, err)
+			}
+			stored[j].def = def
+			offset += n
+		}
+	}
+
+	if len(data)-offset < 1 {
+		return nil, fmt.Errorf("unmarshal schema: primary key truncated")
+	}
+	pkCount := int(data[offset])
+	offset++
+	if len(data)-offset < pkCount {
+		return nil, fmt.Errorf("unmarshal schema: primary key truncated: need %d bytes, have %d", pkCount, len(data)-offset)
+	}
+	pkIndexes := make([]int, pkCount)
+	for i := range pkCount {
+		pkIndexes[i] = int(data[offset+i])
+	}
+	offset += pkCount
+
+	if offset != len(data) {
+		return nil, fmt.Errorf("unmarshal schema: %d trailing bytes after consuming %d", len(data)-offset, offset)
+	}
+
+	s, err := NewCompositeSchema(pkIndexes, columns)
+	if err != nil {
+		return nil, err
+	}
+	s.stored = stored
+	return s, nil
+}
+
+// unmarshalSchemaV1 reads the layout schemas were marshaled in before
+// columns could be altered:
+// [1 byte]  column count
+// [1 byte]  primary key index (the first one, if the key is composite)
+// per column:
+// - [1 byte]  type tag, with the high bit set if the column is nullable
+// - [1 byte]  name length (N)
+// - [N bytes] name
+//
+// followed, only if the primary key is composite, by:
+// [1 byte]  primary key column count (K)
+// [K bytes] primary key indexes, in key order
+//
+// Schemas written before composite keys existed end after the columns.
+func unmarshalSchemaV1(data []byte) (*Schema, error) {
 	if len(data) < 2 {
 		return nil, fmt.Errorf("unmarshal schema: header truncated: got %d bytes, need 2", len(data))
 	}
@@ -350,7 +910,8 @@ func unmarshalSchema(data []byte) (*Schema, error) {
 		if len(data)-offset < 2 {
 			return nil, fmt.Errorf("unmarshal schema: column %d header truncated", i)
 		}
-		columns[i].Type = ColType(data[offset])
+		columns[i].Type = ColType(data[offset] &^ nullableTag)
+		columns[i].Nullable = data[offset]&nullableTag != 0
 		nameLen := int(data[offset+1])
 		offset += 2
 
@@ -361,9 +922,26 @@ func unmarshalSchema(data []byte) (*Schema, error) {
 		offset += nameLen
 	}
 
+	pkIndexes := []int{pkIndex}
+	if offset < len(data) {
+		pkCount := int(data[offset])
+		offset++
+		if len(data)-offset < pkCount {
+			return nil, fmt.Errorf("unmarshal schema: primary key truncated: need %d bytes, have %d", pkCount, len(data)-offset)
+		}
+		pkIndexes = make([]int, pkCount)
+		for i := range pkCount {
+			pkIndexes[i] = int(data[offset+i])
+		}
+		offset += pkCount
+		if pkCount == 0 || pkIndexes[0] != pkIndex {
+			return nil, fmt.Errorf("unmarshal schema: primary key list does not start with index %d", pkIndex)
+		}
+	}
+
 	if offset != len(data) {
 		return nil, fmt.Errorf("unmarshal schema: %d trailing bytes after consuming %d", len(data)-offset, offset)
 	}
 
-	return NewSchema(pkIndex, columns)
+	return NewCompositeSchema(pkIndexes, columns)
 }
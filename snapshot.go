@@ -0,0 +1,121 @@
+package toydb
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/guiwoch/toyDB/internal/storage/btree"
+	"github.com/guiwoch/toyDB/internal/storage/catalog"
+	"github.com/guiwoch/toyDB/internal/storage/pager"
+)
+
+// ErrSnapshotClosed is returned by operations on a snapshot, or on a table
+// handle obtained from it, after Close.
+var ErrSnapshotClosed = errors.New("snapshot is closed")
+
+// ErrReadOnly is returned by writes through a table handle obtained from a
+// [Snapshot].
+var ErrReadOnly = errors.New("table handle is read-only")
+
+// Snapshot is a read-only transaction: a consistent view of every table as
+// of the last commit before [DB.Snapshot] was called. Writes committed
+// afterwards, and the changes of any open [Tx], are invisible to it, and it
+// never blocks writers. Obtain table handles with [Snapshot.Table].
+//
+// The page versions a snapshot reads are kept until it is closed, so
+// long-lived snapshots delay reclaiming log space. A Snapshot is safe for
+// concurrent use.
+type Snapshot struct {
+	db      *DB
+	pages   *pager.Snapshot
+	catalog *catalog.Catalog
+	// metas holds the committed metadata of the tables open in the DB,
+	// which may be ahead of their catalog rows.
+	metas map[string]tableMeta
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// Snapshot opens a read-only view of the last commit. It must be closed.
+func (d *DB) Snapshot() (*Snapshot, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	ps := d.pager.Snapshot()
+	metas := make(map[string]tableMeta, len(d.open))
+	for _, t := range d.open {
+		if t.savedName != "" {
+			metas[t.savedName] = t.savedMeta
+		}
+	}
+	return &Snapshot{
+		db:      d,
+		pages:   ps,
+		catalog: catalog.Open(btree.OpenSnapshot(ps, d.header.catalogRootID)),
+		metas:   metas,
+	}, nil
+}
+
+// Table returns a read-only handle to the named table as of the snapshot.
+// Its writes return ErrReadOnly. Returns ErrTableNotFound if the table did
+// not exist when the snapshot was taken.
+func (s *Snapshot) Table(name string) (*Table, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return nil, ErrSnapshotClosed
+	}
+	row, ok, err := s.catalog.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrTableNotFound
+	}
+	schema, err := tableSchema(row)
+	if err != nil {
+		return nil, err
+	}
+	indexes, err := openIndexes(row, func(rootID uint32) (*btree.Btree, error) {
+		return btree.OpenSnapshot(s.pages, rootID), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	meta := metaFromRow(row)
+	if m, ok := s.metas[name]; ok {
+		meta = &m
+	}
+	return &Table{
+		db:      s.db,
+		name:    name,
+		schema:  schema,
+		tree:    btree.OpenSnapshot(s.pages, row.RootID),
+		snap:    s,
+		indexes: indexes,
+		meta:    meta,
+	}, nil
+}
+
+// Tables returns the names of the tables in the snapshot, in unspecified
+// order.
+func (s *Snapshot) Tables() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return nil, ErrSnapshotClosed
+	}
+	return s.catalog.Names()
+}
+
+// Close releases the snapshot, letting the DB reclaim the page versions it
+// was keeping alive. Returns ErrSnapshotClosed if already closed.
+func (s *Snapshot) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrSnapshotClosed
+	}
+	s.closed = true
+	return s.pages.Close()
+}
@@ -0,0 +1,415 @@
+// Package sqldriver registers toyDB with database/sql under the driver name
+// "toydb", running statements through package query:
+//
+//	import _ "github.com/guiwoch/toyDB/sqldriver"
+//
+//	db, err := sql.Open("toydb", "example.tdb")
+//	rows, err := db.Query("SELECT id, name FROM users WHERE id > ?", 10)
+//
+// The data source name is the path of the database file, optionally
+// followed by ?cache=N to set the buffer pool size in pages. Every
+// connection of a sql.DB shares one toydb.DB, opened with the first
+// connection and closed by sql.DB.Close; use [NewConnector] with
+// sql.OpenDB to run SQL on a toydb.DB opened elsewhere.
+//
+// Arguments may be any type database/sql converts to a driver.Value, or a
+// toydb.Value such as a toydb.DecimalValue, which is passed through as is.
+// Integer, text, bool, timestamp, float and bytes columns scan into the
+// matching Go types (int64, string, bool, time.Time, float64, []byte);
+// decimal, UUID and JSON columns scan as strings.
+//
+// A toydb transaction locks out every other writer until it ends, so a
+// connection inside a transaction makes writes through the pool's other
+// connections wait, as for [toydb.Tx].
+package sqldriver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	toydb "github.com/guiwoch/toyDB"
+	"github.com/guiwoch/toyDB/query"
+)
+
+func init() {
+	sql.Register("toydb", &Driver{})
+}
+
+// Driver is the database/sql driver registered as "toydb".
+type Driver struct{}
+
+// Open opens a connection with a toydb.DB of its own, closed with the
+// connection. database/sql calls [Driver.OpenConnector] instead, whose
+// connections share one.
+func (d *Driver) Open(name string) (driver.Conn, error) {
+	c, err := d.OpenConnector(name)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := c.Connect(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	conn.(*Conn).close = c.(io.Closer).Close
+	return conn, nil
+}
+
+// OpenConnector parses a data source name without opening the file.
+func (d *Driver) OpenConnector(name string) (driver.Connector, error) {
+	path, params, _ := strings.Cut(name, "?")
+	var opts []toydb.Option
+	for param := range strings.SplitSeq(params, "&") {
+		if param == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(param, "=")
+		switch key {
+		case "cache":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("toydb: bad cache size %q", value)
+			}
+			opts = append(opts, toydb.WithCacheSize(n))
+		default:
+			return nil, fmt.Errorf("toydb: unknown parameter %q", key)
+		}
+	}
+	return &fileConnector{driver: d, path: path, opts: opts}, nil
+}
+
+// fileConnector opens a database file with its first connection and
+// shares it with the rest until it is closed.
+type fileConnector struct {
+	driver *Driver
+	path   string
+	opts   []toydb.Option
+
+	mu sync.Mutex
+	db *toydb.DB
+}
+
+func (c *fileConnector) Connect(context.Context) (driver.Conn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.db == nil {
+		db, err := toydb.Open(c.path, c.opts...)
+		if err != nil {
+			return nil, err
+		}
+		c.db = db
+	}
+	return newConn(c.db), nil
+}
+
+func (c *fileConnector) Driver() driver.Driver { return c.driver }
+
+// Close closes the database file. database/sql calls it from sql.DB.Close,
+// after closing every connection.
+func (c *fileConnector) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.db == nil {
+		return nil
+	}
+	err := c.db.Close()
+	c.db = nil
+	return err
+}
+
+// NewConnector returns a connector whose connections run statements on d,
+// for use with sql.OpenDB. Closing the sql.DB leaves d open.
+func NewConnector(d *toydb.DB) driver.Connector {
+	return dbConnector{d}
+}
+
+type dbConnector struct {
+	db *toydb.DB
+}
+
+func (c dbConnector) Connect(context.Context) (driver.Conn, error) { return newConn(c.db), nil }
+func (c dbConnector) Driver() driver.Driver                        { return &Driver{} }
+
+// Conn is a connection: a [query.Session] on the shared toydb.DB.
+type Conn struct {
+	session *query.Session
+	close   func() error // closes a DB owned by the connection
+}
+
+var (
+	_ driver.Conn              = (*Conn)(nil)
+	_ driver.ConnBeginTx       = (*Conn)(nil)
+	_ driver.ExecerContext     = (*Conn)(nil)
+	_ driver.QueryerContext    = (*Conn)(nil)
+	_ driver.NamedValueChecker = (*Conn)(nil)
+	_ driver.Validator         = (*Conn)(nil)
+)
+
+func newConn(d *toydb.DB) *Conn {
+	return &Conn{session: query.NewSession(d)}
+}
+
+// Prepare parses a statement to run later.
+func (c *Conn) Prepare(src string) (driver.Stmt, error) {
+	stmt, err := query.Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	return &Stmt{conn: c, stmt: stmt}, nil
+}
+
+// Close rolls back the connection's open transaction, if any.
+func (c *Conn) Close() error {
+	err := c.session.Close()
+	if c.close != nil {
+		err = errors.Join(err, c.close())
+	}
+	return err
+}
+
+// Begin starts a transaction.
+//
+// Deprecated: Use [Conn.BeginTx]; database/sql does.
+func (c *Conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx starts a transaction. toyDB transactions are serializable, so
+// the default isolation level and any level up to serializable are
+// accepted.
+func (c *Conn) BeginTx(_ context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if opts.Isolation > driver.IsolationLevel(sql.LevelSerializable) {
+		return nil, fmt.Errorf("toydb: unsupported isolation level %v", sql.IsolationLevel(opts.Isolation))
+	}
+	if _, err := c.session.ExecStmt(&query.Begin{}); err != nil {
+		return nil, err
+	}
+	return tx{c}, nil
+}
+
+func (c *Conn) ExecContext(_ context.Context, src string, args []driver.NamedValue) (driver.Result, error) {
+	stmt, err := query.Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	return c.exec(stmt, args)
+}
+
+func (c *Conn) QueryContext(_ context.Context, src string, args []driver.NamedValue) (driver.Rows, error) {
+	stmt, err := query.Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	return c.query(stmt, args)
+}
+
+// CheckNamedValue passes toydb.Value arguments through unconverted and
+// leaves the rest to database/sql's default conversion.
+func (c *Conn) CheckNamedValue(nv *driver.NamedValue) error {
+	if _, ok := nv.Value.(toydb.Value); ok {
+		return nil
+	}
+	return driver.ErrSkip
+}
+
+// IsValid reports whether the connection can be reused: it can unless it
+// has been left inside a transaction.
+func (c *Conn) IsValid() bool {
+	return !c.session.InTx()
+}
+
+func (c *Conn) exec(stmt query.Stmt, args []driver.NamedValue) (driver.Result, error) {
+	values, err := toValues(args)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.session.ExecStmt(stmt, values...)
+	if err != nil {
+		return nil, err
+	}
+	if res.Rows != nil {
+		// A SELECT run through Exec reads nothing.
+		return driver.RowsAffected(0), nil
+	}
+	return driver.RowsAffected(res.RowsAffected), nil
+}
+
+func (c *Conn) query(stmt query.Stmt, args []driver.NamedValue) (driver.Rows, error) {
+	values, err := toValues(args)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.session.ExecStmt(stmt, values...)
+	if err != nil {
+		return nil, err
+	}
+	seq := res.Rows
+	if seq == nil {
+		seq = func(func(toydb.Row, error) bool) {}
+	}
+	next, stop := iter.Pull2(seq)
+	return &Rows{columns: res.Columns, next: next, stop: stop}, nil
+}
+
+// toValues converts statement arguments to toydb values. Placeholders are
+// positional, so named arguments are rejected.
+func toValues(args []driver.NamedValue) ([]toydb.Value, error) {
+	values := make([]toydb.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, fmt.Errorf("toydb: named argument %q: placeholders are positional", arg.Name)
+		}
+		v, err := toValue(arg.Value)
+		if err != nil {
+			return nil, fmt.Errorf("toydb: argument %d: %w", arg.Ordinal, err)
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+func toValue(v driver.Value) (toydb.Value, error) {
+	switch v := v.(type) {
+	case nil:
+		return toydb.Null, nil
+	case int64:
+		return toydb.IntValue(v), nil
+	case float64:
+		return toydb.FloatValue(v), nil
+	case bool:
+		return toydb.BoolValue(v), nil
+	case []byte:
+		return toydb.BytesValue(v), nil
+	case string:
+		return toydb.TextValue(v), nil
+	case time.Time:
+		return toydb.TimestampValue(v.UnixNano()), nil
+	case toydb.Value:
+		return v, nil
+	}
+	return nil, fmt.Errorf("unsupported type %T", v)
+}
+
+// fromValue converts a toydb value to a driver.Value.
+func fromValue(v toydb.Value) driver.Value {
+	switch v := v.(type) {
+	case toydb.IntValue:
+		return int64(v)
+	case toydb.TextValue:
+		return string(v)
+	case toydb.BoolValue:
+		return bool(v)
+	case toydb.TimestampValue:
+		return v.Time()
+	case toydb.FloatValue:
+		return float64(v)
+	case toydb.BytesValue:
+		return []byte(v)
+	case toydb.JSONValue:
+		return string(v)
+	case toydb.DecimalValue, toydb.UUIDValue:
+		return v.(fmt.Stringer).String()
+	}
+	return nil
+}
+
+// Stmt is a prepared statement.
+type Stmt struct {
+	conn *Conn
+	stmt query.Stmt
+}
+
+var (
+	_ driver.StmtExecContext  = (*Stmt)(nil)
+	_ driver.StmtQueryContext = (*Stmt)(nil)
+)
+
+// Close does nothing: a prepared statement holds no resources.
+func (s *Stmt) Close() error { return nil }
+
+// NumInput returns -1: the number of placeholders is not checked before
+// the statement runs, which fails if it is given too few arguments.
+func (s *Stmt) NumInput() int { return -1 }
+
+// Exec runs the statement.
+//
+// Deprecated: Use [Stmt.ExecContext]; database/sql does.
+func (s *Stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.exec(s.stmt, named(args))
+}
+
+// Query runs the statement and returns its rows.
+//
+// Deprecated: Use [Stmt.QueryContext]; database/sql does.
+func (s *Stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.query(s.stmt, named(args))
+}
+
+func (s *Stmt) ExecContext(_ context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.conn.exec(s.stmt, args)
+}
+
+func (s *Stmt) QueryContext(_ context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.conn.query(s.stmt, args)
+}
+
+func named(args []driver.Value) []driver.NamedValue {
+	nv := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		nv[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return nv
+}
+
+// Rows reads the rows of a query as database/sql asks for them.
+type Rows struct {
+	columns []string
+	next    func() (toydb.Row, error, bool)
+	stop    func()
+}
+
+func (r *Rows) Columns() []string { return r.columns }
+
+// Close stops reading rows.
+func (r *Rows) Close() error {
+	r.stop()
+	return nil
+}
+
+// Next reads the next row into dest, or returns io.EOF after the last.
+func (r *Rows) Next(dest []driver.Value) error {
+	row, err, ok := r.next()
+	if !ok {
+		return io.EOF
+	}
+	if err != nil {
+		return err
+	}
+	for i, v := range row {
+		dest[i] = fromValue(v)
+	}
+	return nil
+}
+
+// tx ends the transaction its connection's session has open.
+type tx struct {
+	conn *Conn
+}
+
+func (t tx) Commit() error {
+	_, err := t.conn.session.ExecStmt(&query.Commit{})
+	return err
+}
+
+func (t tx) Rollback() error {
+	_, err := t.conn.session.ExecStmt(&query.Rollback{})
+	return err
+}
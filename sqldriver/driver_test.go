@@ -0,0 +1,188 @@
+package sqldriver_test
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	toydb "github.com/guiwoch/toyDB"
+	"github.com/guiwoch/toyDB/sqldriver"
+)
+
+// openTestDB opens a database/sql handle on a fresh file, closed on test
+// cleanup.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("toydb", t.TempDir()+"/test.db?cache=64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func TestDriverRoundTrip(t *testing.T) {
+	t.Parallel()
+	db := openTestDB(t)
+	if _, err := db.Exec(`CREATE TABLE users (
+		id INT PRIMARY KEY, name TEXT NOT NULL, admin BOOL, joined TIMESTAMP,
+		score FLOAT, avatar BYTES, balance DECIMAL)`); err != nil {
+		t.Fatal(err)
+	}
+
+	joined := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	res, err := db.Exec("INSERT INTO users VALUES (?, ?, ?, ?, ?, ?, ?), (2, 'bob', NULL, NULL, NULL, NULL, NULL)",
+		1, "ann", true, joined, 4.5, []byte{1, 2}, toydb.DecimalValue(12_340_000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 2 {
+		t.Fatalf("RowsAffected = %d, %v; want 2", n, err)
+	}
+
+	var (
+		id      int64
+		name    string
+		admin   sql.NullBool
+		at      sql.NullTime
+		score   sql.NullFloat64
+		avatar  []byte
+		balance sql.NullString
+	)
+	row := db.QueryRow("SELECT id, name, admin, joined, score, avatar, balance FROM users WHERE id = ?", 1)
+	if err := row.Scan(&id, &name, &admin, &at, &score, &avatar, &balance); err != nil {
+		t.Fatal(err)
+	}
+	if id != 1 || name != "ann" || !admin.Bool || !at.Time.Equal(joined) || score.Float64 != 4.5 ||
+		string(avatar) != "\x01\x02" || balance.String != "12.34" {
+		t.Fatalf("got %v %v %v %v %v %v %v", id, name, admin, at, score, avatar, balance)
+	}
+	row = db.QueryRow("SELECT admin, joined, balance FROM users WHERE id = 2")
+	if err := row.Scan(&admin, &at, &balance); err != nil {
+		t.Fatal(err)
+	}
+	if admin.Valid || at.Valid || balance.Valid {
+		t.Fatalf("nulls read back as %v %v %v", admin, at, balance)
+	}
+
+	rows, err := db.Query("SELECT name FROM users ORDER BY id DESC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for rows.Next() {
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != "bob" || names[1] != "ann" {
+		t.Fatalf("names = %q", names)
+	}
+
+	if err := db.QueryRow("SELECT name FROM users WHERE id = 3").Scan(&name); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("missing row: got %v, want sql.ErrNoRows", err)
+	}
+	if _, err := db.Exec("SELEC 1"); err == nil {
+		t.Fatal("bad statement: no error")
+	}
+}
+
+func TestDriverPrepared(t *testing.T) {
+	t.Parallel()
+	db := openTestDB(t)
+	if _, err := db.Exec("CREATE TABLE kv (k TEXT PRIMARY KEY, v INT)"); err != nil {
+		t.Fatal(err)
+	}
+	insert, err := db.Prepare("INSERT INTO kv VALUES (?, ?)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer insert.Close()
+	for i, k := range []string{"a", "b", "c"} {
+		if _, err := insert.Exec(k, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := insert.Exec("a", 9); !errors.Is(err, toydb.ErrDuplicateKey) {
+		t.Fatalf("duplicate insert: got %v, want ErrDuplicateKey", err)
+	}
+	var sum int64
+	if err := db.QueryRow("SELECT v FROM kv WHERE k > ? AND k <= ? ORDER BY k DESC LIMIT 1", "a", "c").Scan(&sum); err != nil || sum != 2 {
+		t.Fatalf("got %d, %v; want 2", sum, err)
+	}
+}
+
+func TestDriverTx(t *testing.T) {
+	t.Parallel()
+	db := openTestDB(t)
+	if _, err := db.Exec("CREATE TABLE kv (k TEXT PRIMARY KEY, v INT)"); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("INSERT INTO kv VALUES ('a', 1)"); err != nil {
+		t.Fatal(err)
+	}
+	var v int64
+	if err := tx.QueryRow("SELECT v FROM kv WHERE k = 'a'").Scan(&v); err != nil || v != 1 {
+		t.Fatalf("inside transaction: got %d, %v", v, err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow("SELECT v FROM kv WHERE k = 'a'").Scan(&v); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("after rollback: got %v, want sql.ErrNoRows", err)
+	}
+
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("INSERT INTO kv VALUES ('b', 2)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow("SELECT v FROM kv WHERE k = 'b'").Scan(&v); err != nil || v != 2 {
+		t.Fatalf("after commit: got %d, %v", v, err)
+	}
+}
+
+func TestNewConnector(t *testing.T) {
+	t.Parallel()
+	d, err := toydb.Open(t.TempDir() + "/test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	db := sql.OpenDB(sqldriver.NewConnector(d))
+	if _, err := db.Exec("CREATE TABLE kv (k TEXT PRIMARY KEY, v INT)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO kv VALUES ('a', 1)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The DB stays open and sees the writes.
+	kv, err := d.OpenTable("kv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	row, err := kv.Get(toydb.TextValue("a"))
+	if err != nil || row[1] != toydb.IntValue(1) {
+		t.Fatalf("got %v, %v", row, err)
+	}
+}
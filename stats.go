@@ -0,0 +1,134 @@
+package toydb
+
+import (
+	"github.com/guiwoch/toyDB/internal/storage/btree"
+)
+
+// TableStats describes the size of a table and its indexes, for estimating
+// what reading them costs.
+type TableStats struct {
+	Rows int64
+	// Pages is the number of leaf pages holding the rows, and Height the
+	// number of pages a lookup by primary key reads.
+	Pages   int
+	Height  int
+	Indexes []IndexStats
+}
+
+// IndexStats describes the size of a secondary index. Reading a row through
+// it costs a lookup in the index and another in the table.
+type IndexStats struct {
+	Name    string
+	Columns []string
+	Pages   int
+	Height  int
+}
+
+// Stats measures the table and its indexes. It reads only the trees'
+// internal pages, a small fraction of each, and reads a snapshot in the
+// same way as [Table.Scan].
+func (t *Table) Stats() (TableStats, error) {
+	v, release, err := t.read()
+	if err != nil {
+		return TableStats{}, err
+	}
+	defer release()
+
+	tree, err := v.tree.Stats()
+	if err != nil {
+		return TableStats{}, err
+	}
+	s := TableStats{Rows: t.rows(), Pages: tree.LeafPages, Height: tree.Height}
+	for _, idx := range v.indexes {
+		is, err := idx.tree.Stats()
+		if err != nil {
+			return TableStats{}, err
+		}
+		columns := make([]string, len(idx.columns))
+		for i, c := range idx.columns {
+			columns[i] = v.schema.columns[c].Name
+		}
+		s.Indexes = append(s.Indexes, IndexStats{Name: idx.name, Columns: columns, Pages: is.LeafPages, Height: is.Height})
+	}
+	return s, nil
+}
+
+// rows returns the table's live row count.
+func (t *Table) rows() int64 {
+	if t.snap == nil && t.tx == nil {
+		t.db.mu.RLock()
+		defer t.db.mu.RUnlock()
+	}
+	return t.meta.rows
+}
+
+// EstimateRange estimates the share of the table's rows, between 0 and 1,
+// with primary keys from lo to hi, both included. A bound holding a prefix
+// of a composite key compares rows on that many leading key columns, so
+// EstimateRange(prefix, prefix) estimates the rows starting with prefix. A
+// nil bound is unbounded on that side. The estimate comes from the shape of
+// the table's tree rather than its rows, reading as many pages as two
+// calls to [Table.Get]. Returns ErrKeyTypeMismatch if a bound value's type
+// does not match its column type.
+func (t *Table) EstimateRange(lo, hi Value) (float64, error) {
+	v, release, err := t.read()
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	var loKey, hiKey []byte
+	if lo != nil {
+		if _, err := v.schema.validateKey(lo); err != nil {
+			return 0, err
+		}
+		loKey = v.schema.encodeKeyFromValue(lo)
+	}
+	if hi != nil {
+		if _, err := v.schema.validateKey(hi); err != nil {
+			return 0, err
+		}
+		hiKey = v.schema.encodeKeyFromValue(hi)
+	}
+	return estimateRange(v.tree, loKey, hiKey)
+}
+
+// EstimateIndexRange is [Table.EstimateRange] for the named index: it
+// estimates the share of the table's rows whose leading indexed columns are
+// from lo to hi, both included, with bounds given as for
+// [Table.ScanIndex]. Returns ErrIndexNotFound if the table has no such
+// index.
+func (t *Table) EstimateIndexRange(indexName string, lo, hi []Value) (float64, error) {
+	v, release, err := t.read()
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	idx, err := v.index(indexName)
+	if err != nil {
+		return 0, err
+	}
+
+	var loKey, hiKey []byte
+	if lo != nil {
+		if loKey, err = idx.prefix(v.schema, lo); err != nil {
+			return 0, err
+		}
+	}
+	if hi != nil {
+		if hiKey, err = idx.prefix(v.schema, hi); err != nil {
+			return 0, err
+		}
+	}
+	return estimateRange(idx.tree, loKey, hiKey)
+}
+
+// estimateRange estimates the share of tree's records from the encoded
+// bounds lo to hi, including every key that starts with hi.
+func estimateRange(tree *btree.Btree, lo, hi []byte) (float64, error) {
+	if hi != nil {
+		// prefixEnd returns nil, unbounded, when no key sorts after hi's.
+		hi = prefixEnd(hi)
+	}
+	return tree.EstimateRange(lo, hi)
+}
@@ -1,119 +1,513 @@
 package toydb
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"iter"
+	"slices"
 
 	"github.com/guiwoch/toyDB/internal/storage/btree"
+	"github.com/guiwoch/toyDB/internal/storage/catalog"
 )
 
 // Table is a handle to one user table inside a [DB]. Obtain a Table with
-// [DB.CreateTable] or [DB.OpenTable]. A Table is valid until the parent
+// [DB.CreateTable] or [DB.OpenTable], whose writes each commit on their
+// own and whose reads see the last commit; with [Tx.Table], whose writes
+// belong to the transaction and whose reads see them; or with
+// [Snapshot.Table], which is read-only. A Table is valid until the parent
 // DB is closed.
 type Table struct {
+	db     *DB
 	name   string
 	schema *Schema
 	tree   *btree.Btree
+	tx     *Tx       // nil unless the handle was obtained from Tx.Table
+	snap   *Snapshot // nil unless the handle was obtained from Snapshot.Table
+
+	// indexes are the table's secondary indexes, in creation order.
+	indexes []*index
+
+	meta *tableMeta
+
+	// savedRootID is the root recorded in the catalog as of the last
+	// commit; DB.commit re-upserts the table when the tree's root, or an
+	// index's, moves.
+	savedRootID uint32
+	// savedName is the table's name as of the last commit, which DB.rollback
+	// restores after a RenameTable; it is empty until a new table is
+	// committed.
+	savedName string
+	// savedMeta is the table's metadata as of the last commit, which
+	// DB.rollback restores. The catalog may hold older row counts; see
+	// tableMeta.touched.
+	savedMeta tableMeta
+}
+
+// catalogRow returns the table's catalog entry as of its current roots.
+func (t *Table) catalogRow() catalog.Row {
+	row := catalog.Row{
+		RootID:        t.tree.RootID(),
+		SchemaBytes:   t.schema.marshal(),
+		SchemaVersion: t.schema.version,
+		Created:       t.meta.created,
+		Modified:      t.meta.modified,
+		RowCount:      uint64(t.meta.rows),
+		Comment:       t.meta.comment,
+	}
+	for _, idx := range t.indexes {
+		row.Indexes = append(row.Indexes, idx.catalogIndex())
+	}
+	return row
+}
+
+// moved reports whether any of the table's roots differ from the ones
+// recorded in the catalog.
+func (t *Table) moved() bool {
+	if t.tree.RootID() != t.savedRootID {
+		return true
+	}
+	for _, idx := range t.indexes {
+		if idx.tree.RootID() != idx.savedRootID {
+			return true
+		}
+	}
+	return false
 }
 
-// Name returns the table's name as it was passed to CreateTable.
-func (t *Table) Name() string { return t.name }
+// saved records the table's current name, roots and metadata as the
+// committed ones.
+func (t *Table) saved() {
+	t.meta.dirty = false
+	t.savedName = t.name
+	t.savedMeta = *t.meta
+	t.savedRootID = t.tree.RootID()
+	for _, idx := range t.indexes {
+		idx.savedRootID = idx.tree.RootID()
+	}
+}
+
+// Name returns the table's name: the one passed to CreateTable, or the
+// latest given to it by RenameTable. Handles from a [Snapshot] keep the
+// name the table had when the snapshot was taken.
+func (t *Table) Name() string {
+	if t.snap == nil && t.tx == nil {
+		t.db.mu.RLock()
+		defer t.db.mu.RUnlock()
+	}
+	return t.name
+}
 
 // Schema returns the table's schema. The returned pointer is shared with
 // the table; use [Schema.Columns] and [Schema.PrimaryKey] for read-only
-// inspection.
-func (t *Table) Schema() *Schema { return t.schema }
+// inspection. Altering the table's columns replaces its schema, so call
+// Schema again afterwards.
+func (t *Table) Schema() *Schema {
+	if t.snap == nil && t.tx == nil {
+		t.db.mu.RLock()
+		defer t.db.mu.RUnlock()
+	}
+	return t.schema
+}
 
 // Insert encodes and stores a row. Returns ErrSchemaMismatch if the row's
-// shape or types do not match the table schema.
+// shape or types do not match the table schema, or ErrDuplicateKey if the
+// table already has a row with the same primary key.
 func (t *Table) Insert(row Row) error {
-	if err := t.schema.validateRow(row); err != nil {
+	return t.write(func() error {
+		if err := t.schema.validateRow(row); err != nil {
+			return err
+		}
+		return t.insert(t.schema.encodeKeyFromRow(row), row)
+	})
+}
+
+// InsertIfNotExists stores a row unless the table already has a row with
+// the same primary key, and reports whether it stored it. Returns
+// ErrSchemaMismatch as for [Table.Insert].
+func (t *Table) InsertIfNotExists(row Row) (inserted bool, err error) {
+	err = t.write(func() error {
+		if err := t.schema.validateRow(row); err != nil {
+			return err
+		}
+		return t.insert(t.schema.encodeKeyFromRow(row), row)
+	})
+	if errors.Is(err, ErrDuplicateKey) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Upsert stores a row, replacing the row with the same primary key if
+// there is one. Returns ErrSchemaMismatch as for [Table.Insert].
+func (t *Table) Upsert(row Row) error {
+	return t.write(func() error {
+		if err := t.schema.validateRow(row); err != nil {
+			return err
+		}
+		return t.upsert(t.schema.encodeKeyFromRow(row), row)
+	})
+}
+
+// upsert stores a validated row under key, replacing any row stored there.
+func (t *Table) upsert(key []byte, row Row) error {
+	err := t.replace(key, row)
+	if errors.Is(err, ErrNotFound) {
+		return t.insert(key, row)
+	}
+	return err
+}
+
+// insert stores a validated row under key, its encoded primary key.
+func (t *Table) insert(key []byte, row Row) error {
+	err := t.tree.Insert(key, t.schema.encodeRow(row))
+	if errors.Is(err, btree.ErrDuplicateKey) {
+		return ErrDuplicateKey
+	}
+	if err != nil {
 		return err
 	}
-	key := t.schema.encodeKeyFromRow(row)
-	val := t.schema.encodeRow(row)
-	return t.tree.Insert(key, val)
+	t.touch(1)
+	return t.insertEntries(row)
 }
 
-// Get returns the row with the given primary key value. Returns ErrNotFound
-// if no row exists with that key, or ErrKeyTypeMismatch if keyVal's type
-// does not match the primary key column type.
-func (t *Table) Get(keyVal Value) (Row, error) {
-	if err := t.schema.validateKey(keyVal); err != nil {
+// replace overwrites the row stored under key with a validated row having
+// that key. The stored row's value is replaced in place, in its leaf, when
+// the new one fits there. Returns ErrNotFound, having changed nothing, if
+// no row is stored under key.
+func (t *Table) replace(key []byte, row Row) error {
+	err := t.deleteEntries(key)
+	if err == nil {
+		err = t.tree.Update(key, t.schema.encodeRow(row))
+	}
+	if errors.Is(err, btree.ErrKeyNotFound) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	t.touch(0)
+	return t.insertEntries(row)
+}
+
+// Get returns the row with the given primary key: a [Key], or a lone Value
+// for a single-column key. Given a prefix of a composite key, it returns
+// the first row, in key order, whose key starts with it. Returns
+// ErrNotFound if no row matches, or ErrKeyTypeMismatch if a key value's
+// type does not match its column type.
+func (t *Table) Get(keyVal Value) (_ Row, err error) {
+	v, release, err := t.read()
+	if err != nil {
 		return nil, err
 	}
-	key := t.schema.encodeKeyFromValue(keyVal)
-	val, err := t.tree.Search(key)
+	defer func() {
+		if rerr := release(); rerr != nil && err == nil {
+			err = rerr
+		}
+	}()
+	full, err := v.schema.validateKey(keyVal)
+	if err != nil {
+		return nil, err
+	}
+	key := v.schema.encodeKeyFromValue(keyVal)
+	var val []byte
+	if full {
+		val, err = v.tree.Search(key)
+	} else {
+		key, val, err = firstWithPrefix(v.tree, key)
+	}
 	if errors.Is(err, btree.ErrKeyNotFound) {
 		return nil, ErrNotFound
 	}
 	if err != nil {
 		return nil, err
 	}
-	return t.schema.decodeRow(keyVal, val)
+	pk, err := v.schema.decodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return v.schema.decodeRow(pk, val)
+}
+
+// firstWithPrefix returns the first record of tree whose key starts with
+// prefix, or btree.ErrKeyNotFound if there is none.
+func firstWithPrefix(tree *btree.Btree, prefix []byte) (key, value []byte, err error) {
+	for r, err := range tree.AscendingRange(prefix, prefixEnd(prefix)) {
+		if err != nil {
+			return nil, nil, err
+		}
+		return r.Key, r.Value, nil
+	}
+	return nil, nil, btree.ErrKeyNotFound
 }
 
 // Update replaces the row with the matching primary key. Returns
-// ErrSchemaMismatch if the row's shape or types do not match the schema.
+// ErrSchemaMismatch if the row's shape or types do not match the schema, or
+// ErrNotFound if the table has no row with that key.
 func (t *Table) Update(row Row) error {
-	if err := t.schema.validateRow(row); err != nil {
+	return t.write(func() error {
+		if err := t.schema.validateRow(row); err != nil {
+			return err
+		}
+		return t.replace(t.schema.encodeKeyFromRow(row), row)
+	})
+}
+
+// CompareAndSwap replaces the row old with new if the table still holds old
+// unchanged, and reports whether it did. Both rows must have the same
+// primary key. Returns ErrNotFound if the table has no row with that key,
+// and ErrSchemaMismatch if either row does not match the schema or their
+// keys differ.
+func (t *Table) CompareAndSwap(old, new Row) (swapped bool, err error) {
+	err = t.write(func() error {
+		if err := t.schema.validateRow(old); err != nil {
+			return err
+		}
+		if err := t.schema.validateRow(new); err != nil {
+			return err
+		}
+		key := t.schema.encodeKeyFromRow(new)
+		if !bytes.Equal(t.schema.encodeKeyFromRow(old), key) {
+			return fmt.Errorf("%w: compare and swap rows have different primary keys", ErrSchemaMismatch)
+		}
+		val, err := t.tree.Search(key)
+		if errors.Is(err, btree.ErrKeyNotFound) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		pk, err := t.schema.decodeKey(key)
+		if err != nil {
+			return err
+		}
+		current, err := t.schema.decodeRow(pk, val)
+		if err != nil {
+			return err
+		}
+		if !slices.EqualFunc(current, old, valuesEqual) {
+			return nil
+		}
+		if err := t.replace(key, new); err != nil {
+			return err
+		}
+		swapped = true
+		return nil
+	})
+	return swapped && err == nil, err
+}
+
+// Delete removes the row with the given primary key, given as for
+// [Table.Get]. Given a prefix of a composite key, it removes every row
+// whose key starts with it. Returns ErrKeyTypeMismatch if a key value's
+// type does not match its column type.
+func (t *Table) Delete(keyVal Value) error {
+	return t.write(func() error {
+		full, err := t.schema.validateKey(keyVal)
+		if err != nil {
+			return err
+		}
+		key := t.schema.encodeKeyFromValue(keyVal)
+		if full {
+			return t.deleteRow(key)
+		}
+		_, err = t.deleteRange(key, prefixEnd(key))
 		return err
+	})
+}
+
+// DeleteRange deletes the rows with primary keys in [lo, hi) and returns
+// how many it removed. Bounds are given and compared as for [Table.Scan];
+// DeleteRange(nil, nil) empties the table.
+//
+// Leaves of the table that fall entirely inside the range are freed whole
+// rather than row by row, so purging a large run of adjacent keys, such as
+// the oldest rows of a table keyed by time, costs little more than the
+// pages it releases. Secondary index entries still have to be found and
+// deleted one row at a time.
+func (t *Table) DeleteRange(lo, hi Value) (deleted int, err error) {
+	err = t.write(func() error {
+		var loKey, hiKey []byte
+		if lo != nil {
+			if _, err := t.schema.validateKey(lo); err != nil {
+				return err
+			}
+			loKey = t.schema.encodeKeyFromValue(lo)
+		}
+		if hi != nil {
+			if _, err := t.schema.validateKey(hi); err != nil {
+				return err
+			}
+			hiKey = t.schema.encodeKeyFromValue(hi)
+		}
+		deleted, err = t.deleteRange(loKey, hiKey)
+		return err
+	})
+	if err != nil {
+		return 0, err
 	}
-	key := t.schema.encodeKeyFromRow(row)
-	val := t.schema.encodeRow(row)
-	if err := t.tree.Delete(key); err != nil {
+	return deleted, nil
+}
+
+// deleteRange removes the rows with encoded keys in [lo, hi) and their
+// index entries.
+func (t *Table) deleteRange(lo, hi []byte) (int, error) {
+	if len(t.indexes) > 0 {
+		for r, err := range t.tree.AscendingRange(lo, hi) {
+			if err != nil {
+				return 0, err
+			}
+			pk, err := t.schema.decodeKey(r.Key)
+			if err != nil {
+				return 0, err
+			}
+			row, err := t.schema.decodeRow(pk, r.Value)
+			if err != nil {
+				return 0, err
+			}
+			for _, idx := range t.indexes {
+				if err := idx.tree.Delete(idx.entryKey(t.schema, row)); err != nil {
+					return 0, err
+				}
+			}
+		}
+	}
+	n, err := t.tree.DeleteRange(lo, hi)
+	if err != nil {
+		return 0, err
+	}
+	t.touch(-n)
+	return n, nil
+}
+
+// deleteRow removes the row stored under key and its index entries.
+func (t *Table) deleteRow(key []byte) error {
+	if err := t.deleteEntries(key); err != nil {
 		return err
 	}
-	if err := t.tree.Insert(key, val); err != nil {
+	if err := t.tree.Delete(key); err != nil {
 		return err
 	}
-
+	t.touch(-1)
 	return nil
 }
 
-// Delete removes the row with the given primary key value. Returns
-// ErrKeyTypeMismatch if keyVal's type does not match the primary key
-// column type.
-func (t *Table) Delete(keyVal Value) error {
-	if err := t.schema.validateKey(keyVal); err != nil {
-		return err
+// write applies fn as part of the handle's transaction, or, for handles
+// outside a transaction, commits it on its own so that a failure part-way
+// through leaves no trace.
+func (t *Table) write(fn func() error) error {
+	if t.snap != nil {
+		return ErrReadOnly
+	}
+	if t.tx == nil {
+		return t.db.autocommit(fn)
+	}
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+	if t.tx.done {
+		return ErrTxDone
+	}
+	return fn()
+}
+
+// view is what one read goes through: the table's schema, tree, and
+// indexes.
+type view struct {
+	schema  *Schema
+	tree    *btree.Btree
+	indexes []*index
+}
+
+func (v *view) index(name string) (*index, error) {
+	for _, idx := range v.indexes {
+		if idx.name == name {
+			return idx, nil
+		}
+	}
+	return nil, ErrIndexNotFound
+}
+
+// read starts a read and returns the view to read through and the function
+// that ends it. A transaction's handles read its live trees, uncommitted
+// changes included, and a snapshot's handles read the snapshot. Other
+// handles read a snapshot of the last commit taken for the duration of the
+// read, so they neither wait for writers nor see their uncommitted changes.
+func (t *Table) read() (v *view, release func() error, err error) {
+	switch {
+	case t.tx != nil:
+		if t.tx.done {
+			return nil, nil, ErrTxDone
+		}
+		return &view{t.schema, t.tree, t.indexes}, func() error { return nil }, nil
+	case t.snap != nil:
+		t.snap.mu.RLock()
+		if t.snap.closed {
+			t.snap.mu.RUnlock()
+			return nil, nil, ErrSnapshotClosed
+		}
+		return &view{t.schema, t.tree, t.indexes}, func() error { t.snap.mu.RUnlock(); return nil }, nil
 	}
-	key := t.schema.encodeKeyFromValue(keyVal)
-	return t.tree.Delete(key)
+	t.db.mu.RLock()
+	defer t.db.mu.RUnlock()
+	ps := t.db.pager.Snapshot()
+	v = &view{schema: t.schema, tree: btree.OpenSnapshot(ps, t.savedRootID)}
+	for _, idx := range t.indexes {
+		v.indexes = append(v.indexes, &index{
+			name:    idx.name,
+			columns: idx.columns,
+			tree:    btree.OpenSnapshot(ps, idx.savedRootID),
+		})
+	}
+	return v, ps.Close, nil
 }
 
 // Scan returns rows with primary keys in [lo, hi), ascending. The upper
 // bound is exclusive. A nil bound is unbounded on that side; Scan(nil, nil)
-// returns every row. Returns ErrKeyTypeMismatch if either bound's type
-// does not match the primary key column type.
+// returns every row. Bounds are given as for [Table.Get]; a bound holding a
+// prefix of a composite key compares rows on that many leading key
+// columns. Returns ErrKeyTypeMismatch if a bound value's type does not match
+// its column type.
+//
+// Outside a transaction the iteration reads a snapshot taken when it
+// starts, so writes committed while it runs, including ones made from
+// inside the loop, do not affect which rows it yields. Through a [Tx]
+// handle it reads the live table, and writing to the table from inside the
+// loop may skip or repeat rows.
 func (t *Table) Scan(lo, hi Value) iter.Seq2[Row, error] {
 	return func(yield func(Row, error) bool) {
+		v, release, err := t.read()
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer release()
+
 		var loKey, hiKey []byte
 		if lo != nil {
-			if err := t.schema.validateKey(lo); err != nil {
+			if _, err := v.schema.validateKey(lo); err != nil {
 				yield(nil, err)
 				return
 			}
-			loKey = t.schema.encodeKeyFromValue(lo)
+			loKey = v.schema.encodeKeyFromValue(lo)
 		}
 		if hi != nil {
-			if err := t.schema.validateKey(hi); err != nil {
+			if _, err := v.schema.validateKey(hi); err != nil {
 				yield(nil, err)
 				return
 			}
-			hiKey = t.schema.encodeKeyFromValue(hi)
+			hiKey = v.schema.encodeKeyFromValue(hi)
 		}
-		for r, err := range t.tree.AscendingRange(loKey, hiKey) {
+		for r, err := range v.tree.AscendingRange(loKey, hiKey) {
 			if err != nil {
 				yield(nil, err)
 				return
 			}
-			pk, err := t.schema.decodeKey(r.Key)
+			pk, err := v.schema.decodeKey(r.Key)
 			if err != nil {
 				yield(nil, err)
 				return
 			}
-			row, err := t.schema.decodeRow(pk, r.Value)
+			row, err := v.schema.decodeRow(pk, r.Value)
 			if err != nil {
 				yield(nil, err)
 				return
@@ -128,37 +522,58 @@ func (t *Table) Scan(lo, hi Value) iter.Seq2[Row, error] {
 // ScanDescending returns rows with primary keys in (lo, hi], descending.
 // The upper bound is inclusive and the lower bound is exclusive (the
 // mirror of [Table.Scan]). A nil bound is unbounded on that side;
-// ScanDescending(nil, nil) returns every row. Returns ErrKeyTypeMismatch
-// if either bound's type does not match the primary key column type.
+// ScanDescending(nil, nil) returns every row. Bounds are given and compared
+// as for Scan, and it reads a snapshot in the same way.
 func (t *Table) ScanDescending(lo, hi Value) iter.Seq2[Row, error] {
 	return func(yield func(Row, error) bool) {
+		v, release, err := t.read()
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer release()
+
 		var loKey, hiKey []byte
 		if lo != nil {
-			if err := t.schema.validateKey(lo); err != nil {
+			full, err := v.schema.validateKey(lo)
+			if err != nil {
 				yield(nil, err)
 				return
 			}
-			loKey = t.schema.encodeKeyFromValue(lo)
+			loKey = v.schema.encodeKeyFromValue(lo)
+			if !full {
+				// Keys starting with a prefix sort after it, so the
+				// exclusive bound moves past all of them.
+				if loKey = prefixEnd(loKey); loKey == nil {
+					return
+				}
+			}
 		}
 		if hi != nil {
-			if err := t.schema.validateKey(hi); err != nil {
+			full, err := v.schema.validateKey(hi)
+			if err != nil {
 				yield(nil, err)
 				return
 			}
-			hiKey = t.schema.encodeKeyFromValue(hi)
+			hiKey = v.schema.encodeKeyFromValue(hi)
+			if !full {
+				// Likewise the inclusive bound. No key equals the moved
+				// bound itself, since every key is longer than a prefix.
+				hiKey = prefixEnd(hiKey)
+			}
 		}
 
-		for r, err := range t.tree.DescendingRange(loKey, hiKey) {
+		for r, err := range v.tree.DescendingRange(loKey, hiKey) {
 			if err != nil {
 				yield(nil, err)
 				return
 			}
-			pk, err := t.schema.decodeKey(r.Key)
+			pk, err := v.schema.decodeKey(r.Key)
 			if err != nil {
 				yield(nil, err)
 				return
 			}
-			row, err := t.schema.decodeRow(pk, r.Value)
+			row, err := v.schema.decodeRow(pk, r.Value)
 			if err != nil {
 				yield(nil, err)
 				return
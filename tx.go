@@ -0,0 +1,92 @@
+package toydb
+
+import "errors"
+
+// Tx groups writes so that they are applied atomically: either every change
+// made through the transaction becomes durable at [Tx.Commit], or none does
+// and [Tx.Rollback] restores the DB to its state at [DB.Begin]. Obtain table
+// handles bound to the transaction with [Tx.Table].
+//
+// Only one transaction can be open on a DB at a time: Begin, and writes
+// through handles not bound to it, wait until it ends. A goroutine holding
+// a Tx must therefore not write outside it, or it deadlocks. Reads through
+// such handles may see the transaction's uncommitted changes. Creating and
+// dropping tables is not transactional and also waits for the transaction.
+//
+// A Tx and the handles obtained from it must be used by one goroutine at a
+// time.
+type Tx struct {
+	db   *DB
+	done bool
+}
+
+// Begin starts a transaction, waiting for any open one to end first.
+func (d *DB) Begin() (*Tx, error) {
+	d.writer.Lock()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.tx = &Tx{db: d}
+	return d.tx, nil
+}
+
+// Table returns a handle to the named table whose writes belong to the
+// transaction. The handle is valid until the transaction ends; afterwards
+// its operations return ErrTxDone. Returns ErrTableNotFound if no table
+// with the given name exists.
+func (tx *Tx) Table(name string) (*Table, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	t, err := tx.db.OpenTable(name)
+	if err != nil {
+		return nil, err
+	}
+	return &Table{
+		db:      t.db,
+		name:    t.name,
+		schema:  t.schema,
+		tree:    t.tree,
+		tx:      tx,
+		indexes: t.indexes,
+		meta:    t.meta,
+	}, nil
+}
+
+// Commit makes every change made through the transaction durable. If the
+// commit fails the transaction is rolled back. Returns ErrTxDone if the
+// transaction has already ended.
+func (tx *Tx) Commit() error {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.end()
+	if err := tx.db.commit(); err != nil {
+		if rbErr := tx.db.rollback(); rbErr != nil {
+			return errors.Join(err, rbErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// Rollback discards every change made through the transaction. Returns
+// ErrTxDone if the transaction has already ended.
+func (tx *Tx) Rollback() error {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.end()
+	return tx.db.rollback()
+}
+
+// end marks the transaction finished and lets the next writer in. Callers
+// hold db.mu exclusively.
+func (tx *Tx) end() {
+	tx.done = true
+	tx.db.tx = nil
+	tx.db.writer.Unlock()
+}
@@ -0,0 +1,404 @@
+package toydb
+
+import (
+	"fmt"
+	"iter"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// TypedTable is a [Table] whose rows are values of the struct type T, so
+// callers neither build [Row] slices nor type-assert their columns.
+//
+// Each exported field of T is a column, named by its toydb tag or, without
+// one, after the field. Fields tagged "-" are skipped, and the tag option
+// "pk" marks primary key columns, in field order:
+//
+//	type Visit struct {
+//		User  int64     `toydb:"user,pk"`
+//		Day   time.Time `toydb:"day,pk"`
+//		Page  string    `toydb:"page"`
+//		Note  *string   `toydb:"note"` // nullable
+//		Cache []byte    `toydb:"-"`
+//	}
+//
+// Field types map to column types as follows; a pointer to any of them is
+// a nullable column of the same type, nil standing for [Null].
+//
+//   - signed integers, uint8, uint16 and uint32: [TypeInt]
+//   - string: [TypeText]
+//   - bool: [TypeBool]
+//   - [time.Time] and [TimestampValue]: [TypeTimestamp]; times read back
+//     in UTC
+//   - float32 and float64: [TypeFloat]
+//   - []byte: [TypeBytes]
+//   - [DecimalValue]: [TypeDecimal]
+//   - [16]byte, including [UUIDValue]: [TypeUUID]
+//   - [JSONValue]: [TypeJSON]
+//
+// Named types with one of these underlying types map like it, the value
+// types ([IntValue], [TextValue], ...) included.
+//
+// A TypedTable checks the table's schema against T once, when it is made,
+// and goes through the [Table] handle it wraps, so one made from a [Tx] or
+// [Snapshot] handle reads and writes as that handle does. Make a new one
+// after altering the table's schema.
+type TypedTable[T any] struct {
+	table *Table
+	// fields maps the table's columns, in schema order, to fields of T.
+	fields []typedField
+}
+
+// typedField ties a field of a struct to a column.
+type typedField struct {
+	index  int // in the struct
+	column Column
+	pk     bool
+	isTime bool // the field is a time.Time, or a pointer to one
+}
+
+var (
+	timeType      = reflect.TypeFor[time.Time]()
+	timestampType = reflect.TypeFor[TimestampValue]()
+	decimalType   = reflect.TypeFor[DecimalValue]()
+	jsonType      = reflect.TypeFor[JSONValue]()
+)
+
+// SchemaOf derives a schema from the fields of the struct type T, as
+// described for [TypedTable].
+func SchemaOf[T any]() (*Schema, error) {
+	fields, err := structFields(reflect.TypeFor[T]())
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]Column, len(fields))
+	var pk []int
+	for i, f := range fields {
+		columns[i] = f.column
+		if f.pk {
+			pk = append(pk, i)
+		}
+	}
+	return NewCompositeSchema(pk, columns)
+}
+
+// CreateTypedTable creates a table with the schema [SchemaOf] derives from
+// T. Returns [ErrTableExists] if a table with that name already exists.
+func CreateTypedTable[T any](d *DB, name string) (*TypedTable[T], error) {
+	s, err := SchemaOf[T]()
+	if err != nil {
+		return nil, err
+	}
+	t, err := d.CreateTable(name, s)
+	if err != nil {
+		return nil, err
+	}
+	return NewTypedTable[T](t)
+}
+
+// OpenTypedTable opens an existing table as a TypedTable. See
+// [NewTypedTable] for how its schema is checked.
+func OpenTypedTable[T any](d *DB, name string) (*TypedTable[T], error) {
+	t, err := d.OpenTable(name)
+	if err != nil {
+		return nil, err
+	}
+	return NewTypedTable[T](t)
+}
+
+// NewTypedTable wraps a table handle as a TypedTable. It returns an error
+// wrapping [ErrSchemaMismatch] unless T maps to exactly the table's columns,
+// by name, with the same types, nullability and primary key; their order
+// may differ.
+func NewTypedTable[T any](t *Table) (*TypedTable[T], error) {
+	typ := reflect.TypeFor[T]()
+	fields, err := structFields(typ)
+	if err != nil {
+		return nil, err
+	}
+	s := t.Schema()
+	mismatch := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %s and table %q: %s", ErrSchemaMismatch, typ, t.Name(), fmt.Sprintf(format, args...))
+	}
+
+	bound := make([]typedField, len(s.columns))
+	for i, column := range s.columns {
+		j := slices.IndexFunc(fields, func(f typedField) bool { return f.column.Name == column.Name })
+		if j < 0 {
+			return nil, mismatch("no field for column %q", column.Name)
+		}
+		f := fields[j]
+		if f.column.Type != column.Type {
+			return nil, mismatch("field %s maps to a %s column, %q is %s", typ.Field(f.index).Name, f.column.Type, column.Name, column.Type)
+		}
+		if f.column.Nullable != column.Nullable {
+			return nil, mismatch("field %s must be a pointer exactly if column %q is nullable", typ.Field(f.index).Name, column.Name)
+		}
+		bound[i] = f
+	}
+	if len(fields) > len(s.columns) {
+		for _, f := range fields {
+			if _, ok := s.columnIndex(f.column.Name); !ok {
+				return nil, mismatch("no column for field %s", typ.Field(f.index).Name)
+			}
+		}
+	}
+	var pk []string
+	for _, f := range fields {
+		if f.pk {
+			pk = append(pk, f.column.Name)
+		}
+	}
+	if want := s.PrimaryKeyColumns(); !slices.Equal(pk, want) {
+		return nil, mismatch("fields tagged pk are (%s), primary key is (%s)", strings.Join(pk, ", "), strings.Join(want, ", "))
+	}
+	return &TypedTable[T]{table: t, fields: bound}, nil
+}
+
+// structFields returns the columns the exported fields of struct type typ
+// map to, in field order.
+func structFields(typ reflect.Type) ([]typedField, error) {
+	if typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("typed table: %s is not a struct", typ)
+	}
+	var fields []typedField
+	for i := range typ.NumField() {
+		sf := typ.Field(i)
+		tag := sf.Tag.Get("toydb")
+		if !sf.IsExported() || tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = sf.Name
+		}
+		f := typedField{index: i, column: Column{Name: name}}
+		for opt := range strings.SplitSeq(opts, ",") {
+			switch opt {
+			case "":
+			case "pk":
+				f.pk = true
+			default:
+				return nil, fmt.Errorf("typed table: field %s.%s: unknown tag option %q", typ, sf.Name, opt)
+			}
+		}
+		ft := sf.Type
+		if ft.Kind() == reflect.Pointer {
+			f.column.Nullable = true
+			ft = ft.Elem()
+		}
+		var ok bool
+		if f.column.Type, ok = columnType(ft); !ok {
+			return nil, fmt.Errorf("typed table: field %s.%s: no column type for %s", typ, sf.Name, sf.Type)
+		}
+		f.isTime = ft == timeType
+		fields = append(fields, f)
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("typed table: %s has no exported fields", typ)
+	}
+	return fields, nil
+}
+
+// columnType returns the column type values of Go type t are stored as.
+func columnType(t reflect.Type) (ColType, bool) {
+	switch t {
+	case timeType, timestampType:
+		return TypeTimestamp, true
+	case decimalType:
+		return TypeDecimal, true
+	case jsonType:
+		return TypeJSON, true
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return TypeInt, true
+	case reflect.String:
+		return TypeText, true
+	case reflect.Bool:
+		return TypeBool, true
+	case reflect.Float32, reflect.Float64:
+		return TypeFloat, true
+	case reflect.Slice:
+		return TypeBytes, t.Elem().Kind() == reflect.Uint8
+	case reflect.Array:
+		return TypeUUID, t.Len() == 16 && t.Elem().Kind() == reflect.Uint8
+	}
+	return 0, false
+}
+
+// value returns the column value of field f in struct s.
+func (f *typedField) value(s reflect.Value) Value {
+	v := s.Field(f.index)
+	if f.column.Nullable {
+		if v.IsNil() {
+			return Null
+		}
+		v = v.Elem()
+	}
+	switch f.column.Type {
+	case TypeInt:
+		if v.CanInt() {
+			return IntValue(v.Int())
+		}
+		return IntValue(v.Uint())
+	case TypeText:
+		return TextValue(v.String())
+	case TypeBool:
+		return BoolValue(v.Bool())
+	case TypeTimestamp:
+		if f.isTime {
+			return TimestampValue(v.Interface().(time.Time).UnixNano())
+		}
+		return TimestampValue(v.Int())
+	case TypeFloat:
+		return FloatValue(v.Float())
+	case TypeBytes:
+		return BytesValue(v.Bytes())
+	case TypeDecimal:
+		return DecimalValue(v.Int())
+	case TypeUUID:
+		var u UUIDValue
+		reflect.Copy(reflect.ValueOf(&u).Elem(), v)
+		return u
+	case TypeJSON:
+		return JSONValue(v.String())
+	}
+	panic(fmt.Sprintf("typed table: unhandled column type %d", f.column.Type))
+}
+
+// set stores the column value val in field f of struct s.
+func (f *typedField) set(s reflect.Value, val Value) error {
+	v := s.Field(f.index)
+	if val == Null {
+		v.SetZero()
+		return nil
+	}
+	if f.column.Nullable {
+		p := reflect.New(v.Type().Elem())
+		v.Set(p)
+		v = p.Elem()
+	}
+	switch val := val.(type) {
+	case IntValue:
+		if v.CanInt() && !v.OverflowInt(int64(val)) {
+			v.SetInt(int64(val))
+			return nil
+		}
+		if v.CanUint() && val >= 0 && !v.OverflowUint(uint64(val)) {
+			v.SetUint(uint64(val))
+			return nil
+		}
+		return fmt.Errorf("%w: column %q holds %d, which overflows %s", ErrSchemaMismatch, f.column.Name, val, v.Type())
+	case TextValue:
+		v.SetString(string(val))
+	case BoolValue:
+		v.SetBool(bool(val))
+	case TimestampValue:
+		if f.isTime {
+			v.Set(reflect.ValueOf(val.Time()))
+		} else {
+			v.SetInt(int64(val))
+		}
+	case FloatValue:
+		v.SetFloat(float64(val))
+	case BytesValue:
+		v.SetBytes(val)
+	case DecimalValue:
+		v.SetInt(int64(val))
+	case UUIDValue:
+		reflect.Copy(v, reflect.ValueOf(val))
+	case JSONValue:
+		v.SetString(string(val))
+	default:
+		return fmt.Errorf("%w: column %q holds a %T", ErrSchemaMismatch, f.column.Name, val)
+	}
+	return nil
+}
+
+// row converts v to a row of the table.
+func (tt *TypedTable[T]) row(v T) Row {
+	s := reflect.ValueOf(&v).Elem()
+	row := make(Row, len(tt.fields))
+	for i := range tt.fields {
+		row[i] = tt.fields[i].value(s)
+	}
+	return row
+}
+
+// decode converts a row of the table to a T.
+func (tt *TypedTable[T]) decode(row Row) (T, error) {
+	var v T
+	s := reflect.ValueOf(&v).Elem()
+	for i := range tt.fields {
+		if err := tt.fields[i].set(s, row[i]); err != nil {
+			var zero T
+			return zero, err
+		}
+	}
+	return v, nil
+}
+
+// Table returns the table handle tt wraps.
+func (tt *TypedTable[T]) Table() *Table { return tt.table }
+
+// Insert adds v as a new row, as [Table.Insert].
+func (tt *TypedTable[T]) Insert(v T) error {
+	return tt.table.Insert(tt.row(v))
+}
+
+// Upsert writes v, replacing any row with the same primary key, as
+// [Table.Upsert].
+func (tt *TypedTable[T]) Upsert(v T) error {
+	return tt.table.Upsert(tt.row(v))
+}
+
+// Update replaces the row with v's primary key, as [Table.Update].
+func (tt *TypedTable[T]) Update(v T) error {
+	return tt.table.Update(tt.row(v))
+}
+
+// Get returns the row with the given primary key, as [Table.Get].
+func (tt *TypedTable[T]) Get(key Value) (T, error) {
+	row, err := tt.table.Get(key)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return tt.decode(row)
+}
+
+// Delete removes the rows with the given primary key, as [Table.Delete].
+func (tt *TypedTable[T]) Delete(key Value) error {
+	return tt.table.Delete(key)
+}
+
+// Scan returns the rows with primary keys in [lo, hi), ascending, as
+// [Table.Scan].
+func (tt *TypedTable[T]) Scan(lo, hi Value) iter.Seq2[T, error] {
+	return tt.values(tt.table.Scan(lo, hi))
+}
+
+// ScanDescending returns the rows with primary keys in (lo, hi],
+// descending, as [Table.ScanDescending].
+func (tt *TypedTable[T]) ScanDescending(lo, hi Value) iter.Seq2[T, error] {
+	return tt.values(tt.table.ScanDescending(lo, hi))
+}
+
+// values converts the rows of seq to T, stopping at the first error.
+func (tt *TypedTable[T]) values(seq iter.Seq2[Row, error]) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		for row, err := range seq {
+			var v T
+			if err == nil {
+				v, err = tt.decode(row)
+			}
+			if !yield(v, err) || err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package toydb_test
+
+import (
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	toydb "github.com/guiwoch/toyDB"
+)
+
+type score int16
+
+// everything has a field of every type a column can map from.
+type everything struct {
+	ID      int64 `toydb:"id,pk"`
+	Small   int8
+	Count   uint32 `toydb:"count"`
+	Score   score  `toydb:"score"`
+	Name    string `toydb:"name"`
+	OK      bool   `toydb:"ok"`
+	At      time.Time
+	Stamp   toydb.TimestampValue
+	Ratio   float32
+	Raw     []byte
+	Price   toydb.DecimalValue
+	Key     [16]byte
+	UUID    toydb.UUIDValue
+	Doc     toydb.JSONValue
+	Note    *string    `toydb:"note"`
+	Seen    *time.Time `toydb:"seen"`
+	Limit   *int       `toydb:"limit"`
+	Cache   []int      `toydb:"-"`
+	private int
+}
+
+func TestSchemaOf(t *testing.T) {
+	t.Parallel()
+	s, err := toydb.SchemaOf[everything]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []toydb.Column{
+		{Name: "id", Type: toydb.TypeInt},
+		{Name: "Small", Type: toydb.TypeInt},
+		{Name: "count", Type: toydb.TypeInt},
+		{Name: "score", Type: toydb.TypeInt},
+		{Name: "name", Type: toydb.TypeText},
+		{Name: "ok", Type: toydb.TypeBool},
+		{Name: "At", Type: toydb.TypeTimestamp},
+		{Name: "Stamp", Type: toydb.TypeTimestamp},
+		{Name: "Ratio", Type: toydb.TypeFloat},
+		{Name: "Raw", Type: toydb.TypeBytes},
+		{Name: "Price", Type: toydb.TypeDecimal},
+		{Name: "Key", Type: toydb.TypeUUID},
+		{Name: "UUID", Type: toydb.TypeUUID},
+		{Name: "Doc", Type: toydb.TypeJSON},
+		{Name: "note", Type: toydb.TypeText, Nullable: true},
+		{Name: "seen", Type: toydb.TypeTimestamp, Nullable: true},
+		{Name: "limit", Type: toydb.TypeInt, Nullable: true},
+	}
+	if got := s.Columns(); !slices.Equal(got, want) {
+		t.Errorf("columns\n got %+v\nwant %+v", got, want)
+	}
+
+	type visit struct {
+		Page string    `toydb:"page"`
+		Day  time.Time `toydb:"day,pk"`
+		User int64     `toydb:"user,pk"`
+	}
+	s, err = toydb.SchemaOf[visit]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.PrimaryKeyColumns(); !slices.Equal(got, []string{"day", "user"}) {
+		t.Errorf("primary key %q, want the pk fields in field order", got)
+	}
+}
+
+func TestSchemaOfErrors(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		fn   func() (*toydb.Schema, error)
+	}{
+		{"not a struct", toydb.SchemaOf[int]},
+		{"unknown tag option", toydb.SchemaOf[struct {
+			ID int `toydb:"id,pk,unique"`
+		}]},
+		{"unmapped type", toydb.SchemaOf[struct {
+			ID   int `toydb:"id,pk"`
+			Tags map[string]string
+		}]},
+		{"uint64", toydb.SchemaOf[struct {
+			ID uint64 `toydb:"id,pk"`
+		}]},
+		{"no exported fields", toydb.SchemaOf[struct {
+			id int
+		}]},
+		{"no primary key", toydb.SchemaOf[struct {
+			ID int
+		}]},
+	}
+	for _, tt := range tests {
+		if _, err := tt.fn(); err == nil {
+			t.Errorf("%s: SchemaOf succeeded", tt.name)
+		}
+	}
+}
+
+func TestTypedTableRoundTrip(t *testing.T) {
+	t.Parallel()
+	d, path := newTestDB(t)
+	tt, err := toydb.CreateTypedTable[everything](d, "everything")
+	if err != nil {
+		t.Fatal(err)
+	}
+	note, limit := "hello", -3
+	seen := time.Date(1969, 7, 20, 20, 17, 0, 0, time.UTC)
+	full := everything{
+		ID:    2,
+		Small: -8,
+		Count: 1 << 31,
+		Score: 300,
+		Name:  "full",
+		OK:    true,
+		At:    time.Date(2024, 5, 1, 12, 0, 0, 5, time.UTC),
+		Stamp: toydb.TimestampValue(-1),
+		Ratio: 0.25,
+		Raw:   []byte{0, 1, 2},
+		Price: toydb.DecimalValue(19_990_000),
+		Key:   [16]byte{15: 1},
+		UUID:  toydb.UUIDValue{0: 0xff},
+		Doc:   `{"a":1}`,
+		Note:  &note,
+		Seen:  &seen,
+		Limit: &limit,
+	}
+	// Nil pointers are stored as Null and read back as nil.
+	empty := everything{ID: 1, At: time.Unix(0, 0).UTC(), Raw: []byte{}, Doc: "null"}
+	for _, v := range []everything{full, empty} {
+		if err := tt.Insert(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Skipped and unexported fields are not stored.
+	if err := tt.Update(everything{ID: 1, At: empty.At, Raw: []byte{}, Doc: "null", Cache: []int{1}, private: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	row, err := tt.Table().Get(toydb.IntValue(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []int{14, 15, 16} {
+		if row[i] != toydb.Null {
+			t.Errorf("nil pointer column %d stored as %v, want null", i, row[i])
+		}
+	}
+
+	check := func(tt *toydb.TypedTable[everything]) {
+		t.Helper()
+		for _, want := range []everything{empty, full} {
+			got, err := tt.Get(toydb.IntValue(want.ID))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Get(%d)\n got %+v\nwant %+v", want.ID, got, want)
+			}
+		}
+		var ids []int64
+		for v, err := range tt.ScanDescending(nil, nil) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, v.ID)
+		}
+		if !slices.Equal(ids, []int64{2, 1}) {
+			t.Errorf("ScanDescending ids %v, want [2 1]", ids)
+		}
+	}
+	check(tt)
+	d = reopen(t, d, path)
+	if tt, err = toydb.OpenTypedTable[everything](d, "everything"); err != nil {
+		t.Fatal(err)
+	}
+	check(tt)
+}
+
+func TestNewTypedTableMismatch(t *testing.T) {
+	t.Parallel()
+	d, _ := newTestDB(t)
+	tbl, err := d.CreateTable("people", mustSchema(t, 0,
+		toydb.Column{Name: "id", Type: toydb.TypeInt},
+		toydb.Column{Name: "city", Type: toydb.TypeText, Nullable: true},
+		toydb.Column{Name: "age", Type: toydb.TypeInt},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tbl.Insert(toydb.Row{toydb.IntValue(1), toydb.Null, toydb.IntValue(300)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Fields may come in any order.
+	type person struct {
+		City *string `toydb:"city"`
+		Age  int     `toydb:"age"`
+		ID   int     `toydb:"id,pk"`
+	}
+	if _, err := toydb.NewTypedTable[person](tbl); err != nil {
+		t.Errorf("fields in another order: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		fn   func(*toydb.Table) error
+	}{
+		{"wrong type", typedErr[struct {
+			ID   int     `toydb:"id,pk"`
+			City *string `toydb:"city"`
+			Age  string  `toydb:"age"`
+		}]},
+		{"not a pointer for a nullable column", typedErr[struct {
+			ID   int    `toydb:"id,pk"`
+			City string `toydb:"city"`
+			Age  int    `toydb:"age"`
+		}]},
+		{"pointer for a non-null column", typedErr[struct {
+			ID   int     `toydb:"id,pk"`
+			City *string `toydb:"city"`
+			Age  *int    `toydb:"age"`
+		}]},
+		{"missing column", typedErr[struct {
+			ID   int     `toydb:"id,pk"`
+			City *string `toydb:"city"`
+		}]},
+		{"extra field", typedErr[struct {
+			ID    int     `toydb:"id,pk"`
+			City  *string `toydb:"city"`
+			Age   int     `toydb:"age"`
+			Email string  `toydb:"email"`
+		}]},
+		{"primary key", typedErr[struct {
+			ID   int     `toydb:"id"`
+			City *string `toydb:"city"`
+			Age  int     `toydb:"age,pk"`
+		}]},
+	}
+	for _, tt := range tests {
+		if err := tt.fn(tbl); !errors.Is(err, toydb.ErrSchemaMismatch) {
+			t.Errorf("%s: got %v, want ErrSchemaMismatch", tt.name, err)
+		}
+	}
+
+	// A value that does not fit its field is a mismatch too.
+	small, err := toydb.NewTypedTable[struct {
+		ID   int     `toydb:"id,pk"`
+		City *string `toydb:"city"`
+		Age  uint8   `toydb:"age"`
+	}](tbl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := small.Get(toydb.IntValue(1)); !errors.Is(err, toydb.ErrSchemaMismatch) {
+		t.Errorf("Get of an overflowing value: got %v, want ErrSchemaMismatch", err)
+	}
+}
+
+// typedErr returns the error NewTypedTable returns for T and tbl.
+func typedErr[T any](tbl *toydb.Table) error {
+	_, err := toydb.NewTypedTable[T](tbl)
+	return err
+}
@@ -0,0 +1,54 @@
+package toydb
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+// UUIDValue is the [Value] for [TypeUUID] columns: a 16-byte UUID, stored
+// as its raw bytes so keys compare in byte order.
+type UUIDValue [16]byte
+
+// NewUUID returns a random (version 4) UUID.
+func NewUUID() UUIDValue {
+	var u UUIDValue
+	rand.Read(u[:])
+	u[6] = u[6]&0x0f | 0x40 // version 4
+	u[8] = u[8]&0x3f | 0x80 // RFC 4122 variant
+	return u
+}
+
+// ParseUUID parses a UUID in its canonical form,
+// xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx, in upper or lower case.
+func ParseUUID(s string) (UUIDValue, error) {
+	var u UUIDValue
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return u, fmt.Errorf("parse uuid %q: not in xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx form", s)
+	}
+	digits := s[0:8] + s[9:13] + s[14:18] + s[19:23] + s[24:36]
+	if _, err := hex.Decode(u[:], []byte(digits)); err != nil {
+		return u, fmt.Errorf("parse uuid %q: %w", s, err)
+	}
+	return u, nil
+}
+
+// String formats the UUID in its canonical lower-case form so %v output is
+// human-readable.
+func (u UUIDValue) String() string {
+	var buf [36]byte
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:36], u[10:16])
+	return string(buf[:])
+}
+
+func (u UUIDValue) encode(dst []byte) []byte {
+	return append(dst, u[:]...)
+}